@@ -0,0 +1,280 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+func init() {
+	commands["status"] = command{
+		usage: "status [-admin-socket path] [-interval 2s] [-once]: show the live state of a running sidekick",
+		run:   runStatusCommand,
+	}
+	commands["rotate"] = command{
+		usage: "rotate [-admin-socket path] [-resource id]: force a running sidekick to re-fetch one or all of its resources",
+		run:   runRotateCommand,
+	}
+}
+
+// refreshResource forces an immediate re-fetch of a resource, set once the vault service has been created
+var refreshResource = func(rn *VaultResource) {}
+
+// rotateRequest is a rotation asked for over the admin api, applied by the main loop under the process lock
+type rotateRequest struct {
+	// id is the id of the resource, or empty for all the resources
+	id string
+	// rotated receives the ids of the resources rotated
+	rotated chan []string
+}
+
+// rotateRequests carries the rotations asked for over the admin api into the main loop
+var rotateRequests = make(chan rotateRequest)
+
+// rotateResources forces an immediate re-fetch of the resource with the id, or all the resources when empty,
+// returning the ids of those rotated
+//	id			: the id of the resource
+func rotateResources(id string) []string {
+	var rotated []string
+	for _, rn := range options.resources.items {
+		if id != "" && rn.ID() != id {
+			continue
+		}
+		glog.Infof("rotating the resource: %s on request", rn)
+		refreshResource(rn)
+		rotated = append(rotated, rn.ID())
+	}
+
+	return rotated
+}
+
+// newAdminMux creates the handlers served on the admin socket
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses.snapshot()); err != nil {
+			glog.Errorf("failed to encode the status, error: %s", err)
+		}
+	})
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := req.URL.Query().Get("resource")
+		// step: hand the rotation to the main loop, which holds the lock the resources are processed under
+		request := rotateRequest{id: id, rotated: make(chan []string, 1)}
+		select {
+		case rotateRequests <- request:
+		case <-req.Context().Done():
+			return
+		}
+		rotated := <-request.rotated
+		if len(rotated) == 0 {
+			http.Error(w, fmt.Sprintf("no resource: %s", id), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string][]string{"rotated": rotated}); err != nil {
+			glog.Errorf("failed to encode the rotated resources, error: %s", err)
+		}
+	})
+
+	return mux
+}
+
+// serveAdmin serves the admin api on a unix socket, local to the pod
+//	path		: the path of the unix socket
+func serveAdmin(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	// step: the socket exposes resource state, restrict it to the owner
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	glog.Infof("serving the admin api on the socket: %s", path)
+	go func() {
+		glog.Fatal(http.Serve(listener, newAdminMux()))
+	}()
+
+	return nil
+}
+
+// newAdminClient creates a http client which talks to the admin api over the unix socket
+func newAdminClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// getStatus retrieves the status of a running sidekick over the admin socket
+func getStatus(client *http.Client) (*sidekickStatus, error) {
+	resp, err := client.Get("http://sidekick/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from the admin api: %s", resp.Status)
+	}
+	status := &sidekickStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// errNoAdminSocket is returned by the commands of the admin api when no socket is given
+var errNoAdminSocket = errors.New("no admin socket given, the sidekick must be run with -admin-socket and the same path given here")
+
+// runStatusCommand handles the status subcommand, rendering a table of the resources which is refreshed in place
+func runStatusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	socket := flags.String("admin-socket", getEnv("VAULT_SIDEKICK_ADMIN_SOCKET", defaultAdminSocket), "the admin socket of the running sidekick")
+	interval := flags.Duration("interval", 2*time.Second, "the interval to refresh the status")
+	once := flags.Bool("once", false, "print the status once and exit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *socket == "" {
+		return errNoAdminSocket
+	}
+	client := newAdminClient(*socket)
+	for {
+		status, err := getStatus(client)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve the status from: %s, error: %s", *socket, err)
+		}
+		if !*once {
+			// step: move the cursor home and clear the screen, so the table refreshes in place
+			fmt.Print("\033[H\033[2J")
+		}
+		renderStatus(os.Stdout, status)
+		if *once {
+			return nil
+		}
+		<-time.After(*interval)
+	}
+}
+
+// runRotateCommand handles the rotate subcommand, asking the running sidekick to re-fetch the resources
+func runRotateCommand(args []string) error {
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	socket := flags.String("admin-socket", getEnv("VAULT_SIDEKICK_ADMIN_SOCKET", defaultAdminSocket), "the admin socket of the running sidekick")
+	resource := flags.String("resource", "", "the id of the resource to rotate, all of them when not given")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *socket == "" {
+		return errNoAdminSocket
+	}
+	rotated, err := rotate(newAdminClient(*socket), *resource)
+	if err != nil {
+		return fmt.Errorf("unable to rotate the resources of: %s, error: %s", *socket, err)
+	}
+	for _, x := range rotated {
+		fmt.Printf("rotating: %s\n", x)
+	}
+
+	return nil
+}
+
+// rotate asks a running sidekick over the admin socket to re-fetch the resource, or all of them when empty
+func rotate(client *http.Client, resource string) ([]string, error) {
+	resp, err := client.Post("http://sidekick/rotate?resource="+url.QueryEscape(resource), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from the admin api: %s", resp.Status)
+	}
+	var rotated map[string][]string
+	if err := json.NewDecoder(resp.Body).Decode(&rotated); err != nil {
+		return nil, err
+	}
+
+	return rotated["rotated"], nil
+}
+
+// renderStatus writes the status as a table
+func renderStatus(w io.Writer, status *sidekickStatus) {
+	fmt.Fprintf(w, "%s %s, %d resources, token expires: %s\n\n", prog, status.Version, len(status.Resources),
+		formatRelative(status.TokenExpiry, status.Time))
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTYPE\tSTATE\tRETRIES\tLAST SUCCESS\tNEXT RENEWAL\tLEASE EXPIRY\tLAST ERROR")
+	for _, x := range status.Resources {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", x.ID, x.Resource, x.State, x.Retries,
+			formatRelative(x.LastSuccess, status.Time), formatRelative(x.NextRenewal, status.Time),
+			formatRelative(x.LeaseExpiry, status.Time), truncate(x.LastError, 60))
+	}
+	table.Flush()
+}
+
+// formatRelative formats a time relative to now, i.e. 5m ago or in 1h
+func formatRelative(t, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return fmt.Sprintf("%s ago", -d)
+	}
+
+	return fmt.Sprintf("in %s", d)
+}
+
+// truncate shortens a string to the length
+func truncate(value string, length int) string {
+	value = strings.Replace(value, "\n", " ", -1)
+	if len(value) <= length {
+		return value
+	}
+
+	return value[:length-3] + "..."
+}
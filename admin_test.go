@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+
+	ok := &VaultResource{Resource: "secret", Path: "secret/ok"}
+	failed := &VaultResource{Resource: "pki", Path: "pki/issue/failed"}
+	statuses.success(ok, time.Now().Add(time.Hour))
+	statuses.failure(failed, errors.New("permission denied"))
+
+	if !assert.NoError(t, serveAdmin(socket)) {
+		return
+	}
+	status, err := getStatus(newAdminClient(socket))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, status.Resources, 2)
+	assert.Equal(t, "pki/issue/failed", status.Resources[0].ID)
+	assert.Equal(t, resourceStateFailed, status.Resources[0].State)
+	assert.Equal(t, resourceStateOK, status.Resources[1].State)
+
+	var buf bytes.Buffer
+	renderStatus(&buf, status)
+	assert.Contains(t, buf.String(), "permission denied")
+}
+
+func TestAdminRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+
+	db := &VaultResource{Resource: "database", Path: "database/creds/app", Name: "db"}
+	cert := &VaultResource{Resource: "pki", Path: "pki/issue/web"}
+	resources := options.resources
+	options.resources = &VaultResources{items: []*VaultResource{db, cert}}
+	defer func() { options.resources = resources }()
+	var refreshed []*VaultResource
+	refreshResource = func(rn *VaultResource) { refreshed = append(refreshed, rn) }
+	defer func() { refreshResource = func(rn *VaultResource) {} }()
+
+	// step: stand in for the main loop, which applies the rotations asked for
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case request := <-rotateRequests:
+				request.rotated <- rotateResources(request.id)
+			case <-done:
+				return
+			}
+		}
+	}()
+	if !assert.NoError(t, serveAdmin(socket)) {
+		return
+	}
+	client := newAdminClient(socket)
+	rotated, err := rotate(client, "db")
+	mustNoError(t, err)
+	assert.Equal(t, []string{"db"}, rotated)
+	assert.Equal(t, []*VaultResource{db}, refreshed)
+
+	rotated, err = rotate(client, "")
+	mustNoError(t, err)
+	assert.Equal(t, []string{"db", "pki/issue/web"}, rotated)
+
+	_, err = rotate(client, "missing")
+	assert.Error(t, err)
+	resp, err := client.Get("http://sidekick/rotate")
+	mustNoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
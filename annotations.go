@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// podAnnotationPrefix is the prefix of the annotations of the pod declaring resources, i.e. vault-sidekick.io/cn-0
+	podAnnotationPrefix = "vault-sidekick.io/cn-"
+	// podAnnotationsAPI reads the annotations of the pod from the kubernetes api rather than a file
+	podAnnotationsAPI = "api"
+)
+
+// kubePod is a pod of the kubernetes api
+type kubePod struct {
+	// the metadata of the pod
+	Metadata kubeMetadata `json:"metadata"`
+}
+
+// readPodAnnotations reads the annotations of the pod, from the file the downward api mounts them to or, given
+// api, from the kubernetes api
+//	source		: the path of the annotations file, or api
+func readPodAnnotations(source string) (map[string]string, error) {
+	if source != podAnnotationsAPI {
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		return parseDownwardAnnotations(content)
+	}
+	kube, err := newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	pod := &kubePod{}
+	if err := kube.get(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", kube.namespace, podName()), pod); err != nil {
+		return nil, withCode(codeKubeRequest, fmt.Errorf("unable to read the pod: %s, error: %s", podName(), err))
+	}
+
+	return pod.Metadata.Annotations, nil
+}
+
+// parseDownwardAnnotations parses the annotations file of the downward api, a key="value" line per annotation
+//	content		: the content of the file
+func parseDownwardAnnotations(content []byte) (map[string]string, error) {
+	annotations := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid annotation line: %s", line)
+		}
+		value, err := strconv.Unquote(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of the annotation: %s, error: %s", line[:i], err)
+		}
+		annotations[line[:i]] = value
+	}
+
+	return annotations, scanner.Err()
+}
+
+// annotatedResources returns the resources declared in the annotations, in the order of their suffixes, numerically
+// where they are numbers
+//	annotations	: the annotations of the pod
+func annotatedResources(annotations map[string]string) []string {
+	var keys []string
+	for key := range annotations {
+		if strings.HasPrefix(key, podAnnotationPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := strings.TrimPrefix(keys[i], podAnnotationPrefix), strings.TrimPrefix(keys[j], podAnnotationPrefix)
+		x, errX := strconv.Atoi(a)
+		y, errY := strconv.Atoi(b)
+		if errX == nil && errY == nil {
+			return x < y
+		}
+
+		return a < b
+	})
+
+	var list []string
+	for _, key := range keys {
+		list = append(list, annotations[key])
+	}
+
+	return list
+}
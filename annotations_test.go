@@ -0,0 +1,46 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDownwardAnnotations(t *testing.T) {
+	annotations, err := parseDownwardAnnotations([]byte(`kubernetes.io/config.seen="2024-01-01T00:00:00Z"
+vault-sidekick.io/cn-0="secret:db/creds:fmt=yaml"
+vault-sidekick.io/cn-1="pki:pki/issue/web:common_name=\"web\""
+`))
+	mustNoError(t, err)
+	assert.Equal(t, "secret:db/creds:fmt=yaml", annotations["vault-sidekick.io/cn-0"])
+	assert.Equal(t, `pki:pki/issue/web:common_name="web"`, annotations["vault-sidekick.io/cn-1"])
+
+	_, err = parseDownwardAnnotations([]byte(`vault-sidekick.io/cn-0=unquoted`))
+	assert.Error(t, err)
+}
+
+func TestAnnotatedResources(t *testing.T) {
+	assert.Equal(t, []string{"secret:a", "secret:b", "secret:c"}, annotatedResources(map[string]string{
+		"vault-sidekick.io/cn-10": "secret:c",
+		"vault-sidekick.io/cn-2":  "secret:b",
+		"vault-sidekick.io/cn-0":  "secret:a",
+		"prometheus.io/scrape":    "true",
+	}))
+	assert.Empty(t, annotatedResources(nil))
+}
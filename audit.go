@@ -0,0 +1,216 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// auditRender is a resource rendered to its files
+	auditRender = "render"
+	// auditWrite is a file written
+	auditWrite = "write"
+	// auditUnchanged is a file left alone as it already held the content
+	auditUnchanged = "unchanged"
+	// auditChmod is the mode of a file changed
+	auditChmod = "chmod"
+	// auditChown is the owner of a file changed
+	auditChown = "chown"
+	// auditExec is a hook run
+	auditExec = "exec"
+)
+
+// auditEvent is a line of the audit log
+type auditEvent struct {
+	// the time of the event
+	Time time.Time `json:"time"`
+	// the type of the event
+	Event string `json:"event"`
+	// the resource the event is part of
+	Resource string `json:"resource,omitempty"`
+	// the file or directory
+	Path string `json:"path,omitempty"`
+	// the mode of the file
+	Mode string `json:"mode,omitempty"`
+	// the uid:gid the file was handed over to
+	Owner string `json:"owner,omitempty"`
+	// the sha256 of the content written
+	SHA256 string `json:"sha256,omitempty"`
+	// the size of the content written
+	Size int64 `json:"size,omitempty"`
+	// the format the resource was rendered in
+	Format string `json:"format,omitempty"`
+	// the hook run, i.e. exec, verify-exec or on-delete
+	Hook string `json:"hook,omitempty"`
+	// the command of the hook
+	Command []string `json:"command,omitempty"`
+	// how long the hook ran for
+	Duration string `json:"duration,omitempty"`
+	// the error the hook or write failed with
+	Error string `json:"error,omitempty"`
+}
+
+// auditLog appends an event to a local jsonl file for every render, file write, permission change and hook
+// run, as evidence of where the secrets were materialised; it's disabled when no file is given
+type auditLog struct {
+	sync.Mutex
+	// the file the events are appended to
+	file *os.File
+	// the resource being written, the files written being part of it
+	resource string
+}
+
+// audit is the audit log of the sidekick
+var audit = &auditLog{}
+
+// openAuditLog opens the audit log for appending, creating it if required
+func openAuditLog(filename string) (*auditLog, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{file: file}, nil
+}
+
+// enabled checks if the events are being recorded
+func (a *auditLog) enabled() bool {
+	return a.file != nil
+}
+
+// record appends the event to the log, synced to disk before returning
+func (a *auditLog) record(event *auditEvent) {
+	if !a.enabled() {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	event.Time = time.Now().UTC()
+	if event.Resource == "" {
+		event.Resource = a.resource
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("failed to encode the audit event: %s, error: %s", event.Event, err)
+		return
+	}
+	if _, err := a.file.Write(append(encoded, '\n')); err != nil {
+		glog.Errorf("failed to write to the audit log: %s, error: %s", a.file.Name(), err)
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		glog.Errorf("failed to sync the audit log: %s, error: %s", a.file.Name(), err)
+	}
+}
+
+// begin attributes the files written until end is called to the resource
+func (a *auditLog) begin(rn *VaultResource) {
+	a.Lock()
+	defer a.Unlock()
+	a.resource = rn.ID()
+}
+
+// end stops attributing the files written to the resource
+func (a *auditLog) end() {
+	a.Lock()
+	defer a.Unlock()
+	a.resource = ""
+}
+
+// hasher returns a hash to take the checksum of the content written with, nil when not auditing
+func (a *auditLog) hasher() hash.Hash {
+	if !a.enabled() {
+		return nil
+	}
+
+	return sha256.New()
+}
+
+// render records the resource has been rendered
+//	rn			: the resource
+//	err			: the error the render failed with, if any
+func (a *auditLog) render(rn *VaultResource, err error) {
+	event := &auditEvent{Event: auditRender, Resource: rn.ID(), Path: resourceFilename(rn), Format: rn.Format}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	a.record(event)
+}
+
+// write records a file written, with the checksum of its content
+//	filename	: the file
+//	mode		: the mode of the file
+//	h			: the hash of the content
+//	size		: the number of bytes written
+func (a *auditLog) write(filename string, mode os.FileMode, h hash.Hash, size int64) {
+	event := &auditEvent{Event: auditWrite, Path: filename, Mode: fmt.Sprintf("%#o", mode), Size: size}
+	if h != nil {
+		event.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	a.record(event)
+}
+
+// unchanged records a file left alone, as it already held the content
+func (a *auditLog) unchanged(filename string) {
+	a.record(&auditEvent{Event: auditUnchanged, Path: filename})
+}
+
+// chmod records the mode of a file being changed
+func (a *auditLog) chmod(filename string, mode os.FileMode) {
+	a.record(&auditEvent{Event: auditChmod, Path: filename, Mode: fmt.Sprintf("%#o", mode)})
+}
+
+// chown records a file being handed over to the uid and gid
+func (a *auditLog) chown(filename string, uid, gid int) {
+	a.record(&auditEvent{Event: auditChown, Path: filename, Owner: fmt.Sprintf("%d:%d", uid, gid)})
+}
+
+// exec records a hook being run
+//	rn			: the resource the hook belongs to
+//	hook		: the hook, i.e. exec, verify-exec or on-delete
+//	command		: the command and its arguments
+//	took		: how long the hook ran for
+//	err			: the error the hook failed with, if any
+func (a *auditLog) exec(rn *VaultResource, hook string, command []string, took time.Duration, err error) {
+	event := &auditEvent{Event: auditExec, Resource: rn.ID(), Hook: hook, Command: command, Duration: took.String()}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	a.record(event)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	// the number of bytes written
+	size int64
+}
+
+// Write counts the bytes
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readAuditLog reads back the events of the audit log
+func readAuditLog(t *testing.T, filename string) []auditEvent {
+	file, err := os.Open(filename)
+	mustNoError(t, err)
+	defer file.Close()
+	var events []auditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event auditEvent
+		mustNoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func TestAuditLog(t *testing.T) {
+	defer withOutputDir(t)()
+	filename := filepath.Join(options.outputDir, "audit.jsonl")
+	log, err := openAuditLog(filename)
+	mustNoError(t, err)
+	previous := audit
+	audit = log
+	defer func() {
+		audit = previous
+		log.file.Close()
+	}()
+
+	marker := filepath.Join(options.outputDir, "reloaded")
+	rn := &VaultResource{
+		Resource: "secret",
+		Path:     "secret/app",
+		Format:   "json",
+		Filename: "app",
+		FileMode: 0640,
+		ExecPath: []string{"touch", marker},
+	}
+	mustNoError(t, processResource(rn, map[string]interface{}{"password": "hunter22"}))
+	pendingExecs.Wait()
+
+	events := readAuditLog(t, filename)
+	if !assert.Len(t, events, 3) {
+		return
+	}
+	written := filepath.Join(options.outputDir, "app")
+	content, err := ioutil.ReadFile(written)
+	mustNoError(t, err)
+	sum := sha256.Sum256(content)
+
+	assert.Equal(t, auditWrite, events[0].Event)
+	assert.Equal(t, "secret/app", events[0].Resource)
+	assert.Equal(t, written, events[0].Path)
+	assert.Equal(t, "0640", events[0].Mode)
+	assert.Equal(t, hex.EncodeToString(sum[:]), events[0].SHA256)
+	assert.Equal(t, int64(len(content)), events[0].Size)
+	assert.Equal(t, auditRender, events[1].Event)
+	assert.Equal(t, "json", events[1].Format)
+	assert.Empty(t, events[1].Error)
+	assert.Equal(t, auditExec, events[2].Event)
+	assert.Equal(t, "exec", events[2].Hook)
+	assert.Equal(t, []string{"touch", marker}, events[2].Command)
+	assert.NotEmpty(t, events[2].Duration)
+	for _, event := range events {
+		assert.False(t, event.Time.IsZero())
+		assert.NotContains(t, event.Path, "hunter22")
+	}
+
+	// step: the log is appended to, never truncated, when opened again
+	reopened, err := openAuditLog(filename)
+	mustNoError(t, err)
+	defer reopened.file.Close()
+	reopened.chown(written, 1000, 1000)
+	events = readAuditLog(t, filename)
+	if !assert.Len(t, events, 4) {
+		return
+	}
+	assert.Equal(t, auditChown, events[3].Event)
+	assert.Equal(t, "1000:1000", events[3].Owner)
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	log := &auditLog{}
+	assert.False(t, log.enabled())
+	assert.Nil(t, log.hasher())
+	log.record(&auditEvent{Event: auditWrite})
+}
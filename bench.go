@@ -0,0 +1,175 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchFormats are the formats which can render any secret, those needing certificates or keys are left out
+var benchFormats = []string{"yaml", "json", "ini", "toml", "csv", "env", "properties", "dotenv", "txt", "pgpass", "mycnf"}
+
+// benchResult is the outcome of rendering the synthetic secrets through a format
+type benchResult struct {
+	// the format rendered
+	format string
+	// the number of secrets rendered
+	count int
+	// the bytes of secret data rendered
+	bytes int64
+	// how long the renders took
+	elapsed time.Duration
+	// the allocations and bytes allocated by the renders
+	allocs     uint64
+	allocBytes uint64
+}
+
+func init() {
+	commands["bench"] = command{
+		usage: "bench [-count 1000] [-keys 4] [-size 64] [-formats yaml,json] [-output dir]: render synthetic secrets through each format and report the throughput",
+		run:   runBenchCommand,
+	}
+}
+
+// runBenchCommand handles the bench subcommand
+func runBenchCommand(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	count := flags.Int("count", 1000, "the number of secrets to render through each format")
+	keys := flags.Int("keys", 4, "the number of keys in each secret")
+	size := flags.Int("size", 64, "the size in bytes of each value")
+	formats := flags.String("formats", strings.Join(benchFormats, ","), "a comma separated list of the formats to render")
+	output := flags.String("output", "", "the directory to render into, a temporary directory removed afterwards by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *count <= 0 || *keys <= 0 || *size <= 0 {
+		return fmt.Errorf("the count, keys and size must all be greater than zero")
+	}
+
+	dir := *output
+	if dir == "" {
+		tmp, err := ioutil.TempDir("", "vault-sidekick-bench")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+	options.outputDir = dir
+
+	fmt.Printf("rendering %d secrets of %d keys of %d bytes into: %s\n\n", *count, *keys, *size, dir)
+	var results []*benchResult
+	for _, format := range strings.Split(*formats, ",") {
+		result, err := benchFormat(strings.TrimSpace(format), *count, *keys, *size)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+	renderBenchResults(os.Stdout, results)
+
+	return nil
+}
+
+// benchFormat renders the synthetic secrets through a format, measuring the time and allocations taken
+//	format		: the output format
+//	count		: the number of secrets to render
+//	keys		: the number of keys in each secret
+//	size		: the size of each value
+func benchFormat(format string, count, keys, size int) (*benchResult, error) {
+	// step: build the secrets up front, so only the render is measured
+	resources := make([]*VaultResource, count)
+	secrets := make([]map[string]interface{}, count)
+	for i := 0; i < count; i++ {
+		resources[i] = &VaultResource{
+			Resource: "secret",
+			Path:     fmt.Sprintf("secret/bench/%s/%d", format, i),
+			Format:   format,
+			FileMode: 0600,
+		}
+		secrets[i] = syntheticSecret(keys, size)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	started := time.Now()
+	for i, rn := range resources {
+		if _, err := writeResource(rn, secrets[i]); err != nil {
+			return nil, fmt.Errorf("unable to render the format: %s, error: %s", format, err)
+		}
+	}
+	elapsed := time.Since(started)
+	runtime.ReadMemStats(&after)
+
+	return &benchResult{
+		format:     format,
+		count:      count,
+		bytes:      int64(count * keys * size),
+		elapsed:    elapsed,
+		allocs:     after.Mallocs - before.Mallocs,
+		allocBytes: after.TotalAlloc - before.TotalAlloc,
+	}, nil
+}
+
+// syntheticSecret generates a secret with the number of keys, each a random value of the size
+func syntheticSecret(keys, size int) map[string]interface{} {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	data := make(map[string]interface{}, keys)
+	for i := 0; i < keys; i++ {
+		value := make([]byte, size)
+		for j := range value {
+			value[j] = letters[rand.Intn(len(letters))]
+		}
+		// step: the database formats look for a username and password
+		name := fmt.Sprintf("key_%03d", i)
+		switch i {
+		case 0:
+			name = "username"
+		case 1:
+			name = "password"
+		}
+		data[name] = string(value)
+	}
+
+	return data
+}
+
+// renderBenchResults writes the results as a table
+func renderBenchResults(w io.Writer, results []*benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORMAT\tSECRETS\tTIME\tSECRETS/S\tMB/S\tNS/OP\tALLOCS/OP\tBYTES/OP")
+	for _, x := range results {
+		seconds := x.elapsed.Seconds()
+		if seconds <= 0 {
+			seconds = 1e-9
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.0f\t%.2f\t%d\t%d\t%d\n", x.format, x.count, x.elapsed,
+			float64(x.count)/seconds, float64(x.bytes)/seconds/(1<<20),
+			x.elapsed.Nanoseconds()/int64(x.count), x.allocs/uint64(x.count), x.allocBytes/uint64(x.count))
+	}
+	tw.Flush()
+}
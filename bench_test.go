@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withOutputDir points the output directory at a temporary directory for the duration of the test
+func withOutputDir(tb testing.TB) func() {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	previous := options.outputDir
+	options.outputDir = dir
+
+	return func() {
+		options.outputDir = previous
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBenchFormat(t *testing.T) {
+	defer withOutputDir(t)()
+
+	var results []*benchResult
+	for _, format := range benchFormats {
+		result, err := benchFormat(format, 5, 3, 16)
+		if !assert.NoError(t, err, "format: %s", format) {
+			continue
+		}
+		assert.Equal(t, int64(5*3*16), result.bytes)
+		results = append(results, result)
+	}
+
+	b := &bytes.Buffer{}
+	renderBenchResults(b, results)
+	assert.Contains(t, b.String(), "ALLOCS/OP")
+	assert.Contains(t, b.String(), "pgpass")
+}
+
+func BenchmarkWriteResource(b *testing.B) {
+	defer withOutputDir(b)()
+
+	for _, size := range []int{64, 4096} {
+		for _, format := range benchFormats {
+			b.Run(fmt.Sprintf("%s/%d", format, size), func(b *testing.B) {
+				rn := &VaultResource{Resource: "secret", Path: "secret/bench", Format: format, FileMode: 0600}
+				data := syntheticSecret(4, size)
+				b.SetBytes(int64(4 * size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := writeResource(rn, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkWriteTemplate(b *testing.B) {
+	defer withOutputDir(b)()
+
+	templateFile := filepath.Join(options.outputDir, "bench.tmpl")
+	if err := ioutil.WriteFile(templateFile, []byte(`{{ range $key := keys . }}{{ $key }}={{ index $ $key | quote }}
+{{ end }}`), 0600); err != nil {
+		b.Fatal(err)
+	}
+	rn := &VaultResource{Resource: "secret", Path: "secret/bench", Format: "template", TemplateFile: templateFile, FileMode: 0600}
+	data := syntheticSecret(4, 64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := writeResource(rn, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteLargeValue(b *testing.B) {
+	defer withOutputDir(b)()
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/blob", Format: "txt", FileMode: 0600}
+	data := syntheticSecret(1, 8<<20)
+	b.SetBytes(8 << 20)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := writeResource(rn, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+const (
+	// breakerClosed lets the requests through to vault
+	breakerClosed = "closed"
+	// breakerOpen holds the requests back for the cool down
+	breakerOpen = "open"
+	// breakerHalfOpen lets a single canary request through once the cool down is over
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops the sidekick hammering vault during an outage; after the threshold of consecutive failures
+// across the resources the requests are held back for the cool down, then a single canary is let through, closing
+// the breaker if it succeeds or opening it for another cool down if it fails
+type circuitBreaker struct {
+	// the consecutive failures which open the breaker, zero disabling it
+	threshold int
+	// how long the breaker stays open
+	cooldown time.Duration
+	// the state of the breaker
+	state string
+	// the consecutive failures
+	failures int
+	// when the breaker is open until
+	until time.Time
+}
+
+// newCircuitBreaker creates a closed breaker
+//	threshold	: the consecutive failures which open the breaker, zero disabling it
+//	cooldown	: how long the breaker stays open
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{threshold: threshold, cooldown: cooldown}
+	b.transition(breakerClosed)
+
+	return b
+}
+
+// allow checks if a request can go to vault, returning how long to hold it back for otherwise
+//	now			: the time now
+func (b *circuitBreaker) allow(now time.Time) time.Duration {
+	switch b.state {
+	case breakerOpen:
+		if now.Before(b.until) {
+			return b.until.Sub(now)
+		}
+		glog.Infof("the circuit breaker cool down is over, letting a canary request through to vault")
+		b.transition(breakerHalfOpen)
+		return 0
+	case breakerHalfOpen:
+		// step: the canary is still in flight, hold the rest back
+		return b.cooldown
+	}
+
+	return 0
+}
+
+// record records the outcome of a request to vault
+//	now			: the time now
+//	err			: the error of the request, nil if it succeeded
+func (b *circuitBreaker) record(now time.Time, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	if err == nil || !vaultUnhealthy(err) {
+		if b.state != breakerClosed {
+			glog.Infof("vault has recovered, closing the circuit breaker")
+		}
+		b.failures = 0
+		b.transition(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		glog.Warningf("vault has failed %d requests in a row, holding requests back for %s, error: %s", b.failures, b.cooldown, err)
+		b.until = now.Add(b.cooldown)
+		b.transition(breakerOpen)
+	}
+}
+
+// transition moves the breaker to the state
+func (b *circuitBreaker) transition(state string) {
+	b.state = state
+	metrics.BreakerState(state)
+}
+
+// vaultUnhealthy checks if a failed request points at vault being unhealthy, rather than the request itself being
+// refused, which vault answering shows it's up
+//	err			: the error of the request
+func vaultUnhealthy(err error) bool {
+	if errorCode(err) == codeVaultDeleted {
+		return false
+	}
+	switch vaultErrorCode(err) {
+	case codeVaultDenied, codeVaultNotFound:
+		return false
+	}
+
+	return true
+}
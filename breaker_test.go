@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	outage := errors.New("Put https://vault:8200/v1/pki/issue/web: dial tcp: connection refused")
+	breaker := newCircuitBreaker(2, 30*time.Second)
+
+	breaker.record(now, outage)
+	assert.Equal(t, time.Duration(0), breaker.allow(now))
+	breaker.record(now, outage)
+	assert.Equal(t, breakerOpen, breaker.state)
+	assert.Equal(t, 20*time.Second, breaker.allow(now.Add(10*time.Second)))
+
+	// step: a failed canary opens the breaker for another cool down
+	assert.Equal(t, time.Duration(0), breaker.allow(now.Add(30*time.Second)))
+	assert.Equal(t, breakerHalfOpen, breaker.state)
+	assert.Equal(t, 30*time.Second, breaker.allow(now.Add(30*time.Second)))
+	breaker.record(now.Add(30*time.Second), outage)
+	assert.Equal(t, breakerOpen, breaker.state)
+
+	// step: a successful canary closes it
+	assert.Equal(t, time.Duration(0), breaker.allow(now.Add(time.Minute)))
+	breaker.record(now.Add(time.Minute), nil)
+	assert.Equal(t, breakerClosed, breaker.state)
+	assert.Equal(t, 0, breaker.failures)
+
+	// step: requests vault refused show it's up
+	breaker.record(now, errors.New("Code: 403. Errors:\n\n* permission denied"))
+	breaker.record(now, errors.New("Code: 403. Errors:\n\n* permission denied"))
+	assert.Equal(t, breakerClosed, breaker.state)
+
+	disabled := newCircuitBreaker(0, time.Second)
+	for i := 0; i < 10; i++ {
+		disabled.record(now, outage)
+	}
+	assert.Equal(t, time.Duration(0), disabled.allow(now))
+}
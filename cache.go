@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// cachedRead is a response from vault kept for reuse
+type cachedRead struct {
+	// the secret read
+	secret *api.Secret
+	// when the response can no longer be reused
+	expires time.Time
+}
+
+// readCache keeps the responses of reads from vault, so resources reading the same path within their
+// cache-ttl share the one read
+type readCache struct {
+	sync.Mutex
+	// the responses by path and parameters
+	items map[string]*cachedRead
+}
+
+// reads is the cache of the reads from vault
+var reads = &readCache{items: make(map[string]*cachedRead)}
+
+// readKey returns the key of a read by the path and parameters of the resource
+func readKey(rn *VaultResource) string {
+	b := &bytes.Buffer{}
+	b.WriteString(rn.Path)
+	var keys []string
+	for key := range rn.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "&%s=%s", key, rn.Options[key])
+	}
+
+	return b.String()
+}
+
+// read returns the cached response of the resource if there's one within its cache-ttl, otherwise reading
+// and caching it; only found secrets without a lease are cached, as a lease is renewed and revoked by the one
+// resource it was issued to, dynamic credentials never being shared
+//	rn			: the resource being read
+//	fetch		: reads the resource from vault
+func (c *readCache) read(rn *VaultResource, fetch func() (*api.Secret, error)) (*api.Secret, error) {
+	if rn.CacheTTL <= 0 {
+		return fetch()
+	}
+	key := readKey(rn)
+
+	c.Lock()
+	cached, found := c.items[key]
+	c.Unlock()
+	if found && time.Now().Before(cached.expires) {
+		glog.V(4).Infof("using the cached read of the resource: %s, expires: %s", rn, cached.expires)
+		return copySecret(cached.secret), nil
+	}
+
+	secret, err := fetch()
+	if err != nil || secret == nil || secret.LeaseID != "" {
+		return secret, err
+	}
+	c.Lock()
+	c.items[key] = &cachedRead{secret: copySecret(secret), expires: time.Now().Add(rn.CacheTTL)}
+	c.Unlock()
+
+	return secret, nil
+}
+
+// invalidate drops the cached response of the resource, so the next read goes to vault
+func (c *readCache) invalidate(rn *VaultResource) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.items, readKey(rn))
+}
+
+// copySecret copies the secret, so a resource reshaping its data doesn't change the cached one
+func copySecret(secret *api.Secret) *api.Secret {
+	x := *secret
+	x.Data = make(map[string]interface{}, len(secret.Data))
+	for key, value := range secret.Data {
+		x.Data[key] = value
+	}
+
+	return &x
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCache(t *testing.T) {
+	cache := &readCache{items: make(map[string]*cachedRead)}
+	fetches := 0
+	fetch := func() (*api.Secret, error) {
+		fetches++
+		return &api.Secret{Data: map[string]interface{}{"password": "test"}}, nil
+	}
+	first := &VaultResource{Resource: "secret", Path: "secret/db", CacheTTL: time.Minute}
+	alias := &VaultResource{Resource: "secret", Path: "secret/db", CacheTTL: time.Minute, Format: "json"}
+	uncached := &VaultResource{Resource: "secret", Path: "secret/db"}
+
+	secret, err := cache.read(first, fetch)
+	mustNoError(t, err)
+	// step: reshaping the data of one resource must not change the cached copy
+	secret.Data["password"] = "changed"
+
+	secret, err = cache.read(alias, fetch)
+	mustNoError(t, err)
+	assert.Equal(t, "test", secret.Data["password"])
+	assert.Equal(t, 1, fetches)
+
+	_, err = cache.read(uncached, fetch)
+	mustNoError(t, err)
+	assert.Equal(t, 2, fetches)
+
+	cache.invalidate(first)
+	_, err = cache.read(alias, fetch)
+	mustNoError(t, err)
+	assert.Equal(t, 3, fetches)
+
+	cache.items[readKey(first)].expires = time.Now().Add(-time.Second)
+	_, err = cache.read(first, fetch)
+	mustNoError(t, err)
+	assert.Equal(t, 4, fetches)
+
+	// step: dynamic credentials are never shared, each resource holding a lease of its own
+	leased := func() (*api.Secret, error) {
+		fetches++
+		return &api.Secret{LeaseID: "database/creds/app/1", Data: map[string]interface{}{"password": "test"}}, nil
+	}
+	creds := &VaultResource{Resource: "database", Path: "database/creds/app", CacheTTL: time.Minute}
+	_, err = cache.read(creds, leased)
+	mustNoError(t, err)
+	_, err = cache.read(creds, leased)
+	mustNoError(t, err)
+	assert.Equal(t, 6, fetches)
+	assert.NotContains(t, cache.items, readKey(creds))
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// chainOptions controls how the certificate chain of a pki resource is written
+type chainOptions struct {
+	// write the root first, down to the leaf, rather than the leaf first
+	rootFirst bool
+	// leave any self signed root out of the chain
+	noRoot bool
+	// append the chain to the certificate file
+	appendChain bool
+}
+
+// parseChainOptions parses the chain option, a list of leaf-first, root-first, root, no-root, append and no-append
+//	value		: the comma separated list of settings
+func parseChainOptions(value string) (*chainOptions, error) {
+	opts := &chainOptions{}
+	for _, x := range strings.Split(value, ",") {
+		switch strings.TrimSpace(x) {
+		case "leaf-first":
+			opts.rootFirst = false
+		case "root-first":
+			opts.rootFirst = true
+		case "root":
+			opts.noRoot = false
+		case "no-root":
+			opts.noRoot = true
+		case "append":
+			opts.appendChain = true
+		case "no-append":
+			opts.appendChain = false
+		default:
+			return nil, fmt.Errorf("the chain option: %s is invalid, should be a list of leaf-first, root-first, root, no-root, append or no-append", value)
+		}
+	}
+
+	return opts, nil
+}
+
+// String returns the settings as they would be given in the chain option
+func (c chainOptions) String() string {
+	settings := []string{"leaf-first", "root", "no-append"}
+	if c.rootFirst {
+		settings[0] = "root-first"
+	}
+	if c.noRoot {
+		settings[1] = "no-root"
+	}
+	if c.appendChain {
+		settings[2] = "append"
+	}
+
+	return strings.Join(settings, ",")
+}
+
+// caChain returns the pem encoded ca certificates of a pki resource, nearest the leaf first, falling back
+// to the issuing ca when there's no chain; roots are dropped and the order reversed as the options ask
+//	data		: the data of the pki resource
+//	opts		: the chain options
+func caChain(data map[string]interface{}, opts *chainOptions) []string {
+	var blocks []*pem.Block
+	if list, ok := data["ca_chain"].([]interface{}); ok {
+		for _, x := range list {
+			blocks = append(blocks, pemCertificates(fmt.Sprintf("%v", x))...)
+		}
+	}
+	if len(blocks) == 0 {
+		if ca, found := data["issuing_ca"]; found {
+			blocks = pemCertificates(fmt.Sprintf("%v", ca))
+		}
+	}
+
+	var chain []string
+	for _, block := range blocks {
+		if opts.noRoot && isRootCertificate(block.Bytes) {
+			continue
+		}
+		chain = append(chain, strings.TrimSpace(string(pem.EncodeToMemory(block))))
+	}
+	if opts.rootFirst {
+		for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+			chain[i], chain[j] = chain[j], chain[i]
+		}
+	}
+
+	return chain
+}
+
+// certificateChain returns the leaf certificate and its ca chain as a single pem, ordered as the options ask
+//	data		: the data of the pki resource
+//	opts		: the chain options
+func certificateChain(data map[string]interface{}, opts *chainOptions) string {
+	leaf := strings.TrimSpace(fmt.Sprintf("%v", data["certificate"]))
+	list := append([]string{leaf}, caChain(data, opts)...)
+	if opts.rootFirst {
+		list = append(caChain(data, opts), leaf)
+	}
+
+	return strings.Join(list, "\n") + "\n"
+}
+
+// leafCertificate returns the content of the certificate file, with the chain appended if the options ask
+func leafCertificate(data map[string]interface{}, opts *chainOptions) string {
+	if opts.appendChain {
+		return certificateChain(data, opts)
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%v", data["certificate"])) + "\n"
+}
+
+// pemCertificates decodes the certificate blocks of the pem content
+func pemCertificates(content string) []*pem.Block {
+	var list []*pem.Block
+	rest := []byte(content)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return list
+		}
+		if block.Type == "CERTIFICATE" {
+			list = append(list, block)
+		}
+	}
+}
+
+// isRootCertificate checks if the der encoded certificate is a self signed root
+func isRootCertificate(der []byte) bool {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return false
+	}
+	if !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		return false
+	}
+
+	return cert.CheckSignatureFrom(cert) == nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checksumSuffix is the suffix of the checksum file written next to each file
+const checksumSuffix = ".sha256"
+
+// checksumTracker keeps the sha256 of the files of the resource being written, so a checksum file can be
+// written next to each once the resource has been written and verified
+type checksumTracker struct {
+	sync.Mutex
+	// whether the checksums are being kept
+	active bool
+	// the checksum of each file, in the order written
+	sums  map[string]string
+	order []string
+}
+
+// checksums keeps the checksums of the resource being written
+var checksums = &checksumTracker{}
+
+// checksumFiles checks if checksum files are written for the resource
+func checksumFiles(rn *VaultResource) bool {
+	return options.checksumFiles || rn.Checksum
+}
+
+// begin starts keeping the checksums of the files written
+func (c *checksumTracker) begin() {
+	c.Lock()
+	defer c.Unlock()
+	c.active = true
+	c.sums = make(map[string]string)
+	c.order = nil
+}
+
+// end stops keeping the checksums, returning them
+func (c *checksumTracker) end() ([]string, map[string]string) {
+	c.Lock()
+	defer c.Unlock()
+	c.active = false
+
+	return c.order, c.sums
+}
+
+// hasher returns a hash the content of the file is written through, nil when no checksums are kept
+func (c *checksumTracker) hasher() hash.Hash {
+	c.Lock()
+	defer c.Unlock()
+	if !c.active {
+		return nil
+	}
+
+	return sha256.New()
+}
+
+// record keeps the checksum of a file written
+//	filename	: the file written
+//	sum			: the sha256 of its content
+func (c *checksumTracker) record(filename string, sum []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.active {
+		return
+	}
+	if _, found := c.sums[filename]; !found {
+		c.order = append(c.order, filename)
+	}
+	c.sums[filename] = hex.EncodeToString(sum)
+}
+
+// recordFile keeps the checksum of a file left as it was, hashing its content on disk
+//	filename	: the file
+func (c *checksumTracker) recordFile(filename string) error {
+	h := c.hasher()
+	if h == nil {
+		return nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	c.record(filename, h.Sum(nil))
+
+	return nil
+}
+
+// writeChecksums ends the tracking and writes a checksum file next to each file written, in the format of
+// sha256sum, i.e. sha256sum -c tls.pem.sha256 run in the directory verifies the file
+//	mode		: the mode of the checksum files
+func (c *checksumTracker) writeChecksums(mode os.FileMode) error {
+	order, sums := c.end()
+	for _, filename := range order {
+		content := fmt.Sprintf("%s  %s\n", sums[filename], filepath.Base(filename))
+		if err := writeFile(filename+checksumSuffix, []byte(content), mode); err != nil {
+			return fmt.Errorf("unable to write the checksum of: %s, error: %s", filename, err)
+		}
+	}
+
+	return nil
+}
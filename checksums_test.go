@@ -0,0 +1,87 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumFiles(t *testing.T) {
+	defer withOutputDir(t)()
+
+	rn := &VaultResource{
+		Resource: "pki",
+		Path:     "pki/issue/app",
+		Format:   "bundle",
+		Filename: "tls",
+		FileMode: 0600,
+		Checksum: true,
+	}
+	_, err := writeResource(rn, newTestPKI(t))
+	mustNoError(t, err)
+
+	for _, name := range []string{"tls.pem", "tls-key.pem", "tls-ca.pem", "tls-bundle.pem"} {
+		filename := filepath.Join(options.outputDir, name)
+		content, err := ioutil.ReadFile(filename)
+		mustNoError(t, err)
+		sum := sha256.Sum256(content)
+		checksum, err := ioutil.ReadFile(filename + checksumSuffix)
+		if !assert.NoError(t, err, "file: %s", name) {
+			continue
+		}
+		assert.Equal(t, fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name), string(checksum))
+	}
+	_, err = os.Stat(filepath.Join(options.outputDir, "tls.pem"+checksumSuffix+checksumSuffix))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestChecksumFilesUnchanged(t *testing.T) {
+	defer withOutputDir(t)()
+	options.skipUnchanged = true
+	defer func() { options.skipUnchanged = false }()
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", Format: "json", Filename: "app", FileMode: 0600}
+	data := map[string]interface{}{"password": "secret"}
+	_, err := writeResource(rn, data)
+	mustNoError(t, err)
+	checksum := filepath.Join(options.outputDir, "app"+checksumSuffix)
+	_, err = os.Stat(checksum)
+	assert.True(t, os.IsNotExist(err), "no checksum should be written without the option")
+
+	// step: turning the option on writes the checksum of a file left as it was
+	rn.Checksum = true
+	_, err = writeResource(rn, data)
+	mustNoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(options.outputDir, "app"))
+	mustNoError(t, err)
+	sum := sha256.Sum256(content)
+	written, err := ioutil.ReadFile(checksum)
+	mustNoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:])+"  app\n", string(written))
+
+	// step: once both are written nothing more is rewritten
+	_, err = writeResource(rn, data)
+	assert.Equal(t, errUnchanged, err)
+}
@@ -0,0 +1,465 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// command is a subcommand of the sidekick, i.e. vault-sidekick config print-effective
+type command struct {
+	// a description of the command for the usage
+	usage string
+	// the handler for the command, given the remaining arguments
+	run func(args []string) error
+}
+
+// commands is a map of the subcommands supported by the sidekick
+var commands = make(map[string]command)
+
+func init() {
+	commands["config"] = command{
+		usage: "config print-effective [options]: print the effective configuration after all precedence rules",
+		run:   runConfigCommand,
+	}
+}
+
+// runCommand checks if the arguments are a subcommand and runs it, returning false if they are not
+//	args		: the command line arguments, excluding the program name
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	cmd, found := commands[args[0]]
+	if !found {
+		return false, nil
+	}
+
+	return true, cmd.run(args[1:])
+}
+
+// commandUsage returns the usage of all the subcommands
+func commandUsage() string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []string
+	for _, name := range names {
+		list = append(list, "  "+commands[name].usage)
+	}
+
+	return strings.Join(list, "\n")
+}
+
+// parseCommandOptions parses the remaining arguments of a subcommand as the usual options
+func parseCommandOptions(args []string) error {
+	os.Args = append([]string{os.Args[0]}, args...)
+
+	return parseOptions()
+}
+
+// runConfigCommand handles the config subcommand
+func runConfigCommand(args []string) error {
+	if len(args) == 0 || args[0] != "print-effective" {
+		return fmt.Errorf("usage: %s %s", prog, commands["config"].usage)
+	}
+	if err := parseCommandOptions(args[1:]); err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(newEffectiveConfig(&options))
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(content))
+
+	return nil
+}
+
+// maskedValue is the value shown in place of anything secret bearing
+const maskedValue = "********"
+
+// mask hides a secret bearing value, leaving empty values as they are
+func mask(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	return maskedValue
+}
+
+// maskPassphrase hides a literal passphrase, leaving references to where it's held visible
+func maskPassphrase(spec string) string {
+	for _, prefix := range []string{"env:", "file:", "vault:"} {
+		if strings.HasPrefix(spec, prefix) {
+			return spec
+		}
+	}
+
+	return mask(spec)
+}
+
+// chainString returns the chain options of a resource, empty when they are not set
+func chainString(chain *chainOptions) string {
+	if chain == nil {
+		return ""
+	}
+
+	return chain.String()
+}
+
+// fileModeStrings returns the modes of the parts of a resource in octal, nil when none are set
+func fileModeStrings(parts map[string]os.FileMode) map[string]string {
+	if len(parts) == 0 {
+		return nil
+	}
+	modes := make(map[string]string)
+	for part, mode := range parts {
+		modes[part] = fmt.Sprintf("%#o", mode)
+	}
+
+	return modes
+}
+
+// effectiveConfig is the printable view of the configuration once flags, environment variables
+// and files have all been applied
+type effectiveConfig struct {
+	Vault         string              `yaml:"vault"`
+	CACert        string              `yaml:"ca-cert,omitempty"`
+	SkipTLSVerify bool                `yaml:"tls-skip-verify"`
+	Auth          effectiveAuth       `yaml:"auth"`
+	RenewToken    bool                `yaml:"renew-token"`
+	TokenMargin   string              `yaml:"token-renewal-margin,omitempty"`
+	RefetchReauth bool                `yaml:"refetch-on-reauth"`
+	Output        string              `yaml:"output"`
+	Umask         string              `yaml:"umask,omitempty"`
+	DirMode       string              `yaml:"dir-mode,omitempty"`
+	RefuseOutput  bool                `yaml:"refuse-writable-output,omitempty"`
+	DryRun        bool                `yaml:"dryrun"`
+	OneShot       bool                `yaml:"one-shot"`
+	StatsInterval time.Duration       `yaml:"stats"`
+	ExecTimeout   time.Duration       `yaml:"exec-timeout"`
+	ExecDryRun    bool                `yaml:"exec-dry-run"`
+	TriggerCheck  time.Duration       `yaml:"trigger-interval"`
+	MetricsPort   uint                `yaml:"metrics-port"`
+	MetricsAddr   string              `yaml:"metrics-listen,omitempty"`
+	MetricsListen []string            `yaml:"metrics-listener,omitempty"`
+	MetricsLabels []string            `yaml:"metrics-label,omitempty"`
+	MetricsCert   string              `yaml:"metrics-tls-cert,omitempty"`
+	MetricsKey    string              `yaml:"metrics-tls-key,omitempty"`
+	MetricsBasic  string              `yaml:"metrics-basic-auth-file,omitempty"`
+	MetricsBearer string              `yaml:"metrics-bearer-token-file,omitempty"`
+	AdminSocket   string              `yaml:"admin-socket,omitempty"`
+	KeystorePass  string              `yaml:"keystore-passphrase,omitempty"`
+	LintSecrets   bool                `yaml:"lint-secrets"`
+	SkipUnchanged bool                `yaml:"skip-unchanged"`
+	RequireTmpfs  bool                `yaml:"require-tmpfs"`
+	Shred         bool                `yaml:"shred"`
+	Checksums     bool                `yaml:"checksum-files"`
+	ExpiryGrace   time.Duration       `yaml:"expiry-grace,omitempty"`
+	ExpiryHook    string              `yaml:"expiry-webhook,omitempty"`
+	Breaker       int                 `yaml:"breaker-threshold"`
+	BreakerCool   time.Duration       `yaml:"breaker-cooldown"`
+	FetchLimit    int                 `yaml:"fetch-concurrency,omitempty"`
+	ExecLimit     int                 `yaml:"exec-concurrency,omitempty"`
+	FetchStagger  time.Duration       `yaml:"fetch-stagger,omitempty"`
+	MaxRetries    int                 `yaml:"max-retries,omitempty"`
+	StartupTime   time.Duration       `yaml:"startup-timeout,omitempty"`
+	RetryBackoff  time.Duration       `yaml:"retry-backoff"`
+	RetryMax      time.Duration       `yaml:"retry-backoff-max"`
+	Renewal       string              `yaml:"renewal,omitempty"`
+	RenewalJitter string              `yaml:"renewal-jitter,omitempty"`
+	StateFile     string              `yaml:"state-file,omitempty"`
+	StateKeyFile  string              `yaml:"state-key-file,omitempty"`
+	ServeSocket   string              `yaml:"serve-socket,omitempty"`
+	FuseMount     string              `yaml:"fuse-mount,omitempty"`
+	ServeUIDs     []string            `yaml:"serve-uids,omitempty"`
+	DeleteOnExit  bool                `yaml:"delete-on-exit"`
+	MaxValueSize  int64               `yaml:"max-value-size,omitempty"`
+	SensitiveKeys []string            `yaml:"sensitive-keys,omitempty"`
+	OutputGC      bool                `yaml:"output-gc"`
+	OutputGCDry   bool                `yaml:"output-gc-dry-run,omitempty"`
+	ResourcesYAML string              `yaml:"resources-yaml,omitempty"`
+	ConfigMap     string              `yaml:"resources-configmap,omitempty"`
+	Annotations   string              `yaml:"pod-annotations,omitempty"`
+	KubeEvents    bool                `yaml:"kube-events,omitempty"`
+	DebugEnds     bool                `yaml:"debug-endpoints,omitempty"`
+	DebugListen   string              `yaml:"debug-listener,omitempty"`
+	PodStatus     bool                `yaml:"pod-status,omitempty"`
+	ReadyFile     string              `yaml:"ready-file,omitempty"`
+	Leader        string              `yaml:"leader-election,omitempty"`
+	LeaderLease   time.Duration       `yaml:"leader-lease-duration,omitempty"`
+	LogFormat     string              `yaml:"log-format,omitempty"`
+	Tracing       string              `yaml:"tracing-endpoint,omitempty"`
+	AuditLog      string              `yaml:"audit-log,omitempty"`
+	Statsd        string              `yaml:"statsd-address,omitempty"`
+	StatsdFormat  string              `yaml:"statsd-format,omitempty"`
+	PushGateway   string              `yaml:"metrics-push-gateway,omitempty"`
+	PushJob       string              `yaml:"metrics-push-job,omitempty"`
+	Textfile      string              `yaml:"metrics-textfile,omitempty"`
+	Command       []string            `yaml:"command,omitempty"`
+	ChildKill     time.Duration       `yaml:"child-kill-timeout,omitempty"`
+	ChildOnChange string              `yaml:"child-on-change,omitempty"`
+	ReadyExec     string              `yaml:"ready-exec,omitempty"`
+	RotationExec  string              `yaml:"rotation-exec,omitempty"`
+	Settle        time.Duration       `yaml:"rotation-settle,omitempty"`
+	ChildSignal   string              `yaml:"child-signal,omitempty"`
+	Resources     []effectiveResource `yaml:"resources"`
+}
+
+// effectiveAuth is the printable view of the authentication options
+type effectiveAuth struct {
+	Method   string `yaml:"method"`
+	File     string `yaml:"file,omitempty"`
+	Format   string `yaml:"format,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+	RoleID   string `yaml:"role_id,omitempty"`
+	SecretID string `yaml:"secret_id,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// effectiveResource is the printable view of a resource
+type effectiveResource struct {
+	ID         string            `yaml:"id"`
+	Resource   string            `yaml:"resource"`
+	Path       string            `yaml:"path"`
+	Format     string            `yaml:"format"`
+	Filename   string            `yaml:"filename"`
+	Template   string            `yaml:"template,omitempty"`
+	Syntax     string            `yaml:"template-syntax,omitempty"`
+	Mode       string            `yaml:"mode"`
+	Modes      map[string]string `yaml:"modes,omitempty"`
+	Renew      bool              `yaml:"renew"`
+	Revoke     bool              `yaml:"revoke"`
+	Update     time.Duration     `yaml:"update,omitempty"`
+	CacheTTL   time.Duration     `yaml:"cache-ttl,omitempty"`
+	Create     bool              `yaml:"create,omitempty"`
+	Exec       string            `yaml:"exec,omitempty"`
+	VerifyExec string            `yaml:"verify-exec,omitempty"`
+	ExecDryRun bool              `yaml:"exec-dry-run,omitempty"`
+	ExecStdin  bool              `yaml:"exec-stdin,omitempty"`
+	SignalProc string            `yaml:"signal-process,omitempty"`
+	Signal     string            `yaml:"signal,omitempty"`
+	Restart    string            `yaml:"restart,omitempty"`
+	ReloadSig  string            `yaml:"reload-signal,omitempty"`
+	RestartChg bool              `yaml:"restart-on-change,omitempty"`
+	Tmpfs      bool              `yaml:"require-tmpfs,omitempty"`
+	Checksum   bool              `yaml:"checksum,omitempty"`
+	OnDelete   string            `yaml:"on-delete,omitempty"`
+	Retries    int               `yaml:"retries,omitempty"`
+	OnFailure  string            `yaml:"on-failure"`
+	FirstFetch time.Duration     `yaml:"first-fetch-timeout,omitempty"`
+	Backoff    time.Duration     `yaml:"backoff,omitempty"`
+	BackoffMax time.Duration     `yaml:"backoff-max,omitempty"`
+	Jitter     time.Duration     `yaml:"jitter,omitempty"`
+	JitterPct  string            `yaml:"jitter-percentage,omitempty"`
+	Renewal    string            `yaml:"renewal,omitempty"`
+	RotateWith []string          `yaml:"rotate-with,omitempty"`
+	DependsOn  []string          `yaml:"depends_on,omitempty"`
+	Trigger    string            `yaml:"trigger,omitempty"`
+	Layout     string            `yaml:"layout,omitempty"`
+	Versions   int               `yaml:"versions,omitempty"`
+	Keys       []string          `yaml:"keys,omitempty"`
+	Rename     map[string]string `yaml:"rename,omitempty"`
+	Grace      time.Duration     `yaml:"grace,omitempty"`
+	Owner      string            `yaml:"owner,omitempty"`
+	Group      string            `yaml:"group,omitempty"`
+	Env        bool              `yaml:"env,omitempty"`
+	EnvOnly    bool              `yaml:"env-only,omitempty"`
+	Passphrase string            `yaml:"passphrase,omitempty"`
+	KeyPass    string            `yaml:"key_passphrase,omitempty"`
+	Order      []string          `yaml:"order,omitempty"`
+	Chain      string            `yaml:"chain,omitempty"`
+	Alias      string            `yaml:"alias,omitempty"`
+	CAAlias    string            `yaml:"ca-alias,omitempty"`
+	DBHost     string            `yaml:"db-host,omitempty"`
+	DBPort     string            `yaml:"db-port,omitempty"`
+	DBName     string            `yaml:"db-name,omitempty"`
+	Options    map[string]string `yaml:"options,omitempty"`
+}
+
+// newEffectiveConfig builds the printable view of the configuration, masking anything secret bearing
+func newEffectiveConfig(cfg *config) *effectiveConfig {
+	auth := cfg.vaultAuthOptions
+	effective := &effectiveConfig{
+		Vault:         cfg.vaultURL,
+		CACert:        cfg.vaultCaFile,
+		SkipTLSVerify: cfg.skipTLSVerify,
+		Auth: effectiveAuth{
+			Method:   auth.Method,
+			File:     cfg.vaultAuthFile,
+			Format:   cfg.vaultAuthFileFormat,
+			Token:    mask(auth.Token),
+			RoleID:   auth.RoleID,
+			SecretID: mask(auth.SecretID),
+			Username: auth.Username,
+			Password: mask(auth.Password),
+		},
+		RenewToken:    cfg.vaultRenewToken,
+		TokenMargin:   cfg.tokenRenewalMargin.String(),
+		RefetchReauth: cfg.refetchOnReauth,
+		Output:        cfg.outputDir,
+		Umask:         cfg.umask.String(),
+		DirMode:       cfg.dirMode.String(),
+		RefuseOutput:  cfg.refuseWritableOutput,
+		DryRun:        cfg.dryRun,
+		OneShot:       cfg.oneShot,
+		StatsInterval: cfg.statsInterval,
+		ExecTimeout:   cfg.execTimeout,
+		ExecDryRun:    cfg.execDryRun,
+		TriggerCheck:  cfg.triggerInterval,
+		MetricsPort:   cfg.metricsPort,
+		MetricsAddr:   cfg.metricsListen,
+		MetricsListen: cfg.metricsListeners,
+		MetricsLabels: cfg.metricsLabels,
+		MetricsCert:   cfg.metricsTLSCert,
+		MetricsKey:    cfg.metricsTLSKey,
+		MetricsBasic:  cfg.metricsBasicAuthFile,
+		MetricsBearer: cfg.metricsBearerTokenFile,
+		AdminSocket:   cfg.adminSocket,
+		KeystorePass:  maskPassphrase(cfg.keystorePassphrase),
+		LintSecrets:   cfg.lintSecrets,
+		SkipUnchanged: cfg.skipUnchanged,
+		RequireTmpfs:  cfg.requireTmpfs,
+		Shred:         cfg.shredFiles,
+		Checksums:     cfg.checksumFiles,
+		ExpiryGrace:   cfg.expiryGrace,
+		ExpiryHook:    cfg.expiryWebhook,
+		Breaker:       cfg.breakerThreshold,
+		BreakerCool:   cfg.breakerCooldown,
+		FetchLimit:    cfg.fetchConcurrency,
+		ExecLimit:     cfg.execConcurrency,
+		FetchStagger:  cfg.fetchStagger,
+		MaxRetries:    cfg.maxRetries,
+		StartupTime:   cfg.startupTimeout,
+		RetryBackoff:  cfg.retryBackoff,
+		RetryMax:      cfg.retryBackoffMax,
+		Renewal:       cfg.renewal.String(),
+		RenewalJitter: cfg.renewalJitter.String(),
+		StateFile:     cfg.stateFile,
+		StateKeyFile:  cfg.stateKeyFile,
+		ServeSocket:   cfg.serveSocket,
+		FuseMount:     cfg.fuseMount,
+		ServeUIDs:     cfg.serveUIDs,
+		DeleteOnExit:  cfg.deleteOnExit,
+		MaxValueSize:  cfg.maxValueSize,
+		SensitiveKeys: cfg.sensitiveKeys,
+		OutputGC:      cfg.outputGC,
+		OutputGCDry:   cfg.outputGCDryRun,
+		ResourcesYAML: cfg.resourcesYAML,
+		ConfigMap:     cfg.resourcesConfigMap,
+		Annotations:   cfg.podAnnotations,
+		KubeEvents:    cfg.kubeEvents,
+		DebugEnds:     cfg.debugEndpoints,
+		DebugListen:   cfg.debugListener,
+		PodStatus:     cfg.podStatus,
+		ReadyFile:     cfg.readyFile,
+		Leader:        cfg.leaderElection,
+		LeaderLease:   cfg.leaderLeaseDuration,
+		LogFormat:     cfg.logFormat,
+		Tracing:       cfg.tracingEndpoint,
+		AuditLog:      cfg.auditLog,
+		Statsd:        cfg.statsdAddress,
+		StatsdFormat:  cfg.statsdFormat,
+		PushGateway:   cfg.metricsPushGateway,
+		PushJob:       cfg.metricsPushJob,
+		Textfile:      cfg.metricsTextfile,
+		Command:       cfg.childCommand,
+		ChildKill:     cfg.childKillTimeout,
+		ChildOnChange: cfg.childOnChange,
+		ReadyExec:     cfg.readyExec,
+		RotationExec:  cfg.rotationExec,
+		Settle:        cfg.rotationSettle,
+		ChildSignal:   cfg.childSignal,
+	}
+	for _, rn := range cfg.resources.items {
+		effective.Resources = append(effective.Resources, newEffectiveResource(rn))
+	}
+
+	return effective
+}
+
+// newEffectiveResource builds the printable view of a resource, masking anything secret bearing
+func newEffectiveResource(rn *VaultResource) effectiveResource {
+	return effectiveResource{
+		ID:         rn.ID(),
+		Resource:   rn.Resource,
+		Path:       rn.Path,
+		Format:     rn.Format,
+		Filename:   resourceFilename(rn),
+		Template:   rn.TemplateFile,
+		Syntax:     rn.TemplateSyntax,
+		Mode:       fmt.Sprintf("%#o", rn.FileMode),
+		Modes:      fileModeStrings(rn.FileModes),
+		Renew:      rn.Renewable,
+		Revoke:     rn.Revoked,
+		Update:     rn.Update,
+		CacheTTL:   rn.CacheTTL,
+		Create:     rn.Create,
+		Exec:       strings.Join(rn.ExecPath, " "),
+		VerifyExec: strings.Join(rn.VerifyExecPath, " "),
+		ExecDryRun: rn.ExecDryRun,
+		ExecStdin:  rn.ExecStdin,
+		SignalProc: rn.SignalProcess,
+		Signal:     rn.Signal,
+		Restart:    rn.Restart,
+		ReloadSig:  rn.ReloadSignal,
+		RestartChg: rn.RestartOnChange,
+		Tmpfs:      requireTmpfs(rn),
+		Checksum:   checksumFiles(rn),
+		OnDelete:   strings.Join(rn.OnDeletePath, " "),
+		Retries:    rn.MaxRetries,
+		OnFailure:  failurePolicy(rn),
+		FirstFetch: firstFetchTimeout(rn),
+		Backoff:    rn.Backoff,
+		BackoffMax: rn.BackoffMax,
+		Jitter:     rn.MaxJitter,
+		JitterPct:  percentOption(rn.JitterFraction).String(),
+		Renewal:    percentOption(rn.RenewalFraction).String(),
+		RotateWith: rn.RotateWith,
+		DependsOn:  rn.DependsOn,
+		Trigger:    rn.TriggerFile,
+		Layout:     rn.Layout,
+		Versions:   rn.Versions,
+		Keys:       rn.Keys,
+		Rename:     rn.Rename,
+		Grace:      rn.GracePeriod,
+		Owner:      rn.Owner,
+		Group:      rn.Group,
+		Env:        rn.Env,
+		EnvOnly:    rn.EnvOnly,
+		Passphrase: maskPassphrase(rn.Passphrase),
+		KeyPass:    maskPassphrase(rn.KeyPassphrase),
+		Order:      rn.Order,
+		Chain:      chainString(rn.Chain),
+		Alias:      rn.KeyAlias,
+		CAAlias:    rn.CAAlias,
+		DBHost:     rn.DBHost,
+		DBPort:     rn.DBPort,
+		DBName:     rn.DBName,
+		Options:    rn.Options,
+	}
+}
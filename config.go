@@ -19,11 +19,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -53,6 +56,44 @@ type config struct {
 	vaultAuthOptions *vaultAuthOptions
 	// renew the token based on ttl
 	vaultRenewToken bool
+	// the share of the token's ttl left when it's refreshed
+	tokenRenewalMargin percentOption
+	// skip rewriting the files, and the exec, when their content is unchanged
+	skipUnchanged bool
+	// log the hooks of the resources rather than running them
+	execDryRun bool
+	// re-fetch all the resources when a new token is acquired
+	refetchOnReauth bool
+	// whether to raise kubernetes events on the pod when resources rotate or fail repeatedly
+	kubeEvents bool
+	// whether to serve the pprof and expvar endpoints alongside the metrics
+	debugEndpoints bool
+	// an address to serve the pprof and expvar endpoints on, apart from the metrics
+	debugListener string
+	// whether to patch the status of the resources into an annotation of the pod
+	podStatus bool
+	// the sentinel file which exists while every resource is written out and fresh
+	readyFile string
+	// the lease, NAME or NAMESPACE/NAME, the sidekicks sharing it elect a leader with
+	leaderElection string
+	// how long the leader election lease is held without being renewed
+	leaderLeaseDuration time.Duration
+	// the format of the log lines, text or json
+	logFormat string
+	// the url of the otlp collector the spans of the rotations are exported to
+	tracingEndpoint string
+	// an append-only file recording the files written and the hooks run
+	auditLog string
+	// the host:port of a statsd agent the metrics are pushed to as well
+	statsdAddress string
+	// the format of the statsd metrics, statsd or dogstatsd
+	statsdFormat string
+	// the url of a pushgateway the metrics are pushed to on exit
+	metricsPushGateway string
+	// the job the metrics are pushed under
+	metricsPushJob string
+	// a file the metrics are written to on exit, for the textfile collector of the node exporter
+	metricsTextfile string
 	// the vault ca file
 	vaultCaFile string
 	// the place to write the resources
@@ -73,12 +114,191 @@ type config struct {
 	oneShot bool
 	// resources YAML file
 	resourcesYAML string
+	// the resources read from the resources yaml file, which are replaced on a reload
+	resourcesFromYAML []*VaultResource
+	// the configmap, NAME or NAMESPACE/NAME, holding resources in the resources yaml format
+	resourcesConfigMap string
+	// the downward api annotations file, or api, the resources are read from the annotations of the pod
+	podAnnotations string
 	// Prometheus metrics port
 	metricsPort uint
+	// the address the metrics are served on, in place of all the interfaces on the metrics port
+	metricsListen string
+	// the interval to check the trigger files of resources
+	triggerInterval time.Duration
+	// the unix socket to serve the admin api on
+	adminSocket string
+	// additional addresses or unix sockets to serve the metrics on
+	metricsListeners listOptions
+	// the static labels, key=value, added to every metric
+	metricsLabels listOptions
+	// the certificate and key files the metrics are served over tls with
+	metricsTLSCert, metricsTLSKey string
+	// a file holding the username:password the metrics require
+	metricsBasicAuthFile string
+	// a file holding a bearer token the metrics require
+	metricsBearerTokenFile string
+	// the command and arguments of the supervised process
+	childCommand []string
+	// how long the supervised process is given to exit before it's killed
+	childKillTimeout time.Duration
+	// the command run once every resource has first been rendered
+	readyExec string
+	// the command run once a batch of rotations has settled
+	rotationExec string
+	// how long the rotations are quiet for before the rotation exec is run
+	rotationSettle time.Duration
+	// what is done to the supervised process when its secrets rotate, restart, signal or none
+	childOnChange string
+	// the signal sent to the supervised process when child on change is signal
+	childSignal string
+	// the passphrase protecting keystores, when the resource does not specify one
+	keystorePassphrase string
+	// warn when secrets look like placeholders or test data
+	lintSecrets bool
+	// remove files from the output directory which were not written by the sidekick
+	outputGC bool
+	// log the files which would be removed from the output directory rather than removing them
+	outputGCDryRun bool
+	// the largest value of a secret which will be written, in bytes, zero for no limit
+	maxValueSize int64
+	// the patterns of the key names which are masked in the logs and the status
+	sensitiveKeys listOptions
+	// the umask of the process, left as inherited when not set
+	umask modeOption
+	// the mode of the directories the sidekick creates
+	dirMode modeOption
+	// refuse to start when the output directory is group or world writable
+	refuseWritableOutput bool
+	// refuse to write secrets to a filesystem other than tmpfs or ramfs
+	requireTmpfs bool
+	// overwrite the content of secret files before removing them
+	shredFiles bool
+	// remove the files written when shutting down
+	deleteOnExit bool
+	// write a sha256 checksum file next to each file written
+	checksumFiles bool
+	// the window before a secret expires, without having been renewed, it's alerted on
+	expiryGrace time.Duration
+	// the url the expiry alerts are posted to
+	expiryWebhook string
+	// the consecutive failed requests to vault which open the circuit breaker, zero disabling it
+	breakerThreshold int
+	// how long the circuit breaker holds requests back for
+	breakerCooldown time.Duration
+	// the most resources whose first retrieval is released each fetch-stagger, zero for no limit
+	fetchConcurrency int
+	// the most hooks run at once, zero for no limit
+	execConcurrency int
+	// the interval between the releases of the first retrievals
+	fetchStagger time.Duration
+	// the retries of resources which don't set their own, zero retrying indefinitely
+	maxRetries int
+	// the deadline for the first successful retrieval of resources which don't set their own, zero waiting indefinitely
+	startupTimeout time.Duration
+	// the first delay before retrying a failed resource, doubled on each failure
+	retryBackoff time.Duration
+	// the longest delay before retrying a failed resource
+	retryBackoffMax time.Duration
+	// the percentage of the ttl resources are renewed at, when they don't set one
+	renewal percentOption
+	// the jitter as a percentage of the renewal time, when the resources don't set one
+	renewalJitter percentOption
+	// the file the vault token and leases are persisted to across restarts
+	stateFile string
+	// the file holding the key the state file is encrypted with
+	stateKeyFile string
+	// the unix socket the secrets are served on from memory, rather than written to disk
+	serveSocket string
+	// the directory the secrets are mounted on with fuse, from memory, rather than written to disk
+	fuseMount string
+	// the uids allowed to read the secrets from the socket
+	serveUIDs listOptions
 }
 
 type VaultResourcesYAML []*VaultResource
 
+// listOptions is a command line option which can be repeated, or given as a comma separated list
+type listOptions []string
+
+// Set adds the values to the list
+func (l *listOptions) Set(value string) error {
+	for _, x := range strings.Split(value, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			*l = append(*l, x)
+		}
+	}
+
+	return nil
+}
+
+// String returns a string representation of the list
+func (l listOptions) String() string {
+	return strings.Join(l, ",")
+}
+
+// percentOption is a command line option holding a percentage, i.e. 75%, kept as a fraction
+type percentOption float64
+
+// Set parses the percentage, an empty value leaving the option unset
+func (p *percentOption) Set(value string) error {
+	if value == "" {
+		*p = 0
+		return nil
+	}
+	fraction, err := parsePercentage(value)
+	if err != nil {
+		return err
+	}
+	*p = percentOption(fraction)
+
+	return nil
+}
+
+// String returns the percentage, empty when not set
+func (p percentOption) String() string {
+	if p == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(float64(p)*100, 'f', -1, 64) + "%"
+}
+
+// modeOption is a command line option holding an octal file mode, i.e. 0755
+type modeOption struct {
+	// the mode
+	mode os.FileMode
+	// whether the option was given
+	set bool
+}
+
+// Set parses the octal mode, an empty value leaving the option unset
+func (m *modeOption) Set(value string) error {
+	if value == "" {
+		m.mode, m.set = 0, false
+		return nil
+	}
+	for len(value) < 4 {
+		value = "0" + value
+	}
+	mode, err := parseFileMode(value)
+	if err != nil {
+		return err
+	}
+	m.mode, m.set = mode, true
+
+	return nil
+}
+
+// String returns the mode in octal, empty when not set
+func (m modeOption) String() string {
+	if !m.set {
+		return ""
+	}
+
+	return fmt.Sprintf("%#04o", m.mode)
+}
+
 var (
 	options config
 )
@@ -116,6 +336,76 @@ func init() {
 		defaultExecTimeout = time.Duration(60) * time.Second
 	}
 
+	defaultTriggerInterval, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_TRIGGER_INTERVAL", "5s"))
+	if err != nil {
+		defaultTriggerInterval = time.Duration(5) * time.Second
+	}
+
+	defaultChildKillTimeout, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_CHILD_KILL_TIMEOUT", "10s"))
+	if err != nil {
+		defaultChildKillTimeout = time.Duration(10) * time.Second
+	}
+
+	defaultRotationSettle, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_ROTATION_SETTLE", "5s"))
+	if err != nil {
+		defaultRotationSettle = time.Duration(5) * time.Second
+	}
+
+	defaultLeaderLeaseDuration, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_LEADER_LEASE_DURATION", "15s"))
+	if err != nil {
+		defaultLeaderLeaseDuration = time.Duration(15) * time.Second
+	}
+
+	defaultExpiryGrace, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_EXPIRY_GRACE", "0s"))
+	if err != nil {
+		defaultExpiryGrace = 0
+	}
+
+	defaultBreakerThreshold, err := strconv.ParseInt(getEnv("VAULT_SIDEKICK_BREAKER_THRESHOLD", "5"), 10, 32)
+	if err != nil {
+		defaultBreakerThreshold = 5
+	}
+
+	defaultBreakerCooldown, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_BREAKER_COOLDOWN", "30s"))
+	if err != nil {
+		defaultBreakerCooldown = 30 * time.Second
+	}
+
+	defaultFetchConcurrency, err := strconv.ParseInt(getEnv("VAULT_SIDEKICK_FETCH_CONCURRENCY", "0"), 10, 32)
+	if err != nil {
+		defaultFetchConcurrency = 0
+	}
+
+	defaultExecConcurrency, err := strconv.ParseInt(getEnv("VAULT_SIDEKICK_EXEC_CONCURRENCY", "0"), 10, 32)
+	if err != nil {
+		defaultExecConcurrency = 0
+	}
+
+	defaultFetchStagger, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_FETCH_STAGGER", "100ms"))
+	if err != nil {
+		defaultFetchStagger = 100 * time.Millisecond
+	}
+
+	defaultMaxRetries, err := strconv.ParseInt(getEnv("VAULT_SIDEKICK_MAX_RETRIES", "0"), 10, 32)
+	if err != nil {
+		defaultMaxRetries = 0
+	}
+
+	defaultStartupTimeout, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_STARTUP_TIMEOUT", "0s"))
+	if err != nil {
+		defaultStartupTimeout = 0
+	}
+
+	defaultRetryBackoff, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_RETRY_BACKOFF", "10s"))
+	if err != nil {
+		defaultRetryBackoff = time.Duration(10) * time.Second
+	}
+
+	defaultRetryBackoffMax, err := time.ParseDuration(getEnv("VAULT_SIDEKICK_RETRY_BACKOFF_MAX", "1h"))
+	if err != nil {
+		defaultRetryBackoffMax = time.Hour
+	}
+
 	defaultOneShot, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_ONE_SHOT", "false"))
 	if err != nil {
 		defaultOneShot = false
@@ -126,21 +416,182 @@ func init() {
 		defaultMetricsPort = 9092
 	}
 
+	defaultLintSecrets, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_LINT_SECRETS", "false"))
+	if err != nil {
+		defaultLintSecrets = false
+	}
+
+	defaultOutputGC, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_OUTPUT_GC", "false"))
+	if err != nil {
+		defaultOutputGC = false
+	}
+
+	defaultOutputGCDryRun, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_OUTPUT_GC_DRY_RUN", "false"))
+	if err != nil {
+		defaultOutputGCDryRun = false
+	}
+
+	defaultMaxValueSize, err := strconv.ParseInt(getEnv("VAULT_SIDEKICK_MAX_VALUE_SIZE", "0"), 10, 64)
+	if err != nil {
+		defaultMaxValueSize = 0
+	}
+
+	defaultSkipUnchanged, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_SKIP_UNCHANGED", "false"))
+	if err != nil {
+		defaultSkipUnchanged = false
+	}
+
+	defaultExecDryRun, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_EXEC_DRY_RUN", "false"))
+	if err != nil {
+		defaultExecDryRun = false
+	}
+
+	defaultRefetchOnReauth, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_REFETCH_ON_REAUTH", "false"))
+	if err != nil {
+		defaultRefetchOnReauth = false
+	}
+
+	defaultKubeEvents, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_KUBE_EVENTS", "false"))
+	if err != nil {
+		defaultKubeEvents = false
+	}
+
+	defaultDebugEndpoints, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_DEBUG_ENDPOINTS", "false"))
+	if err != nil {
+		defaultDebugEndpoints = false
+	}
+
+	defaultPodStatus, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_POD_STATUS", "false"))
+	if err != nil {
+		defaultPodStatus = false
+	}
+
+	defaultRefuseWritableOutput, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_REFUSE_WRITABLE_OUTPUT", "false"))
+	if err != nil {
+		defaultRefuseWritableOutput = false
+	}
+
+	defaultRequireTmpfs, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_REQUIRE_TMPFS", "false"))
+	if err != nil {
+		defaultRequireTmpfs = false
+	}
+
+	defaultShredFiles, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_SHRED", "false"))
+	if err != nil {
+		defaultShredFiles = false
+	}
+
+	defaultDeleteOnExit, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_DELETE_ON_EXIT", "false"))
+	if err != nil {
+		defaultDeleteOnExit = false
+	}
+
+	defaultChecksumFiles, err := strconv.ParseBool(getEnv("VAULT_SIDEKICK_CHECKSUM_FILES", "false"))
+	if err != nil {
+		defaultChecksumFiles = false
+	}
+
 	flag.StringVar(&options.vaultURL, "vault", getEnv("VAULT_ADDR", "https://127.0.0.1:8200"), "url the vault service or VAULT_ADDR")
 	flag.StringVar(&options.vaultAuthFile, "auth", getEnv("AUTH_FILE", ""), "a configuration file in json or yaml containing authentication arguments")
 	flag.BoolVar(&options.vaultRenewToken, "renew-token", defaultRenewToken, "renew vault token according to its ttl")
+	if err := options.tokenRenewalMargin.Set(getEnv("VAULT_SIDEKICK_TOKEN_RENEWAL_MARGIN", "50%")); err != nil {
+		options.tokenRenewalMargin.Set("50%")
+	}
+	flag.Var(&options.tokenRenewalMargin, "token-renewal-margin", "the percentage of the token's ttl still left when renew-token refreshes it, a larger margin refreshing it sooner")
+	flag.BoolVar(&options.kubeEvents, "kube-events", defaultKubeEvents, "raise kubernetes events on the pod when resources are rotated or fail repeatedly")
+	flag.BoolVar(&options.debugEndpoints, "debug-endpoints", defaultDebugEndpoints, "serve the pprof and expvar endpoints, under /debug, alongside the metrics")
+	flag.StringVar(&options.debugListener, "debug-listener", getEnv("VAULT_SIDEKICK_DEBUG_LISTENER", ""), "an address, i.e. localhost:6060, to serve the pprof and expvar endpoints on apart from the metrics")
+	flag.BoolVar(&options.podStatus, "pod-status", defaultPodStatus, "patch the status of the resources into the vault-sidekick.io/status annotation of the pod")
+	flag.StringVar(&options.readyFile, "ready-file", getEnv("VAULT_SIDEKICK_READY_FILE", ""), "a sentinel file created once every resource has been written, and removed while any is unwritten, expired or stale")
+	flag.StringVar(&options.leaderElection, "leader-election", getEnv("VAULT_SIDEKICK_LEADER_ELECTION", ""), "the lease, NAME or NAMESPACE/NAME, the replicas sharing it elect a leader with; only the leader retrieves and writes the resources, the others standing by")
+	flag.DurationVar(&options.leaderLeaseDuration, "leader-lease-duration", defaultLeaderLeaseDuration, "how long the leader election lease is held without being renewed, before another replica takes over")
+	flag.StringVar(&options.logFormat, "log-format", getEnv("VAULT_SIDEKICK_LOG_FORMAT", logFormatText), "the format of the log lines, text as glog writes them or json, the values of the secrets being redacted from the lines logged against a resource or request, and from every line in json")
+	flag.StringVar(&options.tracingEndpoint, "tracing-endpoint", getEnv("VAULT_SIDEKICK_TRACING_ENDPOINT", getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")), "the url of an otlp/http collector, i.e. http://otel-collector:4318, to export the spans of the fetch, write and exec of each rotation to")
+	flag.StringVar(&options.auditLog, "audit-log", getEnv("VAULT_SIDEKICK_AUDIT_LOG", ""), "an append-only file recording, as json lines, every render, file write with its sha256, permission change and hook run")
+	flag.BoolVar(&options.refetchOnReauth, "refetch-on-reauth", defaultRefetchOnReauth, "re-fetch all the resources when renewing the token logs in afresh, as the leases of the old token may have been revoked")
 	flag.StringVar(&options.vaultAuthFileFormat, "format", getEnv("AUTH_FORMAT", "default"), "the auth file format")
-	flag.StringVar(&options.outputDir, "output", getEnv("VAULT_OUTPUT", "/etc/secrets"), "the full path to write resources or VAULT_OUTPUT")
+	flag.StringVar(&options.outputDir, "output", getEnv("VAULT_OUTPUT", "/etc/secrets"), "the full path to write resources or VAULT_OUTPUT, stdout to print them")
+	if err := options.umask.Set(getEnv("VAULT_SIDEKICK_UMASK", "")); err != nil {
+		options.umask.Set("")
+	}
+	flag.Var(&options.umask, "umask", "the umask of the process in octal, i.e. 0077, applied before anything is written; inherited when not given")
+	if err := options.dirMode.Set(getEnv("VAULT_SIDEKICK_DIR_MODE", "0755")); err != nil {
+		options.dirMode.Set("0755")
+	}
+	flag.Var(&options.dirMode, "dir-mode", "the mode in octal of the directories the sidekick creates, the output directory included when missing")
+	flag.BoolVar(&options.refuseWritableOutput, "refuse-writable-output", defaultRefuseWritableOutput, "refuse to start when the output directory is group or world writable")
+	flag.BoolVar(&options.requireTmpfs, "require-tmpfs", defaultRequireTmpfs, "refuse to write secrets to a filesystem other than tmpfs or ramfs, unless the resource overrides it")
+	flag.BoolVar(&options.shredFiles, "shred", defaultShredFiles, "overwrite the content of secret files with zeros before removing them, best effort")
+	flag.BoolVar(&options.deleteOnExit, "delete-on-exit", defaultDeleteOnExit, "remove the files written by the sidekick when it's terminated")
+	flag.BoolVar(&options.checksumFiles, "checksum-files", defaultChecksumFiles, "write a sha256 checksum file, FILE.sha256, next to each file written")
+	flag.StringVar(&options.stateFile, "state-file", getEnv("VAULT_SIDEKICK_STATE_FILE", ""), "persist the vault token and the leases of renewable resources to the file, encrypted, so a restart resumes them rather than issuing new credentials")
+	flag.StringVar(&options.stateKeyFile, "state-key-file", getEnv("VAULT_SIDEKICK_STATE_KEY_FILE", ""), "the file holding the 32 byte key, raw or base64 encoded, the state-file is encrypted with")
+	flag.StringVar(&options.fuseMount, "fuse-mount", getEnv("VAULT_SIDEKICK_FUSE_MOUNT", ""), "mount the secrets on the directory with fuse, read only files served from memory, rather than writing them to disk")
+	flag.StringVar(&options.serveSocket, "serve-socket", getEnv("VAULT_SIDEKICK_SERVE_SOCKET", ""), "serve the secrets from memory over http on the unix socket rather than writing them to disk")
+	options.serveUIDs.Set(getEnv("VAULT_SIDEKICK_SERVE_UIDS", ""))
+	flag.Var(&options.serveUIDs, "serve-uid", "a uid allowed to read the secrets from the serve-socket, can be repeated; the uid of the sidekick when not given")
+	if err := options.renewal.Set(getEnv("VAULT_SIDEKICK_RENEWAL", "")); err != nil {
+		options.renewal.Set("")
+	}
+	flag.DurationVar(&options.expiryGrace, "expiry-grace", defaultExpiryGrace, "alert when a secret is within the window of expiring without having been renewed, zero disabling the alerts")
+	flag.StringVar(&options.expiryWebhook, "expiry-webhook", getEnv("VAULT_SIDEKICK_EXPIRY_WEBHOOK", ""), "a url the expiry alerts are posted to as json")
+	flag.IntVar(&options.breakerThreshold, "breaker-threshold", int(defaultBreakerThreshold), "the consecutive failed requests to vault, across the resources, which hold further requests back for the breaker-cooldown, zero disabling it")
+	flag.DurationVar(&options.breakerCooldown, "breaker-cooldown", defaultBreakerCooldown, "how long requests are held back once vault is failing, before a single canary request is let through")
+	flag.IntVar(&options.execConcurrency, "exec-concurrency", int(defaultExecConcurrency), "the most exec, verify-exec, on-delete and global hooks run at once, zero for no limit; the same command never runs twice at once regardless")
+	flag.IntVar(&options.fetchConcurrency, "fetch-concurrency", int(defaultFetchConcurrency), "the most resources retrieved for the first time each fetch-stagger, zero retrieving them all at once")
+	flag.DurationVar(&options.fetchStagger, "fetch-stagger", defaultFetchStagger, "the interval between the batches of first retrievals when fetch-concurrency is set")
+	flag.DurationVar(&options.startupTimeout, "startup-timeout", defaultStartupTimeout, "the deadline for the first successful retrieval of resources without a first-fetch-timeout, exiting non-zero once it passes, zero waiting indefinitely")
+	flag.IntVar(&options.maxRetries, "max-retries", int(defaultMaxRetries), "the times a failing resource is retried when it doesn't set retries, zero retrying indefinitely")
+	flag.DurationVar(&options.retryBackoff, "retry-backoff", defaultRetryBackoff, "the first delay before retrying a failed resource, doubled on each failure up to the retry-backoff-max")
+	flag.DurationVar(&options.retryBackoffMax, "retry-backoff-max", defaultRetryBackoffMax, "the longest delay before retrying a failed resource, a random delay up to it being taken")
+	flag.Var(&options.renewal, "renewal", "the percentage of the ttl of a lease or certificate resources are renewed at, i.e. 75%, rather than between 80 and 95%")
+	if err := options.renewalJitter.Set(getEnv("VAULT_SIDEKICK_RENEWAL_JITTER", "")); err != nil {
+		options.renewalJitter.Set("")
+	}
+	flag.Var(&options.renewalJitter, "renewal-jitter", "the most taken off the renewal time at random, as a percentage of it, for resources without a jitter")
 	flag.BoolVar(&options.dryRun, "dryrun", defaultDryRun, "perform a dry run, printing the content to screen")
 	flag.BoolVar(&options.skipTLSVerify, "tls-skip-verify", defaultSkipTLSVerify, "whether to check and verify the vault service certificate")
 	flag.StringVar(&options.vaultCaFile, "ca-cert", getEnv("VAULT_SIDEKICK_CA_CERT", ""), "the path to the file container the CA used to verify the vault service")
 	flag.DurationVar(&options.statsInterval, "stats", defaultStatsInterval, "the interval to produce statistics on the accessed resources")
 	flag.DurationVar(&options.execTimeout, "exec-timeout", defaultExecTimeout, "the timeout applied to commands on the exec option")
+	flag.BoolVar(&options.execDryRun, "exec-dry-run", defaultExecDryRun, "log the exec, verify-exec and on-delete commands of the resources rather than running them")
+	flag.DurationVar(&options.triggerInterval, "trigger-interval", defaultTriggerInterval, "the interval to check the trigger files of resources for changes")
+	flag.StringVar(&options.readyExec, "ready-exec", getEnv("VAULT_SIDEKICK_READY_EXEC", ""), "a command run once every resource has first been rendered, i.e. to start the application or flip a readiness flag")
+	flag.StringVar(&options.rotationExec, "rotation-exec", getEnv("VAULT_SIDEKICK_ROTATION_EXEC", ""), "a command run once the resources rotating together have settled, to reload the files of several resources as one")
+	flag.DurationVar(&options.rotationSettle, "rotation-settle", defaultRotationSettle, "how long the rotations must be quiet for before the rotation-exec is run")
+	flag.StringVar(&options.childOnChange, "child-on-change", getEnv("VAULT_SIDEKICK_CHILD_ON_CHANGE", childRestart), "what is done to the supervised process when its secrets rotate, restart to re-execute it with the new environment, signal it with the child-signal or none")
+	flag.StringVar(&options.childSignal, "child-signal", getEnv("VAULT_SIDEKICK_CHILD_SIGNAL", "HUP"), "the signal sent to the supervised process when the child-on-change is signal")
+	flag.DurationVar(&options.childKillTimeout, "child-kill-timeout", defaultChildKillTimeout, "how long the supervised process is given to exit on a re-exec before it's killed")
 	flag.BoolVar(&options.showVersion, "version", false, "show the vault-sidekick version")
 	flag.Var(options.resources, "cn", "a resource to retrieve and monitor from vault")
 	flag.BoolVar(&options.oneShot, "one-shot", defaultOneShot, "retrieve resources from vault once and then exit")
 	flag.StringVar(&options.resourcesYAML, "resources-yaml", getEnv("VAULT_SIDEKICK_RESOURCES_YAML", ""), "a YAML file containing a list of resources to retrieve and monitor from vault")
+	flag.StringVar(&options.podAnnotations, "pod-annotations", getEnv("VAULT_SIDEKICK_POD_ANNOTATIONS", ""), "read resources from the vault-sidekick.io/cn-N annotations of the pod, from the downward api annotations file given or api to use the kubernetes api")
+	flag.StringVar(&options.resourcesConfigMap, "resources-configmap", getEnv("VAULT_SIDEKICK_RESOURCES_CONFIGMAP", ""), "a configmap, NAME or NAMESPACE/NAME, whose keys hold resources in the resources-yaml format, watched for changes")
 	flag.UintVar(&options.metricsPort, "metrics-port", uint(defaultMetricsPort), "TCP port used to export Prometheus metrics")
+	flag.StringVar(&options.metricsListen, "metrics-listen", getEnv("VAULT_SIDEKICK_METRICS_LISTEN", ""), "the address, i.e. 127.0.0.1:9099, to export Prometheus metrics on, in place of all the interfaces on the metrics-port")
+	options.metricsListeners.Set(getEnv("VAULT_SIDEKICK_METRICS_LISTENERS", ""))
+	flag.StringVar(&options.metricsPushGateway, "metrics-push-gateway", getEnv("VAULT_SIDEKICK_METRICS_PUSH_GATEWAY", ""), "the url of a prometheus pushgateway to push the metrics to on exit, for one-shot runs which serve none")
+	flag.StringVar(&options.metricsPushJob, "metrics-push-job", getEnv("VAULT_SIDEKICK_METRICS_PUSH_JOB", prog), "the job the metrics are pushed to the pushgateway under, the hostname being the instance")
+	flag.StringVar(&options.metricsTextfile, "metrics-textfile", getEnv("VAULT_SIDEKICK_METRICS_TEXTFILE", ""), "a file, ending in .prom, to write the metrics to on exit for the textfile collector of the node exporter")
+	flag.StringVar(&options.statsdAddress, "statsd-address", getEnv("VAULT_SIDEKICK_STATSD_ADDRESS", ""), "the host:port of a statsd agent to push the metrics to over udp, as well as serving them to prometheus")
+	flag.StringVar(&options.statsdFormat, "statsd-format", getEnv("VAULT_SIDEKICK_STATSD_FORMAT", metrics.StatsdFormat), "the format of the metrics pushed to the statsd agent, statsd folding the labels into the names or dogstatsd sending them as tags")
+	flag.StringVar(&options.metricsTLSCert, "metrics-tls-cert", getEnv("VAULT_SIDEKICK_METRICS_TLS_CERT", ""), "a pem certificate file to serve the metrics over tls with, reloaded when it changes so it can be one of the resources")
+	flag.StringVar(&options.metricsTLSKey, "metrics-tls-key", getEnv("VAULT_SIDEKICK_METRICS_TLS_KEY", ""), "the pem private key file of the metrics-tls-cert")
+	flag.StringVar(&options.metricsBasicAuthFile, "metrics-basic-auth-file", getEnv("VAULT_SIDEKICK_METRICS_BASIC_AUTH_FILE", ""), "a file holding the username:password required to read the metrics")
+	flag.StringVar(&options.metricsBearerTokenFile, "metrics-bearer-token-file", getEnv("VAULT_SIDEKICK_METRICS_BEARER_TOKEN_FILE", ""), "a file holding a bearer token required to read the metrics")
+	options.metricsLabels.Set(getEnv("VAULT_SIDEKICK_METRICS_LABELS", ""))
+	flag.Var(&options.metricsLabels, "metrics-label", "a static label, key=value, added to every metric, i.e. team=payments, can be repeated")
+	flag.Var(&options.metricsListeners, "metrics-listener", "an additional address (host:port) or unix socket (unix:/path) to export Prometheus metrics on, can be repeated")
+	flag.BoolVar(&options.outputGC, "output-gc", defaultOutputGC, "treat the output directory as managed, removing any files the sidekick did not write once all resources are rendered")
+	flag.BoolVar(&options.outputGCDryRun, "output-gc-dry-run", defaultOutputGCDryRun, "log the files the output-gc option would remove rather than removing them")
+	flag.StringVar(&options.adminSocket, "admin-socket", getEnv("VAULT_SIDEKICK_ADMIN_SOCKET", defaultAdminSocket), "the unix socket to serve the admin api on, used by the status command, empty to disable")
+	flag.StringVar(&options.keystorePassphrase, "keystore-passphrase", getEnv("VAULT_SIDEKICK_KEYSTORE_PASSPHRASE", ""), "the passphrase protecting pkcs12 and jks keystores; a value, env:NAME, file:PATH or vault:PATH#KEY")
+	flag.BoolVar(&options.skipUnchanged, "skip-unchanged", defaultSkipUnchanged, "skip rewriting the files of a resource, and its exec, when their content is unchanged")
+	flag.BoolVar(&options.lintSecrets, "lint-secrets", defaultLintSecrets, "warn when secret values look like placeholders, test data or expired certificates")
+	options.sensitiveKeys.Set(getEnv("VAULT_SIDEKICK_SENSITIVE_KEYS", ""))
+	flag.Var(&options.sensitiveKeys, "sensitive-keys", "a pattern of the key names which are themselves sensitive and masked in the logs and status, can be repeated")
+	flag.Int64Var(&options.maxValueSize, "max-value-size", defaultMaxValueSize, "the largest value of a secret in bytes which will be written, refusing the resource otherwise, zero for no limit")
 }
 
 func parseResourcesFromYAML(filename string) (*VaultResourcesYAML, error) {
@@ -157,32 +608,62 @@ func parseResourcesFromYAML(filename string) (*VaultResourcesYAML, error) {
 	return r, nil
 }
 
+// loadResourcesYAML reads the resources from a yaml file, setting the default values of any not set
+//	filename	: the path of the resources file
+func loadResourcesYAML(filename string) ([]*VaultResource, error) {
+	resources, err := parseResourcesFromYAML(filename)
+	if err != nil {
+		return nil, withCode(codeResourcesFile, err)
+	}
+	setResourceDefaults(*resources)
+
+	return []*VaultResource(*resources), nil
+}
+
+// setResourceDefaults sets the default values of the resources read from yaml in case they are not set already
+//	resources	: the resources read
+func setResourceDefaults(resources []*VaultResource) {
+	defaultResource := defaultVaultResource()
+	for _, resource := range resources {
+		if resource.FileMode == 0 {
+			resource.FileMode = defaultResource.FileMode
+		}
+		if resource.Format == "" {
+			resource.Format = defaultResource.Format
+			if resource.Resource == "tpl" {
+				resource.Format = "template"
+			}
+		}
+		if resource.Size == 0 {
+			resource.Size = defaultResource.Size
+		}
+	}
+}
+
 // parseOptions validate the command line options and validates them
 func parseOptions() error {
 	flag.Parse()
+	options.childCommand = flag.Args()
 
-	if options.resourcesYAML != "" {
-		resources, err := parseResourcesFromYAML(options.resourcesYAML)
+	if options.podAnnotations != "" {
+		annotations, err := readPodAnnotations(options.podAnnotations)
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to read the annotations of the pod, error: %s", err)
 		}
-
-		// Set resource's default vaules in case they are not
-		// set already
-		defaultResource := defaultVaultResource()
-		for _, resource := range *resources {
-			if resource.FileMode == 0 {
-				resource.FileMode = defaultResource.FileMode
-			}
-			if resource.Format == "" {
-				resource.Format = defaultResource.Format
-			}
-			if resource.Size == 0 {
-				resource.Size = defaultResource.Size
+		for _, value := range annotatedResources(annotations) {
+			if err := options.resources.Set(value); err != nil {
+				return fmt.Errorf("invalid resource in the annotations of the pod: %s, error: %s", value, err)
 			}
 		}
+	}
 
-		options.resources.items = append(options.resources.items, []*VaultResource(*resources)...)
+	if options.resourcesYAML != "" {
+		resources, err := loadResourcesYAML(options.resourcesYAML)
+		if err != nil {
+			return err
+		}
+		options.resourcesFromYAML = resources
+		options.resources.items = append(options.resources.items, resources...)
 	}
 
 	return validateOptions(&options)
@@ -229,5 +710,99 @@ func validateOptions(cfg *config) (err error) {
 		return fmt.Errorf("you are skipping the tls but supplying a CA, doesn't make sense")
 	}
 
-	return nil
+	if cfg.execConcurrency < 0 {
+		return fmt.Errorf("the exec-concurrency cannot be negative")
+	}
+	if cfg.rotationExec != "" && cfg.rotationSettle <= 0 {
+		return fmt.Errorf("the rotation-settle must be positive for the rotation-exec to be run")
+	}
+	if len(cfg.childCommand) > 0 && cfg.oneShot {
+		return fmt.Errorf("a supervised process can't be run in one-shot mode")
+	}
+	if len(cfg.childCommand) > 0 {
+		switch cfg.childOnChange {
+		case "", childRestart, childNone:
+		case childSignal:
+			if _, err := parseSignal(cfg.childSignal); err != nil {
+				return fmt.Errorf("invalid child-signal: %s", err)
+			}
+		default:
+			return fmt.Errorf("the child-on-change must be %s, %s or %s", childRestart, childSignal, childNone)
+		}
+	} else if cfg.resources != nil {
+		for _, rn := range cfg.resources.items {
+			if rn.EnvOnly {
+				return fmt.Errorf("the resource: %s is env=%s, but there's no supervised process to pass it to", rn.ID(), envOnly)
+			}
+			if rn.ReloadSignal != "" || (rn.RestartOnChange && rn.SignalProcess == "") {
+				return fmt.Errorf("the resource: %s reloads the supervised process, but there's none", rn.ID())
+			}
+		}
+	}
+
+	// step: expand the placeholders in the output directory and filenames
+	if cfg.outputDir, err = expandFilename(cfg.outputDir, nil); err != nil {
+		return err
+	}
+	if cfg.resources != nil {
+		if err := expandResourceFilenames(cfg.resources.items); err != nil {
+			return err
+		}
+	}
+
+	if err := validateServe(cfg); err != nil {
+		return err
+	}
+	if cfg.expiryWebhook != "" && cfg.expiryGrace <= 0 {
+		return fmt.Errorf("the expiry-webhook needs an expiry-grace to alert within")
+	}
+	if cfg.leaderElection != "" && cfg.leaderLeaseDuration < 3*time.Second {
+		return fmt.Errorf("the leader-lease-duration must be at least 3s")
+	}
+	if cfg.logFormat != "" && !isValidLogFormat(cfg.logFormat) {
+		return fmt.Errorf("the log-format must be %s or %s", logFormatText, logFormatJSON)
+	}
+	if cfg.metricsListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.metricsListen); err != nil {
+			return fmt.Errorf("invalid metrics-listen: '%s' specified, it must be host:port", cfg.metricsListen)
+		}
+	}
+	if (cfg.metricsTLSCert == "") != (cfg.metricsTLSKey == "") {
+		return fmt.Errorf("the metrics-tls-cert and metrics-tls-key must be given together")
+	}
+	if _, err := metrics.ParseLabels(cfg.metricsLabels); err != nil {
+		return err
+	}
+	if cfg.statsdAddress != "" && cfg.statsdFormat != metrics.StatsdFormat && cfg.statsdFormat != metrics.DogstatsdFormat {
+		return fmt.Errorf("the statsd-format must be %s or %s", metrics.StatsdFormat, metrics.DogstatsdFormat)
+	}
+	if cfg.metricsPushGateway != "" {
+		if u, err := url.Parse(cfg.metricsPushGateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid metrics-push-gateway: '%s' specified, it must be an http or https url", cfg.metricsPushGateway)
+		}
+	}
+	if cfg.metricsTextfile != "" && !strings.HasSuffix(cfg.metricsTextfile, ".prom") {
+		return fmt.Errorf("the metrics-textfile must end in .prom to be read by the textfile collector")
+	}
+	if cfg.tracingEndpoint != "" {
+		if u, err := url.Parse(cfg.tracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid tracing-endpoint: '%s' specified, it must be an http or https url", cfg.tracingEndpoint)
+		}
+	}
+	if cfg.logFormat == logFormatJSON {
+		if err := flag.Set("logtostderr", "true"); err != nil {
+			return err
+		}
+	}
+	if cfg.resourcesConfigMap != "" && cfg.resourcesYAML != "" {
+		return fmt.Errorf("the resources can be read from a resources-yaml or a resources-configmap, not both")
+	}
+	if cfg.stateFile != "" && cfg.stateKeyFile == "" {
+		return fmt.Errorf("the state-file needs a state-key-file to encrypt it with")
+	}
+	if cfg.stateFile != "" && cfg.oneShot {
+		return fmt.Errorf("the state-file can't be used in one-shot mode, nothing is renewed")
+	}
+
+	return validateStdout(cfg)
 }
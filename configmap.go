@@ -0,0 +1,148 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// kubeConfigMap is a configmap of the kubernetes api
+type kubeConfigMap struct {
+	// the metadata of the configmap
+	Metadata kubeMetadata `json:"metadata"`
+	// the data of the configmap
+	Data map[string]string `json:"data"`
+}
+
+// splitConfigMap splits a configmap given as NAME or NAMESPACE/NAME into its namespace and name
+//	value		: the configmap
+//	namespace	: the namespace of the pod, used when none is given
+func splitConfigMap(value, namespace string) (string, string) {
+	if i := strings.Index(value, "/"); i >= 0 {
+		return value[:i], value[i+1:]
+	}
+
+	return namespace, value
+}
+
+// parseResourcesConfigMap reads the resources from the keys of a configmap, in the order of the keys, each
+// holding a list of resources in the resources yaml format
+//	data		: the data of the configmap
+func parseResourcesConfigMap(data map[string]string) ([]*VaultResource, error) {
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var list []*VaultResource
+	for _, key := range keys {
+		resources := VaultResourcesYAML{}
+		if err := yaml.Unmarshal([]byte(data[key]), &resources); err != nil {
+			return nil, withCode(codeResourcesFile, fmt.Errorf("unable to parse the resources of the key: %s, error: %s", key, err))
+		}
+		setResourceDefaults(resources)
+		list = append(list, resources...)
+	}
+	if err := expandResourceFilenames(list); err != nil {
+		return nil, withCode(codeResourceInvalid, err)
+	}
+	for _, rn := range list {
+		if err := rn.IsValid(); err != nil {
+			return nil, withCode(codeResourceInvalid, err)
+		}
+	}
+
+	return list, nil
+}
+
+// readResourcesConfigMap reads the resources from a configmap, returning them along with the version of the
+// configmap; a configmap which doesn't exist has no resources
+//	kube		: the kubernetes client
+//	namespace	: the namespace of the configmap
+//	name		: the name of the configmap
+func readResourcesConfigMap(kube *kubeClient, namespace, name string) ([]*VaultResource, string, error) {
+	configMap := &kubeConfigMap{}
+	if err := kube.get(fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", namespace, name), configMap); err != nil {
+		if kubeNotFound(err) {
+			glog.Warningf("the resources configmap: %s/%s does not exist", namespace, name)
+			return nil, "", nil
+		}
+		return nil, "", withCode(codeKubeRequest, fmt.Errorf("unable to read the configmap: %s/%s, error: %s", namespace, name, err))
+	}
+	resources, err := parseResourcesConfigMap(configMap.Data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resources, configMap.Metadata.ResourceVersion, nil
+}
+
+// watchResourcesConfigMap watches a configmap for changes, sending the resources it holds each time it changes;
+// a configmap which is deleted has no resources, while one which can't be parsed is logged and skipped
+//	kube		: the kubernetes client
+//	namespace	: the namespace of the configmap
+//	name		: the name of the configmap
+//	version		: the version of the configmap already read
+//	ch			: the channel the resources are sent on
+func watchResourcesConfigMap(kube *kubeClient, namespace, name, version string, ch chan []*VaultResource) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/configmaps?fieldSelector=%s", namespace, url.QueryEscape("metadata.name="+name))
+	for {
+		err := kube.watch(path, version, func(event *kubeEvent) {
+			configMap := &kubeConfigMap{}
+			if err := json.Unmarshal(event.Object, configMap); err != nil {
+				glog.Errorf("unable to decode the event of the configmap: %s/%s, error: %s", namespace, name, err)
+				return
+			}
+			version = configMap.Metadata.ResourceVersion
+			if event.Type == "DELETED" {
+				glog.Warningf("the resources configmap: %s/%s has been deleted", namespace, name)
+				ch <- nil
+				return
+			}
+			resources, err := parseResourcesConfigMap(configMap.Data)
+			if err != nil {
+				glog.Errorf("unable to read the resources of the configmap: %s/%s, error: %s", namespace, name, err)
+				return
+			}
+			ch <- resources
+		})
+		if err != nil {
+			glog.Warningf("the watch on the configmap: %s/%s failed, error: %s", namespace, name, err)
+		}
+		<-time.After(kubeRetryInterval)
+
+		// step: changes may have been missed while the watch was down
+		resources, current, err := readResourcesConfigMap(kube, namespace, name)
+		if err != nil {
+			glog.Errorf("unable to read the resources of the configmap: %s/%s, error: %s", namespace, name, err)
+			continue
+		}
+		if current != version {
+			version = current
+			ch <- resources
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitConfigMap(t *testing.T) {
+	namespace, name := splitConfigMap("app-secrets", "default")
+	assert.Equal(t, "default", namespace)
+	assert.Equal(t, "app-secrets", name)
+	namespace, name = splitConfigMap("shared/app-secrets", "default")
+	assert.Equal(t, "shared", namespace)
+	assert.Equal(t, "app-secrets", name)
+}
+
+func TestParseResourcesConfigMap(t *testing.T) {
+	resources, err := parseResourcesConfigMap(map[string]string{
+		"b.yaml": "- resource: secret\n  path: secret/b\n",
+		"a.yaml": "- resource: secret\n  path: secret/a\n- resource: aws\n  path: aws/creds/app\n  format: json\n",
+	})
+	mustNoError(t, err)
+	if assert.Len(t, resources, 3) {
+		assert.Equal(t, "secret/a", resources[0].Path)
+		assert.Equal(t, "yaml", resources[0].Format)
+		assert.Equal(t, "json", resources[1].Format)
+		assert.Equal(t, "secret/b", resources[2].Path)
+	}
+
+	_, err = parseResourcesConfigMap(map[string]string{"a.yaml": "not: [a list"})
+	assert.Error(t, err)
+}
+
+func TestWatchResourcesConfigMap(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps/app-secrets":
+			fmt.Fprint(w, `{"metadata":{"resourceVersion":"1"},"data":{"a.yaml":"- resource: secret\n  path: secret/a\n"}}`)
+		case r.URL.Path == "/api/v1/namespaces/default/configmaps" && r.URL.Query().Get("watch") == "1":
+			assert.Equal(t, "1", r.URL.Query().Get("resourceVersion"))
+			assert.Equal(t, "metadata.name=app-secrets", r.URL.Query().Get("fieldSelector"))
+			encoder := json.NewEncoder(w)
+			encoder.Encode(map[string]interface{}{"type": "MODIFIED", "object": map[string]interface{}{
+				"metadata": map[string]interface{}{"resourceVersion": "2"},
+				"data":     map[string]interface{}{"a.yaml": "- resource: secret\n  path: secret/b\n"},
+			}})
+			encoder.Encode(map[string]interface{}{"type": "DELETED", "object": map[string]interface{}{
+				"metadata": map[string]interface{}{"resourceVersion": "3"},
+			}})
+			w.(http.Flusher).Flush()
+			// step: hold the watch open so it isn't retried
+			<-done
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"not found","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+	kube := &kubeClient{host: server.URL, namespace: "default", client: http.DefaultClient}
+
+	resources, version, err := readResourcesConfigMap(kube, "default", "app-secrets")
+	mustNoError(t, err)
+	assert.Equal(t, "1", version)
+	if assert.Len(t, resources, 1) {
+		assert.Equal(t, "secret/a", resources[0].Path)
+	}
+	resources, version, err = readResourcesConfigMap(kube, "default", "missing")
+	mustNoError(t, err)
+	assert.Empty(t, resources)
+	assert.Empty(t, version)
+
+	ch := make(chan []*VaultResource)
+	go watchResourcesConfigMap(kube, "default", "app-secrets", "1", ch)
+	resources = <-ch
+	if assert.Len(t, resources, 1) {
+		assert.Equal(t, "secret/b", resources[0].Path)
+	}
+	assert.Empty(t, <-ch)
+}
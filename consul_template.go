@@ -0,0 +1,200 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// templateSyntaxGo is the default template syntax, the secret of the resource being the context
+	templateSyntaxGo = "go"
+	// templateSyntaxConsul is the consul-template compatible syntax, the template reading the secrets itself
+	templateSyntaxConsul = "consul"
+)
+
+// templateSecretReader reads a secret for a consul-template style template, writing the parameters to
+// the path instead when there are any; it's set once the vault service has been created
+var templateSecretReader func(path string, params map[string]interface{}) (*api.Secret, error)
+
+// templateSecretLister lists the secrets under a path for a consul-template style template
+var templateSecretLister func(path string) ([]string, error)
+
+// consulTemplateFuncs returns the functions of a consul-template style template; the common functions
+// are kept, with the consul-template names added and secret reading vault rather than named resources
+func consulTemplateFuncs() template.FuncMap {
+	funcs := templateFuncs()
+	consul := template.FuncMap{
+		"secret":       consulSecret,
+		"secrets":      consulSecrets,
+		"toJSON":       templateJSON,
+		"toJSONPretty": templatePrettyJSON,
+		"toYAML":       templateYAML,
+		"toUpper":      strings.ToUpper,
+		"toLower":      strings.ToLower,
+		"toTitle":      strings.Title,
+		"trimSpace":    strings.TrimSpace,
+		"replaceAll":   func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"base64Encode": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"base64Decode": templateB64Decode,
+		"parseJSON":    consulParseJSON,
+		"timestamp":    consulTimestamp,
+		"sha256Hex":    templateSHA256,
+		"containsAny":  consulContainsAny,
+		"containsAll":  consulContainsAll,
+		"explode":      func(s string) []string { return strings.Split(s, "") },
+	}
+	for name, fn := range consul {
+		funcs[name] = fn
+	}
+
+	return funcs
+}
+
+// consulSecret reads a secret, or writes to the path when given key=value parameters, as in
+// {{ with secret "pki/issue/app" "common_name=app.svc" }}{{ .Data.certificate }}{{ end }}
+func consulSecret(path string, args ...string) (*api.Secret, error) {
+	if templateSecretReader == nil {
+		return nil, errors.New("unable to read the secret, no vault client available")
+	}
+	params := make(map[string]interface{})
+	for _, x := range args {
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 {
+			return nil, fmt.Errorf("the secret parameter: %s should be in the form key=value", x)
+		}
+		params[items[0]] = items[1]
+	}
+
+	return templateSecretReader(path, params)
+}
+
+// consulSecrets lists the secrets under a path, sorted
+func consulSecrets(path string) ([]string, error) {
+	if templateSecretLister == nil {
+		return nil, errors.New("unable to list the secrets, no vault client available")
+	}
+	keys, err := templateSecretLister(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// consulParseJSON decodes a json string
+func consulParseJSON(s string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// consulTimestamp returns the current time in RFC3339, or the layout given
+func consulTimestamp(layout ...string) string {
+	if len(layout) > 0 {
+		return time.Now().UTC().Format(layout[0])
+	}
+
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// consulContainsAny checks if any of the values are in the list
+func consulContainsAny(values, list []interface{}) bool {
+	for _, x := range values {
+		for _, y := range list {
+			if x == y {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// consulContainsAll checks if all of the values are in the list
+func consulContainsAll(values, list []interface{}) bool {
+	for _, x := range values {
+		if !consulContainsAny([]interface{}{x}, list) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// templateSecret reads a secret for a template, or writes the parameters to the path if there are any. The secrets
+// are read on each render and nothing renews them, so a secret issued with a lease is revoked and refused.
+//	path		: the path of the secret
+//	params		: the parameters to write, if any
+func (r VaultService) templateSecret(path string, params map[string]interface{}) (*api.Secret, error) {
+	var secret *api.Secret
+	var err error
+	if len(params) > 0 {
+		secret, err = r.client.Logical().Write(path, params)
+	} else {
+		secret, err = r.client.Logical().Read(path)
+	}
+	if err != nil {
+		return nil, withCode(vaultErrorCode(err), err)
+	}
+	if secret == nil {
+		return nil, withCode(codeVaultNotFound, fmt.Errorf("the secret: %s does not exist", path))
+	}
+	// step: a lease would be left to expire on its own, and a new one issued on the next render
+	if secret.LeaseID != "" {
+		if err := r.revoke(secret.LeaseID); err != nil {
+			glog.Errorf("failed to revoke the lease of the template secret: %s, error: %s", path, err)
+		}
+		return nil, withCode(codeResourceInvalid, fmt.Errorf("the secret: %s has a lease, which a template can't renew, "+
+			"use a resource of its own for it instead", path))
+	}
+
+	return secret, nil
+}
+
+// listSecrets lists the keys under a path for a template
+//	path		: the path to list
+func (r VaultService) listSecrets(path string) ([]string, error) {
+	secret, err := r.client.Logical().List(path)
+	if err != nil {
+		return nil, withCode(vaultErrorCode(err), err)
+	}
+	var keys []string
+	if secret != nil {
+		if list, ok := secret.Data["keys"].([]interface{}); ok {
+			for _, x := range list {
+				keys = append(keys, fmt.Sprintf("%v", x))
+			}
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsulTemplateSyntax(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/secret/data/db": map[string]interface{}{
+			"data":     map[string]interface{}{"username": "app", "password": "secret"},
+			"metadata": map[string]interface{}{"version": 1},
+		},
+		"/v1/secret/metadata/apps?list": map[string]interface{}{"keys": []string{"web", "api"}},
+		"/v1/pki/issue/app":             map[string]interface{}{"certificate": "CERT"},
+	})
+	defer closer()
+	templateSecretReader, templateSecretLister = service.templateSecret, service.listSecrets
+	defer func() { templateSecretReader, templateSecretLister = nil, nil }()
+
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	templateFile := filepath.Join(dir, "app.ctmpl")
+	mustNoError(t, ioutil.WriteFile(templateFile, []byte(`{{ with secret "secret/data/db" }}user={{ .Data.data.username }}
+pass={{ .Data.data.password | toUpper }}{{ end }}
+{{ range secrets "secret/metadata/apps" }}{{ . }},{{ end }}
+{{ with secret "pki/issue/app" "common_name=app.svc" }}{{ .Data.certificate }}{{ end }}`), 0600))
+
+	filename := filepath.Join(dir, "app.conf")
+	mustNoError(t, writeTemplateFile(filename, nil, 0600, templateFile, templateSyntaxConsul))
+	content, err := ioutil.ReadFile(filename)
+	mustNoError(t, err)
+	assert.Equal(t, "user=app\npass=SECRET\napi,web,\nCERT", string(content))
+
+	// step: a missing secret fails the render, as consul-template would
+	mustNoError(t, ioutil.WriteFile(templateFile, []byte(`{{ with secret "secret/data/missing" }}{{ .Data }}{{ end }}`), 0600))
+	assert.Error(t, writeTemplateFile(filename, nil, 0600, templateFile, templateSyntaxConsul))
+
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+	rn := &VaultResources{}
+	assert.Error(t, rn.Set("tpl:app.conf:template="+templateFile+",template-syntax=jinja"))
+	mustNoError(t, rn.Set("tpl:app.conf:template="+templateFile+",template-syntax=consul"))
+	assert.Equal(t, templateSyntaxConsul, rn.items[0].TemplateSyntax)
+	assert.Equal(t, "template", rn.items[0].Format)
+}
+
+func TestConsulTemplateLeasedSecret(t *testing.T) {
+	var revoked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/sys/leases/revoke/") {
+			revoked = append(revoked, strings.TrimPrefix(r.URL.Path, "/v1/sys/leases/revoke/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       "database/creds/app/1",
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"username": "v-app", "password": "secret"},
+		})
+	}))
+	defer server.Close()
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	mustNoError(t, err)
+	service := &VaultService{client: client}
+
+	// step: the lease is revoked rather than left behind, the render failing
+	_, err = service.templateSecret("database/creds/app", nil)
+	assert.Error(t, err)
+	assert.Equal(t, codeResourceInvalid, errorCode(err))
+	assert.Equal(t, []string{"database/creds/app/1"}, revoked)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// readCSIFiles reads the files the secrets store csi driver has mounted, each file becoming a key of the secret;
+// the hidden files and directories the driver swaps atomically, i.e. ..data, are skipped
+//	path		: the directory the driver mounts, or a single file of it
+func readCSIFiles(path string) (map[string]interface{}, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, withCode(codeCSIRead, err)
+	}
+	if !info.IsDir() {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, withCode(codeCSIRead, err)
+		}
+		return map[string]interface{}{filepath.Base(path): string(content)}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, withCode(codeCSIRead, err)
+	}
+	data := make(map[string]interface{})
+	for _, x := range entries {
+		if strings.HasPrefix(x.Name(), ".") {
+			continue
+		}
+		filename := filepath.Join(path, x.Name())
+		// step: the files are symlinks into the directory the driver last wrote
+		if info, err := os.Stat(filename); err != nil || info.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, withCode(codeCSIRead, err)
+		}
+		data[x.Name()] = string(content)
+	}
+	if len(data) == 0 {
+		return nil, withCode(codeCSIRead, fmt.Errorf("no files found in the directory: %s", path))
+	}
+
+	return data, nil
+}
+
+// csiFingerprint returns a hash of the files the csi driver has mounted, to notice them change
+//	path		: the directory the driver mounts, or a single file of it
+func csiFingerprint(path string) (string, error) {
+	data, err := readCSIFiles(path)
+	if err != nil {
+		return "", err
+	}
+	var keys []string
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%s\x00", key, data[key])
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// watchCSIFiles polls the files of a csi resource and forces a re-read of the resource whenever the driver
+// rotates them
+//	vault		: the vault service used to re-read the resource
+//	rn			: the csi resource
+//	interval	: how often to check the files
+//	stop		: closed once the resource is no longer watched
+func watchCSIFiles(vault *VaultService, rn *VaultResource, interval time.Duration, stop <-chan struct{}) {
+	glog.V(3).Infof("watching the csi files: %s for resource: %s", rn.Path, rn)
+	last, _ := csiFingerprint(rn.Path)
+	for {
+		select {
+		case <-stop:
+			glog.V(3).Infof("no longer watching the csi files: %s for resource: %s", rn.Path, rn)
+			return
+		case <-time.After(interval):
+		}
+		current, err := csiFingerprint(rn.Path)
+		if err != nil {
+			glog.Warningf("unable to check the csi files: %s, error: %s", rn.Path, err)
+			continue
+		}
+		if current != last {
+			glog.Infof("the csi files: %s have changed, refreshing resource: %s", rn.Path, rn)
+			vault.Refresh(rn)
+		}
+		last = current
+	}
+}
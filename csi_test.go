@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCSIFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// step: lay the files out as the driver does, symlinks into a directory swapped atomically
+	mustNoError(t, os.Mkdir(filepath.Join(dir, "..2020_01_01"), 0755))
+	mustNoError(t, ioutil.WriteFile(filepath.Join(dir, "..2020_01_01", "username"), []byte("app"), 0644))
+	mustNoError(t, ioutil.WriteFile(filepath.Join(dir, "..2020_01_01", "password"), []byte("secret"), 0644))
+	mustNoError(t, os.Symlink("..2020_01_01", filepath.Join(dir, "..data")))
+	mustNoError(t, os.Symlink(filepath.Join("..data", "username"), filepath.Join(dir, "username")))
+	mustNoError(t, os.Symlink(filepath.Join("..data", "password"), filepath.Join(dir, "password")))
+
+	data, err := readCSIFiles(dir)
+	mustNoError(t, err)
+	assert.Equal(t, map[string]interface{}{"username": "app", "password": "secret"}, data)
+
+	data, err = readCSIFiles(filepath.Join(dir, "password"))
+	mustNoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "secret"}, data)
+
+	// step: the fingerprint changes once the driver rotates the files
+	before, err := csiFingerprint(dir)
+	mustNoError(t, err)
+	mustNoError(t, ioutil.WriteFile(filepath.Join(dir, "..2020_01_01", "password"), []byte("rotated"), 0644))
+	after, err := csiFingerprint(dir)
+	mustNoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	empty, err := ioutil.TempDir("", "csi")
+	mustNoError(t, err)
+	defer os.RemoveAll(empty)
+	_, err = readCSIFiles(empty)
+	assert.Equal(t, codeCSIRead, errorCode(err))
+}
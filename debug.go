@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/golang/glog"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+// debugHandlers are the pprof and expvar endpoints, by path
+func debugHandlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/debug/pprof/":        http.HandlerFunc(pprof.Index),
+		"/debug/pprof/cmdline": http.HandlerFunc(pprof.Cmdline),
+		"/debug/pprof/profile": http.HandlerFunc(pprof.Profile),
+		"/debug/pprof/symbol":  http.HandlerFunc(pprof.Symbol),
+		"/debug/pprof/trace":   http.HandlerFunc(pprof.Trace),
+		"/debug/vars":          expvar.Handler(),
+	}
+}
+
+// serveDebug serves the pprof and expvar endpoints alongside the metrics, and or on a listener of their own
+//	onMetrics	: whether to serve them alongside the metrics
+//	address		: the address of a listener of their own, if any
+func serveDebug(onMetrics bool, address string) error {
+	handlers := debugHandlers()
+	if onMetrics {
+		for path, handler := range handlers {
+			metrics.Handle(path, handler)
+		}
+	}
+	if address == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	for path, handler := range handlers {
+		mux.Handle(path, handler)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	glog.Infof("serving the debug endpoints on: %s", address)
+	go func() {
+		glog.Fatal(http.Serve(listener, mux))
+	}()
+
+	return nil
+}
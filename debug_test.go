@@ -0,0 +1,45 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDebug(t *testing.T) {
+	// step: find a free port for the debug listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	mustNoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	mustNoError(t, serveDebug(false, address))
+	for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+		resp, err := http.Get("http://" + address + path)
+		mustNoError(t, err)
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		mustNoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.NotEmpty(t, content)
+	}
+}
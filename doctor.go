@@ -0,0 +1,351 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// doctorOK is a check which passed
+	doctorOK = "OK"
+	// doctorWarn is a check which passed with a concern, or couldn't be run
+	doctorWarn = "WARN"
+	// doctorFail is a check which failed
+	doctorFail = "FAIL"
+	// maxClockSkew is the largest difference from the clock of vault before the skew is reported
+	maxClockSkew = 30 * time.Second
+)
+
+// doctorResult is the outcome of one of the checks of the doctor command
+type doctorResult struct {
+	// the name of the check
+	check string
+	// the outcome, OK, WARN or FAIL
+	status string
+	// what was found
+	message string
+	// how to remedy a warning or failure
+	hint string
+}
+
+func init() {
+	commands["doctor"] = command{
+		usage: "doctor [options]: check the connectivity, authentication and permissions the options need, with hints on fixing any problems",
+		run:   runDoctorCommand,
+	}
+}
+
+// runDoctorCommand handles the doctor subcommand
+func runDoctorCommand(args []string) error {
+	if err := parseCommandOptions(args); err != nil {
+		return err
+	}
+	results := runDoctor(&options)
+	stat, _ := os.Stdout.Stat()
+	renderDoctorReport(os.Stdout, results, stat != nil && stat.Mode()&os.ModeCharDevice != 0)
+
+	failed := 0
+	for _, x := range results {
+		if x.status == doctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the %d checks failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// runDoctor runs the checks in order, skipping those which depend on an earlier check which failed
+//	cfg			: the configuration of the sidekick
+func runDoctor(cfg *config) []doctorResult {
+	var results []doctorResult
+	results = append(results, checkOutputDir(cfg.outputDir))
+
+	address, err := url.Parse(cfg.vaultURL)
+	if err != nil {
+		return append(results, doctorResult{check: "vault", status: doctorFail, message: err.Error(),
+			hint: "set the address of vault with -vault or VAULT_ADDR, e.g. https://vault.example.com:8200"})
+	}
+	result := checkDNS(address.Hostname())
+	if results = append(results, result); result.status == doctorFail {
+		return results
+	}
+
+	transport, err := buildHTTPTransport(cfg)
+	if err != nil {
+		return append(results, doctorResult{check: "tls", status: doctorFail, message: err.Error(),
+			hint: "check the file given to -ca-cert exists and holds the pem encoded ca of vault"})
+	}
+	health, date, err := checkHealth(cfg.vaultURL, transport)
+	if results = append(results, health); err != nil {
+		return results
+	}
+	if !date.IsZero() {
+		results = append(results, checkClockSkew(date, time.Now()))
+	}
+
+	client, result := checkAuth(cfg, transport)
+	if results = append(results, result); client == nil {
+		return results
+	}
+	for _, rn := range cfg.resources.items {
+		// step: the files of a csi resource are delivered by the driver rather than read from vault
+		if rn.Resource == "csi" {
+			results = append(results, checkCSIFiles(rn))
+			continue
+		}
+		results = append(results, checkMount(client, rn), checkCapabilities(client, rn))
+	}
+
+	return results
+}
+
+// checkOutputDir checks the output directory exists and a file can be written to it
+func checkOutputDir(dir string) doctorResult {
+	result := doctorResult{check: "output"}
+	if dir == stdoutOutput {
+		result.status, result.message = doctorOK, "the resources are written to stdout"
+		return result
+	}
+	stat, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		result.status, result.message = doctorFail, fmt.Sprintf("the output directory: %s does not exist", dir)
+		result.hint = "create the directory, or mount a volume at it, or change it with -output"
+		return result
+	case err != nil:
+		result.status, result.message = doctorFail, err.Error()
+		return result
+	case !stat.IsDir():
+		result.status, result.message = doctorFail, fmt.Sprintf("the output: %s is not a directory", dir)
+		result.hint = "point -output at a directory"
+		return result
+	}
+	file, err := ioutil.TempFile(dir, ".vault-sidekick-doctor")
+	if err != nil {
+		result.status, result.message = doctorFail, fmt.Sprintf("unable to write to the output directory: %s, error: %s", dir, err)
+		result.hint = "check the directory is writable by the user the sidekick runs as, and the volume isn't read only"
+		return result
+	}
+	file.Close()
+	os.Remove(file.Name())
+	result.status, result.message = doctorOK, fmt.Sprintf("the output directory: %s is writable", dir)
+
+	return result
+}
+
+// checkDNS checks the hostname of vault resolves
+func checkDNS(hostname string) doctorResult {
+	result := doctorResult{check: "dns"}
+	if net.ParseIP(hostname) != nil {
+		result.status, result.message = doctorOK, fmt.Sprintf("vault is addressed by the ip: %s", hostname)
+		return result
+	}
+	addresses, err := net.LookupHost(hostname)
+	if err != nil {
+		result.status, result.message = doctorFail, fmt.Sprintf("unable to resolve: %s, error: %s", hostname, err)
+		result.hint = "check the hostname in -vault, and the resolv.conf and network policies of the pod"
+		return result
+	}
+	result.status, result.message = doctorOK, fmt.Sprintf("%s resolves to: %s", hostname, strings.Join(addresses, ", "))
+
+	return result
+}
+
+// checkHealth checks vault can be reached and is unsealed, returning the time by the clock of vault
+//	address		: the address of vault
+//	transport	: the transport the sidekick talks to vault with
+func checkHealth(address string, transport http.RoundTripper) (doctorResult, time.Time, error) {
+	result := doctorResult{check: "connect"}
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(address, "/") + "/v1/sys/health?standbyok=true&perfstandbyok=true")
+	if err != nil {
+		result.status, result.message = doctorFail, err.Error()
+		result.hint = "check vault is listening on the address, and nothing between blocks the port"
+		if strings.Contains(err.Error(), "x509") || strings.Contains(err.Error(), "tls") {
+			result.check = "tls"
+			result.hint = "pass the ca which signed the certificate of vault with -ca-cert; -tls-skip-verify only as a last resort"
+		}
+		return result, time.Time{}, err
+	}
+	resp.Body.Close()
+	date, _ := http.ParseTime(resp.Header.Get("Date"))
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusTooManyRequests, 473:
+		result.status, result.message = doctorOK, fmt.Sprintf("vault is reachable and unsealed, status: %d", resp.StatusCode)
+	case 501:
+		result.status, result.message = doctorFail, "vault is not initialized"
+		result.hint = "initialize vault with vault operator init"
+	case http.StatusServiceUnavailable:
+		result.status, result.message = doctorFail, "vault is sealed"
+		result.hint = "unseal vault, the sidekick will retry until it is"
+	default:
+		result.status, result.message = doctorWarn, fmt.Sprintf("vault answered the health check with the status: %d", resp.StatusCode)
+		result.hint = "check the address is that of vault and not of a proxy in front of it"
+	}
+
+	return result, date, nil
+}
+
+// checkClockSkew checks the local clock is close to that of vault, as leases and tokens are timed by it
+func checkClockSkew(vault, now time.Time) doctorResult {
+	result := doctorResult{check: "clock"}
+	skew := now.Sub(vault)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		result.status, result.message = doctorWarn, fmt.Sprintf("the local clock is %s off the clock of vault", skew.Round(time.Second))
+		result.hint = "check ntp is running on the node, a skewed clock renews leases and tokens at the wrong time"
+		return result
+	}
+	result.status, result.message = doctorOK, fmt.Sprintf("the local clock is within %s of vault", maxClockSkew)
+
+	return result
+}
+
+// checkAuth checks the sidekick can log in to vault, returning the client if it can
+//	cfg			: the configuration of the sidekick
+//	transport	: the transport the sidekick talks to vault with
+func checkAuth(cfg *config, transport *http.Transport) (*api.Client, doctorResult) {
+	result := doctorResult{check: "auth"}
+	config := api.DefaultConfig()
+	config.Address = cfg.vaultURL
+	config.HttpClient.Transport = transport
+	client, err := api.NewClient(config)
+	if err != nil {
+		result.status, result.message = doctorFail, err.Error()
+		return nil, result
+	}
+	if err := getVaultClientToken(client, cfg); err != nil {
+		result.status, result.message = doctorFail, err.Error()
+		result.hint = fmt.Sprintf("check the credentials of the %s auth method, and that the role exists and is bound to this workload", cfg.vaultAuthOptions.Method)
+		return nil, result
+	}
+	info, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		result.status, result.message = doctorFail, fmt.Sprintf("logged in, but unable to look up the token, error: %s", err)
+		result.hint = "the token needs the default policy, which allows looking itself up"
+		return nil, result
+	}
+	ttl, _ := info.TokenTTL()
+	policies, _ := info.TokenPolicies()
+	result.status, result.message = doctorOK, fmt.Sprintf("logged in with the %s method, ttl: %s, policies: %s", cfg.vaultAuthOptions.Method, ttl, strings.Join(policies, ", "))
+	if ttl > 0 && ttl < 5*time.Minute {
+		result.status = doctorWarn
+		result.hint = "the token expires soon, raise the token ttl of the role or use -renew-token"
+	}
+
+	return client, result
+}
+
+// checkMount checks the mount holding the path of a resource exists
+func checkMount(client *api.Client, rn *VaultResource) doctorResult {
+	result := doctorResult{check: "mount: " + rn.ID()}
+	secret, err := client.Logical().Read("sys/internal/ui/mounts/" + rn.Path)
+	switch {
+	case err != nil && vaultErrorCode(err) == codeVaultDenied:
+		result.status, result.message = doctorWarn, fmt.Sprintf("the token can't see the mount of: %s", rn.Path)
+		result.hint = "the token has no capabilities under the mount, check its policies"
+	case err != nil || secret == nil:
+		result.status, result.message = doctorFail, fmt.Sprintf("no mount was found for: %s", rn.Path)
+		result.hint = "check the path of the resource starts with the path of an enabled secrets engine, see vault secrets list"
+	default:
+		result.status, result.message = doctorOK, fmt.Sprintf("%s is held by the mount: %v (%v)", rn.Path, secret.Data["path"], secret.Data["type"])
+	}
+
+	return result
+}
+
+// checkCSIFiles checks the files of a csi resource have been mounted by the driver
+func checkCSIFiles(rn *VaultResource) doctorResult {
+	result := doctorResult{check: "csi: " + rn.ID()}
+	data, err := readCSIFiles(rn.Path)
+	if err != nil {
+		result.status, result.message = doctorFail, err.Error()
+		result.hint = "check the secrets store csi volume is mounted at the path, and its secret provider class lists the objects"
+		return result
+	}
+	result.status, result.message = doctorOK, fmt.Sprintf("%d files are mounted at: %s", len(data), rn.Path)
+
+	return result
+}
+
+// checkCapabilities checks the token has the capabilities the resource needs on its path
+func checkCapabilities(client *api.Client, rn *VaultResource) doctorResult {
+	result := doctorResult{check: "access: " + rn.ID()}
+	needed := "read"
+	switch rn.Resource {
+	case "pki", "transit", "ssh":
+		needed = "update"
+	}
+	capabilities, err := client.Sys().CapabilitiesSelf(rn.Path)
+	if err != nil {
+		result.status, result.message = doctorWarn, fmt.Sprintf("unable to check the capabilities on: %s, error: %s", rn.Path, err)
+		return result
+	}
+	granted := make(map[string]bool)
+	for _, x := range capabilities {
+		granted[x] = true
+	}
+	switch {
+	case granted["root"] || granted[needed]:
+		result.status, result.message = doctorOK, fmt.Sprintf("the token can %s: %s", needed, rn.Path)
+	default:
+		result.status, result.message = doctorFail, fmt.Sprintf("the token lacks %s on: %s, it has: %s", needed, rn.Path, strings.Join(capabilities, ", "))
+		result.hint = fmt.Sprintf("add a policy with path \"%s\" { capabilities = [\"%s\"] } to the role", rn.Path, needed)
+	}
+	if rn.Create && !granted["root"] && !granted["create"] {
+		result.status = doctorFail
+		result.message += ", and create is needed to create the secret"
+		result.hint = fmt.Sprintf("add create to the capabilities of the path \"%s\"", rn.Path)
+	}
+
+	return result
+}
+
+// renderDoctorReport writes the results, coloured by their status when writing to a terminal
+//	w			: the writer
+//	results		: the results of the checks
+//	color		: whether to colour the status
+func renderDoctorReport(w io.Writer, results []doctorResult, color bool) {
+	colors := map[string]string{doctorOK: "\033[32m", doctorWarn: "\033[33m", doctorFail: "\033[31m"}
+	for _, x := range results {
+		status := fmt.Sprintf("%-4s", x.status)
+		if color {
+			status = colors[x.status] + status + "\033[0m"
+		}
+		fmt.Fprintf(w, "[%s] %-20s %s\n", status, x.check, x.message)
+		if x.hint != "" && x.status != doctorOK {
+			fmt.Fprintf(w, "       %-20s hint: %s\n", "", x.hint)
+		}
+	}
+}
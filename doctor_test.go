@@ -0,0 +1,138 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doctor")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, doctorOK, checkOutputDir(dir).status)
+	assert.Equal(t, doctorFail, checkOutputDir(filepath.Join(dir, "missing")).status)
+
+	file := filepath.Join(dir, "file")
+	mustNoError(t, ioutil.WriteFile(file, nil, 0600))
+	assert.Equal(t, doctorFail, checkOutputDir(file).status)
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "the probe file should have been removed")
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, doctorOK, checkClockSkew(now.Add(-10*time.Second), now).status)
+	assert.Equal(t, doctorWarn, checkClockSkew(now.Add(2*time.Minute), now).status)
+	assert.Equal(t, doctorWarn, checkClockSkew(now.Add(-2*time.Minute), now).status)
+}
+
+func TestCheckHealth(t *testing.T) {
+	cs := []struct {
+		Code   int
+		Status string
+	}{
+		{Code: http.StatusOK, Status: doctorOK},
+		{Code: http.StatusTooManyRequests, Status: doctorOK},
+		{Code: 501, Status: doctorFail},
+		{Code: http.StatusServiceUnavailable, Status: doctorFail},
+		{Code: http.StatusNotFound, Status: doctorWarn},
+	}
+	for _, c := range cs {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/sys/health", r.URL.Path)
+			w.WriteHeader(c.Code)
+		}))
+		result, date, err := checkHealth(server.URL, http.DefaultTransport)
+		server.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, c.Status, result.status, "code: %d", c.Code)
+		assert.False(t, date.IsZero())
+	}
+
+	result, _, err := checkHealth("http://127.0.0.1:1", http.DefaultTransport)
+	assert.Error(t, err)
+	assert.Equal(t, doctorFail, result.status)
+	assert.NotEmpty(t, result.hint)
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	capabilities := map[string][]string{
+		"secret/app":      {"read", "list"},
+		"secret/denied":   {"deny"},
+		"pki/issue/app":   {"read"},
+		"pki/issue/other": {"update"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(map[string]interface{}{"capabilities": capabilities[request["path"]]})
+	}))
+	defer server.Close()
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	mustNoError(t, err)
+
+	cs := []struct {
+		Resource *VaultResource
+		Status   string
+	}{
+		{Resource: &VaultResource{Resource: "secret", Path: "secret/app"}, Status: doctorOK},
+		{Resource: &VaultResource{Resource: "secret", Path: "secret/denied"}, Status: doctorFail},
+		{Resource: &VaultResource{Resource: "secret", Path: "secret/app", Create: true}, Status: doctorFail},
+		{Resource: &VaultResource{Resource: "pki", Path: "pki/issue/app"}, Status: doctorFail},
+		{Resource: &VaultResource{Resource: "pki", Path: "pki/issue/other"}, Status: doctorOK},
+	}
+	for _, c := range cs {
+		result := checkCapabilities(client, c.Resource)
+		assert.Equal(t, c.Status, result.status, "path: %s", c.Resource.Path)
+		if c.Status == doctorFail {
+			assert.NotEmpty(t, result.hint)
+		}
+	}
+}
+
+func TestRenderDoctorReport(t *testing.T) {
+	results := []doctorResult{
+		{check: "dns", status: doctorOK, message: "resolved", hint: "unused"},
+		{check: "auth", status: doctorFail, message: "permission denied", hint: "check the role"},
+	}
+	plain := &bytes.Buffer{}
+	renderDoctorReport(plain, results, false)
+	assert.Contains(t, plain.String(), "[OK  ] dns")
+	assert.Contains(t, plain.String(), "hint: check the role")
+	assert.NotContains(t, plain.String(), "unused")
+	assert.NotContains(t, plain.String(), "\033[")
+
+	colored := &bytes.Buffer{}
+	renderDoctorReport(colored, results, true)
+	assert.Contains(t, colored.String(), "\033[31mFAIL\033[0m")
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The stable codes of the user facing errors; the wording of an error may change between releases but
+// its code won't, so runbooks and alerts should match on the code. Codes are never reused.
+const (
+	// codeUnknown is an error which has not been classified
+	codeUnknown = "VS-GEN-000"
+
+	// codeConfigInvalid is an invalid command line option or environment variable
+	codeConfigInvalid = "VS-CFG-001"
+	// codeResourceInvalid is an invalid resource definition
+	codeResourceInvalid = "VS-CFG-002"
+	// codeResourcesFile is a resources file which can't be read or parsed
+	codeResourcesFile = "VS-CFG-003"
+	// codeCACert is a vault ca certificate which can't be read
+	codeCACert = "VS-CFG-004"
+
+	// codeAuthUnsupported is an unsupported authentication method
+	codeAuthUnsupported = "VS-AUTH-001"
+	// codeAuthClient is a failure to create the vault client
+	codeAuthClient = "VS-AUTH-002"
+	// codeAuthFailed is a failure to log in to vault
+	codeAuthFailed = "VS-AUTH-003"
+	// codeAuthTokenLookup is a failure to look up the ttl of the vault token
+	codeAuthTokenLookup = "VS-AUTH-004"
+
+	// codeVaultNotFound is a resource which does not exist in vault
+	codeVaultNotFound = "VS-VAULT-001"
+	// codeVaultDenied is a request vault refused the token permission for
+	codeVaultDenied = "VS-VAULT-002"
+	// codeVaultRequest is any other failed request to vault
+	codeVaultRequest = "VS-VAULT-003"
+	// codeVaultDeleted is a kv v2 secret whose version has been deleted or destroyed
+	codeVaultDeleted = "VS-VAULT-004"
+
+	// codeWriteFailed is a failure to format or write the files of a resource
+	codeWriteFailed = "VS-WRITE-001"
+	// codeWriteFormat is an unknown output format
+	codeWriteFormat = "VS-WRITE-002"
+	// codeWriteRollback is a failure to roll back the files of a resource which failed verification
+	codeWriteRollback = "VS-WRITE-003"
+	// codeWriteTooLarge is a value of a secret larger than the max-value-size option
+	codeWriteTooLarge = "VS-WRITE-004"
+	// codeWritePersistent is an output directory on a filesystem other than tmpfs when one is required
+	codeWritePersistent = "VS-WRITE-005"
+
+	// codeExecFailed is a failed exec command
+	codeExecFailed = "VS-EXEC-001"
+	// codeVerifyFailed is a failed verify-exec command
+	codeVerifyFailed = "VS-EXEC-002"
+	// codeOnDeleteFailed is a failed on-delete command
+	codeOnDeleteFailed = "VS-EXEC-003"
+	// codeSupervisorFailed is a failure to start the supervised process
+	codeSupervisorFailed = "VS-EXEC-004"
+
+	// codeKubeRequest is a failed request to the kubernetes api
+	codeKubeRequest = "VS-KUBE-001"
+
+	// codeCSIRead is a failure to read the files mounted by the secrets store csi driver
+	codeCSIRead = "VS-CSI-001"
+)
+
+// codedError is an error with a stable code
+type codedError struct {
+	// the code of the error
+	code string
+	// the underlying error
+	err error
+}
+
+// Error returns the message of the error prefixed with its code
+func (e *codedError) Error() string {
+	return fmt.Sprintf("%s: %s", e.code, e.err)
+}
+
+// Code returns the code of the error
+func (e *codedError) Code() string {
+	return e.code
+}
+
+// withCode attaches the code to the error, unless it already has one
+//	code		: the code of the error
+//	err			: the error
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(interface {
+		Code() string
+	}); ok {
+		return err
+	}
+
+	return &codedError{code: code, err: err}
+}
+
+// wrapError adds context to the message of an error, keeping its code or attaching the one given
+//	code		: the code to use if the error has none
+//	err			: the error being wrapped, the last argument of the format
+//	format		: the format of the message
+func wrapError(code string, err error, format string, args ...interface{}) error {
+	if x, ok := err.(*codedError); ok {
+		code, err = x.code, x.err
+	}
+
+	return &codedError{code: code, err: fmt.Errorf(format, append(args, err)...)}
+}
+
+// errorCode returns the code of the error, or the unknown code if it has none
+func errorCode(err error) string {
+	if x, ok := err.(interface {
+		Code() string
+	}); ok {
+		return x.Code()
+	}
+
+	return codeUnknown
+}
+
+// vaultErrorCode classifies an error from the vault api, which only gives us the status in the message
+func vaultErrorCode(err error) string {
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "Code: 403"), strings.Contains(message, "permission denied"):
+		return codeVaultDenied
+	case strings.Contains(message, "Code: 404"):
+		return codeVaultNotFound
+	}
+
+	return codeVaultRequest
+}
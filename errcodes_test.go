@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCodes(t *testing.T) {
+	assert.Nil(t, withCode(codeVaultRequest, nil))
+	assert.Equal(t, codeUnknown, errorCode(errors.New("plain")))
+
+	err := withCode(codeAuthFailed, errors.New("permission denied"))
+	assert.Equal(t, "VS-AUTH-003: permission denied", err.Error())
+	assert.Equal(t, codeAuthFailed, errorCode(err))
+	// step: an error keeps the code it was first given
+	assert.Equal(t, codeAuthFailed, errorCode(withCode(codeVaultRequest, err)))
+
+	wrapped := wrapError(codeAuthClient, err, "unable to create the vault client: %s")
+	assert.Equal(t, "VS-AUTH-003: unable to create the vault client: permission denied", wrapped.Error())
+	wrapped = wrapError(codeAuthClient, errors.New("dial tcp: refused"), "unable to create the vault client: %s")
+	assert.Equal(t, "VS-AUTH-002: unable to create the vault client: dial tcp: refused", wrapped.Error())
+
+	deleted := &deletedSecretError{path: "secret/data/db", version: 2}
+	assert.Equal(t, codeVaultDeleted, errorCode(deleted))
+
+	assert.Equal(t, codeVaultDenied, vaultErrorCode(errors.New("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied")))
+	assert.Equal(t, codeVaultRequest, vaultErrorCode(errors.New("Error making API request.\n\nCode: 500. Errors:")))
+}
+
+func TestGetErrorCodes(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{})
+	defer closer()
+
+	err := service.get(&watchedResource{resource: &VaultResource{Resource: "secret", Path: "secret/missing"}})
+	assert.Equal(t, codeVaultNotFound, errorCode(err))
+
+	err = service.get(&watchedResource{resource: &VaultResource{Resource: "config", Path: "sys/missing", Update: time.Minute}})
+	assert.Equal(t, codeVaultNotFound, errorCode(err))
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/db", Format: "unknown"}
+	_, err = writeResource(rn, map[string]interface{}{})
+	assert.Equal(t, codeWriteFormat, errorCode(err))
+}
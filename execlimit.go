@@ -0,0 +1,138 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// execLimiter bounds the hooks running at once and keeps the same command from racing with itself: while a
+// command runs, the invocations of it which arrive are coalesced into a single one, run once it's finished
+type execLimiter struct {
+	sync.Mutex
+	// the slots of the hooks which can run at once, nil for no limit
+	slots chan struct{}
+	// the state of each command running
+	commands map[string]*commandRun
+}
+
+// commandRun is the state of a command which is running
+type commandRun struct {
+	// the invocation waiting on the running one, if any
+	pending *commandCall
+}
+
+// commandCall is an invocation of a command waiting to run
+type commandCall struct {
+	// runs the command, the latest invocation to be coalesced into the call
+	run func() error
+	// the number of invocations coalesced into the call
+	callers int
+	// the error the command returned
+	err error
+	// closed once the command has run
+	done chan struct{}
+}
+
+var (
+	// execs limits the hooks of the sidekick
+	execs = newExecLimiter(0)
+	// pendingExecs are the execs of the resources running in the background, waited on before a one-shot exits
+	pendingExecs sync.WaitGroup
+)
+
+// execKey identifies the command the hooks are coalesced by, whether the exec of a resource or a global hook, so
+// the same command is never run twice at once; a command piped the content of a resource is keyed by the resource
+// as well, the content of one never standing in for that of another
+//	cmd			: the command
+//	rn			: the resource whose content is piped to it, nil for none
+func execKey(cmd *exec.Cmd, rn *VaultResource) string {
+	key := strings.Join(cmd.Args, " ")
+	if rn != nil && rn.ExecStdin {
+		key += "\x00" + rn.ID()
+	}
+
+	return key
+}
+
+// newExecLimiter creates a limiter running at most concurrency hooks at once, zero for no limit
+func newExecLimiter(concurrency int) *execLimiter {
+	l := &execLimiter{commands: make(map[string]*commandRun)}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+
+	return l
+}
+
+// run runs the command, unless it's already running: then the call waits for it to finish and runs it once more,
+// together with any other calls made in the meantime, the latest of them being run; each caller is given the
+// error of the run it was part of
+//	key			: identifies the command, i.e. the command and its arguments
+//	run			: runs the command
+func (l *execLimiter) run(key string, run func() error) error {
+	l.Lock()
+	current, found := l.commands[key]
+	if found {
+		if current.pending == nil {
+			current.pending = &commandCall{done: make(chan struct{})}
+		} else {
+			glog.V(3).Infof("the command: %s is already pending, coalescing the invocation", key)
+		}
+		call := current.pending
+		call.run = run
+		call.callers++
+		l.Unlock()
+		<-call.done
+
+		return call.err
+	}
+	current = &commandRun{}
+	l.commands[key] = current
+	l.Unlock()
+
+	err := l.limit(run)
+	// step: run the invocations which arrived while the command was running
+	for {
+		l.Lock()
+		call := current.pending
+		current.pending = nil
+		if call == nil {
+			delete(l.commands, key)
+			l.Unlock()
+			return err
+		}
+		l.Unlock()
+		glog.V(3).Infof("running the command: %s for %d coalesced invocations", key, call.callers)
+		call.err = l.limit(call.run)
+		close(call.done)
+	}
+}
+
+// limit runs the hook once a slot is free
+func (l *execLimiter) limit(run func() error) error {
+	if l.slots != nil {
+		l.slots <- struct{}{}
+		defer func() { <-l.slots }()
+	}
+
+	return run()
+}
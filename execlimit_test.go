@@ -0,0 +1,148 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecLimiterCoalesces(t *testing.T) {
+	l := newExecLimiter(0)
+	release := make(chan struct{})
+	var runs int32
+	first := make(chan error)
+	go func() {
+		first <- l.run("reload.sh", func() error {
+			atomic.AddInt32(&runs, 1)
+			<-release
+			return nil
+		})
+	}()
+	for atomic.LoadInt32(&runs) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// step: the invocations arriving while the command runs are coalesced into one, the latest being run
+	failed := errors.New("failed")
+	var wg sync.WaitGroup
+	results := make([]error, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = l.run("reload.sh", func() error {
+				atomic.AddInt32(&runs, 1)
+				return failed
+			})
+		}(i)
+	}
+	for {
+		l.Lock()
+		callers := 0
+		if pending := l.commands["reload.sh"].pending; pending != nil {
+			callers = pending.callers
+		}
+		l.Unlock()
+		if callers == len(results) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+
+	assert.NoError(t, <-first)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+	for _, err := range results {
+		assert.Equal(t, failed, err)
+	}
+	assert.Empty(t, l.commands)
+}
+
+func TestExecLimiterConcurrency(t *testing.T) {
+	l := newExecLimiter(2)
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.run(string(rune('a'+i)), func() error {
+				now := atomic.AddInt32(&running, 1)
+				for {
+					seen := atomic.LoadInt32(&most)
+					if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&most))
+}
+
+func TestProcessResourceCoalescesExecs(t *testing.T) {
+	defer withOutputDir(t)()
+	runs := filepath.Join(options.outputDir, "runs")
+	release := filepath.Join(options.outputDir, "release")
+	rn := &VaultResource{
+		Resource: "secret",
+		Path:     "secret/app",
+		Format:   "json",
+		Filename: "app",
+		FileMode: 0600,
+		ExecPath: []string{"sh", "-c", "echo run >> " + runs + "; while [ ! -f " + release + " ]; do sleep 0.05; done"},
+	}
+
+	// step: the renders return while the exec runs, those arriving meanwhile being coalesced into one further run
+	for i := 0; i < 4; i++ {
+		mustNoError(t, processResource(rn, map[string]interface{}{"password": fmt.Sprintf("secret-%d", i)}))
+	}
+	key := strings.Join(rn.ExecPath, " ")
+	for {
+		execs.Lock()
+		callers := 0
+		if current, found := execs.commands[key]; found && current.pending != nil {
+			callers = current.pending.callers
+		}
+		execs.Unlock()
+		if callers == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mustNoError(t, ioutil.WriteFile(release, nil, 0600))
+	pendingExecs.Wait()
+
+	content, err := ioutil.ReadFile(runs)
+	mustNoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(content))
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+// expiryCheckInterval is how often the expiries of the resources are checked against the grace window
+const expiryCheckInterval = 30 * time.Second
+
+// expiryAlert is the body posted to the expiry webhook
+type expiryAlert struct {
+	// the id of the resource
+	ID string `json:"id"`
+	// the type of the resource
+	Resource string `json:"resource"`
+	// the path of the resource
+	Path string `json:"path"`
+	// when the secret expires
+	Expiry time.Time `json:"expiry"`
+	// the time left until it expires, in seconds
+	Remaining int64 `json:"remaining_seconds"`
+	// the last error retrieving or renewing the resource
+	LastError string `json:"last_error,omitempty"`
+}
+
+// expiryTracker tracks when the secret of each resource expires, alerting once one is within the grace window
+// without having been renewed
+type expiryTracker struct {
+	sync.Mutex
+	// when the secret of each resource expires
+	expiries map[*VaultResource]time.Time
+	// the expiry each resource was last alerted on, so the webhook is called once per expiry
+	alerted map[*VaultResource]time.Time
+}
+
+// expiries tracks the expiry of the secrets of the resources
+var expiries = &expiryTracker{
+	expiries: make(map[*VaultResource]time.Time),
+	alerted:  make(map[*VaultResource]time.Time),
+}
+
+// update records when the secret of the resource expires, the zero time if it doesn't
+//	rn			: the resource
+//	expiry		: when the secret expires
+func (e *expiryTracker) update(rn *VaultResource, expiry time.Time) {
+	e.Lock()
+	defer e.Unlock()
+	if expiry.IsZero() {
+		delete(e.expiries, rn)
+		return
+	}
+	e.expiries[rn] = expiry
+}
+
+// remove stops tracking a resource which is no longer watched
+//	rn			: the resource
+func (e *expiryTracker) remove(rn *VaultResource) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.expiries, rn)
+	delete(e.alerted, rn)
+	metrics.ResourceExpiring(rn.ID(), false)
+}
+
+// check flags the resources expiring within the grace window, returning those to alert on for the first time
+//	now			: the time now
+//	grace		: the grace window
+func (e *expiryTracker) check(now time.Time, grace time.Duration) []*VaultResource {
+	e.Lock()
+	defer e.Unlock()
+	var alerts []*VaultResource
+	for rn, expiry := range e.expiries {
+		expiring := now.Add(grace).After(expiry)
+		metrics.ResourceExpiring(rn.ID(), expiring)
+		if !expiring || e.alerted[rn].Equal(expiry) {
+			continue
+		}
+		e.alerted[rn] = expiry
+		alerts = append(alerts, rn)
+	}
+
+	return alerts
+}
+
+// watchExpiries checks the expiries of the resources every interval, logging and calling the webhook, if any,
+// for each resource newly within the grace window
+//	grace		: the grace window
+//	webhook		: the url alerts are posted to, empty for none
+func watchExpiries(grace time.Duration, webhook string) {
+	for {
+		<-time.After(expiryCheckInterval)
+		now := time.Now()
+		for _, rn := range expiries.check(now, grace) {
+			alert := newExpiryAlert(rn, now)
+			glog.Warningf("resource: %s expires in %ds, at %s, without having been renewed", rn, alert.Remaining, alert.Expiry)
+			if webhook != "" {
+				go func(a *expiryAlert) {
+					if err := postExpiryAlert(webhook, a); err != nil {
+						glog.Errorf("failed to post the expiry alert of resource: %s to the webhook, error: %s", a.ID, err)
+					}
+				}(alert)
+			}
+		}
+	}
+}
+
+// newExpiryAlert builds the alert of a resource
+//	rn			: the resource
+//	now			: the time now
+func newExpiryAlert(rn *VaultResource, now time.Time) *expiryAlert {
+	expiries.Lock()
+	expiry := expiries.expiries[rn]
+	expiries.Unlock()
+	alert := &expiryAlert{
+		ID:        rn.ID(),
+		Resource:  rn.Resource,
+		Path:      rn.Path,
+		Expiry:    expiry,
+		Remaining: int64(expiry.Sub(now).Seconds()),
+	}
+	statuses.RLock()
+	if x, found := statuses.resources[rn]; found && x.State == resourceStateFailed {
+		alert.LastError = x.LastError
+	}
+	statuses.RUnlock()
+
+	return alert
+}
+
+// postExpiryAlert posts the alert to the webhook as json
+//	url			: the url of the webhook
+//	alert		: the alert
+func postExpiryAlert(url string, alert *expiryAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response from the webhook: %s", resp.Status)
+	}
+
+	return nil
+}
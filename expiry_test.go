@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryTrackerCheck(t *testing.T) {
+	tracker := &expiryTracker{
+		expiries: make(map[*VaultResource]time.Time),
+		alerted:  make(map[*VaultResource]time.Time),
+	}
+	now := time.Now()
+	soon := &VaultResource{Resource: "pki", Path: "pki/issue/web"}
+	later := &VaultResource{Resource: "aws", Path: "aws/creds/app"}
+	tracker.update(soon, now.Add(time.Hour))
+	tracker.update(later, now.Add(48*time.Hour))
+	tracker.update(&VaultResource{Resource: "secret", Path: "secret/app"}, time.Time{})
+
+	assert.Equal(t, []*VaultResource{soon}, tracker.check(now, 24*time.Hour))
+	// step: a resource is alerted on once per expiry
+	assert.Empty(t, tracker.check(now, 24*time.Hour))
+
+	// step: once renewed the new expiry is alerted on again
+	tracker.update(soon, now.Add(2*time.Hour))
+	assert.Equal(t, []*VaultResource{soon}, tracker.check(now, 24*time.Hour))
+
+	tracker.remove(soon)
+	assert.Empty(t, tracker.check(now, 24*time.Hour))
+}
+
+func TestPostExpiryAlert(t *testing.T) {
+	var received expiryAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		mustNoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	alert := &expiryAlert{ID: "pki:web", Resource: "pki", Path: "pki/issue/web", Remaining: 3600}
+	mustNoError(t, postExpiryAlert(server.URL, alert))
+	assert.Equal(t, "pki:web", received.ID)
+	assert.Equal(t, int64(3600), received.Remaining)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.Error(t, postExpiryAlert(failing.URL, alert))
+}
+
+func TestRefreshAt(t *testing.T) {
+	updated := time.Now()
+
+	// step: a leased secret expires with its lease
+	database := &watchedResource{
+		resource:        &VaultResource{Resource: "database", Path: "database/creds/app"},
+		secret:          &api.Secret{LeaseID: "database/creds/app/1234", LeaseDuration: 3600},
+		lastUpdated:     updated,
+		leaseExpireTime: updated.Add(time.Hour),
+	}
+	assert.Equal(t, updated.Add(time.Hour), database.refreshAt())
+
+	// step: a kv secret without a lease is reported by when it's next re-read
+	kv := &watchedResource{
+		resource:    &VaultResource{Resource: "secret", Path: "secret/db", Update: 10 * time.Minute},
+		secret:      &api.Secret{Data: map[string]interface{}{"password": "secret"}},
+		lastUpdated: updated,
+	}
+	assert.Equal(t, updated.Add(10*time.Minute), kv.refreshAt())
+
+	none := &watchedResource{
+		resource: &VaultResource{Resource: "secret", Path: "secret/db"},
+		secret:   &api.Secret{Data: map[string]interface{}{"password": "secret"}},
+	}
+	assert.True(t, none.refreshAt().IsZero())
+}
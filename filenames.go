@@ -0,0 +1,104 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// filenameContext are the fields a templated filename or output directory can refer to
+type filenameContext struct {
+	// Env are the environment variables of the sidekick
+	Env map[string]string
+	// Hostname is the hostname of the node or pod
+	Hostname string
+	// CommonName is the common name of a pki resource
+	CommonName string
+	// Name is the name of the resource
+	Name string
+	// Path is the path of the resource in vault
+	Path string
+	// Resource is the type of the resource
+	Resource string
+}
+
+// newFilenameContext creates the context a filename is expanded with, the resource being optional
+func newFilenameContext(rn *VaultResource) (*filenameContext, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	ctx := &filenameContext{Env: make(map[string]string), Hostname: hostname}
+	for _, x := range os.Environ() {
+		if kv := strings.SplitN(x, "=", 2); len(kv) == 2 {
+			ctx.Env[kv[0]] = kv[1]
+		}
+	}
+	if rn != nil {
+		ctx.CommonName = rn.Options["common_name"]
+		ctx.Name = rn.Name
+		ctx.Path = rn.Path
+		ctx.Resource = rn.Resource
+	}
+
+	return ctx, nil
+}
+
+// expandFilename expands the placeholders of a filename, i.e. /etc/secrets/{{.Env.NODE_NAME}}.pem, returning
+// the filename as it is when it has none; an unknown field or environment variable is an error
+//	value		: the filename
+//	rn			: the resource the file belongs to, nil for the output directory
+func expandFilename(value string, rn *VaultResource) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("the filename: %s is not a valid template, error: %s", value, err)
+	}
+	ctx, err := newFilenameContext(rn)
+	if err != nil {
+		return "", err
+	}
+	expanded := &bytes.Buffer{}
+	if err := tmpl.Execute(expanded, ctx); err != nil {
+		return "", fmt.Errorf("unable to expand the filename: %s, error: %s", value, err)
+	}
+	if expanded.Len() == 0 {
+		return "", fmt.Errorf("the filename: %s expanded to nothing", value)
+	}
+
+	return expanded.String(), nil
+}
+
+// expandResourceFilenames expands the placeholders in the filenames of the resources
+//	items		: the resources
+func expandResourceFilenames(items []*VaultResource) error {
+	for _, rn := range items {
+		filename, err := expandFilename(rn.Filename, rn)
+		if err != nil {
+			return fmt.Errorf("resource: %s, %s", rn.ID(), err)
+		}
+		rn.Filename = filename
+	}
+
+	return nil
+}
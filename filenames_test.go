@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandFilename(t *testing.T) {
+	os.Setenv("TEST_NODE_NAME", "node-1")
+	defer os.Unsetenv("TEST_NODE_NAME")
+	hostname, _ := os.Hostname()
+	rn := &VaultResource{
+		Resource: "pki",
+		Path:     "pki/issue/node",
+		Name:     "node",
+		Options:  map[string]string{"common_name": "node-1.nodes.svc"},
+	}
+
+	cs := []struct {
+		Value    string
+		Expected string
+		Error    bool
+	}{
+		{Value: "/etc/secrets/app.pem", Expected: "/etc/secrets/app.pem"},
+		{Value: "/etc/certs/{{.Env.TEST_NODE_NAME}}/tls", Expected: "/etc/certs/node-1/tls"},
+		{Value: "{{.CommonName}}", Expected: "node-1.nodes.svc"},
+		{Value: "{{.Hostname}}-{{.Name}}", Expected: hostname + "-node"},
+		{Value: "{{.Resource}}/{{.Path}}", Expected: "pki/pki/issue/node"},
+		{Value: "{{.Env.TEST_NOT_SET}}", Error: true},
+		{Value: "{{.Unknown}}", Error: true},
+		{Value: "{{.Env.TEST_NODE_NAME", Error: true},
+	}
+	for _, c := range cs {
+		filename, err := expandFilename(c.Value, rn)
+		if c.Error {
+			assert.Error(t, err, "value: %s", c.Value)
+			continue
+		}
+		assert.NoError(t, err, "value: %s", c.Value)
+		assert.Equal(t, c.Expected, filename)
+	}
+}
+
+func TestValidateOptionsExpandsFilenames(t *testing.T) {
+	os.Setenv("TEST_NODE_NAME", "node-1")
+	defer os.Unsetenv("TEST_NODE_NAME")
+
+	cfg := &config{
+		vaultURL:  "https://127.0.0.1:8200",
+		outputDir: "/etc/certs/{{.Env.TEST_NODE_NAME}}",
+		resources: &VaultResources{items: []*VaultResource{
+			{Resource: "pki", Path: "pki/issue/node", Filename: "{{.CommonName}}", Options: map[string]string{"common_name": "node-1"}},
+		}},
+	}
+	assert.NoError(t, validateOptions(cfg))
+	assert.Equal(t, "/etc/certs/node-1", cfg.outputDir)
+	assert.Equal(t, "node-1", cfg.resources.items[0].Filename)
+
+	cfg.resources.items[0].Filename = "{{.Env.TEST_NOT_SET}}"
+	assert.Error(t, validateOptions(cfg))
+}
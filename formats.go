@@ -23,22 +23,150 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"strings"
-	"text/template"
+	"unicode/utf16"
 
 	"github.com/golang/glog"
 	"gopkg.in/yaml.v2"
 )
 
-func writeIniFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeIniFile(filename string, data map[string]interface{}, mode os.FileMode, section string) error {
+	return writeFile(filename, generateIniFile(data, section), mode)
+}
+
+// generateIniFile renders the secret as an ini file, optionally placing the keys under a section; nested
+// maps are written as their own sections
+func generateIniFile(data map[string]interface{}, section string) []byte {
 	var buf bytes.Buffer
-	for key, val := range data {
-		buf.WriteString(fmt.Sprintf("%s = %v\n", key, val))
+	writeIniSection(&buf, section, normalizeValue(data).(map[string]interface{}))
+
+	return buf.Bytes()
+}
+
+func writeIniSection(buf *bytes.Buffer, section string, data map[string]interface{}) {
+	if section != "" {
+		buf.WriteString(fmt.Sprintf("[%s]\n", section))
+	}
+	var sections []string
+	for _, key := range sortedKeys(data) {
+		if _, ok := data[key].(map[string]interface{}); ok {
+			sections = append(sections, key)
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%s = %v\n", key, data[key]))
+	}
+	for _, key := range sections {
+		name := key
+		if section != "" {
+			name = section + "." + key
+		}
+		buf.WriteString("\n")
+		writeIniSection(buf, name, data[key].(map[string]interface{}))
 	}
+}
 
-	return writeFile(filename, buf.Bytes(), mode)
+func writeTOMLFile(filename string, data map[string]interface{}, mode os.FileMode, section string) error {
+	return writeFile(filename, generateTOMLFile(data, section), mode)
+}
+
+// generateTOMLFile renders the secret as a toml document, optionally placing the keys under a table; nested
+// maps are written as sub-tables
+func generateTOMLFile(data map[string]interface{}, section string) []byte {
+	var buf bytes.Buffer
+	var table []string
+	if section != "" {
+		table = []string{section}
+	}
+	writeTOMLTable(&buf, table, normalizeValue(data).(map[string]interface{}))
+
+	return buf.Bytes()
+}
+
+func writeTOMLTable(buf *bytes.Buffer, table []string, data map[string]interface{}) {
+	if len(table) > 0 {
+		var names []string
+		for _, x := range table {
+			names = append(names, tomlKey(x))
+		}
+		buf.WriteString(fmt.Sprintf("[%s]\n", strings.Join(names, ".")))
+	}
+	var tables []string
+	for _, key := range sortedKeys(data) {
+		if _, ok := data[key].(map[string]interface{}); ok {
+			tables = append(tables, key)
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("%s = %s\n", tomlKey(key), tomlValue(data[key])))
+	}
+	for _, key := range tables {
+		buf.WriteString("\n")
+		writeTOMLTable(buf, append(append([]string{}, table...), key), data[key].(map[string]interface{}))
+	}
+}
+
+// tomlKey returns the key as a bare key if possible, otherwise a quoted one
+func tomlKey(key string) string {
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return tomlString(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+
+	return key
+}
+
+// tomlValue renders a value as a toml value
+func tomlValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `""`
+	case bool, int64, float64:
+		return fmt.Sprintf("%v", v)
+	case []interface{}:
+		var list []string
+		for _, x := range v {
+			list = append(list, tomlValue(x))
+		}
+		return "[" + strings.Join(list, ", ") + "]"
+	case string:
+		return tomlString(v)
+	default:
+		return tomlString(fmt.Sprintf("%v", v))
+	}
+}
+
+// tomlString renders a toml basic string, escaping as per the specification
+func tomlString(value string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range value {
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				buf.WriteString(fmt.Sprintf(`\u%04X`, c))
+				continue
+			}
+			buf.WriteRune(c)
+		}
+	}
+	buf.WriteByte('"')
+
+	return buf.String()
 }
 
 func writeCSVFile(filename string, data map[string]interface{}, mode os.FileMode) error {
@@ -52,7 +180,7 @@ func writeCSVFile(filename string, data map[string]interface{}, mode os.FileMode
 
 func writeYAMLFile(filename string, data map[string]interface{}, mode os.FileMode) error {
 	// marshall the content to yaml
-	content, err := yaml.Marshal(data)
+	content, err := generateYAMLFile(data)
 	if err != nil {
 		return err
 	}
@@ -60,6 +188,40 @@ func writeYAMLFile(filename string, data map[string]interface{}, mode os.FileMod
 	return writeFile(filename, content, mode)
 }
 
+// generateYAMLFile renders the secret as a yaml document, preserving any nested maps and lists
+func generateYAMLFile(data map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(normalizeValue(data))
+}
+
+// normalizeValue converts the json numbers decoded by the vault client into native numbers, so they
+// are not rendered as strings, walking any nested maps and lists
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, x := range v {
+			normalized[key] = normalizeValue(x)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, x := range v {
+			normalized[i] = normalizeValue(x)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
 func writeEnvFile(filename string, data map[string]interface{}, mode os.FileMode) error {
 	var buf bytes.Buffer
 	for key, val := range data {
@@ -69,7 +231,155 @@ func writeEnvFile(filename string, data map[string]interface{}, mode os.FileMode
 	return writeFile(filename, buf.Bytes(), mode)
 }
 
-func writeCertificateFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeDotEnvFile(filename string, data map[string]interface{}, mode os.FileMode, export bool) error {
+	return writeFile(filename, generateDotEnvFile(data, export), mode)
+}
+
+// generateDotEnvFile renders the secret as KEY=value lines which can be safely sourced by a shell,
+// quoting and escaping any values containing whitespace, quotes or special characters
+func generateDotEnvFile(data map[string]interface{}, export bool) []byte {
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(data) {
+		if export {
+			buf.WriteString("export ")
+		}
+		buf.WriteString(fmt.Sprintf("%s=%s\n", dotEnvKey(key), dotEnvValue(fmt.Sprintf("%v", data[key]))))
+	}
+
+	return buf.Bytes()
+}
+
+// dotEnvKey converts the key into a valid environment variable name
+func dotEnvKey(key string) string {
+	name := []rune(strings.ToUpper(key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] >= '0' && name[0] <= '9' {
+		return "_" + string(name)
+	}
+
+	return string(name)
+}
+
+// dotEnvValue quotes the value if it contains anything other than safe characters
+func dotEnvValue(value string) string {
+	safe := value != ""
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_-.,/:@+%=", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return value
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range value {
+		switch c {
+		case '"', '\\', '$', '`':
+			buf.WriteRune('\\')
+			buf.WriteRune(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	buf.WriteByte('"')
+
+	return buf.String()
+}
+
+func writePropertiesFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+	return writeFile(filename, generatePropertiesFile(data), mode)
+}
+
+// generatePropertiesFile renders the secret as a java properties file, flattening nested maps into dotted
+// keys and escaping as per java.util.Properties
+func generatePropertiesFile(data map[string]interface{}) []byte {
+	flattened := make(map[string]interface{})
+	flattenValue(flattened, "", normalizeValue(data))
+
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(flattened) {
+		buf.WriteString(fmt.Sprintf("%s=%s\n", propertiesEscape(key, true), propertiesEscape(fmt.Sprintf("%v", flattened[key]), false)))
+	}
+
+	return buf.Bytes()
+}
+
+// flattenValue flattens nested maps into dotted keys, joining lists with commas
+func flattenValue(flattened map[string]interface{}, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, x := range v {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flattenValue(flattened, name, x)
+		}
+	case []interface{}:
+		var list []string
+		for _, x := range v {
+			list = append(list, fmt.Sprintf("%v", x))
+		}
+		flattened[prefix] = strings.Join(list, ",")
+	case nil:
+		flattened[prefix] = ""
+	default:
+		flattened[prefix] = v
+	}
+}
+
+// propertiesEscape escapes a key or value for a java properties file; non ascii characters are written
+// as unicode escapes so the file is valid in ISO-8859-1
+func propertiesEscape(value string, key bool) string {
+	var buf bytes.Buffer
+	for i, c := range value {
+		switch {
+		case c == '\\':
+			buf.WriteString(`\\`)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c == '\t':
+			buf.WriteString(`\t`)
+		case c == '\f':
+			buf.WriteString(`\f`)
+		case c == ' ' && (key || i == 0):
+			buf.WriteString(`\ `)
+		case key && strings.ContainsRune("=:#!", c), !key && i == 0 && strings.ContainsRune("#!", c):
+			buf.WriteRune('\\')
+			buf.WriteRune(c)
+		case c < 0x20 || c > 0x7e:
+			if c > 0xffff {
+				for _, x := range utf16.Encode([]rune{c}) {
+					buf.WriteString(fmt.Sprintf(`\u%04x`, x))
+				}
+				continue
+			}
+			buf.WriteString(fmt.Sprintf(`\u%04x`, c))
+		default:
+			buf.WriteRune(c)
+		}
+	}
+
+	return buf.String()
+}
+
+func writeCertificateFile(filename string, data map[string]interface{}, mode os.FileMode, chainOpts *chainOptions) error {
+	if chainOpts != nil {
+		data = withCertificate(data, leafCertificate(data, chainOpts))
+	}
 	files := map[string]string{
 		"certificate": "crt",
 		"issuing_ca":  "ca",
@@ -94,7 +404,7 @@ func writeCertificateFile(filename string, data map[string]interface{}, mode os.
 
 }
 
-func writeCertificateBundleFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeCertificateBundleFile(filename string, data map[string]interface{}, mode os.FileMode, chainOpts *chainOptions) error {
 	bundleFile := fmt.Sprintf("%s-bundle.pem", filename)
 	keyFile := fmt.Sprintf("%s-key.pem", filename)
 	caFile := fmt.Sprintf("%s-ca.pem", filename)
@@ -104,6 +414,10 @@ func writeCertificateBundleFile(filename string, data map[string]interface{}, mo
 	key := fmt.Sprintf("%s\n", data["private_key"])
 	ca := fmt.Sprintf("%s\n", data["issuing_ca"])
 	certificate := fmt.Sprintf("%s\n", data["certificate"])
+	if chainOpts != nil {
+		bundle = fmt.Sprintf("%s\n%s", certificateChain(data, chainOpts), data["private_key"])
+		certificate = leafCertificate(data, chainOpts)
+	}
 
 	if err := writeFile(bundleFile, []byte(bundle), mode); err != nil {
 		glog.Errorf("failed to write the bundled certificate file, error: %s", err)
@@ -128,7 +442,7 @@ func writeCertificateBundleFile(filename string, data map[string]interface{}, mo
 	return nil
 }
 
-func writeCertificateChainFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeCertificateChainFile(filename string, data map[string]interface{}, mode os.FileMode, chainOpts *chainOptions) error {
 	certChainFile := fmt.Sprintf("%s-cert-chain.pem", filename)
 	keyFile := fmt.Sprintf("%s-key.pem", filename)
 	caFile := fmt.Sprintf("%s-ca.pem", filename)
@@ -150,6 +464,10 @@ func writeCertificateChainFile(filename string, data map[string]interface{}, mod
 	key := fmt.Sprintf("%s\n", data["private_key"])
 	ca := fmt.Sprintf("%s\n", data["issuing_ca"])
 	certificate := fmt.Sprintf("%s\n", data["certificate"])
+	if chainOpts != nil {
+		certChain = certificateChain(data, chainOpts)
+		certificate = leafCertificate(data, chainOpts)
+	}
 
 	if err := writeFile(certChainFile, []byte(certChain), mode); err != nil {
 		glog.Errorf("failed to write the bundle chain certificate file, error: %s", err)
@@ -217,13 +535,122 @@ func generateAwsCredentialFile(data map[string]interface{}) []byte {
 	return []byte(fmt.Sprintf("%s\n%s\n%s\n", profileName, accessKey, secretKey))
 }
 
+// credentialFileMode is the only mode psql and the mysql client accept a credentials file with
+const credentialFileMode = os.FileMode(0600)
+
+// databaseTarget is the server and database the credentials in a pgpass or my.cnf file are for
+type databaseTarget struct {
+	// the hostname of the server
+	host string
+	// the port of the server
+	port string
+	// the name of the database
+	database string
+}
+
+// targetValue returns the value of the target, falling back to the secret, then the default
+func targetValue(value string, data map[string]interface{}, key, fallback string) string {
+	if value != "" {
+		return value
+	}
+	if x, found := data[key]; found && x != nil {
+		return fmt.Sprintf("%v", x)
+	}
+
+	return fallback
+}
+
+// writeCredentialsFile writes a database credentials file, enforcing the 0600 mode the clients require
+// even when the file already exists with a wider one
+func writeCredentialsFile(filename string, content []byte, mode os.FileMode) error {
+	if mode != credentialFileMode {
+		glog.V(4).Infof("the credentials file: %s is written with the mode: %#o rather than: %#o", filename, credentialFileMode, mode)
+	}
+	if err := writeFile(filename, content, credentialFileMode); err != nil {
+		return err
+	}
+	if options.dryRun {
+		return nil
+	}
+
+	return os.Chmod(filename, credentialFileMode)
+}
+
+// writePgPassFile writes the credentials as a postgres password file, as read by psql and libpq
+//	filename	: the file to write
+//	data		: the secret holding the username and password
+//	mode		: the file permissions, always 0600
+//	target		: the server and database the credentials are for
+func writePgPassFile(filename string, data map[string]interface{}, mode os.FileMode, target databaseTarget) error {
+	return writeCredentialsFile(filename, generatePgPassFile(data, target), mode)
+}
+
+// generatePgPassFile renders the credentials as a hostname:port:database:username:password line; any
+// part of the target not known matches anything
+func generatePgPassFile(data map[string]interface{}, target databaseTarget) []byte {
+	fields := []string{
+		targetValue(target.host, data, "host", "*"),
+		targetValue(target.port, data, "port", "*"),
+		targetValue(target.database, data, "database", "*"),
+		fmt.Sprintf("%v", data["username"]),
+		fmt.Sprintf("%v", data["password"]),
+	}
+	for i, x := range fields {
+		if x == "*" && i < 3 {
+			continue
+		}
+		// step: backslashes and colons are escaped with a backslash
+		fields[i] = strings.NewReplacer(`\`, `\\`, ":", `\:`).Replace(x)
+	}
+
+	return []byte(strings.Join(fields, ":") + "\n")
+}
+
+// writeMyCnfFile writes the credentials as a mysql option file, as read by the mysql client
+//	filename	: the file to write
+//	data		: the secret holding the username and password
+//	mode		: the file permissions, always 0600
+//	section		: the option group, client by default
+//	target		: the server and database the credentials are for
+func writeMyCnfFile(filename string, data map[string]interface{}, mode os.FileMode, section string, target databaseTarget) error {
+	return writeCredentialsFile(filename, generateMyCnfFile(data, section, target), mode)
+}
+
+// generateMyCnfFile renders the credentials as an option group of a mysql option file, the database going under a
+// [mysql] group of its own as only the mysql client knows the option, mysqldump and mysqladmin refusing it
+func generateMyCnfFile(data map[string]interface{}, section string, target databaseTarget) []byte {
+	if section == "" {
+		section = "client"
+	}
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("[%s]\n", section))
+	buf.WriteString(fmt.Sprintf("user=\"%s\"\n", quote.Replace(fmt.Sprintf("%v", data["username"]))))
+	buf.WriteString(fmt.Sprintf("password=\"%s\"\n", quote.Replace(fmt.Sprintf("%v", data["password"]))))
+	if host := targetValue(target.host, data, "host", ""); host != "" {
+		buf.WriteString(fmt.Sprintf("host=\"%s\"\n", quote.Replace(host)))
+	}
+	if port := targetValue(target.port, data, "port", ""); port != "" {
+		buf.WriteString(fmt.Sprintf("port=%s\n", port))
+	}
+	if database := targetValue(target.database, data, "database", ""); database != "" {
+		if section != "mysql" {
+			buf.WriteString("\n[mysql]\n")
+		}
+		buf.WriteString(fmt.Sprintf("database=\"%s\"\n", quote.Replace(database)))
+	}
+
+	return buf.Bytes()
+}
+
 func writeTxtFile(filename string, data map[string]interface{}, mode os.FileMode) error {
 	keys := getKeys(data)
 	if len(keys) > 1 {
 		// step: for plain formats we need to iterate the keys and produce a file per key
 		for suffix, content := range data {
 			name := fmt.Sprintf("%s.%s", filename, suffix)
-			if err := writeFile(name, []byte(fmt.Sprintf("%v", content)), mode); err != nil {
+			if err := streamFile(name, mode, func(w io.Writer) error { return writeValue(w, content) }); err != nil {
 				glog.Errorf("failed to write resource: %s, elemment: %s, filename: %s, error: %s",
 					filename, suffix, name, err)
 				continue
@@ -234,9 +661,8 @@ func writeTxtFile(filename string, data map[string]interface{}, mode os.FileMode
 
 	// step: we only have the one key, so will write plain
 	value, _ := data[keys[0]]
-	content := []byte(fmt.Sprintf("%s", value))
 
-	return writeFile(filename, content, mode)
+	return streamFile(filename, mode, func(w io.Writer) error { return writeValue(w, value) })
 }
 
 func writeRootCAFile(filename string, data map[string]interface{}, mode os.FileMode) error {
@@ -279,27 +705,306 @@ func writeJSONFile(filename string, data map[string]interface{}, mode os.FileMod
 	return writeFile(filename, content, mode)
 }
 
-func writeTemplateFile(filename string, data map[string]interface{}, mode os.FileMode, templateFile string) error {
-	tpl := template.Must(template.ParseFiles(templateFile))
+func writeTemplateFile(filename string, data map[string]interface{}, mode os.FileMode, templateFile, syntax string) error {
+	tpl, err := parseTemplate(templateFile, syntax)
+	if err != nil {
+		return fmt.Errorf("unable to parse the template: %s, error: %s", templateFile, err)
+	}
 
 	var templateOutput bytes.Buffer
 	if err := tpl.Execute(&templateOutput, data); err != nil {
 		return err
 	}
 
-	content := []byte(fmt.Sprintf("%s", templateOutput.String()))
-
-	return writeFile(filename, content, mode)
+	return streamFile(filename, mode, func(w io.Writer) error {
+		_, err := w.Write(templateOutput.Bytes())
+		return err
+	})
 }
 
 // writeFile writes the file to stdout or an actual file
 func writeFile(filename string, content []byte, mode os.FileMode) error {
+	return streamFile(filename, mode, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// streamFile writes the content produced by the writer function straight to stdout or the file, so large
+// values don't need to be held in memory again as a whole
+//	filename	: the file to write
+//	mode		: the file permissions
+//	write		: writes the content
+func streamFile(filename string, mode os.FileMode, write func(io.Writer) error) error {
+	if isStdout(filename) {
+		return write(os.Stdout)
+	}
+	if hookInputs.capturing() {
+		return hookInputs.store(write)
+	}
+	if servesFromMemory() {
+		return memoryFiles.store(filename, mode, write)
+	}
 	if options.dryRun {
 		glog.Infof("dry-run: filename: %s, content:", filename)
-		fmt.Printf("%s\n", string(content))
+		if err := write(os.Stdout); err != nil {
+			return err
+		}
+		fmt.Println()
 		return nil
 	}
+	mode, part := modes.mode(filename, mode)
+	// step: leave the file alone when it already holds the content
+	if same, err := changes.unchanged(filename, write); err != nil {
+		return err
+	} else if same {
+		glog.V(3).Infof("the file: %s is unchanged, skipping", filename)
+		writtenFiles.add(filename)
+		audit.unchanged(filename)
+		if part {
+			if err := os.Chmod(filename, mode); err != nil {
+				return err
+			}
+			audit.chmod(filename, mode)
+		}
+		if err := checksums.recordFile(filename); err != nil {
+			return err
+		}
+		return owners.apply(filename)
+	}
 	glog.V(3).Infof("saving the file: %s", filename)
 
-	return ioutil.WriteFile(filename, content, mode)
+	if err := journal.record(filename); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	// step: an existing file keeps its mode when opened, so the mode of a part is applied before the content
+	if part {
+		if err := file.Chmod(mode); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	// step: hash the content as it's written when a checksum file is kept or the writes are audited
+	writers := []io.Writer{file}
+	h := checksums.hasher()
+	if h != nil {
+		writers = append(writers, h)
+	}
+	ah, counter := audit.hasher(), &countingWriter{}
+	if ah != nil {
+		writers = append(writers, ah, counter)
+	}
+	if err := write(io.MultiWriter(writers...)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if h != nil {
+		checksums.record(filename, h.Sum(nil))
+	}
+	if err := applyFileACL(filename, mode); err != nil {
+		return err
+	}
+	writtenFiles.add(filename)
+	audit.write(filename, mode, ah, counter.size)
+
+	return owners.apply(filename)
+}
+
+// writeValue writes a value of a secret, strings and bytes as they are without copying them
+//	w			: the writer
+//	value		: the value to write
+func writeValue(w io.Writer, value interface{}) error {
+	var err error
+	switch x := value.(type) {
+	case string:
+		_, err = io.WriteString(w, x)
+	case []byte:
+		_, err = w.Write(x)
+	default:
+		_, err = fmt.Fprintf(w, "%v", x)
+	}
+
+	return err
+}
+
+// writePKCS12File writes the private key, certificate and chain of a pki resource into a pkcs12 keystore
+//	filename	: the filename of the resource, the keystore is written to filename.p12
+//	data		: the data of the pki resource
+//	mode		: the file permissions
+//	alias		: the friendly name of the key in the keystore
+//	passphrase	: the passphrase of the keystore, or where to find it
+func writePKCS12File(filename string, data map[string]interface{}, mode os.FileMode, alias, passphrase string) error {
+	content, err := generatePKCS12File(data, alias, passphrase)
+	if err != nil {
+		glog.Errorf("failed to generate the pkcs12 keystore, error: %s", err)
+		return err
+	}
+
+	return writeFile(fmt.Sprintf("%s.p12", filename), content, mode)
+}
+
+// generatePKCS12File creates a pkcs12 keystore from the data of a pki resource
+func generatePKCS12File(data map[string]interface{}, alias, passphrase string) ([]byte, error) {
+	password, err := keystorePassword(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodePrivateKey(fmt.Sprintf("%v", data["private_key"]))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode the private key, error: %s", err)
+	}
+	certs, err := pkiCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodePKCS12(key, certs, alias, password)
+}
+
+// writeJKSFile writes the key pair of a pki resource into a java keystore, and the ca chain into a truststore
+//	filename	: the filename of the resource, the stores are written to filename.jks and filename-truststore.jks
+//	data		: the data of the pki resource
+//	mode		: the file permissions
+//	alias		: the alias of the key in the keystore
+//	caAlias		: the alias of the ca certificates in the truststore
+//	passphrase	: the password of the stores, or where to find it
+func writeJKSFile(filename string, data map[string]interface{}, mode os.FileMode, alias, caAlias, passphrase string) error {
+	keystore, truststore, err := generateJKSFiles(data, alias, caAlias, passphrase)
+	if err != nil {
+		glog.Errorf("failed to generate the java keystore, error: %s", err)
+		return err
+	}
+	if err := writeFile(fmt.Sprintf("%s.jks", filename), keystore, mode); err != nil {
+		glog.Errorf("failed to write the java keystore, error: %s", err)
+		return err
+	}
+	if err := writeFile(fmt.Sprintf("%s-truststore.jks", filename), truststore, mode); err != nil {
+		glog.Errorf("failed to write the java truststore, error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// generateJKSFiles creates the java keystore and truststore from the data of a pki resource
+func generateJKSFiles(data map[string]interface{}, alias, caAlias, passphrase string) ([]byte, []byte, error) {
+	password, err := keystorePassword(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := decodePrivateKey(fmt.Sprintf("%v", data["private_key"]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to decode the private key, error: %s", err)
+	}
+	certs, err := pkiCertificates(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(certs) < 2 {
+		return nil, nil, errors.New("no ca certificates found in the resource for the truststore")
+	}
+	if alias == "" {
+		alias = "vault"
+	}
+	if caAlias == "" {
+		caAlias = "ca"
+	}
+
+	keystore, err := encodeJKSKeyStore(key, certs, alias, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keystore, encodeJKSTrustStore(certs[1:], caAlias, password), nil
+}
+
+// withCertificate returns a copy of the data of a pki resource with the certificate replaced
+func withCertificate(data map[string]interface{}, certificate string) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	copied["certificate"] = certificate
+
+	return copied
+}
+
+// combinedParts are the parts of a pki resource which can be placed in the combined format
+var combinedParts = map[string]func(map[string]interface{}, *chainOptions) string{
+	"key": func(data map[string]interface{}, _ *chainOptions) string {
+		return fmt.Sprintf("%v", data["private_key"])
+	},
+	"cert": func(data map[string]interface{}, _ *chainOptions) string {
+		return fmt.Sprintf("%v", data["certificate"])
+	},
+	"chain": func(data map[string]interface{}, chain *chainOptions) string {
+		return strings.Join(caChain(data, chain), "\n")
+	},
+}
+
+// defaultCombinedOrder is the order of the combined format, as haproxy expects
+var defaultCombinedOrder = []string{"cert", "chain", "key"}
+
+// writeCombinedFile writes the key, certificate and chain of a pki resource concatenated into a single pem
+//	filename	: the filename to write
+//	data		: the data of the pki resource
+//	mode		: the file permissions
+//	order		: the order of the parts, defaults to the certificate, chain and then key
+//	chainOpts	: which of the ca certificates are included and in what order
+func writeCombinedFile(filename string, data map[string]interface{}, mode os.FileMode, order []string, chainOpts *chainOptions) error {
+	return writeFile(filename, generateCombinedFile(data, order, chainOpts), mode)
+}
+
+// generateCombinedFile concatenates the parts of a pki resource in the order given
+func generateCombinedFile(data map[string]interface{}, order []string, chainOpts *chainOptions) []byte {
+	if len(order) == 0 {
+		order = defaultCombinedOrder
+	}
+	if chainOpts == nil {
+		chainOpts = &chainOptions{}
+	}
+	var buf bytes.Buffer
+	for _, name := range order {
+		if part := strings.TrimSpace(combinedParts[name](data, chainOpts)); part != "" {
+			buf.WriteString(part + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// writeDERFiles writes the certificate, issuing ca and private key of a pki resource as der encoded
+// files FILE.crt, FILE.ca and FILE.key
+func writeDERFiles(filename string, data map[string]interface{}, mode os.FileMode) error {
+	files := []struct {
+		key    string
+		suffix string
+	}{
+		{"certificate", "crt"},
+		{"issuing_ca", "ca"},
+		{"private_key", "key"},
+	}
+	for _, x := range files {
+		content, found := data[x.key]
+		if !found {
+			continue
+		}
+		block, _ := pem.Decode([]byte(fmt.Sprintf("%v", content)))
+		if block == nil {
+			return fmt.Errorf("the %s of the resource is not pem encoded", x.key)
+		}
+		if err := writeFile(fmt.Sprintf("%s.%s", filename, x.suffix), block.Bytes, mode); err != nil {
+			glog.Errorf("failed to write the der file: %s.%s, error: %s", filename, x.suffix, err)
+			return err
+		}
+	}
+
+	return nil
 }
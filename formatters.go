@@ -0,0 +1,148 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Formatter renders the secret of a resource into one or more files; a format is added by registering
+// an implementation under its name from an init function, e.g. in a file of a fork
+type Formatter interface {
+	// Write formats the secret data of the resource and writes it to the filename, using writeFile or
+	// streamFile so dry runs, verification rollbacks and the output gc keep working
+	Write(filename string, rn *VaultResource, data map[string]interface{}) error
+}
+
+// FormatterFunc adapts a function to the Formatter interface
+type FormatterFunc func(filename string, rn *VaultResource, data map[string]interface{}) error
+
+// Write calls the function
+func (f FormatterFunc) Write(filename string, rn *VaultResource, data map[string]interface{}) error {
+	return f(filename, rn, data)
+}
+
+// formatters is the registry of the output formats, by name
+var formatters = struct {
+	sync.RWMutex
+	items map[string]Formatter
+}{items: make(map[string]Formatter)}
+
+// RegisterFormatter adds an output format, replacing any already registered under the name
+//	name		: the name of the format, as given to the fmt option
+//	formatter	: the implementation of the format
+func RegisterFormatter(name string, formatter Formatter) {
+	formatters.Lock()
+	defer formatters.Unlock()
+	formatters.items[name] = formatter
+}
+
+// lookupFormatter returns the implementation of a format
+func lookupFormatter(name string) (Formatter, bool) {
+	formatters.RLock()
+	defer formatters.RUnlock()
+	formatter, found := formatters.items[name]
+
+	return formatter, found
+}
+
+// formatterNames returns the names of the registered formats, sorted
+func formatterNames() []string {
+	formatters.RLock()
+	defer formatters.RUnlock()
+	var names []string
+	for name := range formatters.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	yamlFormat := FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeYAMLFile(filename, data, rn.FileMode)
+	})
+	RegisterFormatter("yaml", yamlFormat)
+	RegisterFormatter("yml", yamlFormat)
+	RegisterFormatter("json", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeJSONFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("ini", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeIniFile(filename, data, rn.FileMode, rn.Section)
+	}))
+	RegisterFormatter("toml", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeTOMLFile(filename, data, rn.FileMode, rn.Section)
+	}))
+	RegisterFormatter("csv", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeCSVFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("env", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeEnvFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("properties", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writePropertiesFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("dotenv", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeDotEnvFile(filename, data, rn.FileMode, rn.Export)
+	}))
+	RegisterFormatter("rootca", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeRootCAFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("cert", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeCertificateFile(filename, data, rn.FileMode, rn.Chain)
+	}))
+	RegisterFormatter("certchain", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeCertificateChainFile(filename, data, rn.FileMode, rn.Chain)
+	}))
+	RegisterFormatter("txt", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeTxtFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("bundle", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeCertificateBundleFile(filename, data, rn.FileMode, rn.Chain)
+	}))
+	RegisterFormatter("combined", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeCombinedFile(filename, data, rn.FileMode, rn.Order, rn.Chain)
+	}))
+	RegisterFormatter("der", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeDERFiles(filename, data, rn.FileMode)
+	}))
+	pkcs12Format := FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writePKCS12File(filename, data, rn.FileMode, rn.GetKeyAlias(), rn.Passphrase)
+	})
+	RegisterFormatter("p12", pkcs12Format)
+	RegisterFormatter("pkcs12", pkcs12Format)
+	RegisterFormatter("jks", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeJKSFile(filename, data, rn.FileMode, rn.GetKeyAlias(), rn.CAAlias, rn.Passphrase)
+	}))
+	RegisterFormatter("credential", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeCredentialFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("template", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeTemplateFile(filename, data, rn.FileMode, rn.TemplateFile, rn.TemplateSyntax)
+	}))
+	RegisterFormatter("aws", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeAwsCredentialFile(filename, data, rn.FileMode)
+	}))
+	RegisterFormatter("pgpass", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writePgPassFile(filename, data, rn.FileMode, rn.databaseTarget())
+	}))
+	RegisterFormatter("mycnf", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeMyCnfFile(filename, data, rn.FileMode, rn.Section, rn.databaseTarget())
+	}))
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatterRegistry(t *testing.T) {
+	for _, name := range []string{"yaml", "yml", "json", "txt", "p12", "pkcs12", "template", "pgpass", "mycnf"} {
+		_, found := lookupFormatter(name)
+		assert.True(t, found, "format: %s", name)
+	}
+	_, found := lookupFormatter("company")
+	assert.False(t, found)
+
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+	items := &VaultResources{}
+	assert.Error(t, items.Set("secret:secret/app:fmt=company"))
+
+	RegisterFormatter("company", FormatterFunc(func(filename string, rn *VaultResource, data map[string]interface{}) error {
+		return writeFile(filename, []byte(fmt.Sprintf("%s=%v", rn.ID(), data["value"])), rn.FileMode)
+	}))
+	defer func() {
+		formatters.Lock()
+		delete(formatters.items, "company")
+		formatters.Unlock()
+	}()
+	assert.Contains(t, formatterNames(), "company")
+
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	mustNoError(t, items.Set("secret:secret/app:fmt=company,file="+filepath.Join(dir, "app")))
+	filename, err := writeResource(items.items[0], map[string]interface{}{"value": "test"})
+	mustNoError(t, err)
+	content, err := ioutil.ReadFile(filename)
+	mustNoError(t, err)
+	assert.Equal(t, "secret/app=test", string(content))
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templateFuncs returns the functions available to templates; they follow the names and argument order
+// of the commonly used sprig functions, so the value being piped is always the last argument
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      strings.Title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       templateJoin,
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"quote":      func(v interface{}) string { return fmt.Sprintf("%q", templateString(v)) },
+		"squote":     func(v interface{}) string { return "'" + templateString(v) + "'" },
+		"indent":     templateIndent,
+		"nindent":    func(spaces int, s string) string { return "\n" + templateIndent(spaces, s) },
+		"trunc":      templateTrunc,
+		// encoding
+		"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":       templateB64Decode,
+		"sha256sum":    templateSHA256,
+		"toJson":       templateJSON,
+		"toPrettyJson": templatePrettyJSON,
+		"toYaml":       templateYAML,
+		// defaults and flow
+		"default":  templateDefault,
+		"empty":    templateEmpty,
+		"coalesce": templateCoalesce,
+		"required": templateRequired,
+		"ternary":  templateTernary,
+		// dicts and lists
+		"list":   func(items ...interface{}) []interface{} { return items },
+		"dict":   templateDict,
+		"keys":   templateKeys,
+		"hasKey": func(m map[string]interface{}, key string) bool { _, found := m[key]; return found },
+		"get":    func(m map[string]interface{}, key string) interface{} { return m[key] },
+		// environment and time
+		"env":  os.Getenv,
+		"now":  time.Now,
+		"date": func(layout string, t time.Time) string { return t.Format(layout) },
+		// paths
+		"base": filepath.Base,
+		"dir":  filepath.Dir,
+		// the secrets of the named resources
+		"secret": templates.secret,
+	}
+}
+
+// parseTemplate parses a template file with the functions of its syntax
+//	filename	: the path of the template
+//	syntax		: the syntax of the template, go or consul
+func parseTemplate(filename, syntax string) (*template.Template, error) {
+	funcs := templateFuncs()
+	if syntax == templateSyntaxConsul {
+		funcs = consulTemplateFuncs()
+	}
+
+	return template.New(filepath.Base(filename)).Funcs(funcs).ParseFiles(filename)
+}
+
+// templateString converts a value to a string, as printed by the template
+func templateString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// templateJoin joins a list of any type with the separator
+func templateJoin(sep string, v interface{}) string {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return templateString(v)
+	}
+	var list []string
+	for i := 0; i < value.Len(); i++ {
+		list = append(list, templateString(value.Index(i).Interface()))
+	}
+
+	return strings.Join(list, sep)
+}
+
+// templateIndent indents each line of the string by the number of spaces
+func templateIndent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// templateTrunc truncates the string to the length, or removes the length from the start if negative
+func templateTrunc(length int, s string) string {
+	switch {
+	case length < 0 && len(s)+length > 0:
+		return s[len(s)+length:]
+	case length >= 0 && len(s) > length:
+		return s[:length]
+	}
+
+	return s
+}
+
+// templateB64Decode decodes a base64 string
+func templateB64Decode(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
+
+// templateSHA256 returns the hex encoded sha256 digest of the string
+func templateSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// templateJSON encodes the value as json
+func templateJSON(v interface{}) (string, error) {
+	encoded, err := json.Marshal(normalizeValue(v))
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+// templatePrettyJSON encodes the value as indented json
+func templatePrettyJSON(v interface{}) (string, error) {
+	encoded, err := json.MarshalIndent(normalizeValue(v), "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
+
+// templateYAML encodes the value as yaml
+func templateYAML(v interface{}) (string, error) {
+	encoded, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(encoded), "\n"), nil
+}
+
+// templateEmpty checks if the value is missing or the zero value of its type
+func templateEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+
+	return reflect.DeepEqual(v, reflect.Zero(value.Type()).Interface())
+}
+
+// templateDefault returns the value, or the default if it's empty
+func templateDefault(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || templateEmpty(v[0]) {
+		return def
+	}
+
+	return v[0]
+}
+
+// templateCoalesce returns the first value which is not empty
+func templateCoalesce(v ...interface{}) interface{} {
+	for _, x := range v {
+		if !templateEmpty(x) {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// templateRequired fails the render with the message if the value is empty
+func templateRequired(message string, v interface{}) (interface{}, error) {
+	if templateEmpty(v) {
+		return nil, errors.New(message)
+	}
+
+	return v, nil
+}
+
+// templateTernary returns the first value if the choice is true, otherwise the second
+func templateTernary(a, b interface{}, choice bool) interface{} {
+	if choice {
+		return a
+	}
+
+	return b
+}
+
+// templateDict builds a map from a list of key and value pairs
+func templateDict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict requires pairs of keys and values")
+	}
+	dict := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		dict[templateString(pairs[i])] = pairs[i+1]
+	}
+
+	return dict, nil
+}
+
+// templateKeys returns the sorted keys of a map
+func templateKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
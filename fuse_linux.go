@@ -0,0 +1,539 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the version of the fuse protocol spoken, the minor lowered to the kernel's when it's older
+	fuseMajor = 7
+	fuseMinor = 26
+	// the largest write the kernel is told to send, the filesystem being read only
+	fuseMaxWrite = 128 * 1024
+	// the nodeid of the root directory of the mount
+	fuseRootID = 1
+	// the file is read straight through, bypassing the page cache, so a rotation is seen by the next read
+	fuseDirectIO = 1 << 0
+	// the type of a directory and of a file in a directory entry
+	fuseTypeDir  = syscall.S_IFDIR >> 12
+	fuseTypeFile = syscall.S_IFREG >> 12
+)
+
+// the opcodes of the requests handled
+const (
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseFlush       = 25
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseAccess      = 34
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fusePoll        = 40
+	fuseBatchForget = 42
+)
+
+// fuseInHeader is the header of each request from the kernel
+type fuseInHeader struct {
+	Len     uint32
+	Opcode  uint32
+	Unique  uint64
+	NodeID  uint64
+	UID     uint32
+	GID     uint32
+	PID     uint32
+	Padding uint32
+}
+
+// fuseOutHeader is the header of each reply to the kernel
+type fuseOutHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+// fuseInitIn is the body of the init request
+type fuseInitIn struct {
+	Major        uint32
+	Minor        uint32
+	MaxReadahead uint32
+	Flags        uint32
+}
+
+// fuseInitOut is the reply to the init request
+type fuseInitOut struct {
+	Major               uint32
+	Minor               uint32
+	MaxReadahead        uint32
+	Flags               uint32
+	MaxBackground       uint16
+	CongestionThreshold uint16
+	MaxWrite            uint32
+	TimeGran            uint32
+	Unused              [9]uint32
+}
+
+// fuseAttr are the attributes of a file or directory
+type fuseAttr struct {
+	Ino       uint64
+	Size      uint64
+	Blocks    uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	Atimensec uint32
+	Mtimensec uint32
+	Ctimensec uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	Rdev      uint32
+	Blksize   uint32
+	Flags     uint32
+}
+
+// fuseEntryOut is the reply to a lookup
+type fuseEntryOut struct {
+	NodeID         uint64
+	Generation     uint64
+	EntryValid     uint64
+	AttrValid      uint64
+	EntryValidNsec uint32
+	AttrValidNsec  uint32
+	Attr           fuseAttr
+}
+
+// fuseAttrOut is the reply to a getattr
+type fuseAttrOut struct {
+	AttrValid     uint64
+	AttrValidNsec uint32
+	Dummy         uint32
+	Attr          fuseAttr
+}
+
+// fuseOpenIn is the body of an open request
+type fuseOpenIn struct {
+	Flags  uint32
+	Unused uint32
+}
+
+// fuseOpenOut is the reply to an open
+type fuseOpenOut struct {
+	Fh        uint64
+	OpenFlags uint32
+	Padding   uint32
+}
+
+// fuseReadIn is the body of a read or readdir request
+type fuseReadIn struct {
+	Fh        uint64
+	Offset    uint64
+	Size      uint32
+	ReadFlags uint32
+	LockOwner uint64
+	Flags     uint32
+	Padding   uint32
+}
+
+// fusePollOut is the reply to a poll
+type fusePollOut struct {
+	Revents uint32
+	Padding uint32
+}
+
+// fuseStatfsOut is the reply to a statfs
+type fuseStatfsOut struct {
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Bsize   uint32
+	Namelen uint32
+	Frsize  uint32
+	Padding uint32
+	Spare   [6]uint32
+}
+
+// fuseDirent is the header of an entry in the reply to a readdir, followed by the name padded to 8 bytes
+type fuseDirent struct {
+	Ino     uint64
+	Off     uint64
+	Namelen uint32
+	Type    uint32
+}
+
+// nativeEndian is the byte order of the host, which the kernel speaks the protocol in
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// fuseServer answers the requests of the kernel for a read only filesystem holding the files rendered in memory
+type fuseServer struct {
+	// the fuse device the requests are read from
+	fd int
+	// the files rendered in memory
+	store *memoryStore
+	// the uid and gid the files belong to
+	uid, gid uint32
+	// the nodeid of each file, by its name, and the name of each nodeid
+	nodes map[string]uint64
+	names map[uint64]string
+	// the content of each open file, taken when it was opened so a rotation doesn't change it mid read
+	handles map[uint64][]byte
+	// the next handle given out
+	next uint64
+}
+
+// fuseMounted is the path the secrets are mounted on, if any, cleared before it's unmounted on shutdown
+var fuseMounted = struct {
+	sync.Mutex
+	path string
+}{}
+
+// newFuseServer creates the server of the files held in the store
+//	fd			: the fuse device
+//	store		: the files rendered in memory
+func newFuseServer(fd int, store *memoryStore) *fuseServer {
+	return &fuseServer{
+		fd:      fd,
+		store:   store,
+		uid:     uint32(os.Getuid()),
+		gid:     uint32(os.Getgid()),
+		nodes:   make(map[string]uint64),
+		names:   make(map[uint64]string),
+		handles: make(map[uint64][]byte),
+	}
+}
+
+// mountFuse mounts a read only filesystem on the path, the resources appearing as files read from memory
+//	path		: the directory to mount on
+func mountFuse(path string) error {
+	fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open /dev/fuse, error: %s", err)
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=%o,user_id=%d,group_id=%d,default_permissions,allow_other",
+		fd, syscall.S_IFDIR, os.Getuid(), os.Getgid())
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_RDONLY)
+	if err := syscall.Mount("vault-sidekick", path, "fuse.vault-sidekick", flags, data); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("unable to mount the secrets, error: %s", err)
+	}
+	fuseMounted.Lock()
+	fuseMounted.path = path
+	fuseMounted.Unlock()
+	glog.Infof("serving the secrets from memory on the fuse mount: %s", path)
+	go func() {
+		err := newFuseServer(fd, memoryFiles).serve()
+		syscall.Close(fd)
+		fuseStopped(path, err)
+	}()
+
+	return nil
+}
+
+// fuseStopped is called once the server of the mount has stopped; unless the mount is being unmounted it's left
+// dead, every read failing, so it's unmounted and the sidekick exits rather than staying ready with no files
+//	path		: the directory mounted on
+//	err			: the error the server stopped with, if any
+func fuseStopped(path string, err error) {
+	fuseMounted.Lock()
+	defer fuseMounted.Unlock()
+	if fuseMounted.path != path {
+		return
+	}
+	glog.Errorf("the fuse mount: %s has stopped serving the secrets, error: %v, exiting", path, err)
+	if err := syscall.Unmount(path, syscall.MNT_DETACH); err != nil {
+		glog.Errorf("unable to unmount the secrets from: %s, error: %s", path, err)
+	}
+	fuseMounted.path = ""
+	exit(1)
+}
+
+// unmountFuse lazily unmounts the secrets, the files open being closed as they're finished with
+func unmountFuse() {
+	fuseMounted.Lock()
+	path := fuseMounted.path
+	fuseMounted.path = ""
+	fuseMounted.Unlock()
+	if path == "" {
+		return
+	}
+	if err := syscall.Unmount(path, syscall.MNT_DETACH); err != nil {
+		glog.Errorf("unable to unmount the secrets from: %s, error: %s", path, err)
+	}
+}
+
+// serve answers the requests of the kernel until the filesystem is unmounted
+func (f *fuseServer) serve() error {
+	buffer := make([]byte, fuseMaxWrite+4096)
+	for {
+		n, err := syscall.Read(f.fd, buffer)
+		switch err {
+		case nil:
+		case syscall.EINTR, syscall.EAGAIN, syscall.ENOENT:
+			// step: interrupted, or the request was aborted before it was read
+			continue
+		case syscall.ENODEV:
+			return nil
+		default:
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if done, err := f.handle(buffer[:n]); err != nil || done {
+			return err
+		}
+	}
+}
+
+// handle answers a request, returning true once the kernel has let the filesystem go
+//	request		: the request read from the device
+func (f *fuseServer) handle(request []byte) (bool, error) {
+	header := &fuseInHeader{}
+	if err := binary.Read(bytes.NewReader(request), nativeEndian, header); err != nil {
+		return false, err
+	}
+	body := request[unsafe.Sizeof(*header):]
+
+	switch header.Opcode {
+	case fuseInit:
+		in := &fuseInitIn{}
+		if err := binary.Read(bytes.NewReader(body), nativeEndian, in); err != nil {
+			return false, err
+		}
+		if in.Major != fuseMajor || in.Minor < 9 {
+			return false, f.reply(header, syscall.EPROTO, nil)
+		}
+		out := &fuseInitOut{Major: fuseMajor, Minor: fuseMinor, MaxReadahead: in.MaxReadahead, MaxWrite: fuseMaxWrite}
+		if in.Minor < out.Minor {
+			out.Minor = in.Minor
+		}
+		return false, f.reply(header, 0, out)
+	case fuseLookup:
+		if header.NodeID != fuseRootID {
+			return false, f.reply(header, syscall.ENOENT, nil)
+		}
+		name := strings.TrimRight(string(body), "\x00")
+		content, found := f.store.get(name)
+		if !found {
+			return false, f.reply(header, syscall.ENOENT, nil)
+		}
+		id := f.node(name)
+		return false, f.reply(header, 0, &fuseEntryOut{NodeID: id, Attr: f.fileAttr(id, name, content)})
+	case fuseGetattr:
+		if header.NodeID == fuseRootID {
+			return false, f.reply(header, 0, &fuseAttrOut{Attr: f.rootAttr()})
+		}
+		name, content, found := f.file(header.NodeID)
+		if !found {
+			return false, f.reply(header, syscall.ENOENT, nil)
+		}
+		return false, f.reply(header, 0, &fuseAttrOut{Attr: f.fileAttr(header.NodeID, name, content)})
+	case fuseOpen:
+		in := &fuseOpenIn{}
+		if err := binary.Read(bytes.NewReader(body), nativeEndian, in); err != nil {
+			return false, err
+		}
+		if in.Flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+			return false, f.reply(header, syscall.EROFS, nil)
+		}
+		_, content, found := f.file(header.NodeID)
+		if !found {
+			return false, f.reply(header, syscall.ENOENT, nil)
+		}
+		f.next++
+		f.handles[f.next] = content
+		return false, f.reply(header, 0, &fuseOpenOut{Fh: f.next, OpenFlags: fuseDirectIO})
+	case fuseRead:
+		in := &fuseReadIn{}
+		if err := binary.Read(bytes.NewReader(body), nativeEndian, in); err != nil {
+			return false, err
+		}
+		content, found := f.handles[in.Fh]
+		if !found {
+			return false, f.reply(header, syscall.EBADF, nil)
+		}
+		if in.Offset >= uint64(len(content)) {
+			return false, f.replyBytes(header, nil)
+		}
+		end := in.Offset + uint64(in.Size)
+		if end > uint64(len(content)) {
+			end = uint64(len(content))
+		}
+		return false, f.replyBytes(header, content[in.Offset:end])
+	case fuseRelease:
+		var fh uint64
+		if err := binary.Read(bytes.NewReader(body), nativeEndian, &fh); err != nil {
+			return false, err
+		}
+		delete(f.handles, fh)
+		return false, f.reply(header, 0, nil)
+	case fuseOpendir:
+		if header.NodeID != fuseRootID {
+			return false, f.reply(header, syscall.ENOTDIR, nil)
+		}
+		return false, f.reply(header, 0, &fuseOpenOut{})
+	case fuseReaddir:
+		in := &fuseReadIn{}
+		if err := binary.Read(bytes.NewReader(body), nativeEndian, in); err != nil {
+			return false, err
+		}
+		return false, f.replyBytes(header, f.readdir(in.Offset, in.Size))
+	case fusePoll:
+		// step: the content is in memory, so a file is always ready to be read
+		return false, f.reply(header, 0, &fusePollOut{Revents: syscall.EPOLLIN | syscall.EPOLLRDNORM})
+	case fuseStatfs:
+		return false, f.reply(header, 0, &fuseStatfsOut{Bsize: 4096, Frsize: 4096, Namelen: 255})
+	case fuseReleasedir, fuseFlush, fuseAccess:
+		return false, f.reply(header, 0, nil)
+	case fuseForget, fuseBatchForget, fuseInterrupt:
+		// step: the nodeids are kept for as long as the files are, nothing is interrupted as nothing blocks
+		return false, nil
+	case fuseDestroy:
+		return true, f.reply(header, 0, nil)
+	}
+
+	return false, f.reply(header, syscall.ENOSYS, nil)
+}
+
+// node returns the nodeid of the file, giving it one the first time it's looked up
+func (f *fuseServer) node(name string) uint64 {
+	if id, found := f.nodes[name]; found {
+		return id
+	}
+	id := uint64(len(f.nodes)) + fuseRootID + 1
+	f.nodes[name] = id
+	f.names[id] = name
+
+	return id
+}
+
+// file returns the name and content of the file with the nodeid
+func (f *fuseServer) file(id uint64) (string, []byte, bool) {
+	name, found := f.names[id]
+	if !found {
+		return "", nil, false
+	}
+	content, found := f.store.get(name)
+
+	return name, content, found
+}
+
+// rootAttr returns the attributes of the root directory
+func (f *fuseServer) rootAttr() fuseAttr {
+	return fuseAttr{Ino: fuseRootID, Mode: syscall.S_IFDIR | 0555, Nlink: 2, UID: f.uid, GID: f.gid}
+}
+
+// fileAttr returns the attributes of a file, read only with the mode of its resource
+func (f *fuseServer) fileAttr(id uint64, name string, content []byte) fuseAttr {
+	size := uint64(len(content))
+	return fuseAttr{
+		Ino:     id,
+		Size:    size,
+		Blocks:  (size + 511) / 512,
+		Mode:    syscall.S_IFREG | uint32(f.store.mode(name)&0444),
+		Nlink:   1,
+		UID:     f.uid,
+		GID:     f.gid,
+		Blksize: 4096,
+	}
+}
+
+// readdir returns the entries of the root directory from the offset, as many as fit in the size
+func (f *fuseServer) readdir(offset uint64, size uint32) []byte {
+	type entry struct {
+		ino  uint64
+		name string
+		kind uint32
+	}
+	entries := []entry{{fuseRootID, ".", fuseTypeDir}, {fuseRootID, "..", fuseTypeDir}}
+	for _, name := range f.store.names() {
+		entries = append(entries, entry{f.node(name), name, fuseTypeFile})
+	}
+
+	out := &bytes.Buffer{}
+	for i := offset; i < uint64(len(entries)); i++ {
+		x := entries[i]
+		length := int(unsafe.Sizeof(fuseDirent{})) + len(x.name)
+		padded := (length + 7) &^ 7
+		if out.Len()+padded > int(size) {
+			break
+		}
+		binary.Write(out, nativeEndian, &fuseDirent{Ino: x.ino, Off: i + 1, Namelen: uint32(len(x.name)), Type: x.kind})
+		out.WriteString(x.name)
+		out.Write(make([]byte, padded-length))
+	}
+
+	return out.Bytes()
+}
+
+// reply writes the reply to a request, an errno or the body
+func (f *fuseServer) reply(header *fuseInHeader, errno syscall.Errno, body interface{}) error {
+	payload := &bytes.Buffer{}
+	if errno == 0 && body != nil {
+		if err := binary.Write(payload, nativeEndian, body); err != nil {
+			return err
+		}
+	}
+
+	return f.write(header, -int32(errno), payload.Bytes())
+}
+
+// replyBytes writes the reply to a read
+func (f *fuseServer) replyBytes(header *fuseInHeader, content []byte) error {
+	return f.write(header, 0, content)
+}
+
+// write writes the reply, its header followed by the payload, in one write as the device expects
+func (f *fuseServer) write(header *fuseInHeader, errno int32, payload []byte) error {
+	out := &bytes.Buffer{}
+	length := uint32(unsafe.Sizeof(fuseOutHeader{})) + uint32(len(payload))
+	binary.Write(out, nativeEndian, &fuseOutHeader{Len: length, Error: errno, Unique: header.Unique})
+	out.Write(payload)
+	if _, err := syscall.Write(f.fd, out.Bytes()); err != nil && err != syscall.ENOENT {
+		// step: ENOENT being the request having been interrupted, which is no failure of the mount
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fuseClient plays the kernel, sending the requests to the server over a socket pair
+type fuseClient struct {
+	t      *testing.T
+	fd     int
+	server *fuseServer
+	unique uint64
+}
+
+func newFuseClient(t *testing.T, store *memoryStore) (*fuseClient, func()) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	mustNoError(t, err)
+
+	return &fuseClient{t: t, fd: fds[0], server: newFuseServer(fds[1], store)}, func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	}
+}
+
+// call sends the request to the server and returns the errno and body of the reply
+func (c *fuseClient) call(opcode uint32, node uint64, body interface{}) (int32, []byte) {
+	payload := &bytes.Buffer{}
+	if s, ok := body.(string); ok {
+		payload.WriteString(s + "\x00")
+	} else if body != nil {
+		mustNoError(c.t, binary.Write(payload, nativeEndian, body))
+	}
+	c.unique += 2
+	request := &bytes.Buffer{}
+	length := uint32(unsafe.Sizeof(fuseInHeader{})) + uint32(payload.Len())
+	binary.Write(request, nativeEndian, &fuseInHeader{Len: length, Opcode: opcode, Unique: c.unique, NodeID: node})
+	request.Write(payload.Bytes())
+
+	_, err := c.server.handle(request.Bytes())
+	mustNoError(c.t, err)
+	reply := make([]byte, 1<<16)
+	n, err := syscall.Read(c.fd, reply)
+	mustNoError(c.t, err)
+	header := &fuseOutHeader{}
+	mustNoError(c.t, binary.Read(bytes.NewReader(reply[:n]), nativeEndian, header))
+	assert.Equal(c.t, c.unique, header.Unique)
+	assert.Equal(c.t, uint32(n), header.Len)
+
+	return header.Error, reply[unsafe.Sizeof(*header):n]
+}
+
+func TestFuseServer(t *testing.T) {
+	store := &memoryStore{files: make(map[string][]byte), modes: make(map[string]os.FileMode)}
+	store.store("/secrets/db.json", 0640, func(w io.Writer) error {
+		_, err := w.Write([]byte(`{"password":"hunter22"}`))
+		return err
+	})
+	client, closer := newFuseClient(t, store)
+	defer closer()
+
+	errno, body := client.call(fuseInit, 0, &fuseInitIn{Major: 7, Minor: 31, MaxReadahead: 4096})
+	init := &fuseInitOut{}
+	mustNoError(t, binary.Read(bytes.NewReader(body), nativeEndian, init))
+	assert.Zero(t, errno)
+	assert.Equal(t, uint32(fuseMinor), init.Minor)
+
+	// step: the files are looked up in the root by name, read only with the mode of their resource
+	errno, _ = client.call(fuseLookup, fuseRootID, "missing.json")
+	assert.Equal(t, -int32(syscall.ENOENT), errno)
+	errno, body = client.call(fuseLookup, fuseRootID, "db.json")
+	entry := &fuseEntryOut{}
+	mustNoError(t, binary.Read(bytes.NewReader(body), nativeEndian, entry))
+	assert.Zero(t, errno)
+	assert.Equal(t, uint32(syscall.S_IFREG|0440), entry.Attr.Mode)
+	assert.Equal(t, uint64(23), entry.Attr.Size)
+
+	errno, _ = client.call(fuseOpen, entry.NodeID, &fuseOpenIn{Flags: syscall.O_WRONLY})
+	assert.Equal(t, -int32(syscall.EROFS), errno)
+	errno, body = client.call(fuseOpen, entry.NodeID, &fuseOpenIn{Flags: syscall.O_RDONLY})
+	open := &fuseOpenOut{}
+	mustNoError(t, binary.Read(bytes.NewReader(body), nativeEndian, open))
+	assert.Zero(t, errno)
+
+	// step: an open file keeps the content it was opened with, a rotation being seen by the next open
+	store.store("db.json", 0640, func(w io.Writer) error {
+		_, err := w.Write([]byte(`{"password":"rotated"}`))
+		return err
+	})
+	_, body = client.call(fuseRead, entry.NodeID, &fuseReadIn{Fh: open.Fh, Offset: 13, Size: 8})
+	assert.Equal(t, "hunter22", string(body))
+	client.call(fuseRelease, entry.NodeID, &fuseReadIn{Fh: open.Fh})
+	assert.Empty(t, client.server.handles)
+	_, body = client.call(fuseOpen, entry.NodeID, &fuseOpenIn{})
+	mustNoError(t, binary.Read(bytes.NewReader(body), nativeEndian, open))
+	_, body = client.call(fuseRead, entry.NodeID, &fuseReadIn{Fh: open.Fh, Size: 4096})
+	assert.Equal(t, `{"password":"rotated"}`, string(body))
+
+	// step: the root lists the files after . and ..
+	_, body = client.call(fuseReaddir, fuseRootID, &fuseReadIn{Size: 4096})
+	var names []string
+	for reader := bytes.NewReader(body); reader.Len() > 0; {
+		dirent := &fuseDirent{}
+		mustNoError(t, binary.Read(reader, nativeEndian, dirent))
+		name := make([]byte, (int(unsafe.Sizeof(*dirent))+int(dirent.Namelen)+7)&^7-int(unsafe.Sizeof(*dirent)))
+		reader.Read(name)
+		names = append(names, string(name[:dirent.Namelen]))
+	}
+	assert.Equal(t, []string{".", "..", "db.json"}, names)
+
+	errno, _ = client.call(fuseOpendir+100, fuseRootID, nil)
+	assert.Equal(t, -int32(syscall.ENOSYS), errno)
+}
+
+func TestFuseMount(t *testing.T) {
+	previous := memoryFiles
+	memoryFiles = &memoryStore{files: make(map[string][]byte), modes: make(map[string]os.FileMode)}
+	defer func() { memoryFiles = previous }()
+	dir, err := ioutil.TempDir("", "fuse")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	if err := mountFuse(dir); err != nil {
+		t.Skipf("unable to mount with fuse here, %s", err)
+	}
+	defer unmountFuse()
+	memoryFiles.store("fuse-mount.json", 0444, func(w io.Writer) error {
+		_, err := w.Write([]byte("secret"))
+		return err
+	})
+
+	// step: the mount is read by another process, the sidekick itself never reading its own mount
+	content, err := exec.Command("cat", filepath.Join(dir, "fuse-mount.json")).Output()
+	mustNoError(t, err)
+	assert.Equal(t, "secret", string(content))
+	assert.Error(t, exec.Command("sh", "-c", "echo x > "+filepath.Join(dir, "fuse-mount.json")).Run())
+
+	// step: the server stopping on an unmount is expected, rather than a dead mount to exit on
+	unmountFuse()
+	fuseStopped(dir, nil)
+}
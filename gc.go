@@ -0,0 +1,195 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// managedFiles is the set of files written by the sidekick
+type managedFiles struct {
+	sync.RWMutex
+	// a map of the cleaned filenames written
+	files map[string]bool
+	// the resource each file was written for, so its files are let go when it's removed
+	resources map[string]*VaultResource
+	// the resource the files are being written for, nil for those of the sidekick itself
+	resource *VaultResource
+}
+
+// writtenFiles are the files written by the sidekick since it started
+var writtenFiles = newManagedFiles()
+
+// newManagedFiles creates an empty set of files
+func newManagedFiles() *managedFiles {
+	return &managedFiles{files: make(map[string]bool), resources: make(map[string]*VaultResource)}
+}
+
+// add records the file as written by the sidekick, for the resource being written if any
+func (m *managedFiles) add(filename string) {
+	m.Lock()
+	defer m.Unlock()
+	filename = filepath.Clean(filename)
+	m.files[filename] = true
+	if m.resource != nil {
+		m.resources[filename] = m.resource
+	} else {
+		delete(m.resources, filename)
+	}
+}
+
+// begin attributes the files written until end is called to the resource
+func (m *managedFiles) begin(rn *VaultResource) {
+	m.Lock()
+	defer m.Unlock()
+	m.resource = rn
+}
+
+// end stops attributing the files written to the resource
+func (m *managedFiles) end() {
+	m.Lock()
+	defer m.Unlock()
+	m.resource = nil
+}
+
+// forget lets go of the files written for a resource which is no longer wanted, leaving them to be collected
+//	rn			: the resource removed or replaced
+func (m *managedFiles) forget(rn *VaultResource) {
+	m.Lock()
+	defer m.Unlock()
+	for filename, owner := range m.resources {
+		if owner == rn {
+			delete(m.files, filename)
+			delete(m.resources, filename)
+		}
+	}
+}
+
+// has checks if the file was written by the sidekick
+func (m *managedFiles) has(filename string) bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.files[filepath.Clean(filename)]
+}
+
+// outputCollector removes the files in the output directory which the sidekick did not write, once
+// every resource has been rendered at least once
+type outputCollector struct {
+	sync.Mutex
+	// the directory being managed
+	directory string
+	// log what would be removed rather than removing it
+	dryRun bool
+	// the resources which have not been rendered yet
+	pending map[*VaultResource]bool
+}
+
+// newOutputCollector creates a collector for the output directory
+//	directory	: the output directory being managed
+//	items		: the resources which must all be rendered before anything is collected
+//	dryRun		: whether to log the files rather than remove them
+func newOutputCollector(directory string, items []*VaultResource, dryRun bool) *outputCollector {
+	pending := make(map[*VaultResource]bool)
+	for _, rn := range items {
+		pending[rn] = true
+	}
+
+	return &outputCollector{directory: directory, dryRun: dryRun, pending: pending}
+}
+
+// watch adds resources which must be rendered before anything more is collected; with none to wait on, the
+// files of the resources removed are collected straight away
+//	items		: the resources now being watched
+func (c *outputCollector) watch(items []*VaultResource) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, rn := range items {
+		c.pending[rn] = true
+	}
+	if len(c.pending) > 0 {
+		return
+	}
+	if err := c.collect(); err != nil {
+		glog.Errorf("failed to garbage collect the output directory: %s, error: %s", c.directory, err)
+	}
+}
+
+// rendered records the resource has been rendered, collecting any orphaned files once all have been
+//	rn			: the resource which has been rendered
+func (c *outputCollector) rendered(rn *VaultResource) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.pending, rn)
+	if len(c.pending) > 0 {
+		return
+	}
+	if err := c.collect(); err != nil {
+		glog.Errorf("failed to garbage collect the output directory: %s, error: %s", c.directory, err)
+	}
+}
+
+// ownFiles are the files the sidekick keeps for itself rather than for a resource, which are never collected
+func ownFiles() map[string]bool {
+	files := make(map[string]bool)
+	if sentinel != nil {
+		files[filepath.Clean(sentinel.path)] = true
+	}
+	for _, x := range state.files() {
+		files[filepath.Clean(x)] = true
+	}
+	if options.auditLog != "" {
+		files[filepath.Clean(options.auditLog)] = true
+	}
+
+	return files
+}
+
+// collect removes the regular files in the output directory which were not written by the sidekick
+func (c *outputCollector) collect() error {
+	files, err := ioutil.ReadDir(c.directory)
+	if err != nil {
+		return err
+	}
+	for _, x := range files {
+		// step: only regular files are considered, symlinks and directories are left alone
+		if !x.Mode().IsRegular() {
+			continue
+		}
+		filename := filepath.Join(c.directory, x.Name())
+		if writtenFiles.has(filename) || ownFiles()[filename] {
+			continue
+		}
+		if c.dryRun {
+			glog.Infof("gc dry-run: would remove the orphaned file: %s", filename)
+			continue
+		}
+		glog.Infof("removing the orphaned file: %s from the output directory", filename)
+		if err := removeSecret(filename); err != nil {
+			glog.Errorf("failed to remove the orphaned file: %s, error: %s", filename, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	orphan := filepath.Join(dir, "removed.secret")
+	assert.NoError(t, ioutil.WriteFile(orphan, []byte("stale"), 0600))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0700))
+
+	first := &VaultResource{Resource: "secret", Path: "first"}
+	second := &VaultResource{Resource: "secret", Path: "second"}
+	collector := newOutputCollector(dir, []*VaultResource{first, second}, false)
+
+	assert.NoError(t, writeFile(filepath.Join(dir, "first.secret"), []byte("1"), 0600))
+	collector.rendered(first)
+	_, err = os.Stat(orphan)
+	assert.NoError(t, err, "nothing should be collected until all resources are rendered")
+
+	assert.NoError(t, writeFile(filepath.Join(dir, "second.secret"), []byte("2"), 0600))
+	collector.rendered(second)
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err))
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 3)
+}
+
+func TestOutputCollectorRemovedResource(t *testing.T) {
+	defer withOutputDir(t)()
+	written := writtenFiles
+	writtenFiles = newManagedFiles()
+	defer func() { writtenFiles = written }()
+	items, fromYAML := options.resources.items, options.resourcesFromYAML
+	defer func() { options.resources.items, options.resourcesFromYAML = items, fromYAML }()
+
+	newResource := func(name string) *VaultResource {
+		rn := defaultVaultResource()
+		rn.Resource = "secret"
+		rn.Path = "secret/" + name
+		rn.Format = "json"
+		rn.Filename = name
+		rn.FileMode = 0600
+		return rn
+	}
+	kept, removed := newResource("kept"), newResource("removed")
+	options.resources.items = []*VaultResource{kept, removed}
+	options.resourcesFromYAML = []*VaultResource{kept, removed}
+	collector := newOutputCollector(options.outputDir, options.resources.items, false)
+	for _, rn := range options.resources.items {
+		_, err := writeResource(rn, map[string]interface{}{"password": "secret"})
+		mustNoError(t, err)
+		collector.rendered(rn)
+	}
+
+	// step: the file of a resource removed on a reload is collected, the others left alone
+	vault := &VaultService{
+		resourceChannel: make(chan *watchedResource, 1),
+		unwatchChannel:  make(chan *VaultResource, 1),
+	}
+	plan, _, err := applyResources(vault, "test", []*VaultResource{newResource("kept")})
+	mustNoError(t, err)
+	assert.Equal(t, []*VaultResource{removed}, plan.removed)
+	collector.watch(plan.watched())
+
+	_, err = os.Stat(filepath.Join(options.outputDir, "removed"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(options.outputDir, "kept"))
+	assert.NoError(t, err)
+}
+
+func TestOutputCollectorOwnFiles(t *testing.T) {
+	defer withOutputDir(t)()
+	previous := sentinel
+	defer func() { sentinel = previous }()
+	sentinel = &readyFile{path: filepath.Join(options.outputDir, ".vault-sidekick-ready")}
+	mustNoError(t, ioutil.WriteFile(sentinel.path, nil, 0644))
+	persisted := state
+	defer func() { state = persisted }()
+	state = &stateTracker{path: filepath.Join(options.outputDir, "state.json")}
+	for _, x := range state.files() {
+		mustNoError(t, ioutil.WriteFile(x, nil, 0600))
+	}
+	auditLog := options.auditLog
+	defer func() { options.auditLog = auditLog }()
+	options.auditLog = filepath.Join(options.outputDir, "audit.jsonl")
+	mustNoError(t, ioutil.WriteFile(options.auditLog, nil, 0600))
+	orphan := filepath.Join(options.outputDir, "orphan")
+	mustNoError(t, ioutil.WriteFile(orphan, nil, 0600))
+
+	// step: the files the sidekick keeps for itself are never collected
+	collector := newOutputCollector(options.outputDir, nil, false)
+	mustNoError(t, collector.collect())
+	for _, x := range append(state.files(), sentinel.path, options.auditLog) {
+		_, err := os.Stat(x)
+		assert.NoError(t, err)
+	}
+	_, err := os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -19,29 +19,62 @@ package main
 import (
 	"crypto/rand"
 	"io"
+	"sort"
 )
 
 var stdChars = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!@#$%^&*()-_=+,.?/:;{}[]`~")
 
+// charsets are the named character sets a generated secret can be drawn from
+var charsets = map[string][]byte{
+	"default":      stdChars,
+	"alphanumeric": []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"),
+	"alpha":        []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"),
+	"lower":        []byte("abcdefghijklmnopqrstuvwxyz0123456789"),
+	"numeric":      []byte("0123456789"),
+	"hex":          []byte("0123456789abcdef"),
+}
+
 func newPassword(length int) string {
 	return randString(length, stdChars)
 }
 
+// newPasswordFromCharset generates a password from one of the named character sets
+func newPasswordFromCharset(length int, charset string) string {
+	chars, found := charsets[charset]
+	if !found {
+		chars = stdChars
+	}
+
+	return randString(length, chars)
+}
+
+// charsetNames returns the sorted names of the available character sets
+func charsetNames() []string {
+	var list []string
+	for name := range charsets {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
 func randString(length int, chars []byte) string {
 	pass := make([]byte, length)
 	data := make([]byte, length+(length/4)) // storage for random bytes.
-	clen := byte(len(chars))
-	maxrb := byte(256 - (256 % len(chars)))
+	clen := len(chars)
+	// the largest multiple of the charset length, to avoid a modulo bias
+	maxrb := 256 - (256 % clen)
 	i := 0
 	for {
 		if _, err := io.ReadFull(rand.Reader, data); err != nil {
 			panic(err)
 		}
 		for _, c := range data {
-			if c >= maxrb {
+			if int(c) >= maxrb {
 				continue
 			}
-			pass[i] = chars[c%clen]
+			pass[i] = chars[int(c)%clen]
 			i++
 			if i == length {
 				return string(pass)
@@ -0,0 +1,151 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+	"github.com/golang/glog"
+)
+
+// previousSuffix is appended to the filename of a resource for the certificate it rotated away from
+const previousSuffix = "-previous"
+
+// graceRegistry keeps the certificate a pki resource has rotated away from available for the grace
+// period of the resource, so servers supporting several certificates can serve both while the trust
+// of the new one propagates to the clients
+type graceRegistry struct {
+	sync.Mutex
+	// the secret data last written for each resource
+	current map[*VaultResource]map[string]interface{}
+	// the timers removing the previous certificate of each resource
+	timers map[*VaultResource]*time.Timer
+}
+
+// graces are the certificates of the resources with a grace period
+var graces = &graceRegistry{
+	current: make(map[*VaultResource]map[string]interface{}),
+	timers:  make(map[*VaultResource]*time.Timer),
+}
+
+// rotate writes the certificate being replaced under the previous suffix, before the new one is written,
+// and schedules its removal once the grace period is over; nothing is done if the certificate is the same
+//	rn			: the resource about to be written
+//	formatter	: the format of the resource
+//	filename	: the filename of the resource
+//	data		: the secret data about to be written
+func (g *graceRegistry) rotate(rn *VaultResource, formatter Formatter, filename string, data map[string]interface{}) error {
+	g.Lock()
+	defer g.Unlock()
+	previous, found := g.current[rn]
+	if !found || certificateSerial(previous) == certificateSerial(data) {
+		return nil
+	}
+
+	glog.V(3).Infof("resource: %s has rotated, keeping the previous certificate for: %s", rn.ID(), rn.GracePeriod)
+	if err := formatter.Write(filename+previousSuffix, rn, previous); err != nil {
+		return fmt.Errorf("unable to write the previous certificate, error: %s", err)
+	}
+	metrics.CertificateSerial(rn.ID(), "previous", certificateSerial(previous), certificateExpiry(previous))
+
+	if timer, found := g.timers[rn]; found {
+		timer.Stop()
+	}
+	g.timers[rn] = time.AfterFunc(rn.GracePeriod, func() {
+		g.expire(rn, filename)
+	})
+
+	return nil
+}
+
+// written records the certificate written for a resource
+func (g *graceRegistry) written(rn *VaultResource, data map[string]interface{}) {
+	g.Lock()
+	defer g.Unlock()
+	g.current[rn] = data
+	metrics.CertificateSerial(rn.ID(), "current", certificateSerial(data), certificateExpiry(data))
+}
+
+// expire removes the previous certificate of a resource once the grace period is over
+//	rn			: the resource
+//	filename	: the filename of the resource
+func (g *graceRegistry) expire(rn *VaultResource, filename string) {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.timers, rn)
+	metrics.RemoveCertificateSerial(rn.ID(), "previous")
+	if options.dryRun {
+		return
+	}
+	files, err := filepath.Glob(filepath.Clean(filename) + previousSuffix + "*")
+	if err != nil {
+		glog.Errorf("unable to find the previous certificate of resource: %s, error: %s", rn.ID(), err)
+		return
+	}
+	glog.V(3).Infof("the grace period of resource: %s is over, removing the previous certificate", rn.ID())
+	for _, x := range files {
+		if err := removeSecret(x); err != nil {
+			glog.Errorf("unable to remove the previous certificate: %s, error: %s", x, err)
+		}
+	}
+}
+
+// certificateSerial returns the serial number of the certificate of a pki secret, as vault formats it
+func certificateSerial(data map[string]interface{}) string {
+	if serial, found := data["serial_number"]; found {
+		return fmt.Sprintf("%v", serial)
+	}
+	certificate := parseLeafCertificate(data)
+	if certificate == nil {
+		return ""
+	}
+	var octets []string
+	for _, x := range certificate.SerialNumber.Bytes() {
+		octets = append(octets, fmt.Sprintf("%02x", x))
+	}
+
+	return strings.Join(octets, ":")
+}
+
+// certificateExpiry returns when the certificate of a pki secret expires, the zero time if it can't be parsed
+func certificateExpiry(data map[string]interface{}) time.Time {
+	if certificate := parseLeafCertificate(data); certificate != nil {
+		return certificate.NotAfter
+	}
+
+	return time.Time{}
+}
+
+// parseLeafCertificate parses the certificate of a pki secret, returning nil if there isn't one
+func parseLeafCertificate(data map[string]interface{}) *x509.Certificate {
+	certificates, err := pkiCertificates(data)
+	if err != nil {
+		return nil
+	}
+	certificate, err := x509.ParseCertificate(certificates[0])
+	if err != nil {
+		return nil
+	}
+
+	return certificate
+}
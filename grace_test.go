@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGracePeriod(t *testing.T) {
+	defer withOutputDir(t)()
+	rn := &VaultResource{Resource: "pki", Path: "pki/issue/app", Format: "cert", Filename: "tls", FileMode: 0600, GracePeriod: time.Hour}
+	first := newTestPKI(t)
+	first["serial_number"] = "01:01"
+	second := newTestPKI(t)
+	second["serial_number"] = "02:02"
+
+	// step: the first certificate has nothing to replace
+	filename, err := writeResource(rn, first)
+	mustNoError(t, err)
+	_, err = os.Stat(filename + previousSuffix + ".crt")
+	assert.True(t, os.IsNotExist(err))
+
+	// step: a renewal of the same certificate keeps nothing either
+	_, err = writeResource(rn, first)
+	mustNoError(t, err)
+	_, err = os.Stat(filename + previousSuffix + ".crt")
+	assert.True(t, os.IsNotExist(err))
+
+	// step: a rotation keeps the previous pair alongside the new one
+	_, err = writeResource(rn, second)
+	mustNoError(t, err)
+	for suffix, key := range map[string]string{".crt": "certificate", ".key": "private_key"} {
+		content, err := ioutil.ReadFile(filename + previousSuffix + suffix)
+		mustNoError(t, err)
+		assert.Equal(t, first[key], string(content))
+		content, err = ioutil.ReadFile(filename + suffix)
+		mustNoError(t, err)
+		assert.Equal(t, second[key], string(content))
+	}
+
+	// step: the previous pair is removed once the grace period is over
+	graces.Lock()
+	graces.timers[rn].Stop()
+	graces.Unlock()
+	graces.expire(rn, filename)
+	for _, suffix := range []string{".crt", ".key", ".ca"} {
+		_, err = os.Stat(filename + previousSuffix + suffix)
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filename + suffix)
+		assert.NoError(t, err)
+	}
+
+	graces.Lock()
+	delete(graces.current, rn)
+	graces.Unlock()
+}
+
+func TestCertificateSerial(t *testing.T) {
+	data := newTestPKI(t)
+	assert.Equal(t, "03", certificateSerial(data))
+	assert.False(t, certificateExpiry(data).IsZero())
+	data["serial_number"] = "39:dd:2e"
+	assert.Equal(t, "39:dd:2e", certificateSerial(data))
+	assert.Equal(t, "", certificateSerial(map[string]interface{}{}))
+	assert.True(t, certificateExpiry(map[string]interface{}{}).IsZero())
+}
+
+func TestGraceOption(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+
+	resources := &VaultResources{}
+	mustNoError(t, resources.Set("pki:pki/issue/app:common_name=app.svc,grace=24h"))
+	assert.Equal(t, 24*time.Hour, resources.items[0].GracePeriod)
+	assert.NoError(t, resources.items[0].IsValid())
+
+	assert.Error(t, resources.Set("pki:pki/issue/app:common_name=app.svc,grace=soon"))
+	mustNoError(t, resources.Set("secret:secret/app:grace=24h"))
+	assert.Error(t, resources.items[1].IsValid())
+}
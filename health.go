@@ -0,0 +1,77 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+// unready returns why the sidekick isn't ready: the resources yet to be written out at least once, those
+// whose lease has expired without being renewed and those kept stale having exhausted their retries
+//	now			: the time now
+func (s *statusRegistry) unready(now time.Time) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	var reasons []string
+	for _, x := range s.resources {
+		switch {
+		case x.LastWritten.IsZero():
+			reasons = append(reasons, fmt.Sprintf("resource: %s has not been written yet", x.ID))
+		case !x.LeaseExpiry.IsZero() && now.After(x.LeaseExpiry):
+			reasons = append(reasons, fmt.Sprintf("resource: %s expired at %s", x.ID, x.LeaseExpiry.Format(time.RFC3339)))
+		case x.Stale:
+			reasons = append(reasons, fmt.Sprintf("resource: %s is stale, having exhausted its retries", x.ID))
+		}
+	}
+	sort.Strings(reasons)
+
+	return reasons
+}
+
+// readyHandler responds with whether every resource has been written out and none has expired, for the
+// readiness probe of the pod
+//	registry	: the state of the resources
+func readyHandler(registry *statusRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reasons := registry.unready(time.Now())
+		if len(reasons) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			for _, x := range reasons {
+				fmt.Fprintln(w, x)
+			}
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// healthHandler responds the sidekick is alive, for the liveness probe of the pod
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// serveHealth adds the /healthz and /readyz endpoints, served alongside the metrics
+func serveHealth() {
+	metrics.Handle("/healthz", http.HandlerFunc(healthHandler))
+	metrics.Handle("/readyz", readyHandler(statuses))
+}
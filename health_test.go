@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyHandler(t *testing.T) {
+	registry := &statusRegistry{resources: make(map[*VaultResource]*resourceStatus)}
+	db := &VaultResource{Resource: "secret", Path: "secret/db"}
+	cert := &VaultResource{Resource: "pki", Path: "pki/issue/app"}
+	registry.add(db)
+	registry.add(cert)
+
+	ready := func() (int, string) {
+		w := httptest.NewRecorder()
+		readyHandler(registry)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code, w.Body.String()
+	}
+
+	// step: unready until every resource has been written
+	code, body := ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, db.ID())
+	assert.Contains(t, body, cert.ID())
+
+	registry.success(db, time.Time{})
+	registry.written(db)
+	registry.success(cert, time.Now().Add(time.Hour))
+	registry.written(cert)
+	code, body = ready()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	// step: unready again once a lease expires
+	registry.success(cert, time.Now().Add(-time.Minute))
+	code, body = ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, cert.ID()+" expired")
+	assert.NotContains(t, body, db.ID())
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// hookInputRecorder renders the resources piped to their exec into memory rather than to disk, holding the
+// content until the exec takes it as its stdin
+type hookInputRecorder struct {
+	sync.Mutex
+	// whether the files being written are captured
+	active bool
+	// the content captured while active
+	captured *bytes.Buffer
+	// the content waiting on the exec of each resource
+	content map[*VaultResource][]byte
+}
+
+// hookInputs holds the content piped to the exec of the resources
+var hookInputs = &hookInputRecorder{content: make(map[*VaultResource][]byte)}
+
+// render formats the resource into memory, the files of a format writing several following one another
+//	rn			: the vault resource
+//	formatter	: the formatter of the resource
+//	filename	: the file the resource would be written to
+//	data		: the secret data of the resource
+func (h *hookInputRecorder) render(rn *VaultResource, formatter Formatter, filename string, data map[string]interface{}) error {
+	h.Lock()
+	h.active, h.captured = true, &bytes.Buffer{}
+	h.Unlock()
+
+	err := formatter.Write(filename, rn, data)
+
+	h.Lock()
+	defer h.Unlock()
+	content := h.captured.Bytes()
+	h.active, h.captured = false, nil
+	if err != nil {
+		return err
+	}
+	h.content[rn] = content
+
+	return nil
+}
+
+// capturing checks if the files being written are captured
+func (h *hookInputRecorder) capturing() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.active
+}
+
+// store captures the content of a file
+func (h *hookInputRecorder) store(write func(io.Writer) error) error {
+	content := &bytes.Buffer{}
+	if err := write(content); err != nil {
+		return err
+	}
+	h.Lock()
+	defer h.Unlock()
+	if h.captured != nil {
+		h.captured.Write(content.Bytes())
+	}
+
+	return nil
+}
+
+// take returns the content rendered for the exec of the resource, which is no longer held
+func (h *hookInputRecorder) take(rn *VaultResource) []byte {
+	h.Lock()
+	defer h.Unlock()
+	content := h.content[rn]
+	delete(h.content, rn)
+
+	return content
+}
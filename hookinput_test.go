@@ -0,0 +1,60 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecStdin(t *testing.T) {
+	defer withOutputDir(t)()
+
+	piped := filepath.Join(options.outputDir, "piped")
+	rn := &VaultResource{
+		Resource:  "secret",
+		Path:      "secret/app",
+		Format:    "env",
+		Filename:  "app",
+		FileMode:  0600,
+		ExecPath:  []string{"sh", "-c", "cat > " + piped},
+		ExecStdin: true,
+	}
+	mustNoError(t, processResource(rn, map[string]interface{}{"password": "hunter22"}))
+	pendingExecs.Wait()
+
+	// step: the secret reaches the exec, without a file being written
+	content, err := ioutil.ReadFile(piped)
+	mustNoError(t, err)
+	assert.Equal(t, "PASSWORD='hunter22'\n", string(content))
+	_, err = os.Stat(filepath.Join(options.outputDir, "app"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, hookInputs.take(rn))
+}
+
+func TestExecStdinOptions(t *testing.T) {
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", ExecStdin: true}
+	assert.Error(t, rn.IsValid())
+	rn.ExecPath = []string{"kubectl", "apply", "-f", "-"}
+	assert.NoError(t, rn.IsValid())
+	rn.Versions = 3
+	assert.Error(t, rn.IsValid())
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// readyHook is the hook run once every resource has first been rendered
+	readyHook = "ready-exec"
+	// rotationHook is the hook run once a batch of rotations has settled
+	rotationHook = "rotation-exec"
+)
+
+// globalHooks runs the hooks which aren't tied to a resource: the ready exec, once every resource has first been
+// rendered, and the rotation exec, once the resources rotating together have settled, so the files of several
+// resources can be reloaded as one
+type globalHooks struct {
+	sync.Mutex
+	// the command run once every resource has been rendered
+	readyExec []string
+	// the command run once the rotations have settled
+	rotationExec []string
+	// how long the rotations are quiet for before the rotation exec is run
+	settle time.Duration
+	// whether the ready exec has been run
+	ready bool
+	// the resources rotated since the rotation exec was last run
+	rotated map[string]bool
+	// the timer running the rotation exec once the rotations settle
+	timer *time.Timer
+}
+
+// hooks are the global hooks of the sidekick
+var hooks = newGlobalHooks("", "", 0)
+
+// newGlobalHooks creates the global hooks
+//	readyExec		: the command run once every resource has been rendered, split on spaces
+//	rotationExec	: the command run once the rotations have settled, split on spaces
+//	settle			: how long the rotations are quiet for before the rotation exec is run
+func newGlobalHooks(readyExec, rotationExec string, settle time.Duration) *globalHooks {
+	h := &globalHooks{settle: settle, rotated: make(map[string]bool)}
+	if readyExec != "" {
+		h.readyExec = strings.Split(readyExec, " ")
+	}
+	if rotationExec != "" {
+		h.rotationExec = strings.Split(rotationExec, " ")
+	}
+
+	return h
+}
+
+// rendered records the resource has been rendered, running the ready exec once every resource has been and
+// scheduling the rotation exec for a change afterwards
+//	rn			: the resource which has been rendered
+//	changed		: whether the content of the resource changed
+func (h *globalHooks) rendered(rn *VaultResource, changed bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	// step: the first renders are not rotations, the ready exec being run once they're all done
+	if !h.ready {
+		if len(statuses.unready(time.Now())) > 0 {
+			return
+		}
+		h.ready = true
+		if len(h.readyExec) > 0 {
+			if err := runGlobalHook(readyHook, h.readyExec, resourceIDs(options.resources.items)); err != nil {
+				glog.Errorf("failed to run the %s command: %s, error: %s", readyHook, h.readyExec[0], err)
+			}
+		}
+		return
+	}
+	if !changed || len(h.rotationExec) == 0 {
+		return
+	}
+
+	// step: wait for the rotations to settle before running the rotation exec
+	h.rotated[rn.ID()] = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	h.timer = time.AfterFunc(h.settle, h.settled)
+}
+
+// settled runs the rotation exec for the resources rotated since it last ran
+func (h *globalHooks) settled() {
+	h.Lock()
+	var rotated []string
+	for id := range h.rotated {
+		rotated = append(rotated, id)
+	}
+	h.rotated = make(map[string]bool)
+	h.timer = nil
+	h.Unlock()
+	if len(rotated) == 0 {
+		return
+	}
+	sort.Strings(rotated)
+	if err := runGlobalHook(rotationHook, h.rotationExec, rotated); err != nil {
+		glog.Errorf("failed to run the %s command: %s, error: %s", rotationHook, h.rotationExec[0], err)
+	}
+}
+
+// runGlobalHook runs a global hook, the resources it's run for given in VAULT_SIDEKICK_RESOURCES
+//	hook		: the name of the hook
+//	command		: the command and its arguments
+//	resources	: the ids of the resources the hook is run for
+func runGlobalHook(hook string, command, resources []string) error {
+	env := []string{"VAULT_SIDEKICK_RESOURCES=" + strings.Join(resources, ",")}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	if options.execDryRun {
+		glog.Infof("exec-dry-run: would run the %s command: %s, args: %q, env: %q", hook, command[0], command[1:], env)
+		return nil
+	}
+
+	glog.V(3).Infof("running the %s command: %s for the resources: %s", hook, command, strings.Join(resources, ", "))
+	err := execs.run(execKey(cmd, nil), func() error {
+		started := time.Now()
+		if err := cmd.Start(); err != nil {
+			audit.record(&auditEvent{Event: auditExec, Hook: hook, Command: cmd.Args, Error: err.Error()})
+			return err
+		}
+		timer := time.AfterFunc(options.execTimeout, func() {
+			if err := cmd.Process.Kill(); err != nil {
+				glog.Errorf("failed to kill the %s command, pid: %d, error: %s", hook, cmd.Process.Pid, err)
+			}
+		})
+		err := cmd.Wait()
+		timer.Stop()
+		event := &auditEvent{Event: auditExec, Hook: hook, Command: cmd.Args, Duration: time.Since(started).String()}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		audit.record(event)
+
+		return err
+	})
+	if err != nil {
+		return withCode(codeExecFailed, err)
+	}
+
+	return nil
+}
+
+// resourceIDs returns the ids of the resources
+func resourceIDs(items []*VaultResource) []string {
+	var ids []string
+	for _, rn := range items {
+		ids = append(ids, rn.ID())
+	}
+
+	return ids
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobalHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "hooks")
+	script := filepath.Join(dir, "hook.sh")
+	mustNoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $VAULT_SIDEKICK_RESOURCES\" >> "+output+"\n"), 0755))
+
+	db := &VaultResource{Resource: "secret", Path: "secret/db"}
+	tls := &VaultResource{Resource: "pki", Path: "pki/issue/app"}
+	previous, items := statuses, options.resources
+	statuses = &statusRegistry{resources: make(map[*VaultResource]*resourceStatus)}
+	options.resources = &VaultResources{items: []*VaultResource{db, tls}}
+	defer func() { statuses, options.resources = previous, items }()
+	statuses.add(db)
+	statuses.add(tls)
+
+	h := newGlobalHooks(script+" ready", script+" rotated", 200*time.Millisecond)
+
+	// step: the ready exec waits on every resource being rendered, and is only run once
+	statuses.written(db)
+	h.rendered(db, true)
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+	statuses.written(tls)
+	h.rendered(tls, true)
+	h.rendered(tls, false)
+	waitForContent(t, output, "ready secret/db,pki/issue/app\n")
+
+	// step: the rotations are run as one once they settle, unchanged renders not counting
+	h.rendered(tls, true)
+	h.rendered(db, true)
+	h.rendered(tls, true)
+	h.rendered(db, false)
+	waitForContent(t, output, "ready secret/db,pki/issue/app\nrotated pki/issue/app,secret/db\n")
+	time.Sleep(300 * time.Millisecond)
+	waitForContent(t, output, "ready secret/db,pki/issue/app\nrotated pki/issue/app,secret/db\n")
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// An encoder for the Java KeyStore (JKS) format; the private key is protected with the sun key protector
+// and the store is integrity protected with the salted sha1 digest the jvm verifies on load.
+
+const (
+	// jksMagic is the magic number of a java keystore
+	jksMagic = 0xfeedfeed
+	// jksVersion is the version of the keystore format
+	jksVersion = 2
+	// jksPrivateKeyEntry is the tag of a private key entry
+	jksPrivateKeyEntry = 1
+	// jksTrustedCertEntry is the tag of a trusted certificate entry
+	jksTrustedCertEntry = 2
+	// jksWhitener is mixed into the integrity digest of the keystore
+	jksWhitener = "Mighty Aphrodite"
+)
+
+// oidJKSKeyProtector is the algorithm of the sun proprietary key protector
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksWriter builds the content of a java keystore
+type jksWriter struct {
+	bytes.Buffer
+	// the time recorded against the entries
+	created time.Time
+	// the number of entries written
+	entries int
+}
+
+// writeInt writes a big endian integer of the size of the value
+func (w *jksWriter) writeInt(value interface{}) {
+	binary.Write(w, binary.BigEndian, value)
+}
+
+// writeUTF writes a string in the java modified utf-8 encoding
+func (w *jksWriter) writeUTF(value string) {
+	w.writeInt(uint16(len(value)))
+	w.WriteString(value)
+}
+
+// writeCertificate writes a x.509 certificate
+func (w *jksWriter) writeCertificate(der []byte) {
+	w.writeUTF("X.509")
+	w.writeInt(int32(len(der)))
+	w.Write(der)
+}
+
+// writeEntry writes the header of an entry
+func (w *jksWriter) writeEntry(tag int32, alias string) {
+	w.entries++
+	w.writeInt(tag)
+	// step: the jvm lowercases the aliases on lookup
+	w.writeUTF(strings.ToLower(alias))
+	w.writeInt(w.created.UnixNano() / int64(time.Millisecond))
+}
+
+// encodeJKS encodes the entries into a keystore, adding the header and integrity digest
+func (w *jksWriter) encodeJKS(password string) []byte {
+	store := &bytes.Buffer{}
+	binary.Write(store, binary.BigEndian, uint32(jksMagic))
+	binary.Write(store, binary.BigEndian, int32(jksVersion))
+	binary.Write(store, binary.BigEndian, int32(w.entries))
+	store.Write(w.Bytes())
+
+	digest := sha1.New()
+	digest.Write(utf16BigEndian(password))
+	digest.Write([]byte(jksWhitener))
+	digest.Write(store.Bytes())
+
+	return append(store.Bytes(), digest.Sum(nil)...)
+}
+
+// encodeJKSKeyStore creates a java keystore holding the private key and certificate chain
+//	key			: the PKCS#8 der of the private key
+//	certs		: the der of the leaf certificate followed by the chain
+//	alias		: the alias of the key entry
+//	password	: the password protecting the store and key
+func encodeJKSKeyStore(key []byte, certs [][]byte, alias, password string) ([]byte, error) {
+	protected, err := jksProtectKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &jksWriter{created: time.Now()}
+	w.writeEntry(jksPrivateKeyEntry, alias)
+	w.writeInt(int32(len(protected)))
+	w.Write(protected)
+	w.writeInt(int32(len(certs)))
+	for _, cert := range certs {
+		w.writeCertificate(cert)
+	}
+
+	return w.encodeJKS(password), nil
+}
+
+// encodeJKSTrustStore creates a java keystore holding the certificates as trusted entries
+//	certs		: the der of the trusted certificates
+//	alias		: the alias of the first entry, subsequent entries are suffixed -1, -2 etc
+//	password	: the password protecting the store
+func encodeJKSTrustStore(certs [][]byte, alias, password string) []byte {
+	w := &jksWriter{created: time.Now()}
+	for i, cert := range certs {
+		name := alias
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", alias, i)
+		}
+		w.writeEntry(jksTrustedCertEntry, name)
+		w.writeCertificate(cert)
+	}
+
+	return w.encodeJKS(password)
+}
+
+// jksProtectKey encrypts the private key with the sun key protector; a sha1 keystream derived from the
+// password and a random salt, followed by a sha1 check of the plaintext
+func jksProtectKey(key []byte, password string) ([]byte, error) {
+	passwd := utf16BigEndian(password)
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, len(key))
+	digest := salt
+	for i := 0; i < len(key); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwd...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(key); j++ {
+			encrypted[i+j] = key[i+j] ^ digest[j]
+		}
+	}
+	check := sha1.Sum(append(append([]byte{}, passwd...), key...))
+
+	protected := append(append(salt, encrypted...), check[:]...)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+		Data:      protected,
+	})
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jksReader reads back the fields of a java keystore
+type jksReader struct {
+	*bytes.Reader
+}
+
+func (r jksReader) readInt(value interface{}) {
+	binary.Read(r, binary.BigEndian, value)
+}
+
+func (r jksReader) readUTF() string {
+	var size uint16
+	r.readInt(&size)
+	buf := make([]byte, size)
+	io.ReadFull(r, buf)
+	return string(buf)
+}
+
+func (r jksReader) readBytes() []byte {
+	var size int32
+	r.readInt(&size)
+	buf := make([]byte, size)
+	io.ReadFull(r, buf)
+	return buf
+}
+
+// verifyJKS checks the integrity digest of the store, returning the entries
+func verifyJKS(t *testing.T, content []byte, password string) jksReader {
+	t.Helper()
+	body := content[:len(content)-sha1.Size]
+	digest := sha1.New()
+	digest.Write(utf16BigEndian(password))
+	digest.Write([]byte(jksWhitener))
+	digest.Write(body)
+	assert.Equal(t, digest.Sum(nil), content[len(body):], "the integrity digest should match")
+
+	r := jksReader{bytes.NewReader(body)}
+	var magic uint32
+	var version int32
+	r.readInt(&magic)
+	r.readInt(&version)
+	assert.Equal(t, uint32(jksMagic), magic)
+	assert.Equal(t, int32(jksVersion), version)
+
+	return r
+}
+
+func TestGenerateJKSFiles(t *testing.T) {
+	data := newTestPKI(t)
+	keystore, truststore, err := generateJKSFiles(data, "Kafka-Client", "", "changeit")
+	mustNoError(t, err)
+
+	// step: check the keystore holds the key and chain
+	r := verifyJKS(t, keystore, "changeit")
+	var count, tag int32
+	var created int64
+	r.readInt(&count)
+	r.readInt(&tag)
+	assert.Equal(t, int32(1), count)
+	assert.Equal(t, int32(jksPrivateKeyEntry), tag)
+	assert.Equal(t, "kafka-client", r.readUTF())
+	r.readInt(&created)
+
+	keyInfo := encryptedPrivateKeyInfo{}
+	_, err = asn1.Unmarshal(r.readBytes(), &keyInfo)
+	mustNoError(t, err)
+	assert.Equal(t, oidJKSKeyProtector, keyInfo.Algorithm.Algorithm)
+
+	// step: reverse the key protector and compare against the original key
+	passwd := utf16BigEndian("changeit")
+	protected := keyInfo.Data
+	digest := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	decrypted := make([]byte, len(encrypted))
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwd...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			decrypted[i+j] = encrypted[i+j] ^ digest[j]
+		}
+	}
+	expected, err := decodePrivateKey(data["private_key"].(string))
+	mustNoError(t, err)
+	assert.Equal(t, expected, decrypted)
+	check := sha1.Sum(append(append([]byte{}, passwd...), decrypted...))
+	assert.Equal(t, check[:], protected[len(protected)-sha1.Size:])
+
+	var chain int32
+	r.readInt(&chain)
+	assert.Equal(t, int32(3), chain)
+
+	// step: check the truststore holds the ca
+	r = verifyJKS(t, truststore, "changeit")
+	r.readInt(&count)
+	r.readInt(&tag)
+	assert.Equal(t, int32(2), count)
+	assert.Equal(t, int32(jksTrustedCertEntry), tag)
+	assert.Equal(t, "ca", r.readUTF())
+	r.readInt(&created)
+	assert.Equal(t, "X.509", r.readUTF())
+	certs, _ := pkiCertificates(data)
+	assert.Equal(t, certs[1], r.readBytes())
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	oidPublicKeyRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+	oidNamedCurves = map[elliptic.Curve]asn1.ObjectIdentifier{
+		elliptic.P224(): {1, 3, 132, 0, 33},
+		elliptic.P256(): {1, 2, 840, 10045, 3, 1, 7},
+		elliptic.P384(): {1, 3, 132, 0, 34},
+		elliptic.P521(): {1, 3, 132, 0, 35},
+	}
+)
+
+// secretReader reads the data of a secret from vault, it's set once the vault service has been created
+var secretReader func(path string) (map[string]interface{}, error)
+
+// resolvePassphrase resolves a passphrase specification into the passphrase, the specification being one of
+// env:NAME, file:/path/to/file, vault:path/to/secret#key or otherwise the passphrase itself
+//	spec		: the passphrase specification
+func resolvePassphrase(spec string) (string, error) {
+	switch {
+	case strings.HasPrefix(spec, "env:"):
+		name := strings.TrimPrefix(spec, "env:")
+		value, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("the passphrase environment variable: %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(spec, "file:"):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(spec, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("unable to read the passphrase file, error: %s", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case strings.HasPrefix(spec, "vault:"):
+		path := strings.TrimPrefix(spec, "vault:")
+		key := "value"
+		if i := strings.LastIndex(path, "#"); i >= 0 {
+			path, key = path[:i], path[i+1:]
+		}
+		if secretReader == nil {
+			return "", errors.New("unable to read the passphrase from vault, no vault client available")
+		}
+		data, err := secretReader(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the passphrase from vault: %s, error: %s", path, err)
+		}
+		value, found := data[key]
+		if !found {
+			return "", fmt.Errorf("the passphrase key: %s was not found in: %s", key, path)
+		}
+		return fmt.Sprintf("%v", value), nil
+	default:
+		return spec, nil
+	}
+}
+
+// keystorePassword resolves the password of a keystore, falling back to the keystore-passphrase option
+//	spec		: the passphrase specification of the resource
+func keystorePassword(spec string) (string, error) {
+	if spec == "" {
+		spec = options.keystorePassphrase
+	}
+
+	return resolvePassphrase(spec)
+}
+
+// encryptedPrivateKeyInfo is the asn.1 structure of an encrypted PKCS#8 private key
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+// pkcs8PrivateKey is the asn.1 structure of a PKCS#8 private key
+type pkcs8PrivateKey struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// decodePrivateKey decodes the pem encoded private key of a pki secret into PKCS#8 der
+//	content		: the pem encoded private key, PKCS#1, SEC 1 or PKCS#8
+func decodePrivateKey(content string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, errors.New("no pem encoded private key found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+		return block.Bytes, nil
+	case "RSA PRIVATE KEY":
+		if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8PrivateKey{
+			Algorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidPublicKeyRSA,
+				Parameters: asn1.RawValue{Tag: asn1.TagNull},
+			},
+			PrivateKey: block.Bytes,
+		})
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		oid, found := oidNamedCurves[key.Curve]
+		if !found {
+			return nil, errors.New("unsupported elliptic curve in the private key")
+		}
+		curve, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(pkcs8PrivateKey{
+			Algorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidPublicKeyECDSA,
+				Parameters: asn1.RawValue{FullBytes: curve},
+			},
+			PrivateKey: block.Bytes,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+}
+
+// decodeCertificates decodes all the pem encoded certificates in the content, returning the der of each
+func decodeCertificates(content string) [][]byte {
+	var list [][]byte
+	for _, block := range pemCertificates(content) {
+		list = append(list, block.Bytes)
+	}
+
+	return list
+}
+
+// pkiCertificates returns the der of the leaf certificate followed by the chain of a pki secret, falling
+// back to the issuing ca when there's no chain
+func pkiCertificates(data map[string]interface{}) ([][]byte, error) {
+	leaf := decodeCertificates(fmt.Sprintf("%v", data["certificate"]))
+	if len(leaf) == 0 {
+		return nil, errors.New("no certificate found in the resource")
+	}
+	var chain [][]byte
+	if list, ok := data["ca_chain"].([]interface{}); ok {
+		for _, x := range list {
+			chain = append(chain, decodeCertificates(fmt.Sprintf("%v", x))...)
+		}
+	}
+	if len(chain) == 0 {
+		if ca, found := data["issuing_ca"]; found {
+			chain = decodeCertificates(fmt.Sprintf("%v", ca))
+		}
+	}
+
+	return append(leaf, chain...), nil
+}
+
+// utf16BigEndian encodes the value as big endian utf-16, as java and PKCS#12 expect of passwords
+func utf16BigEndian(value string) []byte {
+	var buf []byte
+	for _, c := range utf16.Encode([]rune(value)) {
+		buf = append(buf, byte(c>>8), byte(c))
+	}
+
+	return buf
+}
+
+const (
+	// pbkdf2Iterations is the number of iterations used to derive the key of an encrypted private key
+	pbkdf2Iterations = 100000
+	// pbkdf2SaltSize is the size of the salt used to derive the key of an encrypted private key
+	pbkdf2SaltSize = 16
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// encryptPrivateKey encrypts a pem encoded private key into an encrypted PKCS#8 pem, using PBES2 with
+// PBKDF2-HMAC-SHA256 and AES-256-CBC
+//	content		: the pem encoded private key
+//	passphrase	: the passphrase to encrypt the key with
+func encryptPrivateKey(content, passphrase string) ([]byte, error) {
+	key, err := decodePrivateKey(content)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, pbkdf2SaltSize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, pbkdf2Iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(key)%aes.BlockSize
+	encrypted := append(append([]byte{}, key...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// pbkdf2SHA256 is the key derivation function from RFC 8018, using HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:size]
+}
+
+// encryptResourceKey returns a copy of the data of a pki resource with the private key encrypted
+//	data		: the data of the pki resource
+//	passphrase	: the passphrase to encrypt the key with, or where to find it
+func encryptResourceKey(data map[string]interface{}, passphrase string) (map[string]interface{}, error) {
+	content, found := data["private_key"]
+	if !found {
+		return data, nil
+	}
+	password, err := resolvePassphrase(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptPrivateKey(fmt.Sprintf("%v", content), password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt the private key, error: %s", err)
+	}
+
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	copied["private_key"] = strings.TrimSuffix(string(encrypted), "\n")
+
+	return copied, nil
+}
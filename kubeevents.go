@@ -0,0 +1,157 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// kubeEventFailures is the consecutive failures of a resource before a failure event is raised
+	kubeEventFailures = 3
+	// kubeEventRotated is the reason of the event raised when a resource is rotated
+	kubeEventRotated = "VaultSecretRotated"
+	// kubeEventRotateFailed is the reason of the event raised when a resource fails repeatedly
+	kubeEventRotateFailed = "VaultSecretRotateFailed"
+)
+
+// kubeObjectReference refers to the object an event is about
+type kubeObjectReference struct {
+	// the api version of the object
+	APIVersion string `json:"apiVersion"`
+	// the kind of the object
+	Kind string `json:"kind"`
+	// the name of the object
+	Name string `json:"name"`
+	// the namespace of the object
+	Namespace string `json:"namespace"`
+	// the uid of the object
+	UID string `json:"uid,omitempty"`
+}
+
+// kubeEventSource is the component raising an event
+type kubeEventSource struct {
+	// the name of the component
+	Component string `json:"component"`
+}
+
+// kubeObjectEvent is an event of the kubernetes api
+type kubeObjectEvent struct {
+	// the metadata of the event
+	Metadata kubeMetadata `json:"metadata"`
+	// the object the event is about
+	InvolvedObject kubeObjectReference `json:"involvedObject"`
+	// the reason of the event
+	Reason string `json:"reason"`
+	// the message of the event
+	Message string `json:"message"`
+	// the type of the event, Normal or Warning
+	Type string `json:"type"`
+	// the component raising the event
+	Source kubeEventSource `json:"source"`
+	// when the event first and last happened
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+	// the times the event happened
+	Count int `json:"count"`
+}
+
+// kubeEventRecorder raises events on the pod the sidekick is running in
+type kubeEventRecorder struct {
+	// the kubernetes client
+	kube *kubeClient
+	// the pod the events are raised on
+	pod kubeObjectReference
+}
+
+// newKubeEventRecorder creates a recorder of the events of the pod the sidekick is running in, the uid of the pod
+// being read from the POD_UID environment variable given by the downward api, or the pod itself
+func newKubeEventRecorder() (*kubeEventRecorder, error) {
+	kube, err := newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	pod := kubeObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       podName(),
+		Namespace:  kube.namespace,
+		UID:        os.Getenv("POD_UID"),
+	}
+	if pod.UID == "" {
+		x := &kubePod{}
+		if err := kube.get(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", pod.Namespace, pod.Name), x); err != nil {
+			glog.Warningf("unable to read the uid of the pod: %s, the events may not be shown against it, error: %s", pod.Name, err)
+		}
+		pod.UID = x.Metadata.UID
+	}
+
+	return &kubeEventRecorder{kube: kube, pod: pod}, nil
+}
+
+// record raises an event on the pod
+//	eventType	: the type of the event, Normal or Warning
+//	reason		: the reason of the event
+//	message		: the message of the event
+func (r *kubeEventRecorder) record(eventType, reason, message string) error {
+	now := time.Now().UTC()
+	event := &kubeObjectEvent{
+		Metadata: kubeMetadata{
+			Name:      fmt.Sprintf("%s.%x", r.pod.Name, now.UnixNano()),
+			Namespace: r.pod.Namespace,
+		},
+		InvolvedObject: r.pod,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         kubeEventSource{Component: prog},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	err := r.kube.do(http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/events", r.pod.Namespace), "application/json", event, nil)
+
+	return withCode(codeKubeRequest, err)
+}
+
+// watch raises events for the resources rotated and those failing repeatedly, once per run of failures
+//	updates		: the events of the resources
+func (r *kubeEventRecorder) watch(updates chan VaultEvent) {
+	for evt := range updates {
+		var err error
+		switch {
+		case evt.Type == EventTypeSuccess && evt.Rotated:
+			err = r.record("Normal", kubeEventRotated, fmt.Sprintf("resource: %s has been rotated", evt.Resource))
+		case evt.Type == EventTypeFailure && evt.Resource.Retries == kubeEventFailures:
+			message := fmt.Sprintf("resource: %s has failed %d times in a row", evt.Resource, evt.Resource.Retries)
+			if evt.Err != nil {
+				message += ", error: " + evt.Err.Error()
+			}
+			err = r.record("Warning", kubeEventRotateFailed, message)
+		default:
+			continue
+		}
+		if err != nil {
+			glog.Warningf("unable to raise the kubernetes event of resource: %s, error: %s", evt.Resource, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubeEventRecorder(t *testing.T) {
+	events := make(chan *kubeObjectEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/default/events", r.URL.Path)
+		event := &kubeObjectEvent{}
+		mustNoError(t, json.NewDecoder(r.Body).Decode(event))
+		events <- event
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	recorder := &kubeEventRecorder{
+		kube: &kubeClient{host: server.URL, namespace: "default", client: http.DefaultClient},
+		pod:  kubeObjectReference{APIVersion: "v1", Kind: "Pod", Name: "app-0", Namespace: "default", UID: "1234"},
+	}
+	updates := make(chan VaultEvent)
+	go recorder.watch(updates)
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/db"}
+	updates <- VaultEvent{Resource: rn, Type: EventTypeSuccess}
+	updates <- VaultEvent{Resource: rn, Type: EventTypeSuccess, Rotated: true}
+	event := <-events
+	assert.Equal(t, kubeEventRotated, event.Reason)
+	assert.Equal(t, "Normal", event.Type)
+	assert.Equal(t, "1234", event.InvolvedObject.UID)
+
+	// step: a failure is raised once, on the third in a row
+	for i := 1; i <= 4; i++ {
+		failed := &VaultResource{Resource: "secret", Path: "secret/db", Retries: i}
+		updates <- VaultEvent{Resource: failed, Type: EventTypeFailure, Err: errors.New("permission denied")}
+	}
+	close(updates)
+	event = <-events
+	assert.Equal(t, kubeEventRotateFailed, event.Reason)
+	assert.Equal(t, "Warning", event.Type)
+	assert.Contains(t, event.Message, "permission denied")
+	assert.Equal(t, 0, len(events))
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// kubeServiceAccountPath is where the service account of the pod is mounted
+	kubeServiceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// kubeRetryInterval is how long to wait before watching the kubernetes api again once a watch ends
+	kubeRetryInterval = 5 * time.Second
+)
+
+// kubeClient is a minimal client of the kubernetes api, authenticating with the service account of the pod
+type kubeClient struct {
+	// the url of the api server
+	host string
+	// the bearer token of the service account
+	token string
+	// the namespace of the pod
+	namespace string
+	// the http client
+	client *http.Client
+}
+
+// kubeStatus is the status the kubernetes api responds with on an error
+type kubeStatus struct {
+	// the message of the error
+	Message string `json:"message"`
+	// the reason of the error, i.e. NotFound
+	Reason string `json:"reason"`
+	// the http status code
+	Code int `json:"code"`
+}
+
+// kubeError is a failed request to the kubernetes api
+type kubeError struct {
+	// the status code of the response
+	code int
+	// the message of the error
+	message string
+}
+
+// Error returns the message of the error
+func (e *kubeError) Error() string {
+	return fmt.Sprintf("kubernetes api responded with %d: %s", e.code, e.message)
+}
+
+// kubeEvent is an event of a watch on the kubernetes api
+type kubeEvent struct {
+	// the type of the event, ADDED, MODIFIED, DELETED or ERROR
+	Type string `json:"type"`
+	// the object the event relates to
+	Object json.RawMessage `json:"object"`
+}
+
+// kubeMetadata is the metadata of a kubernetes object
+type kubeMetadata struct {
+	// the name of the object
+	Name string `json:"name,omitempty"`
+	// the namespace of the object
+	Namespace string `json:"namespace,omitempty"`
+	// the version of the object, used to resume a watch
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// the uid of the object
+	UID string `json:"uid,omitempty"`
+	// the labels of the object
+	Labels map[string]string `json:"labels,omitempty"`
+	// the annotations of the object
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// kubeNotFound checks if the error is the kubernetes api not finding the object
+func kubeNotFound(err error) bool {
+	x, ok := err.(*kubeError)
+
+	return ok && x.code == http.StatusNotFound
+}
+
+// podName returns the name of the pod the sidekick is running in, from the POD_NAME environment variable given by
+// the downward api, or the hostname
+func podName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+
+	return name
+}
+
+// newKubeClient creates a client of the kubernetes api the pod is running in, from the service account
+// mounted into it
+func newKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, withCode(codeKubeRequest, fmt.Errorf("not running in kubernetes, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set"))
+	}
+	token, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountPath, "token"))
+	if err != nil {
+		return nil, withCode(codeKubeRequest, fmt.Errorf("unable to read the service account token, error: %s", err))
+	}
+	namespace, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountPath, "namespace"))
+	if err != nil {
+		return nil, withCode(codeKubeRequest, fmt.Errorf("unable to read the namespace of the pod, error: %s", err))
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountPath, "ca.crt"))
+	if err != nil {
+		return nil, withCode(codeKubeRequest, fmt.Errorf("unable to read the kubernetes ca, error: %s", err))
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+
+	return &kubeClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(namespace)),
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     &tls.Config{RootCAs: pool},
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// request sends a request to the kubernetes api
+//	method		: the http method
+//	path		: the path of the request
+//	contentType	: the content type of the body
+//	body		: the body, marshalled to json, if any
+func (k *kubeClient) request(method, path, contentType string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequest(method, k.host+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		status := &kubeStatus{}
+		if err := json.NewDecoder(resp.Body).Decode(status); err != nil || status.Message == "" {
+			status.Message = resp.Status
+		}
+		return nil, &kubeError{code: resp.StatusCode, message: status.Message}
+	}
+
+	return resp, nil
+}
+
+// do sends a request to the kubernetes api, decoding the response into out, if given
+//	method		: the http method
+//	path		: the path of the request
+//	contentType	: the content type of the body
+//	body		: the body, marshalled to json, if any
+//	out			: decoded from the response, if not nil
+func (k *kubeClient) do(method, path, contentType string, body, out interface{}) error {
+	resp, err := k.request(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// get reads an object from the kubernetes api
+//	path		: the path of the object
+//	out			: the object decoded
+func (k *kubeClient) get(path string, out interface{}) error {
+	return k.do(http.MethodGet, path, "", nil, out)
+}
+
+// watch streams the events of a watch on the kubernetes api until it ends, calling handle with each
+//	path		: the path of the collection, with any query, watched from the version given
+//	version		: the resource version to watch from
+//	handle		: called with each event
+func (k *kubeClient) watch(path, version string, handle func(*kubeEvent)) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	path += separator + "watch=1"
+	if version != "" {
+		path += "&resourceVersion=" + version
+	}
+	resp, err := k.request(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		event := &kubeEvent{}
+		if err := decoder.Decode(event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if event.Type == "ERROR" {
+			status := &kubeStatus{}
+			json.Unmarshal(event.Object, status)
+			return &kubeError{code: status.Code, message: status.Message}
+		}
+		handle(event)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+	"github.com/golang/glog"
+)
+
+// timedTransport records the latency of each request to vault, by operation and status
+type timedTransport struct {
+	// the transport the requests are sent with
+	next http.RoundTripper
+}
+
+// RoundTrip sends the request, recording how long vault took to respond
+func (t *timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	operation, took := vaultOperation(req), time.Since(started)
+	metrics.VaultRequest(operation, status, took)
+	if glog.V(4) {
+		withRequest(operation, req.URL.Path, took).Infof("vault request, status: %s", status)
+	}
+
+	return resp, err
+}
+
+// vaultOperation classifies a request to vault as a login, renew, revoke, issue, read or write
+//	req			: the request to vault
+func vaultOperation(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(path, "auth/") && strings.Contains(path, "/login"):
+		return "login"
+	case strings.HasPrefix(path, "sys/leases/renew"), path == "sys/renew", strings.HasPrefix(path, "auth/token/renew"):
+		return "renew"
+	case strings.HasPrefix(path, "sys/leases/revoke"), strings.HasPrefix(path, "sys/revoke"), strings.HasSuffix(path, "/revoke"):
+		return "revoke"
+	case strings.Contains(path, "/issue/"), strings.Contains(path, "/sign/"):
+		return "issue"
+	case req.Method == http.MethodGet, req.Method == "LIST":
+		return "read"
+	}
+
+	return "write"
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultOperation(t *testing.T) {
+	cases := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{method: http.MethodPut, path: "/v1/auth/kubernetes/login", expected: "login"},
+		{method: http.MethodPut, path: "/v1/auth/userpass/login/app", expected: "login"},
+		{method: http.MethodPut, path: "/v1/sys/leases/renew", expected: "renew"},
+		{method: http.MethodPut, path: "/v1/auth/token/renew-self", expected: "renew"},
+		{method: http.MethodPut, path: "/v1/sys/leases/revoke", expected: "revoke"},
+		{method: http.MethodPut, path: "/v1/pki/revoke", expected: "revoke"},
+		{method: http.MethodPut, path: "/v1/pki/issue/web", expected: "issue"},
+		{method: http.MethodGet, path: "/v1/secret/data/db", expected: "read"},
+		{method: http.MethodGet, path: "/v1/database/creds/app", expected: "read"},
+		{method: http.MethodPut, path: "/v1/transit/decrypt/app", expected: "write"},
+	}
+	for _, x := range cases {
+		assert.Equal(t, x.expected, vaultOperation(httptest.NewRequest(x.method, x.path, nil)), x.path)
+	}
+}
+
+func TestTimedTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &timedTransport{next: http.DefaultTransport}}
+	resp, err := client.Get(server.URL + "/v1/secret/data/db")
+	mustNoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
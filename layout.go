@@ -0,0 +1,119 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// layoutFile writes the resource to its filename in the format given, the default
+	layoutFile = "file"
+	// layoutDir writes the resource into a directory of its own, under fixed filenames
+	layoutDir = "dir"
+)
+
+// resourceMeta is the content of the meta.json of the dir layout
+type resourceMeta struct {
+	// the id of the resource
+	ID string `json:"id"`
+	// the type of the resource
+	Resource string `json:"resource"`
+	// the path of the resource
+	Path string `json:"path"`
+	// the time the files were written
+	Updated time.Time `json:"updated"`
+	// the time the lease of the secret expires, if it has one
+	LeaseExpiry *time.Time `json:"lease_expiry,omitempty"`
+	// the serial number and expiry of the certificate, for pki resources
+	Serial string     `json:"serial,omitempty"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+	// the files written to the directory
+	Files []string `json:"files"`
+}
+
+// dirLayout writes a resource into a directory of its own, under a contract which doesn't depend on the
+// options of the resource; the secret is always written to data.json and the metadata to meta.json, with
+// cert.pem, key.pem and ca.pem written as well when the secret holds a certificate
+type dirLayout struct{}
+
+// Write writes the files of the resource into the directory
+//	dir			: the directory of the resource
+//	rn			: the resource
+//	data		: the secret data
+func (dirLayout) Write(dir string, rn *VaultResource, data map[string]interface{}) error {
+	if !options.dryRun {
+		if err := makeDirectory(dir, directoryMode(&options)); err != nil {
+			return fmt.Errorf("unable to create the resource directory: %s, error: %s", dir, err)
+		}
+		if err := owners.apply(dir); err != nil {
+			return err
+		}
+	}
+
+	files := []string{"data.json"}
+	if err := writeJSONFile(filepath.Join(dir, "data.json"), data, rn.FileMode); err != nil {
+		return err
+	}
+	pems := []struct {
+		name string
+		key  string
+	}{
+		{name: "cert.pem", key: "certificate"},
+		{name: "key.pem", key: "private_key"},
+		{name: "ca.pem", key: "issuing_ca"},
+	}
+	for _, x := range pems {
+		value, found := data[x.key]
+		if !found {
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, x.name), []byte(fmt.Sprintf("%s\n", value)), rn.FileMode); err != nil {
+			return err
+		}
+		files = append(files, x.name)
+	}
+
+	meta := resourceMeta{
+		ID:       rn.ID(),
+		Resource: rn.Resource,
+		Path:     rn.Path,
+		Updated:  time.Now().UTC(),
+		Files:    append(files, "meta.json"),
+	}
+	if expiry := statuses.leaseExpiry(rn); !expiry.IsZero() {
+		meta.LeaseExpiry = &expiry
+	}
+	if _, found := data["certificate"]; found {
+		meta.Serial = certificateSerial(data)
+		if expiry := certificateExpiry(data); !expiry.IsZero() {
+			meta.Expiry = &expiry
+		}
+	}
+	content, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+	glog.V(10).Infof("writing the dir layout of resource: %s to: %s", rn.ID(), dir)
+
+	return writeFile(filepath.Join(dir, "meta.json"), content, rn.FileMode)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirLayout(t *testing.T) {
+	defer withOutputDir(t)()
+
+	rn := &VaultResource{Resource: "pki", Path: "pki/issue/app", Format: "yaml", Layout: layoutDir, Filename: "app", FileMode: 0600}
+	data := newTestPKI(t)
+	dir, err := writeResource(rn, data)
+	mustNoError(t, err)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "cert.pem"))
+	mustNoError(t, err)
+	assert.Equal(t, data["certificate"].(string)+"\n", string(content))
+	content, err = ioutil.ReadFile(filepath.Join(dir, "data.json"))
+	mustNoError(t, err)
+	decoded := make(map[string]interface{})
+	mustNoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, data["private_key"], decoded["private_key"])
+
+	content, err = ioutil.ReadFile(filepath.Join(dir, "meta.json"))
+	mustNoError(t, err)
+	meta := resourceMeta{}
+	mustNoError(t, json.Unmarshal(content, &meta))
+	assert.Equal(t, "pki/issue/app", meta.ID)
+	assert.Equal(t, "03", meta.Serial)
+	assert.NotNil(t, meta.Expiry)
+	assert.Equal(t, []string{"data.json", "cert.pem", "key.pem", "ca.pem", "meta.json"}, meta.Files)
+
+	// step: a secret without a certificate has only the data and meta
+	rn = &VaultResource{Resource: "secret", Path: "secret/app", Format: "yaml", Layout: layoutDir, FileMode: 0600}
+	dir, err = writeResource(rn, map[string]interface{}{"password": "secret"})
+	mustNoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	mustNoError(t, err)
+	assert.Len(t, files, 2)
+}
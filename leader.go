@@ -0,0 +1,197 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+// leaseTimeFormat is the format of the micro-second times of a lease
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubeLeaseSpec is the spec of a coordination lease
+type kubeLeaseSpec struct {
+	// the identity of the holder of the lease
+	HolderIdentity string `json:"holderIdentity,omitempty"`
+	// how long the lease is held for without being renewed
+	LeaseDurationSeconds int `json:"leaseDurationSeconds,omitempty"`
+	// when the lease was acquired by the holder
+	AcquireTime string `json:"acquireTime,omitempty"`
+	// when the lease was last renewed by the holder
+	RenewTime string `json:"renewTime,omitempty"`
+	// the number of times the lease has changed holder
+	LeaseTransitions int `json:"leaseTransitions,omitempty"`
+}
+
+// kubeLease is a coordination lease, used to elect a leader
+type kubeLease struct {
+	// the api version of the object
+	APIVersion string `json:"apiVersion"`
+	// the kind of the object
+	Kind string `json:"kind"`
+	// the metadata of the lease
+	Metadata kubeMetadata `json:"metadata"`
+	// the spec of the lease
+	Spec kubeLeaseSpec `json:"spec"`
+}
+
+// leaderElector elects a single sidekick of those sharing a lease to retrieve and write the resources,
+// the others standing by until the lease expires
+type leaderElector struct {
+	// the client of the kubernetes api
+	kube *kubeClient
+	// the namespace and name of the lease
+	namespace string
+	name      string
+	// the identity of the sidekick, the name of its pod
+	identity string
+	// how long the lease is held without being renewed
+	duration time.Duration
+	// the time now, replaced in the tests
+	now func() time.Time
+}
+
+// newLeaderElector creates an elector for the lease given as NAME or NAMESPACE/NAME
+//	lease		: the lease
+//	duration	: how long the lease is held without being renewed
+func newLeaderElector(lease string, duration time.Duration) (*leaderElector, error) {
+	kube, err := newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace, name := splitConfigMap(lease, kube.namespace)
+
+	return &leaderElector{
+		kube:      kube,
+		namespace: namespace,
+		name:      name,
+		identity:  podName(),
+		duration:  duration,
+		now:       time.Now,
+	}, nil
+}
+
+// path returns the path of the lease in the kubernetes api
+func (l *leaderElector) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.namespace, l.name)
+}
+
+// tryAcquire acquires or renews the lease, returning whether the sidekick holds it
+func (l *leaderElector) tryAcquire() (bool, error) {
+	now := l.now().UTC().Format(leaseTimeFormat)
+	lease := &kubeLease{}
+	err := l.kube.get(l.path(), lease)
+	switch {
+	case kubeNotFound(err):
+		// step: no one has created the lease yet, create it holding it
+		lease = &kubeLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   kubeMetadata{Name: l.name, Namespace: l.namespace},
+			Spec: kubeLeaseSpec{
+				HolderIdentity:       l.identity,
+				LeaseDurationSeconds: int(l.duration.Seconds()),
+				AcquireTime:          now,
+				RenewTime:            now,
+			},
+		}
+		path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.namespace)
+
+		return l.update(http.MethodPost, path, lease)
+	case err != nil:
+		return false, err
+	}
+
+	// step: check if the lease is held by another sidekick which is still renewing it
+	if lease.Spec.HolderIdentity != l.identity && lease.Spec.HolderIdentity != "" {
+		renewed, err := time.Parse(leaseTimeFormat, lease.Spec.RenewTime)
+		expires := renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if err == nil && l.now().Before(expires) {
+			return false, nil
+		}
+		glog.Infof("the leader election lease: %s/%s held by: %s has expired, taking it over", l.namespace, l.name, lease.Spec.HolderIdentity)
+	}
+	if lease.Spec.HolderIdentity != l.identity {
+		lease.Spec.HolderIdentity = l.identity
+		lease.Spec.AcquireTime = now
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+	lease.Spec.RenewTime = now
+
+	// step: the resource version of the lease guards against another sidekick updating it in between
+	return l.update(http.MethodPut, l.path(), lease)
+}
+
+// update writes the lease, a conflict meaning another sidekick got there first
+//	method		: the http method
+//	path		: the path of the request
+//	lease		: the lease written
+func (l *leaderElector) update(method, path string, lease *kubeLease) (bool, error) {
+	err := l.kube.do(method, path, "application/json", lease, nil)
+	if x, ok := err.(*kubeError); ok && (x.code == http.StatusConflict) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// acquire blocks until the sidekick holds the lease, then keeps renewing it in the background, exiting
+// should it fail to renew the lease before it expires, as another sidekick may then have taken over
+func (l *leaderElector) acquire() {
+	interval := l.duration / 3
+	metrics.Leader(false)
+	for {
+		leader, err := l.tryAcquire()
+		if err != nil {
+			glog.Errorf("unable to acquire the leader election lease: %s/%s, error: %s", l.namespace, l.name, err)
+		}
+		if leader {
+			break
+		}
+		glog.V(3).Infof("standing by, the leader election lease: %s/%s is held by another sidekick", l.namespace, l.name)
+		time.Sleep(interval)
+	}
+	glog.Infof("acquired the leader election lease: %s/%s as: %s", l.namespace, l.name, l.identity)
+	metrics.Leader(true)
+
+	go func() {
+		renewed := l.now()
+		for {
+			time.Sleep(interval)
+			leader, err := l.tryAcquire()
+			if err != nil {
+				glog.Errorf("unable to renew the leader election lease: %s/%s, error: %s", l.namespace, l.name, err)
+			}
+			if leader {
+				renewed = l.now()
+				continue
+			}
+			if err == nil || l.now().Sub(renewed) >= l.duration {
+				glog.Errorf("lost the leader election lease: %s/%s, exiting so another sidekick takes over", l.namespace, l.name)
+				metrics.Leader(false)
+				exit(1)
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLeases is a kubernetes api holding a single lease
+type fakeLeases struct {
+	sync.Mutex
+	lease   *kubeLease
+	version int
+}
+
+func (f *fakeLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(&kubeStatus{Reason: "NotFound", Message: "leases not found", Code: http.StatusNotFound})
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPost, http.MethodPut:
+		lease := &kubeLease{}
+		if err := json.NewDecoder(r.Body).Decode(lease); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.lease != nil && (r.Method == http.MethodPost || lease.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.version++
+		lease.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.lease = lease
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(lease)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	leases := &fakeLeases{}
+	server := httptest.NewServer(leases)
+	defer server.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	kube := &kubeClient{host: server.URL, namespace: "default", client: http.DefaultClient}
+	first := &leaderElector{kube: kube, namespace: "default", name: "sidekick", identity: "app-0", duration: 15 * time.Second, now: clock}
+	second := &leaderElector{kube: kube, namespace: "default", name: "sidekick", identity: "app-1", duration: 15 * time.Second, now: clock}
+
+	// step: the first creates the lease, the second stands by
+	leader, err := first.tryAcquire()
+	mustNoError(t, err)
+	assert.True(t, leader)
+	leader, err = second.tryAcquire()
+	mustNoError(t, err)
+	assert.False(t, leader)
+
+	// step: the first renews the lease, keeping it
+	now = now.Add(10 * time.Second)
+	leader, err = first.tryAcquire()
+	mustNoError(t, err)
+	assert.True(t, leader)
+	now = now.Add(10 * time.Second)
+	leader, err = second.tryAcquire()
+	mustNoError(t, err)
+	assert.False(t, leader)
+
+	// step: the second takes over once the lease expires
+	now = now.Add(10 * time.Second)
+	leader, err = second.tryAcquire()
+	mustNoError(t, err)
+	assert.True(t, leader)
+	assert.Equal(t, "app-1", leases.lease.Spec.HolderIdentity)
+	assert.Equal(t, 1, leases.lease.Spec.LeaseTransitions)
+	leader, err = first.tryAcquire()
+	mustNoError(t, err)
+	assert.False(t, leader)
+}
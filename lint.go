@@ -0,0 +1,184 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+const (
+	// lintCheckPlaceholder is raised when a value looks like placeholder or test data
+	lintCheckPlaceholder = "placeholder"
+	// lintCheckEntropy is raised when a value has suspiciously low entropy
+	lintCheckEntropy = "low_entropy"
+	// lintCheckExpiredCert is raised when a value contains an expired certificate
+	lintCheckExpiredCert = "expired_certificate"
+	// lintMinimumEntropy is the minimum shannon entropy (bits per character) we expect of a secret
+	lintMinimumEntropy = 2.5
+	// lintMinimumLength is the shortest value we apply the entropy check to
+	lintMinimumLength = 8
+)
+
+// lintPlaceholders is a list of values commonly used as placeholders or test data
+var lintPlaceholders = []string{
+	"changeme",
+	"change_me",
+	"change-me",
+	"todo",
+	"fixme",
+	"placeholder",
+	"replaceme",
+	"dummy",
+	"example",
+	"password",
+	"secret",
+	"test",
+	"xxx",
+}
+
+// lintWarning is a suspicious finding in the content of a secret
+type lintWarning struct {
+	// the key within the secret
+	key string
+	// the check which raised the warning
+	check string
+	// a description of the problem
+	message string
+}
+
+// lintResource runs the secret heuristics over the data, logging and recording any findings; it never blocks the write
+//	rn			: the resource the secret belongs to
+//	data		: the secret data
+func lintResource(rn *VaultResource, data map[string]interface{}) {
+	for _, x := range lintSecret(data, time.Now()) {
+		glog.Warningf("lint: resource: %s, key: %s, check: %s, %s", rn, keyName(rn, x.key), x.check, x.message)
+		metrics.ResourceLintWarning(rn.ID(), x.check)
+	}
+}
+
+// lintSecret checks the values of a secret for content which looks like placeholders or test data
+//	data		: the secret data
+//	now			: the time used to check certificate expiry
+func lintSecret(data map[string]interface{}, now time.Time) []lintWarning {
+	var list []lintWarning
+	for key, v := range data {
+		value, ok := v.(string)
+		if !ok {
+			continue
+		}
+		// step: certificates are checked for expiry only, the other heuristics make no sense on them
+		if strings.Contains(value, "-----BEGIN CERTIFICATE-----") {
+			if cert := expiredCertificate([]byte(value), now); cert != nil {
+				list = append(list, lintWarning{
+					key:     key,
+					check:   lintCheckExpiredCert,
+					message: fmt.Sprintf("certificate: %s expired at: %s", cert.Subject.CommonName, cert.NotAfter),
+				})
+			}
+			continue
+		}
+		if isPlaceholder(value) {
+			list = append(list, lintWarning{
+				key:     key,
+				check:   lintCheckPlaceholder,
+				message: "the value looks like a placeholder or test data",
+			})
+			continue
+		}
+		if len(value) >= lintMinimumLength {
+			if entropy := shannonEntropy(value); entropy < lintMinimumEntropy {
+				list = append(list, lintWarning{
+					key:     key,
+					check:   lintCheckEntropy,
+					message: fmt.Sprintf("the value has a low entropy of %.2f bits per character", entropy),
+				})
+			}
+		}
+	}
+
+	return list
+}
+
+// isPlaceholder checks if the value is, or is built from, a well known placeholder
+func isPlaceholder(value string) bool {
+	normalized := strings.ToLower(strings.TrimSpace(value))
+	if normalized == "" {
+		return true
+	}
+	for _, x := range lintPlaceholders {
+		if normalized == x || strings.HasPrefix(normalized, x+"123") || normalized == "<"+x+">" {
+			return true
+		}
+	}
+	// step: values such as 'TODO: fill me in' or '${CHANGEME}'
+	for _, x := range []string{"todo", "changeme", "fixme", "placeholder", "replaceme"} {
+		if strings.Contains(normalized, x) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shannonEntropy calculates the shannon entropy of the value in bits per character
+func shannonEntropy(value string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, c := range value {
+		counts[c]++
+		total++
+	}
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// expiredCertificate returns the first certificate in the pem content which has expired
+func expiredCertificate(content []byte, now time.Time) *x509.Certificate {
+	for len(content) > 0 {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if now.After(cert.NotAfter) {
+			return cert
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintSecretPlaceholders(t *testing.T) {
+	for _, value := range []string{"changeme", "TODO", "<password>", "password123", "", "please CHANGEME"} {
+		warnings := lintSecret(map[string]interface{}{"value": value}, time.Now())
+		if assert.Len(t, warnings, 1, "value: %q", value) {
+			assert.Equal(t, lintCheckPlaceholder, warnings[0].check)
+		}
+	}
+}
+
+func TestLintSecretEntropy(t *testing.T) {
+	warnings := lintSecret(map[string]interface{}{"value": "aaaaaaaaaaaa"}, time.Now())
+	if assert.Len(t, warnings, 1) {
+		assert.Equal(t, lintCheckEntropy, warnings[0].check)
+	}
+	assert.Empty(t, lintSecret(map[string]interface{}{"value": newPassword(20)}, time.Now()))
+	assert.Empty(t, lintSecret(map[string]interface{}{"port": 5432}, time.Now()))
+}
+
+func TestLintSecretExpiredCertificate(t *testing.T) {
+	content, err := ioutil.ReadFile("tests/ca_bundle.pem")
+	if !assert.NoError(t, err) {
+		return
+	}
+	data := map[string]interface{}{"ca": string(content)}
+
+	assert.Empty(t, lintSecret(data, time.Time{}))
+	warnings := lintSecret(data, time.Now().AddDate(100, 0, 0))
+	if assert.Len(t, warnings, 1) {
+		assert.Equal(t, lintCheckExpiredCert, warnings[0].check)
+	}
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// logFormatText leaves the log lines as glog formats them
+	logFormatText = "text"
+	// logFormatJSON writes each log line as a json object
+	logFormatJSON = "json"
+	// minSubstringLength is the shortest value redacted wherever it appears, shorter ones being masked only
+	// when they stand alone, else a value such as 1 would mask every timestamp
+	minSubstringLength = 6
+)
+
+var (
+	// logHeader matches the header glog gives each line, i.e. I1017 12:00:00.000000   123 main.go:45] msg
+	logHeader = regexp.MustCompile(`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+\d+ ([^\]]+)\] (.*)$`)
+	// logLevels maps the severity of glog to the level
+	logLevels = map[string]string{"I": "info", "W": "warning", "E": "error", "F": "fatal"}
+)
+
+// logEntry is a line of the log in the json format
+type logEntry struct {
+	// the time the line was logged
+	Time string `json:"ts"`
+	// the severity of the line
+	Level string `json:"level"`
+	// the file and line which logged it
+	Caller string `json:"caller,omitempty"`
+	// the message
+	Message string `json:"msg"`
+	// the id of the resource the message refers to
+	ResourceID string `json:"resource_id,omitempty"`
+	// the type of the resource
+	Type string `json:"type,omitempty"`
+	// the path in vault
+	Path string `json:"path,omitempty"`
+	// the vault operation
+	Operation string `json:"operation,omitempty"`
+	// how long the operation took
+	Duration string `json:"duration,omitempty"`
+	// the error encountered
+	Error string `json:"error,omitempty"`
+}
+
+// logRelay holds the values of the secrets redacted from the logs and, with the json format, relays the
+// lines glog writes to stderr, rewriting each as json
+type logRelay struct {
+	sync.RWMutex
+	// serialises the writes to stderr, between the relay and the entries logged with their fields
+	output sync.Mutex
+	// the values redacted, longest first, so one never leaves part of another behind
+	values []string
+	// the current and previous values of each source, the previous kept while anything could still log them
+	current, previous map[string][]string
+	// the format the lines are written in
+	format string
+	// the stderr of the process, where the lines end up
+	stderr *os.File
+	// the write end of the pipe which replaced stderr
+	pipe *os.File
+	// closed once the relay has written out everything sent to it
+	done chan struct{}
+}
+
+// logs is the relay of the log lines of the sidekick
+var logs = &logRelay{
+	current:  make(map[string][]string),
+	previous: make(map[string][]string),
+	format:   logFormatText,
+	stderr:   os.Stderr,
+}
+
+// isValidLogFormat checks the log format is one we know
+func isValidLogFormat(format string) bool {
+	return format == logFormatText || format == logFormatJSON
+}
+
+// secretValues returns the string values of the secret, and each line of those spanning several, as log
+// lines can only be matched one at a time
+func secretValues(value interface{}) []string {
+	var list []string
+	switch x := value.(type) {
+	case string:
+		list = append(list, x)
+		if strings.Contains(x, "\n") {
+			list = append(list, strings.Split(x, "\n")...)
+		}
+	case map[string]interface{}:
+		for _, v := range x {
+			list = append(list, secretValues(v)...)
+		}
+	case []interface{}:
+		for _, v := range x {
+			list = append(list, secretValues(v)...)
+		}
+	}
+
+	return list
+}
+
+// redactSecret records the values of a secret as to be redacted from the logs, replacing those of the
+// previous but one secret from the same source
+//	source		: the resource, or token, the values came from
+//	value		: the secret, a string or the data of a secret
+func (l *logRelay) redactSecret(source string, value interface{}) {
+	var values []string
+	for _, x := range secretValues(value) {
+		if x = strings.TrimSpace(x); x != "" {
+			values = append(values, x)
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.previous[source] = l.current[source]
+	l.current[source] = values
+
+	unique := make(map[string]bool)
+	for _, m := range []map[string][]string{l.current, l.previous} {
+		for _, list := range m {
+			for _, x := range list {
+				unique[x] = true
+			}
+		}
+	}
+	l.values = l.values[:0]
+	for x := range unique {
+		l.values = append(l.values, x)
+	}
+	sort.Slice(l.values, func(i, j int) bool {
+		return len(l.values[i]) > len(l.values[j])
+	})
+}
+
+// redact masks the values of the secrets in the line
+func (l *logRelay) redact(line string) string {
+	l.RLock()
+	defer l.RUnlock()
+	for _, x := range l.values {
+		if !strings.Contains(line, x) {
+			continue
+		}
+		if len(x) >= minSubstringLength {
+			line = strings.Replace(line, x, maskedValue, -1)
+			continue
+		}
+		line = redactToken(line, x)
+	}
+
+	return line
+}
+
+// redactToken masks the value wherever it isn't part of a longer word
+//	line		: the line being redacted
+//	value		: the value of the secret
+func redactToken(line, value string) string {
+	for i := 0; i < len(line); {
+		n := strings.Index(line[i:], value)
+		if n < 0 {
+			break
+		}
+		begin, end := i+n, i+n+len(value)
+		if (begin > 0 && isWordByte(line[begin-1])) || (end < len(line) && isWordByte(line[end])) {
+			i = begin + 1
+			continue
+		}
+		line = line[:begin] + maskedValue + line[end:]
+		i = begin + len(maskedValue)
+	}
+
+	return line
+}
+
+// isWordByte checks if the byte is a letter, digit or underscore
+func isWordByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// formatJSON rewrites a line of the log as json, lines without a glog header being taken as info; the
+// fields are only those of the header, the rest being given by the callers logging with logFields
+//	line		: the redacted line
+//	now			: the time the line was read, for those without a header
+func formatJSON(line string, now time.Time) []byte {
+	entry := &logEntry{Time: now.Format(time.RFC3339Nano), Level: "info", Message: line}
+	if m := logHeader.FindStringSubmatch(line); m != nil {
+		entry.Level = logLevels[m[1]]
+		entry.Caller = m[3]
+		entry.Message = m[4]
+		if ts, err := time.ParseInLocation("0102 15:04:05.000000", m[2], time.Local); err == nil {
+			entry.Time = ts.AddDate(now.Year(), 0, 0).Format(time.RFC3339Nano)
+		}
+	}
+	encoded, _ := json.Marshal(entry)
+
+	return append(encoded, '\n')
+}
+
+// write writes out the encoded lines, keeping them whole between the relay and the callers
+func (l *logRelay) write(writer io.Writer, encoded []byte) {
+	l.output.Lock()
+	defer l.output.Unlock()
+	writer.Write(encoded)
+}
+
+// relay copies the lines from the reader to the writer, redacted and as json; lines of any length are read
+// whole, so one long line can't stop the relay and leave glog blocked on the pipe
+func (l *logRelay) relay(reader io.Reader, writer io.Writer) {
+	defer close(l.done)
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			l.write(writer, formatJSON(l.redact(line), time.Now()))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// start replaces stderr with a pipe into the relay when the lines are written as json; the text format
+// leaves glog writing to stderr, the secrets being redacted by the callers logging with logFields
+//	format		: the format of the log lines
+func (l *logRelay) start(format string) error {
+	l.format = format
+	if format != logFormatJSON {
+		return nil
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	l.pipe = writer
+	l.done = make(chan struct{})
+	os.Stderr = writer
+	go l.relay(reader, l.stderr)
+
+	return nil
+}
+
+// stop flushes the logs and waits for the relay to write them out
+func (l *logRelay) stop() {
+	if l.pipe == nil {
+		return
+	}
+	glog.Flush()
+	os.Stderr = l.stderr
+	l.pipe.Close()
+	<-l.done
+	l.pipe = nil
+}
+
+// logFields are the fields logged alongside a message, given by the caller rather than picked out of it
+type logFields struct {
+	// the resource the message refers to
+	resource *VaultResource
+	// the vault operation
+	operation string
+	// the path in vault
+	path string
+	// how long the operation took
+	duration time.Duration
+	// the error encountered
+	err error
+}
+
+// withResource logs the message against the resource
+func withResource(rn *VaultResource) *logFields {
+	return &logFields{resource: rn}
+}
+
+// withRequest logs the message against a request to vault
+//	operation	: the vault operation
+//	path		: the path of the request
+//	took		: how long the request took
+func withRequest(operation, path string, took time.Duration) *logFields {
+	return &logFields{operation: operation, path: path, duration: took}
+}
+
+// withError adds the error to the fields
+func (f *logFields) withError(err error) *logFields {
+	f.err = err
+	return f
+}
+
+// Infof logs the message at the info level
+func (f *logFields) Infof(format string, args ...interface{}) {
+	f.log("I", format, args...)
+}
+
+// Warningf logs the message at the warning level
+func (f *logFields) Warningf(format string, args ...interface{}) {
+	f.log("W", format, args...)
+}
+
+// Errorf logs the message at the error level
+func (f *logFields) Errorf(format string, args ...interface{}) {
+	f.log("E", format, args...)
+}
+
+// entry builds the log entry of the message, the message and every field redacted
+func (f *logFields) entry(severity, message string) *logEntry {
+	entry := &logEntry{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Level:     logLevels[severity],
+		Message:   logs.redact(message),
+		Operation: f.operation,
+		Path:      logs.redact(f.path),
+	}
+	if f.resource != nil {
+		entry.ResourceID = logs.redact(f.resource.ID())
+		entry.Type = f.resource.Resource
+		entry.Path = logs.redact(f.resource.Path)
+	}
+	if f.duration > 0 {
+		entry.Duration = f.duration.String()
+	}
+	if f.err != nil {
+		entry.Error = logs.redact(f.err.Error())
+	}
+
+	return entry
+}
+
+// text formats the entry as a glog message, the fields following it
+func (e *logEntry) text() string {
+	text := e.Message
+	for _, x := range []struct{ name, value string }{
+		{"resource", e.ResourceID},
+		{"type", e.Type},
+		{"path", e.Path},
+		{"operation", e.Operation},
+		{"duration", e.Duration},
+		{"error", e.Error},
+	} {
+		if x.value != "" {
+			text = fmt.Sprintf("%s, %s: %s", text, x.name, x.value)
+		}
+	}
+
+	return text
+}
+
+// log writes the message with its fields, as json straight to stderr or to glog as text
+func (f *logFields) log(severity, format string, args ...interface{}) {
+	entry := f.entry(severity, fmt.Sprintf(format, args...))
+	if logs.format != logFormatJSON {
+		switch severity {
+		case "W":
+			glog.WarningDepth(2, entry.text())
+		case "E":
+			glog.ErrorDepth(2, entry.text())
+		default:
+			glog.InfoDepth(2, entry.text())
+		}
+		return
+	}
+	if _, file, line, found := runtime.Caller(2); found {
+		entry.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	encoded, _ := json.Marshal(entry)
+	logs.write(logs.stderr, append(encoded, '\n'))
+}
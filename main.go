@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -38,28 +39,107 @@ var (
 
 func main() {
 	version := fmt.Sprintf("%s (git+sha %s)", release, gitsha)
+	// step: check if we are running one of the subcommands
+	if handled, err := runCommand(os.Args[1:]); handled {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+			exit(1)
+		}
+		return
+	}
 	// step: parse and validate the command line / environment options
 	if err := parseOptions(); err != nil {
-		showUsage("invalid options, %s", err)
+		showUsage("%s", wrapError(codeConfigInvalid, err, "invalid options, %s"))
 	}
 	if options.showVersion {
 		fmt.Printf("%s %s\n", prog, version)
 		return
 	}
+	// step: with the json format, pass everything logged through the relay, redacting the secrets
+	if err := logs.start(options.logFormat); err != nil {
+		showUsage("%s", wrapError(codeConfigInvalid, err, "unable to relay the logs, %s"))
+	}
 	glog.Infof("starting the %s, %s", prog, version)
 
-	//  Don't initialise metrics in one-shot mode.
+	// step: apply the umask and check the output directory before anything is written
+	if err := prepareOutputDir(&options); err != nil {
+		showUsage("%s", withCode(codeConfigInvalid, err))
+	}
+
+	// step: are the metrics pushed to a statsd agent as well?
+	labels, _ := metrics.ParseLabels(options.metricsLabels)
+	if options.statsdAddress != "" {
+		sink, err := metrics.NewStatsdSink(options.statsdAddress, options.statsdFormat, labels)
+		if err != nil {
+			showUsage("%s", wrapError(codeConfigInvalid, err, "unable to push the metrics to the statsd agent: %s, error: %s", options.statsdAddress))
+		}
+		metrics.AddSink(sink)
+	}
+
+	//  Don't initialise metrics or the admin api in one-shot mode.
 	if options.oneShot {
 		glog.Infof("running in one-shot mode")
+		// step: the metrics are still gathered when they're pushed or written out on exit
+		if options.metricsPushGateway != "" || options.metricsTextfile != "" {
+			metrics.Register(labels)
+			metrics.BuildInfo(release, gitsha)
+		}
 	} else {
-		metrics.Init(options.vaultAuthOptions.RoleID, options.metricsPort)
+		serveHealth()
+		if err := secureMetrics(&options); err != nil {
+			showUsage("%s", wrapError(codeConfigInvalid, err, "unable to secure the metrics, %s"))
+		}
+		address := options.metricsListen
+		if address == "" {
+			address = fmt.Sprintf(":%d", options.metricsPort)
+		}
+		metrics.Init(labels, address, options.metricsListeners)
+		metrics.BuildInfo(release, gitsha)
+		if err := serveDebug(options.debugEndpoints, options.debugListener); err != nil {
+			showUsage("%s", wrapError(codeConfigInvalid, err, "unable to serve the debug endpoints on: %s, error: %s", options.debugListener))
+		}
+		if options.adminSocket != "" {
+			if err := serveAdmin(options.adminSocket); err != nil {
+				showUsage("%s", wrapError(codeConfigInvalid, err, "unable to serve the admin api on: %s, error: %s", options.adminSocket))
+			}
+		}
+		if options.serveSocket != "" {
+			if err := serveSecrets(options.serveSocket, options.serveUIDs); err != nil {
+				showUsage("%s", wrapError(codeConfigInvalid, err, "unable to serve the secrets on: %s, error: %s", options.serveSocket))
+			}
+		}
+		if options.fuseMount != "" {
+			if err := mountFuse(options.fuseMount); err != nil {
+				showUsage("%s", wrapError(codeConfigInvalid, err, "unable to mount the secrets on: %s, error: %s", options.fuseMount))
+			}
+		}
+	}
+
+	// step: stand by until elected the leader of the replicas sharing the lease
+	if options.leaderElection != "" {
+		elector, err := newLeaderElector(options.leaderElection, options.leaderLeaseDuration)
+		if err != nil {
+			showUsage("%s", wrapError(codeKubeRequest, err, "unable to take part in the leader election, %s"))
+		}
+		elector.acquire()
+	}
+
+	// step: resume the token and leases of a previous run
+	if options.stateFile != "" {
+		if err := state.open(options.stateFile, options.stateKeyFile); err != nil {
+			showUsage("%s", wrapError(codeConfigInvalid, err, "unable to open the state file: %s, error: %s", options.stateFile))
+		}
 	}
 
 	// step: create a client to vault
 	vault, err := NewVaultService(options.vaultURL)
 	if err != nil {
-		showUsage("unable to create the vault client: %s", err)
+		showUsage("%s", wrapError(codeAuthClient, err, "unable to create the vault client: %s"))
 	}
+	secretReader = vault.read
+	templateSecretReader = vault.templateSecret
+	templateSecretLister = vault.listSecrets
+	refreshResource = vault.Refresh
 
 	// step: create a channel to receive events upon and add our resources for renewal
 	updates := make(chan VaultEvent, 10)
@@ -69,22 +149,136 @@ func main() {
 	vault.AddListener(expiryUpdates)
 	// Start a background worker which listens for resource updates and reports expiry metrics.
 	go reportExpiryMetrics(expiryUpdates)
+	if options.expiryGrace > 0 && !options.oneShot {
+		go watchExpiries(options.expiryGrace, options.expiryWebhook)
+	}
+	if options.kubeEvents {
+		recorder, err := newKubeEventRecorder()
+		if err != nil {
+			showUsage("%s", wrapError(codeKubeRequest, err, "unable to raise kubernetes events, %s"))
+		}
+		kubeUpdates := make(chan VaultEvent, 10)
+		vault.AddListener(kubeUpdates)
+		go recorder.watch(kubeUpdates)
+	}
+	if options.podStatus && !options.oneShot {
+		reporter, err := newPodStatusReporter()
+		if err != nil {
+			showUsage("%s", wrapError(codeKubeRequest, err, "unable to patch the status of the pod, %s"))
+		}
+		go reporter.watch()
+	}
 
 	// step: create a channel to receive events and keep the metrics
 	// collector data in sync
 	metricUpdates := make(chan VaultEvent, 10)
 	vault.AddListener(metricUpdates)
 
+	// step: are the files written and hooks run audited?
+	if options.auditLog != "" {
+		log, err := openAuditLog(options.auditLog)
+		if err != nil {
+			showUsage("%s", wrapError(codeConfigInvalid, err, "unable to open the audit log: %s, error: %s", options.auditLog))
+		}
+		audit = log
+	}
+
+	// step: are the rotations traced?
+	if options.tracingEndpoint != "" {
+		traces.start(options.tracingEndpoint, getEnv("OTEL_SERVICE_NAME", prog))
+	}
+
 	// step: setup the termination signals
-	signalChannel := make(chan os.Signal)
-	signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, terminationSignals...)
+	rotateChannel := make(chan os.Signal, 1)
+	if len(rotationSignals) > 0 && !options.oneShot {
+		signal.Notify(rotateChannel, rotationSignals...)
+	}
+	dumpChannel := make(chan os.Signal, 1)
+	if len(dumpSignals) > 0 {
+		signal.Notify(dumpChannel, dumpSignals...)
+	}
+
+	// step: are the resources declared in a configmap, watched for changes?
+	var configMapChannel chan []*VaultResource
+	if options.resourcesConfigMap != "" {
+		kube, err := newKubeClient()
+		if err != nil {
+			showUsage("%s", wrapError(codeKubeRequest, err, "unable to watch the resources configmap, %s"))
+		}
+		namespace, name := splitConfigMap(options.resourcesConfigMap, kube.namespace)
+		resources, version, err := readResourcesConfigMap(kube, namespace, name)
+		if err != nil {
+			showUsage("%s", err)
+		}
+		options.resourcesFromYAML = resources
+		options.resources.items = append(options.resources.items, resources...)
+		if !options.oneShot {
+			configMapChannel = make(chan []*VaultResource)
+			go watchResourcesConfigMap(kube, namespace, name, version, configMapChannel)
+		}
+	}
 
 	// step: add each of the resources to the service processor
 	for _, rn := range options.resources.items {
 		if err := rn.IsValid(); err != nil {
-			showUsage("%s", err)
+			showUsage("%s", withCode(codeResourceInvalid, err))
 		}
 		vault.Watch(rn)
+		if !options.oneShot {
+			watchers.start(vault, rn, options.triggerInterval)
+		}
+		if timeout := firstFetchTimeout(rn); timeout > 0 {
+			go watchFirstFetch(rn, timeout)
+		}
+	}
+
+	// step: limit the hooks run at once
+	execs = newExecLimiter(options.execConcurrency)
+
+	// step: the hooks run once the resources are all rendered and once their rotations settle
+	hooks = newGlobalHooks(options.readyExec, options.rotationExec, options.rotationSettle)
+
+	// step: is there a sentinel file to create once the resources are all written?
+	if options.readyFile != "" {
+		if sentinel, err = newReadyFile(options.readyFile); err != nil {
+			showUsage("%s", wrapError(codeConfigInvalid, err, "unable to remove the ready file: %s, error: %s", options.readyFile))
+		}
+		if !options.oneShot {
+			go sentinel.watch()
+		}
+	}
+
+	metrics.ResourcesConfigured(resourceSpecs(options.resources.items))
+
+	// step: are we managing the content of the output directory?
+	var collector *outputCollector
+	if options.outputGC && !options.dryRun && options.outputDir != stdoutOutput && !servesFromMemory() {
+		collector = newOutputCollector(options.outputDir, options.resources.items, options.outputGCDryRun)
+	}
+
+	// step: are we supervising a process with the secrets in its environment?
+	if len(options.childCommand) > 0 {
+		var signal syscall.Signal
+		if options.childOnChange == childSignal {
+			signal, _ = parseSignal(options.childSignal)
+		}
+		supervised = newSupervisor(options.childCommand, options.resources.items, options.childKillTimeout,
+			options.childOnChange, signal)
+		supervised.reload()
+	}
+
+	// step: build the graph of resources which rotate together
+	graph, err := newDependencyGraph(options.resources.items)
+	if err != nil {
+		showUsage("%s", withCode(codeResourceInvalid, err))
+	}
+	if err := templates.watch(options.resources.items); err != nil {
+		showUsage("%s", withCode(codeResourceInvalid, err))
+	}
+	if err := ordering.watch(options.resources.items); err != nil {
+		showUsage("%s", withCode(codeResourceInvalid, err))
 	}
 
 	toProcess := options.resources.items
@@ -92,8 +286,22 @@ func main() {
 	failedResource := false
 	if options.oneShot && len(toProcess) == 0 {
 		glog.Infof("nothing to retrieve from vault. exiting...")
-		os.Exit(0)
+		exit(0)
 	}
+	// shutdown stops the service, exiting with the code
+	shutdown := func(code int) {
+		sentinel.remove()
+		supervised.stop()
+		unmountFuse()
+		vault.Shutdown(shutdownTimeout)
+		metrics.Shutdown(shutdownTimeout)
+		if options.deleteOnExit && !options.dryRun {
+			toProcessLock.Lock()
+			removeWrittenFiles()
+		}
+		exit(code)
+	}
+
 	// step: we simply wait for events i.e. secrets from vault and write them to the output directory
 	for {
 		select {
@@ -104,9 +312,44 @@ func main() {
 				defer toProcessLock.Unlock()
 				switch r.Type {
 				case EventTypeSuccess:
-					if err := processResource(evt.Resource, evt.Secret); err != nil {
-						glog.Errorf("failed to write out the update, error: %s", err)
+					metrics.ResourceStale(evt.Resource.ID(), false)
+					templates.update(evt.Resource, evt.Secret)
+					if !templates.ready(evt.Resource) {
+						glog.V(3).Infof("resource: %s is waiting on the resources its template refers to", evt.Resource)
+					} else if !ordering.ready(evt.Resource) {
+						glog.V(3).Infof("resource: %s is waiting on the resources it depends on: %s", evt.Resource,
+							strings.Join(ordering.hold(evt.Resource, evt.Secret), ", "))
+					} else if !graph.handleSuccess(vault, evt.Resource, evt.Secret) {
+						if err := processResource(evt.Resource, evt.Secret); err != nil {
+							traces.end(evt.Resource, err)
+							metrics.ResourceErrorCode(evt.Resource.ID(), errorCode(err))
+							withResource(evt.Resource).withError(err).Errorf("failed to write out the update")
+						} else {
+							if collector != nil {
+								collector.rendered(evt.Resource)
+							}
+							supervised.rendered(evt.Resource, evt.Secret)
+							// step: render the resources which were waiting on this one
+							releaseResources(ordering.success(evt.Resource), collector)
+						}
 					}
+					// step: re-render the templates which refer to the resource
+					rendered, err := templates.rendered(evt.Resource)
+					if err != nil {
+						metrics.ResourceErrorCode(evt.Resource.ID(), errorCode(err))
+						withResource(evt.Resource).withError(err).Errorf("failed to write out the templates referring to the resource")
+					}
+					for _, x := range rendered {
+						if collector != nil {
+							collector.rendered(x)
+						}
+						releaseResources(ordering.success(x), collector)
+					}
+					// step: the lease the secret replaces is only revoked once the replacement has been rendered
+					if evt.Superseded != "" {
+						go vault.supersede(evt.Superseded, evt.Resource.RevokeDelay)
+					}
+					traces.end(evt.Resource, nil)
 					if options.oneShot {
 						for i, r := range toProcess {
 							if evt.Resource == r {
@@ -114,42 +357,125 @@ func main() {
 							}
 						}
 					}
+				case EventTypeDeleted:
+					if deleted, ok := evt.Err.(*deletedSecretError); ok {
+						if err := deletedResource(evt.Resource, deleted); err != nil {
+							metrics.ResourceErrorCode(evt.Resource.ID(), errorCode(err))
+							withResource(evt.Resource).withError(err).Errorf("failed to run the on-delete command of the resource")
+						}
+					}
+					fallthrough
 				case EventTypeFailure:
-					if evt.Resource.MaxRetries > 0 && evt.Resource.MaxRetries < evt.Resource.Retries {
-						for i, r := range toProcess {
-							if evt.Resource == r {
-								toProcess = append(toProcess[:i], toProcess[i+1:]...)
-								failedResource = true
+					graph.handleFailure(vault, evt.Resource)
+					if retriesExhausted(evt.Resource) {
+						policy := failurePolicy(evt.Resource)
+						switch policy {
+						case failurePolicyFatal:
+							glog.Errorf("resource: %s has exhausted its retries, shutting down", evt.Resource)
+							reportFailures(os.Stderr)
+							sentinel.remove()
+							exit(1)
+						case failurePolicyKeepStale:
+							glog.Warningf("resource: %s has exhausted its retries, keeping the last good copy", evt.Resource)
+							metrics.ResourceStale(evt.Resource.ID(), true)
+							statuses.stale(evt.Resource)
+						}
+						// step: a stale copy is only kept on while the sidekick keeps running
+						if policy != failurePolicyKeepStale || options.oneShot {
+							for i, r := range toProcess {
+								if evt.Resource == r {
+									toProcess = append(toProcess[:i], toProcess[i+1:]...)
+									failedResource = failedResource || policy != failurePolicyKeepStale
+								}
 							}
 						}
 					}
+				case EventTypeTokenAcquired:
+					glog.V(3).Infof("received the vault event: %s, token ttl: %s", evt.Type, evt.TokenTTL)
+					// step: the leases of the old token may have been revoked along with it
+					if options.refetchOnReauth {
+						glog.Infof("a new vault token has been acquired, re-fetching %d resources", len(options.resources.items))
+						for _, rn := range options.resources.items {
+							go vault.Refresh(rn)
+						}
+					}
+				case EventTypeTokenRenewed:
+					glog.V(3).Infof("received the vault event: %s, token ttl: %s", evt.Type, evt.TokenTTL)
+				case EventTypeTokenExpired:
+					glog.Warningf("the vault token has expired without being refreshed, error: %s", evt.Err)
 				}
 				if len(toProcess) == 0 {
 					glog.Infof("no resources left to process. exiting...")
+					pendingExecs.Wait()
 					if failedResource {
-						os.Exit(1)
+						reportFailures(os.Stderr)
+						exit(1)
 					} else {
-						os.Exit(0)
+						sentinel.update(statuses, time.Now())
+						exit(0)
 					}
 				}
 			}(evt)
-		case <-signalChannel:
+		case next := <-configMapChannel:
+			toProcessLock.Lock()
+			plan, reloaded, err := applyResources(vault, options.resourcesConfigMap, next)
+			if err != nil {
+				glog.Errorf("failed to apply the resources from the configmap: %s, error: %s", options.resourcesConfigMap, err)
+			} else if reloaded != nil {
+				graph = reloaded
+				collector.watch(plan.watched())
+			}
+			toProcessLock.Unlock()
+		case sig := <-rotateChannel:
+			glog.Infof("recieved the signal: %s, rotating all the resources", sig)
+			toProcessLock.Lock()
+			rotateResources("")
+			toProcessLock.Unlock()
+		case request := <-rotateRequests:
+			toProcessLock.Lock()
+			request.rotated <- rotateResources(request.id)
+			toProcessLock.Unlock()
+		case sig := <-dumpChannel:
+			glog.Infof("recieved the signal: %s, dumping the state of the resources", sig)
+			logStatus(statuses.snapshot())
+		case sig := <-signalChannel:
+			// step: a hangup reloads the resources file, if there's one to reload
+			if sig == syscall.SIGHUP && options.resourcesYAML != "" && !options.oneShot {
+				toProcessLock.Lock()
+				plan, reloaded, err := reloadResources(vault)
+				if err != nil {
+					glog.Errorf("failed to reload the resources from: %s, error: %s", options.resourcesYAML, err)
+				} else if reloaded != nil {
+					graph = reloaded
+					collector.watch(plan.watched())
+				}
+				toProcessLock.Unlock()
+				break
+			}
 			glog.Infof("recieved a termination signal, shutting down the service")
-			os.Exit(0)
+			shutdown(0)
+		case code := <-supervised.exitCodes():
+			glog.Infof("the supervised process exited with: %d, shutting down the service", code)
+			shutdown(code)
 		}
 	}
 }
 
-// reportExpiryMetrics takes a channel of VaultEvents, and reports expiry metrics on every successful renewal event.
+// reportExpiryMetrics takes a channel of VaultEvents, and reports expiry metrics on every successful renewal event;
+// the expiration of a certificate, the end of the lease of any other leased secret, or when a kv secret is next re-read.
 func reportExpiryMetrics(updates chan VaultEvent) {
 	for {
 		select {
 		case event := <-updates:
-			if event.Type == EventTypeFailure {
+			if event.Type != EventTypeSuccess {
 				continue
 			}
 
+			// step: leased secrets expire with their lease, the others are reported by when they're next re-read
 			if event.Resource.Resource != "pki" {
+				if !event.Expiry.IsZero() {
+					metrics.ResourceExpiry(event.Resource.ID(), event.Expiry)
+				}
 				continue
 			}
 
@@ -169,3 +495,19 @@ func reportExpiryMetrics(updates chan VaultEvent) {
 		}
 	}
 }
+
+// resourceSpecs describes the resources for the inventory metrics
+//	resources	: the resources the sidekick is configured with
+func resourceSpecs(resources []*VaultResource) []metrics.ResourceSpec {
+	var specs []metrics.ResourceSpec
+	for _, rn := range resources {
+		specs = append(specs, metrics.ResourceSpec{
+			ID:     rn.ID(),
+			Type:   rn.Resource,
+			Path:   rn.Path,
+			Format: rn.Format,
+		})
+	}
+
+	return specs
+}
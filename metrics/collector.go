@@ -7,6 +7,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// certificateSerial is the serial number and expiry of a certificate being served
+type certificateSerial struct {
+	serial string
+	expiry time.Time
+}
+
 type collector struct {
 	resourceExpiryMetric *prometheus.Desc
 
@@ -14,16 +20,57 @@ type collector struct {
 	resourceSuccessMetric *prometheus.Desc
 	resourceErrorsMetric  *prometheus.Desc
 
+	resourceLastSuccessMetric *prometheus.Desc
+	resourceLastFailureMetric *prometheus.Desc
+	resourceDurationMetric    *prometheus.Desc
+
 	resourceProcessTotalMetric   *prometheus.Desc
 	resourceProcessSuccessMetric *prometheus.Desc
 	resourceProcessErrorsMetric  *prometheus.Desc
 
+	resourceLintWarningsMetric *prometheus.Desc
+
+	resourceLastReloadMetric *prometheus.Desc
+
+	resourceDeletedMetric *prometheus.Desc
+
+	resourceErrorCodesMetric *prometheus.Desc
+
+	resourceUnchangedMetric *prometheus.Desc
+
+	resourceBackoffMetric *prometheus.Desc
+
+	resourceNextRenewalMetric *prometheus.Desc
+
+	resourceStaleMetric *prometheus.Desc
+
+	resourceLeaseReducedMetric *prometheus.Desc
+
+	resourceExpiringMetric *prometheus.Desc
+
+	certificateSerialMetric *prometheus.Desc
+
 	tokenTotalMetric   *prometheus.Desc
 	tokenSuccessMetric *prometheus.Desc
 	tokenErrorsMetric  *prometheus.Desc
+	tokenTTLMetric     *prometheus.Desc
 
 	errorsMetric *prometheus.Desc
 
+	configGenerationMetric *prometheus.Desc
+
+	breakerStateMetric *prometheus.Desc
+
+	leaderMetric *prometheus.Desc
+
+	buildInfoMetric          *prometheus.Desc
+	resourceConfiguredMetric *prometheus.Desc
+
+	// vaultRequestDuration tracks the latency of the requests to vault, by operation and status.
+	vaultRequestDuration *prometheus.HistogramVec
+	// vaultRequests counts the responses from vault, by status code and operation.
+	vaultRequests *prometheus.CounterVec
+
 	// resourceExpiry is a map from resource ID to the last observed expiry time of resource.
 	resourceExpiry map[string]time.Time
 
@@ -32,19 +79,79 @@ type collector struct {
 	resourceSuccesses map[string]int64
 	resourceErrors    map[string]int64
 
+	// resourceLast{Success,Failure} tracks when each resource ID was last retrieved or renewed successfully, and last failed.
+	resourceLastSuccess map[string]time.Time
+	resourceLastFailure map[string]time.Time
+
+	// resourceDuration tracks how long the last retrieval of each resource ID took.
+	resourceDuration map[string]time.Duration
+
 	// resourceProcess{Totals,Successes,Errors} tracks counts of resource processes (i.e. writing to disk, running exec) per resource ID, and whether they succeeded or failed.
 	resourceProcessTotals    map[string]map[string]int64
 	resourceProcessSuccesses map[string]map[string]int64
 	resourceProcessErrors    map[string]map[string]int64
 
+	// resourceLintWarnings tracks counts of suspicious secret content per resource ID, by check.
+	resourceLintWarnings map[string]map[string]int64
+
+	// resourceLastReload tracks when the exec hook of each resource ID last ran successfully, by hook.
+	resourceLastReload map[string]map[string]time.Time
+
+	// resourceDeleted tracks counts of reads which found the secret of each resource ID deleted, by state.
+	resourceDeleted map[string]map[string]int64
+
+	// resourceErrorCodes tracks counts of the errors of each resource ID, by their stable error code.
+	resourceErrorCodes map[string]map[string]int64
+
+	// resourceUnchanged tracks counts of the writes of each resource ID skipped as the content was unchanged.
+	resourceUnchanged map[string]int64
+
+	// resourceBackoff tracks the delay before the next retry of each failing resource ID, zero once it succeeds.
+	resourceBackoff map[string]time.Duration
+
+	// resourceNextRenewal tracks when each resource ID is next due to be renewed, retried or re-read.
+	resourceNextRenewal map[string]time.Time
+
+	// resourceStale tracks whether each resource ID is serving a stale copy, having exhausted its retries.
+	resourceStale map[string]bool
+
+	// resourceLeaseReduced tracks counts of the leases of each resource ID vault cut shorter than expected.
+	resourceLeaseReduced map[string]int64
+
+	// resourceExpiring tracks whether the secret of each resource ID is within the grace window of expiring.
+	resourceExpiring map[string]bool
+
+	// certificateSerials tracks the serial and expiry of the current and previous certificate of each resource ID, by slot.
+	certificateSerials map[string]map[string]certificateSerial
+
 	// token{Totals,Successes,Errors} tracks counts of authentication attempts, and whether they succeeded or failed.
 	tokenTotals    int64
 	tokenSuccesses int64
 	tokenErrors    int64
 
+	// tokenExpiry tracks when the vault token expires, from its ttl when it was last looked up, once it has been.
+	tokenExpiry      time.Time
+	tokenExpiryKnown bool
+
 	// errors Tracks counts generic, non-resource related errors, by reason.
 	errors map[string]int
 
+	// configGeneration is the generation of the configuration last applied, bumped on each reload.
+	configGeneration int64
+
+	// breakerStates tracks which state the circuit breaker is in, one for the current state and zero for the others.
+	breakerStates map[string]float64
+
+	// buildInfo is the version and git sha of the sidekick, once known.
+	buildInfo []string
+
+	// resourcesConfigured are the resources the sidekick is configured with.
+	resourcesConfigured []ResourceSpec
+
+	// leader tracks whether the sidekick holds the leader election lease, once it takes part in one.
+	leader      bool
+	leaderKnown bool
+
 	metricsMutex sync.RWMutex
 }
 
@@ -63,12 +170,20 @@ func (c *collector) ResourceTotal(resourceID string) {
 func (c *collector) ResourceSuccess(resourceID string) {
 	c.metricsMutex.Lock()
 	c.resourceSuccesses[resourceID]++
+	c.resourceLastSuccess[resourceID] = time.Now()
 	c.metricsMutex.Unlock()
 }
 
 func (c *collector) ResourceError(resourceID string) {
 	c.metricsMutex.Lock()
 	c.resourceErrors[resourceID]++
+	c.resourceLastFailure[resourceID] = time.Now()
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceDuration(resourceID string, duration time.Duration) {
+	c.metricsMutex.Lock()
+	c.resourceDuration[resourceID] = duration
 	c.metricsMutex.Unlock()
 }
 
@@ -99,6 +214,93 @@ func (c *collector) ResourceProcessError(resourceID, stage string) {
 	c.metricsMutex.Unlock()
 }
 
+func (c *collector) ResourceLintWarning(resourceID, check string) {
+	c.metricsMutex.Lock()
+	if _, ok := c.resourceLintWarnings[resourceID]; !ok {
+		c.resourceLintWarnings[resourceID] = make(map[string]int64)
+	}
+	c.resourceLintWarnings[resourceID][check]++
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceLastReload(resourceID, hook string, at time.Time) {
+	c.metricsMutex.Lock()
+	if _, ok := c.resourceLastReload[resourceID]; !ok {
+		c.resourceLastReload[resourceID] = make(map[string]time.Time)
+	}
+	c.resourceLastReload[resourceID][hook] = at
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceDeleted(resourceID, state string) {
+	c.metricsMutex.Lock()
+	if _, ok := c.resourceDeleted[resourceID]; !ok {
+		c.resourceDeleted[resourceID] = make(map[string]int64)
+	}
+	c.resourceDeleted[resourceID][state]++
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceErrorCode(resourceID, code string) {
+	c.metricsMutex.Lock()
+	if _, ok := c.resourceErrorCodes[resourceID]; !ok {
+		c.resourceErrorCodes[resourceID] = make(map[string]int64)
+	}
+	c.resourceErrorCodes[resourceID][code]++
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceUnchanged(resourceID string) {
+	c.metricsMutex.Lock()
+	c.resourceUnchanged[resourceID]++
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceBackoff(resourceID string, backoff time.Duration) {
+	c.metricsMutex.Lock()
+	c.resourceBackoff[resourceID] = backoff
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceNextRenewal(resourceID string, at time.Time) {
+	c.metricsMutex.Lock()
+	c.resourceNextRenewal[resourceID] = at
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceStale(resourceID string, stale bool) {
+	c.metricsMutex.Lock()
+	c.resourceStale[resourceID] = stale
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceLeaseReduced(resourceID string) {
+	c.metricsMutex.Lock()
+	c.resourceLeaseReduced[resourceID]++
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourceExpiring(resourceID string, expiring bool) {
+	c.metricsMutex.Lock()
+	c.resourceExpiring[resourceID] = expiring
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) CertificateSerial(resourceID, slot, serial string, expiry time.Time) {
+	c.metricsMutex.Lock()
+	if _, ok := c.certificateSerials[resourceID]; !ok {
+		c.certificateSerials[resourceID] = make(map[string]certificateSerial)
+	}
+	c.certificateSerials[resourceID][slot] = certificateSerial{serial: serial, expiry: expiry}
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) RemoveCertificateSerial(resourceID, slot string) {
+	c.metricsMutex.Lock()
+	delete(c.certificateSerials[resourceID], slot)
+	c.metricsMutex.Unlock()
+}
+
 func (c *collector) TokenTotal() {
 	c.metricsMutex.Lock()
 	c.tokenTotals++
@@ -117,12 +319,58 @@ func (c *collector) TokenError() {
 	c.metricsMutex.Unlock()
 }
 
+func (c *collector) TokenTTL(ttl time.Duration) {
+	c.metricsMutex.Lock()
+	c.tokenExpiry = time.Now().Add(ttl)
+	c.tokenExpiryKnown = true
+	c.metricsMutex.Unlock()
+}
+
 func (c *collector) Error(reason string) {
 	c.metricsMutex.Lock()
 	c.errors[reason]++
 	c.metricsMutex.Unlock()
 }
 
+func (c *collector) ConfigGeneration(generation int64) {
+	c.metricsMutex.Lock()
+	c.configGeneration = generation
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) BreakerState(state string) {
+	c.metricsMutex.Lock()
+	for x := range c.breakerStates {
+		c.breakerStates[x] = 0
+	}
+	c.breakerStates[state] = 1
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) Leader(leader bool) {
+	c.metricsMutex.Lock()
+	c.leader = leader
+	c.leaderKnown = true
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) BuildInfo(version, gitsha string) {
+	c.metricsMutex.Lock()
+	c.buildInfo = []string{version, gitsha}
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) ResourcesConfigured(resources []ResourceSpec) {
+	c.metricsMutex.Lock()
+	c.resourcesConfigured = resources
+	c.metricsMutex.Unlock()
+}
+
+func (c *collector) VaultRequest(operation, status string, duration time.Duration) {
+	c.vaultRequestDuration.WithLabelValues(operation, status).Observe(duration.Seconds())
+	c.vaultRequests.WithLabelValues(status, operation).Inc()
+}
+
 func (c *collector) Describe(ch chan<- *prometheus.Desc) {
 	// Expiry metric
 	ch <- c.resourceExpiryMetric
@@ -131,14 +379,64 @@ func (c *collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.resourceTotalMetric
 	ch <- c.resourceSuccessMetric
 	ch <- c.resourceErrorsMetric
+	ch <- c.resourceLastSuccessMetric
+	ch <- c.resourceLastFailureMetric
+	ch <- c.resourceDurationMetric
+
+	// Lint metrics
+	ch <- c.resourceLintWarningsMetric
+
+	// Reload metrics
+	ch <- c.resourceLastReloadMetric
+
+	// Deletion metrics
+	ch <- c.resourceDeletedMetric
+
+	// Error code metrics
+	ch <- c.resourceErrorCodesMetric
+
+	// Unchanged metrics
+	ch <- c.resourceUnchangedMetric
+
+	// Backoff metrics
+	ch <- c.resourceBackoffMetric
+	ch <- c.resourceNextRenewalMetric
+
+	// Stale metrics
+	ch <- c.resourceStaleMetric
+
+	// Lease metrics
+	ch <- c.resourceLeaseReducedMetric
+	ch <- c.resourceExpiringMetric
+
+	// Certificate metrics
+	ch <- c.certificateSerialMetric
 
 	// Token metrics
 	ch <- c.tokenTotalMetric
 	ch <- c.tokenSuccessMetric
 	ch <- c.tokenErrorsMetric
+	ch <- c.tokenTTLMetric
 
 	// General errors metric
 	ch <- c.errorsMetric
+
+	// Config metrics
+	ch <- c.configGenerationMetric
+
+	// Breaker metrics
+	ch <- c.breakerStateMetric
+
+	// Leader election metrics
+	ch <- c.leaderMetric
+
+	// Inventory metrics
+	ch <- c.buildInfoMetric
+	ch <- c.resourceConfiguredMetric
+
+	// Vault request metrics
+	c.vaultRequestDuration.Describe(ch)
+	c.vaultRequests.Describe(ch)
 }
 
 func (c *collector) Collect(ch chan<- prometheus.Metric) {
@@ -166,6 +464,21 @@ func (c *collector) Collect(ch chan<- prometheus.Metric) {
 			resourceID)
 	}
 
+	for resourceID, at := range c.resourceLastSuccess {
+		ch <- prometheus.MustNewConstMetric(c.resourceLastSuccessMetric, prometheus.GaugeValue, float64(at.Unix()),
+			resourceID)
+	}
+
+	for resourceID, at := range c.resourceLastFailure {
+		ch <- prometheus.MustNewConstMetric(c.resourceLastFailureMetric, prometheus.GaugeValue, float64(at.Unix()),
+			resourceID)
+	}
+
+	for resourceID, duration := range c.resourceDuration {
+		ch <- prometheus.MustNewConstMetric(c.resourceDurationMetric, prometheus.GaugeValue, duration.Seconds(),
+			resourceID)
+	}
+
 	for resourceID, countsByStage := range c.resourceProcessTotals {
 		for stage, count := range countsByStage {
 			ch <- prometheus.MustNewConstMetric(c.resourceProcessTotalMetric, prometheus.CounterValue, float64(count),
@@ -187,12 +500,133 @@ func (c *collector) Collect(ch chan<- prometheus.Metric) {
 		}
 	}
 
+	for resourceID, countsByCheck := range c.resourceLintWarnings {
+		for check, count := range countsByCheck {
+			ch <- prometheus.MustNewConstMetric(c.resourceLintWarningsMetric, prometheus.CounterValue, float64(count),
+				resourceID, check)
+		}
+	}
+
+	for resourceID, reloadsByHook := range c.resourceLastReload {
+		for hook, at := range reloadsByHook {
+			ch <- prometheus.MustNewConstMetric(c.resourceLastReloadMetric, prometheus.GaugeValue, float64(at.Unix()),
+				resourceID, hook)
+		}
+	}
+
+	for resourceID, countsByState := range c.resourceDeleted {
+		for state, count := range countsByState {
+			ch <- prometheus.MustNewConstMetric(c.resourceDeletedMetric, prometheus.CounterValue, float64(count),
+				resourceID, state)
+		}
+	}
+
+	for resourceID, countsByCode := range c.resourceErrorCodes {
+		for code, count := range countsByCode {
+			ch <- prometheus.MustNewConstMetric(c.resourceErrorCodesMetric, prometheus.CounterValue, float64(count),
+				resourceID, code)
+		}
+	}
+
+	for resourceID, count := range c.resourceUnchanged {
+		ch <- prometheus.MustNewConstMetric(c.resourceUnchangedMetric, prometheus.CounterValue, float64(count),
+			resourceID)
+	}
+
+	for resourceID, backoff := range c.resourceBackoff {
+		ch <- prometheus.MustNewConstMetric(c.resourceBackoffMetric, prometheus.GaugeValue, backoff.Seconds(),
+			resourceID)
+	}
+
+	for resourceID, at := range c.resourceNextRenewal {
+		ch <- prometheus.MustNewConstMetric(c.resourceNextRenewalMetric, prometheus.GaugeValue, remaining(at),
+			resourceID)
+	}
+
+	for resourceID, stale := range c.resourceStale {
+		value := 0.0
+		if stale {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.resourceStaleMetric, prometheus.GaugeValue, value, resourceID)
+	}
+
+	for resourceID, count := range c.resourceLeaseReduced {
+		ch <- prometheus.MustNewConstMetric(c.resourceLeaseReducedMetric, prometheus.CounterValue, float64(count),
+			resourceID)
+	}
+
+	for resourceID, expiring := range c.resourceExpiring {
+		value := 0.0
+		if expiring {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.resourceExpiringMetric, prometheus.GaugeValue, value, resourceID)
+	}
+
+	for resourceID, certificatesBySlot := range c.certificateSerials {
+		for slot, certificate := range certificatesBySlot {
+			ch <- prometheus.MustNewConstMetric(c.certificateSerialMetric, prometheus.GaugeValue, float64(certificate.expiry.Unix()),
+				resourceID, slot, certificate.serial)
+		}
+	}
+
 	ch <- prometheus.MustNewConstMetric(c.tokenTotalMetric, prometheus.CounterValue, float64(c.tokenTotals))
 	ch <- prometheus.MustNewConstMetric(c.tokenSuccessMetric, prometheus.CounterValue, float64(c.tokenSuccesses))
 	ch <- prometheus.MustNewConstMetric(c.tokenErrorsMetric, prometheus.CounterValue, float64(c.tokenErrors))
+	// the remaining ttl of the token counts down between the lookups
+	if c.tokenExpiryKnown {
+		ch <- prometheus.MustNewConstMetric(c.tokenTTLMetric, prometheus.GaugeValue, remaining(c.tokenExpiry))
+	}
 
 	for reason, errCount := range c.errors {
 		ch <- prometheus.MustNewConstMetric(c.errorsMetric, prometheus.CounterValue, float64(errCount),
 			reason)
 	}
+
+	ch <- prometheus.MustNewConstMetric(c.configGenerationMetric, prometheus.GaugeValue, float64(c.configGeneration))
+
+	for state, value := range c.breakerStates {
+		ch <- prometheus.MustNewConstMetric(c.breakerStateMetric, prometheus.GaugeValue, value, state)
+	}
+
+	if c.leaderKnown {
+		leader := 0.0
+		if c.leader {
+			leader = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.leaderMetric, prometheus.GaugeValue, leader)
+	}
+
+	c.collectInventory(ch)
+
+	c.vaultRequestDuration.Collect(ch)
+	c.vaultRequests.Collect(ch)
+}
+
+// collectInventory collects the build info and the resources configured; the lock must be held
+func (c *collector) collectInventory(ch chan<- prometheus.Metric) {
+	if c.buildInfo != nil {
+		ch <- prometheus.MustNewConstMetric(c.buildInfoMetric, prometheus.GaugeValue, 1, c.buildInfo...)
+	}
+
+	// the same resource can be written out more than once, each set of labels is only collected once
+	configured := make(map[ResourceSpec]bool)
+	for _, x := range c.resourcesConfigured {
+		if configured[x] {
+			continue
+		}
+		configured[x] = true
+		ch <- prometheus.MustNewConstMetric(c.resourceConfiguredMetric, prometheus.GaugeValue, 1,
+			x.ID, x.Type, x.Path, x.Format)
+	}
+}
+
+// remaining returns the seconds until the time, zero once it has passed
+func remaining(at time.Time) float64 {
+	if left := time.Until(at); left > 0 {
+		return left.Seconds()
+	}
+
+	return 0
 }
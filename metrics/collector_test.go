@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectResourcesConfigured(t *testing.T) {
+	c := &collector{
+		buildInfoMetric:          prometheus.NewDesc("build_info", "build_info", []string{"version", "gitsha"}, nil),
+		resourceConfiguredMetric: prometheus.NewDesc("configured", "configured", []string{"resource_id", "type", "path", "format"}, nil),
+	}
+	c.BuildInfo("v0.3.10", "abc123")
+	db := ResourceSpec{ID: "secret/db", Type: "secret", Path: "secret/db", Format: "yaml"}
+	c.ResourcesConfigured([]ResourceSpec{db, db, {ID: "web", Type: "pki", Path: "pki/issue/web", Format: "cert"}})
+
+	ch := make(chan prometheus.Metric, 10)
+	c.collectInventory(ch)
+	close(ch)
+	assert.Equal(t, 3, len(ch))
+}
+
+func TestRemaining(t *testing.T) {
+	assert.InDelta(t, 60, remaining(time.Now().Add(time.Minute)), 1)
+	assert.Equal(t, 0.0, remaining(time.Now().Add(-time.Minute)))
+}
+
+func TestVaultRequests(t *testing.T) {
+	c := newCollector(nil)
+	c.VaultRequest("read", "200", time.Millisecond)
+	c.VaultRequest("read", "503", time.Millisecond)
+	c.VaultRequest("read", "503", time.Millisecond)
+	c.VaultRequest("renew", "307", time.Millisecond)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if !assert.NoError(t, err) {
+		return
+	}
+	counts := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "vault_sidekick_vault_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, x := range m.GetLabel() {
+				labels[x.GetName()] = x.GetValue()
+			}
+			counts[labels["op"]+"/"+labels["code"]] = m.GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, map[string]float64{"read/200": 1, "read/503": 2, "renew/307": 1}, counts)
+}
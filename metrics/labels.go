@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// labelName matches a valid prometheus label name
+	labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// reservedLabels are the labels of the metrics themselves, and those added by prometheus and the pushgateway,
+	// which a static label can't take the place of
+	reservedLabels = map[string]bool{
+		"check": true, "code": true, "format": true, "gitsha": true, "hook": true, "instance": true, "job": true,
+		"le": true, "op": true, "operation": true, "path": true, "reason": true, "resource_id": true, "serial": true,
+		"slot": true, "stage": true, "state": true, "status": true, "type": true, "version": true,
+	}
+)
+
+// ParseLabels parses the static labels added to every metric, each given as key=value
+func ParseLabels(list []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, x := range list {
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 || items[1] == "" {
+			return nil, fmt.Errorf("invalid metrics label: %s, it must be key=value", x)
+		}
+		name := strings.TrimSpace(items[0])
+		switch {
+		case !labelName.MatchString(name), strings.HasPrefix(name, "__"):
+			return nil, fmt.Errorf("invalid metrics label: %s, the name isn't a valid label name", x)
+		case reservedLabels[name]:
+			return nil, fmt.Errorf("invalid metrics label: %s, the name is used by the metrics themselves", x)
+		}
+		if _, found := labels[name]; found {
+			return nil, fmt.Errorf("the metrics label: %s is given more than once", name)
+		}
+		labels[name] = items[1]
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"team=payments", "env=prod", "cluster=eu-west-1=a"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"team": "payments", "env": "prod", "cluster": "eu-west-1=a"}, labels)
+	}
+
+	for _, x := range [][]string{
+		{"team"},
+		{"team="},
+		{"1team=payments"},
+		{"__name__=payments"},
+		{"resource_id=db"},
+		{"job=sidekick"},
+		{"team=payments", "team=platform"},
+	} {
+		_, err := ParseLabels(x)
+		assert.Error(t, err, "expected an error for: %v", x)
+	}
+}
@@ -1,208 +1,618 @@
 package metrics
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 var (
-	col            *collector
+	// sinks are where the metrics are sent, the prometheus collector once initialised and any added
+	sinks          []Sink
 	collectorMutex sync.RWMutex
+	// mux serves the metrics and the other endpoints added, rather than the default mux which packages
+	// register handlers on as they're imported
+	mux = http.NewServeMux()
+	// servers are the servers of the metrics, shut down together
+	servers     []*http.Server
+	serverMutex sync.Mutex
 )
 
-func Init(role string, metricsPort uint) {
-	collectorMutex.Lock()
-	defer collectorMutex.Unlock()
-
-	col = &collector{
+// newCollector creates the prometheus collector, every metric carrying the static labels
+func newCollector(labels prometheus.Labels) *collector {
+	return &collector{
 		resourceExpiryMetric: prometheus.NewDesc("vault_sidekick_resource_expiry_gauge",
 			"vault_sidekick_resource_expiry_gauge",
 			[]string{"resource_id"},
-			nil,
+			labels,
 		),
 
 		resourceTotalMetric: prometheus.NewDesc("vault_sidekick_resource_total_counter",
 			"vault_sidekick_resource_total_counter",
 			[]string{"resource_id"},
-			nil,
+			labels,
 		),
 		resourceSuccessMetric: prometheus.NewDesc("vault_sidekick_resource_success_counter",
 			"vault_sidekick_resource_success_counter",
 			[]string{"resource_id"},
-			nil,
+			labels,
 		),
 		resourceErrorsMetric: prometheus.NewDesc("vault_sidekick_resource_error_counter",
 			"vault_sidekick_resource_error_counter",
 			[]string{"resource_id"},
-			nil,
+			labels,
+		),
+		resourceLastSuccessMetric: prometheus.NewDesc("vault_sidekick_resource_last_success_timestamp_seconds",
+			"vault_sidekick_resource_last_success_timestamp_seconds",
+			[]string{"resource_id"},
+			labels,
+		),
+		resourceLastFailureMetric: prometheus.NewDesc("vault_sidekick_resource_last_failure_timestamp_seconds",
+			"vault_sidekick_resource_last_failure_timestamp_seconds",
+			[]string{"resource_id"},
+			labels,
+		),
+		resourceDurationMetric: prometheus.NewDesc("vault_sidekick_resource_duration_seconds",
+			"vault_sidekick_resource_duration_seconds",
+			[]string{"resource_id"},
+			labels,
 		),
 
 		resourceProcessTotalMetric: prometheus.NewDesc("vault_sidekick_resource_process_total_counter",
 			"vault_sidekick_resource_process_total_counter",
 			[]string{"resource_id", "stage"},
-			nil,
+			labels,
 		),
 		resourceProcessSuccessMetric: prometheus.NewDesc("vault_sidekick_resource_process_success_counter",
 			"vault_sidekick_resource_process_",
 			[]string{"resource_id", "stage"},
-			nil,
+			labels,
 		),
 		resourceProcessErrorsMetric: prometheus.NewDesc("vault_sidekick_resource_process_error_counter",
 			"vault_sidekick_resource_process_",
 			[]string{"resource_id", "stage"},
-			nil,
+			labels,
+		),
+
+		resourceLintWarningsMetric: prometheus.NewDesc("vault_sidekick_resource_lint_warning_counter",
+			"vault_sidekick_resource_lint_warning_counter",
+			[]string{"resource_id", "check"},
+			labels,
+		),
+
+		resourceLastReloadMetric: prometheus.NewDesc("vault_sidekick_last_reload_timestamp",
+			"vault_sidekick_last_reload_timestamp",
+			[]string{"resource_id", "hook"},
+			labels,
+		),
+
+		resourceDeletedMetric: prometheus.NewDesc("vault_sidekick_resource_deleted_counter",
+			"vault_sidekick_resource_deleted_counter",
+			[]string{"resource_id", "state"},
+			labels,
+		),
+
+		resourceErrorCodesMetric: prometheus.NewDesc("vault_sidekick_resource_error_code_counter",
+			"vault_sidekick_resource_error_code_counter",
+			[]string{"resource_id", "code"},
+			labels,
+		),
+
+		resourceUnchangedMetric: prometheus.NewDesc("vault_sidekick_resource_unchanged_total",
+			"vault_sidekick_resource_unchanged_total",
+			[]string{"resource_id"},
+			labels,
+		),
+
+		resourceBackoffMetric: prometheus.NewDesc("vault_sidekick_resource_backoff_seconds",
+			"vault_sidekick_resource_backoff_seconds",
+			[]string{"resource_id"},
+			labels,
+		),
+
+		resourceNextRenewalMetric: prometheus.NewDesc("vault_sidekick_resource_next_renewal_seconds",
+			"vault_sidekick_resource_next_renewal_seconds",
+			[]string{"resource_id"},
+			labels,
+		),
+
+		resourceStaleMetric: prometheus.NewDesc("vault_sidekick_resource_stale",
+			"vault_sidekick_resource_stale",
+			[]string{"resource_id"},
+			labels,
+		),
+
+		resourceLeaseReducedMetric: prometheus.NewDesc("vault_sidekick_resource_lease_reduced_total",
+			"vault_sidekick_resource_lease_reduced_total",
+			[]string{"resource_id"},
+			labels,
+		),
+
+		resourceExpiringMetric: prometheus.NewDesc("vault_sidekick_resource_expiring",
+			"vault_sidekick_resource_expiring",
+			[]string{"resource_id"},
+			labels,
+		),
+
+		certificateSerialMetric: prometheus.NewDesc("vault_sidekick_certificate_serial",
+			"vault_sidekick_certificate_serial",
+			[]string{"resource_id", "slot", "serial"},
+			labels,
 		),
 
 		tokenTotalMetric: prometheus.NewDesc("vault_sidekick_token_total_counter",
 			"vault_sidekick_token_total_counter",
 			nil,
-			nil,
+			labels,
 		),
 		tokenSuccessMetric: prometheus.NewDesc("vault_sidekick_token_success_counter",
 			"vault_sidekick_token_success_counter",
 			nil,
-			nil,
+			labels,
 		),
 		tokenErrorsMetric: prometheus.NewDesc("vault_sidekick_token_error_counter",
 			"vault_sidekick_token_error_counter",
 			nil,
+			labels,
+		),
+		tokenTTLMetric: prometheus.NewDesc("vault_sidekick_token_ttl_seconds",
+			"vault_sidekick_token_ttl_seconds",
 			nil,
+			labels,
 		),
 
 		errorsMetric: prometheus.NewDesc("vault_sidekick_error_counter",
 			"vault_sidekick_error_counter",
 			[]string{"reason"},
+			labels,
+		),
+
+		configGenerationMetric: prometheus.NewDesc("vault_sidekick_config_generation",
+			"vault_sidekick_config_generation",
+			nil,
+			labels,
+		),
+
+		breakerStateMetric: prometheus.NewDesc("vault_sidekick_circuit_breaker_state",
+			"vault_sidekick_circuit_breaker_state",
+			[]string{"state"},
+			labels,
+		),
+
+		leaderMetric: prometheus.NewDesc("vault_sidekick_leader",
+			"vault_sidekick_leader",
 			nil,
+			labels,
 		),
 
+		buildInfoMetric: prometheus.NewDesc("vault_sidekick_build_info",
+			"vault_sidekick_build_info",
+			[]string{"version", "gitsha"},
+			labels,
+		),
+		resourceConfiguredMetric: prometheus.NewDesc("vault_sidekick_resource_configured",
+			"vault_sidekick_resource_configured",
+			[]string{"resource_id", "type", "path", "format"},
+			labels,
+		),
+
+		vaultRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "vault_sidekick_vault_request_duration_seconds",
+			Help:        "vault_sidekick_vault_request_duration_seconds",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: labels,
+		}, []string{"operation", "status"}),
+		vaultRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "vault_sidekick_vault_requests_total",
+			Help:        "vault_sidekick_vault_requests_total",
+			ConstLabels: labels,
+		}, []string{"code", "op"}),
+
 		resourceExpiry: make(map[string]time.Time),
 
 		resourceTotals:    make(map[string]int64),
 		resourceSuccesses: make(map[string]int64),
 		resourceErrors:    make(map[string]int64),
 
+		resourceLastSuccess: make(map[string]time.Time),
+		resourceLastFailure: make(map[string]time.Time),
+
+		resourceDuration: make(map[string]time.Duration),
+
 		resourceProcessTotals:    make(map[string]map[string]int64),
 		resourceProcessSuccesses: make(map[string]map[string]int64),
 		resourceProcessErrors:    make(map[string]map[string]int64),
 
+		resourceLintWarnings: make(map[string]map[string]int64),
+
+		resourceLastReload: make(map[string]map[string]time.Time),
+
+		resourceDeleted: make(map[string]map[string]int64),
+
+		resourceErrorCodes: make(map[string]map[string]int64),
+
+		resourceUnchanged: make(map[string]int64),
+
+		resourceBackoff: make(map[string]time.Duration),
+
+		resourceNextRenewal: make(map[string]time.Time),
+
+		resourceStale: make(map[string]bool),
+
+		resourceLeaseReduced: make(map[string]int64),
+
+		resourceExpiring: make(map[string]bool),
+
+		certificateSerials: make(map[string]map[string]certificateSerial),
+
 		errors: make(map[string]int),
+
+		configGeneration: 1,
+
+		breakerStates: make(map[string]float64),
 	}
+}
 
+// Register creates the collector without serving the metrics, for them to be pushed or written out instead
+//	labels		: the static labels added to every metric
+func Register(labels map[string]string) {
+	collectorMutex.Lock()
+	defer collectorMutex.Unlock()
+
+	col := newCollector(labels)
 	prometheus.MustRegister(col)
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		glog.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", metricsPort), nil))
-	}()
+	sinks = append(sinks, col)
+}
+
+// Init creates the collector and serves the metrics on the address, i.e. :9092 or 127.0.0.1:9099, plus any
+// additional listeners given as either host:port or unix:/path/to/socket
+func Init(labels map[string]string, address string, listeners []string) {
+	Register(labels)
+
+	mux.Handle("/metrics", promhttp.Handler())
+	handler := protect(mux)
+	listener, err := listen(address)
+	if err != nil {
+		glog.Fatalf("unable to listen on the metrics address: %s, error: %s", address, err)
+	}
+	go serve(listener, handler)
+	for _, address := range listeners {
+		listener, err := listen(address)
+		if err != nil {
+			glog.Fatalf("unable to listen on the metrics address: %s, error: %s", address, err)
+		}
+		glog.Infof("serving metrics on the additional listener: %s", address)
+		go serve(listener, handler)
+	}
+}
+
+// serve serves the handler on the listener, over tls if enabled and the listener isn't a unix socket
+func serve(listener net.Listener, handler http.Handler) {
+	if tlsConfig != nil && listener.Addr().Network() == "tcp" {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	server := &http.Server{Handler: handler}
+	serverMutex.Lock()
+	servers = append(servers, server)
+	serverMutex.Unlock()
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		glog.Fatal(err)
+	}
+}
+
+// Shutdown stops the servers of the metrics, letting the requests in flight finish within the timeout
+func Shutdown(timeout time.Duration) {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, x := range servers {
+		if err := x.Shutdown(ctx); err != nil {
+			glog.Warningf("failed to shut the metrics server down gracefully, error: %s", err)
+		}
+	}
+	servers = nil
+}
+
+// Handle serves the handler alongside the metrics
+func Handle(pattern string, handler http.Handler) {
+	mux.Handle(pattern, handler)
+}
+
+// listen creates a listener on either a tcp address or a unix socket, removing any stale socket file
+func listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", address)
 }
 
 func ResourceExpiry(resourceID string, expiry time.Time) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceExpiry(resourceID, expiry)
 	}
-	col.ResourceExpiry(resourceID, expiry)
 }
 
 func ResourceTotal(resourceID string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceTotal(resourceID)
 	}
-	col.ResourceTotal(resourceID)
 }
 
 func ResourceSuccess(resourceID string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceSuccess(resourceID)
 	}
-	col.ResourceSuccess(resourceID)
 }
 
 func ResourceError(resourceID string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceError(resourceID)
 	}
-	col.ResourceError(resourceID)
 }
 
 func ResourceProcessTotal(resourceID, stage string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceProcessTotal(resourceID, stage)
 	}
-	col.ResourceProcessTotal(resourceID, stage)
 }
 
 func ResourceProcessSuccess(resourceID, stage string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceProcessSuccess(resourceID, stage)
 	}
-	col.ResourceProcessSuccess(resourceID, stage)
 }
 
 func ResourceProcessError(resourceID, stage string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.ResourceProcessError(resourceID, stage)
+	}
+}
+
+func ResourceLintWarning(resourceID, check string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceLintWarning(resourceID, check)
+	}
+}
+
+func ResourceLastReload(resourceID, hook string, at time.Time) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceLastReload(resourceID, hook, at)
+	}
+}
+
+func ResourceDeleted(resourceID, state string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceDeleted(resourceID, state)
+	}
+}
+
+func ResourceErrorCode(resourceID, code string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceErrorCode(resourceID, code)
+	}
+}
+
+func ResourceUnchanged(resourceID string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceUnchanged(resourceID)
+	}
+}
+
+func ResourceBackoff(resourceID string, backoff time.Duration) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceBackoff(resourceID, backoff)
+	}
+}
+
+func ResourceStale(resourceID string, stale bool) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceStale(resourceID, stale)
+	}
+}
+
+func ResourceLeaseReduced(resourceID string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceLeaseReduced(resourceID)
+	}
+}
+
+func ResourceExpiring(resourceID string, expiring bool) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceExpiring(resourceID, expiring)
+	}
+}
+
+func CertificateSerial(resourceID, slot, serial string, expiry time.Time) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.CertificateSerial(resourceID, slot, serial, expiry)
+	}
+}
+
+func RemoveCertificateSerial(resourceID, slot string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.RemoveCertificateSerial(resourceID, slot)
 	}
-	col.ResourceProcessError(resourceID, stage)
 }
 
 func TokenTotal() {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.TokenTotal()
 	}
-	col.TokenTotal()
 }
 
 func TokenSuccess() {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.TokenSuccess()
 	}
-	col.TokenSuccess()
 }
 
 func TokenError() {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.TokenError()
+	}
+}
+
+func TokenTTL(ttl time.Duration) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.TokenTTL(ttl)
 	}
-	col.TokenError()
 }
 
 func Error(reason string) {
 	collectorMutex.RLock()
 	defer collectorMutex.RUnlock()
 
-	if col == nil {
-		return
+	for _, x := range sinks {
+		x.Error(reason)
+	}
+}
+
+func ConfigGeneration(generation int64) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ConfigGeneration(generation)
+	}
+}
+
+func BreakerState(state string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.BreakerState(state)
+	}
+}
+
+func Leader(leader bool) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.Leader(leader)
+	}
+}
+
+func VaultRequest(operation, status string, duration time.Duration) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.VaultRequest(operation, status, duration)
+	}
+}
+
+func BuildInfo(version, gitsha string) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.BuildInfo(version, gitsha)
+	}
+}
+
+func ResourcesConfigured(resources []ResourceSpec) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourcesConfigured(resources)
+	}
+}
+
+func ResourceNextRenewal(resourceID string, at time.Time) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceNextRenewal(resourceID, at)
+	}
+}
+
+func ResourceDuration(resourceID string, duration time.Duration) {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+
+	for _, x := range sinks {
+		x.ResourceDuration(resourceID, duration)
 	}
-	col.Error(reason)
 }
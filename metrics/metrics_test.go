@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeShutdown(t *testing.T) {
+	listener, err := listen("127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		serve(listener, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		close(done)
+	}()
+
+	url := "http://" + listener.Addr().String() + "/metrics"
+	resp, err := http.Get(url)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	Shutdown(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the metrics server was not shut down")
+	}
+	_, err = http.Get(url)
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// gatherText encodes the metrics of the sidekick in the prometheus text format, leaving out those of the go
+// runtime and the process, which mean little once it has exited
+func gatherText() ([]byte, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "vault_sidekick_") {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Push sends the metrics to a prometheus pushgateway, replacing any pushed before under the same job and instance
+//	gateway		: the url of the pushgateway
+//	job			: the job the metrics are grouped under
+//	instance	: the instance the metrics are grouped under
+func Push(gateway, job, instance string) error {
+	content, err := gatherText()
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/metrics/job/%s/instance/%s", strings.TrimSuffix(gateway, "/"),
+		url.PathEscape(job), url.PathEscape(instance))
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtText))
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response from the pushgateway: %s, %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// WriteTextfile writes the metrics to a file for the textfile collector of the node exporter, replacing it
+// in one rename so it's never read half written
+//	filename	: the file, ending in .prom
+func WriteTextfile(filename string) error {
+	content, err := gatherText()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushAndTextfile(t *testing.T) {
+	col := newCollector(prometheus.Labels{"team": "payments"})
+	if !assert.NoError(t, prometheus.Register(col)) {
+		return
+	}
+	defer prometheus.Unregister(col)
+	col.ResourceSuccess("secret/db")
+	col.ResourceDuration("secret/db", 250*time.Millisecond)
+
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		content, _ := ioutil.ReadAll(req.Body)
+		method, path, body = req.Method, req.URL.Path, string(content)
+	}))
+	defer server.Close()
+
+	if !assert.NoError(t, Push(server.URL+"/", "vault-sidekick", "app-0")) {
+		return
+	}
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/vault-sidekick/instance/app-0", path)
+	assert.Contains(t, body, `vault_sidekick_resource_success_counter{resource_id="secret/db",team="payments"} 1`)
+	assert.Contains(t, body, `vault_sidekick_resource_duration_seconds{resource_id="secret/db",team="payments"} 0.25`)
+	assert.NotContains(t, body, "go_goroutines")
+
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "vault-sidekick.prom")
+	if !assert.NoError(t, WriteTextfile(filename)) {
+		return
+	}
+	content, err := ioutil.ReadFile(filename)
+	if assert.NoError(t, err) {
+		assert.Equal(t, body, string(content))
+	}
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
+
+func TestPushRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := Push(server.URL, "vault-sidekick", "app-0")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "pushed metrics are invalid")
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	// tlsConfig serves the metrics over tls when set
+	tlsConfig *tls.Config
+	// authenticate checks the credentials of a request when set
+	authenticate func(req *http.Request) bool
+	// unauthenticated are the paths served without credentials, the probes of the kubelet sending none
+	unauthenticated = map[string]bool{"/healthz": true, "/readyz": true}
+)
+
+// certificateFiles is a certificate and key loaded from files, reloaded whenever either changes so a
+// certificate the sidekick rotates itself is picked up
+type certificateFiles struct {
+	sync.Mutex
+	// the files of the certificate and key
+	certFile, keyFile string
+	// when the files were last modified, as of the certificate loaded
+	modified time.Time
+	// the certificate loaded
+	certificate *tls.Certificate
+}
+
+// lastModified returns when the later of the files was modified
+func (c *certificateFiles) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, filename := range []string{c.certFile, c.keyFile} {
+		stat, err := os.Stat(filename)
+		if err != nil {
+			return latest, err
+		}
+		if stat.ModTime().After(latest) {
+			latest = stat.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// get returns the certificate, reloading it if the files have changed; the last one loaded is kept should
+// they be mid rotation
+func (c *certificateFiles) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	modified, err := c.lastModified()
+	if err == nil && !modified.Equal(c.modified) {
+		certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err == nil {
+			c.certificate, c.modified = &certificate, modified
+		} else if c.certificate == nil {
+			return nil, err
+		} else {
+			glog.Warningf("unable to reload the metrics certificate: %s, keeping the last, error: %s", c.certFile, err)
+		}
+	}
+	if c.certificate == nil {
+		return nil, fmt.Errorf("the metrics certificate: %s is not available yet", c.certFile)
+	}
+
+	return c.certificate, nil
+}
+
+// ServeTLS serves the metrics over tls, on the metrics port and any tcp listeners; the files needn't exist
+// yet, the handshakes failing until they do, so the certificate can be one of the resources
+//	certFile	: the pem encoded certificate, with any chain
+//	keyFile		: the pem encoded private key
+func ServeTLS(certFile, keyFile string) {
+	files := &certificateFiles{certFile: certFile, keyFile: keyFile}
+	tlsConfig = &tls.Config{
+		GetCertificate: files.get,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// RequireAuth requires the requests for the metrics, and anything but the health checks served with
+// them, to carry either of the credentials
+//	username	: the username of basic auth, disabled if empty
+//	password	: the password of basic auth
+//	token		: a bearer token, disabled if empty
+func RequireAuth(username, password, token string) {
+	authenticate = func(req *http.Request) bool {
+		if user, pass, found := req.BasicAuth(); found && username != "" {
+			return equal(user, username) && equal(pass, password)
+		}
+		if header := req.Header.Get("Authorization"); token != "" && len(header) > 7 && header[:7] == "Bearer " {
+			return equal(header[7:], token)
+		}
+
+		return false
+	}
+}
+
+// equal compares the strings in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// protect wraps the handler so the requests must be authenticated, when required
+func protect(handler http.Handler) http.Handler {
+	if authenticate == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !unauthenticated[req.URL.Path] && !authenticate(req) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vault-sidekick"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
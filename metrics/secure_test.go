@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCertificate writes a self signed certificate and its key to the files
+func writeTestCertificate(t *testing.T, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "metrics"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	encoded, err := x509.MarshalECPrivateKey(key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encoded}), 0600))
+}
+
+func TestCertificateFilesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "tls.pem"), filepath.Join(dir, "tls-key.pem")
+	files := &certificateFiles{certFile: certFile, keyFile: keyFile}
+
+	// step: the certificate isn't there until the resource is written
+	_, err = files.get(nil)
+	assert.Error(t, err)
+
+	writeTestCertificate(t, certFile, keyFile, 1)
+	first, err := files.get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// step: a rotated certificate is picked up, a broken one leaving the last in place
+	writeTestCertificate(t, certFile, keyFile, 2)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	second, err := files.get(nil)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+	}
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	third, err := files.get(nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, second, third)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	defer func() { authenticate = nil }()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	RequireAuth("prometheus", "s3cret", "token-abc")
+	handler := protect(ok)
+
+	for _, x := range []struct {
+		path   string
+		setup  func(*http.Request)
+		status int
+	}{
+		{path: "/metrics", status: http.StatusUnauthorized},
+		{path: "/metrics", setup: func(r *http.Request) { r.SetBasicAuth("prometheus", "s3cret") }, status: http.StatusOK},
+		{path: "/metrics", setup: func(r *http.Request) { r.SetBasicAuth("prometheus", "wrong") }, status: http.StatusUnauthorized},
+		{path: "/metrics", setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token-abc") }, status: http.StatusOK},
+		{path: "/metrics", setup: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token-xyz") }, status: http.StatusUnauthorized},
+		{path: "/debug/pprof/", status: http.StatusUnauthorized},
+		{path: "/healthz", status: http.StatusOK},
+		{path: "/readyz", status: http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, x.path, nil)
+		if x.setup != nil {
+			x.setup(req)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, x.status, recorder.Code, "path: %s", x.path)
+	}
+}
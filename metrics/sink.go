@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Sink receives the metrics of the sidekick; the prometheus collector is one, and alternative exporters can be
+// plugged in with AddSink, embedding NopSink to only implement the metrics they export
+type Sink interface {
+	ResourceExpiry(resourceID string, expiry time.Time)
+	ResourceTotal(resourceID string)
+	ResourceSuccess(resourceID string)
+	ResourceError(resourceID string)
+	ResourceDuration(resourceID string, duration time.Duration)
+	ResourceProcessTotal(resourceID, stage string)
+	ResourceProcessSuccess(resourceID, stage string)
+	ResourceProcessError(resourceID, stage string)
+	ResourceLintWarning(resourceID, check string)
+	ResourceLastReload(resourceID, hook string, at time.Time)
+	ResourceDeleted(resourceID, state string)
+	ResourceErrorCode(resourceID, code string)
+	ResourceUnchanged(resourceID string)
+	ResourceBackoff(resourceID string, backoff time.Duration)
+	ResourceNextRenewal(resourceID string, at time.Time)
+	ResourceStale(resourceID string, stale bool)
+	ResourceLeaseReduced(resourceID string)
+	ResourceExpiring(resourceID string, expiring bool)
+	CertificateSerial(resourceID, slot, serial string, expiry time.Time)
+	RemoveCertificateSerial(resourceID, slot string)
+	TokenTotal()
+	TokenSuccess()
+	TokenError()
+	TokenTTL(ttl time.Duration)
+	Error(reason string)
+	ConfigGeneration(generation int64)
+	BreakerState(state string)
+	Leader(leader bool)
+	VaultRequest(operation, status string, duration time.Duration)
+	BuildInfo(version, gitsha string)
+	ResourcesConfigured(resources []ResourceSpec)
+}
+
+// ResourceSpec describes a resource the sidekick is configured with, for inventory
+type ResourceSpec struct {
+	// the id of the resource
+	ID string
+	// the type of the resource, i.e. pki
+	Type string
+	// the path of the resource in vault
+	Path string
+	// the output format of the resource
+	Format string
+}
+
+// the prometheus collector is a sink
+var _ Sink = &collector{}
+
+// AddSink sends the metrics to the sink as well as any others, from then on
+func AddSink(sink Sink) {
+	collectorMutex.Lock()
+	defer collectorMutex.Unlock()
+
+	sinks = append(sinks, sink)
+}
+
+// NopSink discards every metric, for sinks to embed
+type NopSink struct{}
+
+func (NopSink) ResourceExpiry(resourceID string, expiry time.Time)                  {}
+func (NopSink) ResourceTotal(resourceID string)                                     {}
+func (NopSink) ResourceSuccess(resourceID string)                                   {}
+func (NopSink) ResourceError(resourceID string)                                     {}
+func (NopSink) ResourceDuration(resourceID string, duration time.Duration)          {}
+func (NopSink) ResourceProcessTotal(resourceID, stage string)                       {}
+func (NopSink) ResourceProcessSuccess(resourceID, stage string)                     {}
+func (NopSink) ResourceProcessError(resourceID, stage string)                       {}
+func (NopSink) ResourceLintWarning(resourceID, check string)                        {}
+func (NopSink) ResourceLastReload(resourceID, hook string, at time.Time)            {}
+func (NopSink) ResourceDeleted(resourceID, state string)                            {}
+func (NopSink) ResourceErrorCode(resourceID, code string)                           {}
+func (NopSink) ResourceUnchanged(resourceID string)                                 {}
+func (NopSink) ResourceBackoff(resourceID string, backoff time.Duration)            {}
+func (NopSink) ResourceNextRenewal(resourceID string, at time.Time)                 {}
+func (NopSink) ResourceStale(resourceID string, stale bool)                         {}
+func (NopSink) ResourceLeaseReduced(resourceID string)                              {}
+func (NopSink) ResourceExpiring(resourceID string, expiring bool)                   {}
+func (NopSink) CertificateSerial(resourceID, slot, serial string, expiry time.Time) {}
+func (NopSink) RemoveCertificateSerial(resourceID, slot string)                     {}
+func (NopSink) TokenTotal()                                                         {}
+func (NopSink) TokenSuccess()                                                       {}
+func (NopSink) TokenError()                                                         {}
+func (NopSink) TokenTTL(ttl time.Duration)                                          {}
+func (NopSink) Error(reason string)                                                 {}
+func (NopSink) ConfigGeneration(generation int64)                                   {}
+func (NopSink) BreakerState(state string)                                           {}
+func (NopSink) Leader(leader bool)                                                  {}
+func (NopSink) VaultRequest(operation, status string, duration time.Duration)       {}
+func (NopSink) BuildInfo(version, gitsha string)                                    {}
+func (NopSink) ResourcesConfigured(resources []ResourceSpec)                        {}
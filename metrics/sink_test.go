@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSink counts the resources retrieved successfully
+type countingSink struct {
+	NopSink
+	successes map[string]int
+}
+
+func (c *countingSink) ResourceSuccess(resourceID string) {
+	c.successes[resourceID]++
+}
+
+func TestAddSink(t *testing.T) {
+	defer func(previous []Sink) { sinks = previous }(sinks)
+
+	sink := &countingSink{successes: make(map[string]int)}
+	AddSink(sink)
+	ResourceSuccess("secret/db")
+	ResourceSuccess("secret/db")
+	ResourceTotal("secret/db")
+	assert.Equal(t, 2, sink.successes["secret/db"])
+}
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StatsdFormat folds the labels into the metric names, plain statsd having no tags
+	StatsdFormat = "statsd"
+	// DogstatsdFormat sends the labels as dogstatsd tags
+	DogstatsdFormat = "dogstatsd"
+	// statsdPrefix prefixes the name of every metric
+	statsdPrefix = "vault_sidekick."
+)
+
+var (
+	// statsdInvalidTag matches the characters which can't appear in a dogstatsd tag
+	statsdInvalidTag = regexp.MustCompile(`[^A-Za-z0-9_.\-/:]`)
+	// statsdInvalidName matches the characters which can't appear in a part of a statsd metric name
+	statsdInvalidName = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+)
+
+// StatsdSink pushes the metrics to a statsd or dogstatsd agent over udp, for environments which aren't
+// scraped by prometheus
+type StatsdSink struct {
+	NopSink
+	sync.Mutex
+	// the connection to the agent
+	conn net.Conn
+	// whether the labels are sent as dogstatsd tags
+	dogstatsd bool
+	// the static labels, as tags added to every metric
+	tags []string
+}
+
+// the statsd exporter is a sink
+var _ Sink = &StatsdSink{}
+
+// NewStatsdSink creates a sink sending the metrics to the statsd agent
+//	address		: the host:port of the agent
+//	format		: statsd or dogstatsd
+//	labels		: the static labels, sent as tags with every metric by dogstatsd, which plain statsd has no room for
+func NewStatsdSink(address, format string, labels map[string]string) (*StatsdSink, error) {
+	if format != StatsdFormat && format != DogstatsdFormat {
+		return nil, fmt.Errorf("the statsd format must be %s or %s", StatsdFormat, DogstatsdFormat)
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for key, value := range labels {
+		tags = append(tags, statsdTag(key, value))
+	}
+	sort.Strings(tags)
+
+	return &StatsdSink{conn: conn, dogstatsd: format == DogstatsdFormat, tags: tags}, nil
+}
+
+// statsdTag formats a label as a tag, or a part of the metric name
+func statsdTag(key, value string) string {
+	return key + ":" + value
+}
+
+// send writes a metric to the agent, the tags given as key:value pairs; as it's over udp, there's no error to report
+//	name		: the name of the metric, without the prefix
+//	value		: the value and type of the metric, i.e. 1|c
+//	tags		: the labels of the metric
+func (s *StatsdSink) send(name, value string, tags ...string) {
+	line := statsdPrefix + name
+	if s.dogstatsd {
+		line += ":" + value
+		if all := append(append([]string{}, tags...), s.tags...); len(all) > 0 {
+			for i, x := range all {
+				all[i] = statsdInvalidTag.ReplaceAllString(x, "_")
+			}
+			line += "|#" + strings.Join(all, ",")
+		}
+	} else {
+		for _, x := range tags {
+			line += "." + statsdInvalidName.ReplaceAllString(x[strings.Index(x, ":")+1:], "_")
+		}
+		line += ":" + value
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.conn.Write([]byte(line))
+}
+
+// count increments a counter
+func (s *StatsdSink) count(name string, tags ...string) {
+	s.send(name, "1|c", tags...)
+}
+
+// gauge sets a gauge
+func (s *StatsdSink) gauge(name string, value float64, tags ...string) {
+	s.send(name, fmt.Sprintf("%g|g", value), tags...)
+}
+
+// flag sets a gauge to 1 or 0
+func (s *StatsdSink) flag(name string, value bool, tags ...string) {
+	if value {
+		s.gauge(name, 1, tags...)
+		return
+	}
+	s.gauge(name, 0, tags...)
+}
+
+func (s *StatsdSink) ResourceExpiry(resourceID string, expiry time.Time) {
+	s.gauge("resource.expiry", float64(expiry.Unix()), statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceTotal(resourceID string) {
+	s.count("resource.total", statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceSuccess(resourceID string) {
+	s.count("resource.success", statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceError(resourceID string) {
+	s.count("resource.error", statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceDuration(resourceID string, duration time.Duration) {
+	s.send("resource.duration", fmt.Sprintf("%g|ms", float64(duration)/float64(time.Millisecond)), statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceProcessTotal(resourceID, stage string) {
+	s.count("resource.process.total", statsdTag("resource_id", resourceID), statsdTag("stage", stage))
+}
+
+func (s *StatsdSink) ResourceProcessSuccess(resourceID, stage string) {
+	s.count("resource.process.success", statsdTag("resource_id", resourceID), statsdTag("stage", stage))
+}
+
+func (s *StatsdSink) ResourceProcessError(resourceID, stage string) {
+	s.count("resource.process.error", statsdTag("resource_id", resourceID), statsdTag("stage", stage))
+}
+
+func (s *StatsdSink) ResourceLintWarning(resourceID, check string) {
+	s.count("resource.lint_warning", statsdTag("resource_id", resourceID), statsdTag("check", check))
+}
+
+func (s *StatsdSink) ResourceLastReload(resourceID, hook string, at time.Time) {
+	s.gauge("resource.last_reload", float64(at.Unix()), statsdTag("resource_id", resourceID), statsdTag("hook", hook))
+}
+
+func (s *StatsdSink) ResourceDeleted(resourceID, state string) {
+	s.count("resource.deleted", statsdTag("resource_id", resourceID), statsdTag("state", state))
+}
+
+func (s *StatsdSink) ResourceErrorCode(resourceID, code string) {
+	s.count("resource.error_code", statsdTag("resource_id", resourceID), statsdTag("code", code))
+}
+
+func (s *StatsdSink) ResourceUnchanged(resourceID string) {
+	s.count("resource.unchanged", statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceBackoff(resourceID string, backoff time.Duration) {
+	s.gauge("resource.backoff_seconds", backoff.Seconds(), statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceNextRenewal(resourceID string, at time.Time) {
+	s.gauge("resource.next_renewal", float64(at.Unix()), statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceStale(resourceID string, stale bool) {
+	s.flag("resource.stale", stale, statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceLeaseReduced(resourceID string) {
+	s.count("resource.lease_reduced", statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) ResourceExpiring(resourceID string, expiring bool) {
+	s.flag("resource.expiring", expiring, statsdTag("resource_id", resourceID))
+}
+
+func (s *StatsdSink) TokenTotal() {
+	s.count("token.total")
+}
+
+func (s *StatsdSink) TokenSuccess() {
+	s.count("token.success")
+}
+
+func (s *StatsdSink) TokenError() {
+	s.count("token.error")
+}
+
+func (s *StatsdSink) TokenTTL(ttl time.Duration) {
+	s.gauge("token.ttl_seconds", ttl.Seconds())
+}
+
+func (s *StatsdSink) Error(reason string) {
+	s.count("error", statsdTag("reason", reason))
+}
+
+func (s *StatsdSink) ConfigGeneration(generation int64) {
+	s.gauge("config.generation", float64(generation))
+}
+
+func (s *StatsdSink) BreakerState(state string) {
+	s.count("breaker.transition", statsdTag("state", state))
+}
+
+func (s *StatsdSink) Leader(leader bool) {
+	s.flag("leader", leader)
+}
+
+func (s *StatsdSink) VaultRequest(operation, status string, duration time.Duration) {
+	s.send("vault.request", fmt.Sprintf("%g|ms", float64(duration)/float64(time.Millisecond)),
+		statsdTag("operation", operation), statsdTag("status", status))
+}
+
+func (s *StatsdSink) ResourcesConfigured(resources []ResourceSpec) {
+	s.gauge("resources.configured", float64(len(resources)))
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readPackets reads the count of packets sent to the agent
+func readPackets(t *testing.T, conn net.PacketConn, count int) []string {
+	var packets []string
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(packets) < count {
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			break
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+
+	return packets
+}
+
+func TestStatsdSink(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer agent.Close()
+
+	sink, err := NewStatsdSink(agent.LocalAddr().String(), StatsdFormat, map[string]string{"team": "payments"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sink.ResourceSuccess("secret/db")
+	sink.ResourceProcessError("pki/issue/web.example.com", "exec")
+	sink.ResourceStale("secret/db", true)
+	sink.VaultRequest("read", "200", 1500*time.Microsecond)
+
+	assert.Equal(t, []string{
+		"vault_sidekick.resource.success.secret_db:1|c",
+		"vault_sidekick.resource.process.error.pki_issue_web_example_com.exec:1|c",
+		"vault_sidekick.resource.stale.secret_db:1|g",
+		"vault_sidekick.vault.request.read.200:1.5|ms",
+	}, readPackets(t, agent, 4))
+}
+
+func TestDogstatsdSink(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer agent.Close()
+
+	sink, err := NewStatsdSink(agent.LocalAddr().String(), DogstatsdFormat, map[string]string{"team": "payments", "env": "prod"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	sink.ResourceProcessError("pki/issue/web.example.com", "exec")
+	sink.TokenTTL(time.Hour)
+
+	assert.Equal(t, []string{
+		"vault_sidekick.resource.process.error:1|c|#resource_id:pki/issue/web.example.com,stage:exec,env:prod,team:payments",
+		"vault_sidekick.token.ttl_seconds:3600|g|#env:prod,team:payments",
+	}, readPackets(t, agent, 2))
+}
+
+func TestStatsdSinkFormat(t *testing.T) {
+	_, err := NewStatsdSink("127.0.0.1:8125", "graphite", nil)
+	assert.Error(t, err)
+}
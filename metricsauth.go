@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+// readCredential reads a credential from the file, trimmed of the trailing newline
+func readCredential(filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	credential := strings.TrimSpace(string(content))
+	if credential == "" {
+		return "", fmt.Errorf("the file: %s is empty", filename)
+	}
+
+	return credential, nil
+}
+
+// secureMetrics serves the metrics over tls and requires the credentials asked for by the options
+//	cfg			: the configuration of the sidekick
+func secureMetrics(cfg *config) error {
+	if cfg.metricsTLSCert != "" {
+		metrics.ServeTLS(cfg.metricsTLSCert, cfg.metricsTLSKey)
+	}
+	if cfg.metricsBasicAuthFile == "" && cfg.metricsBearerTokenFile == "" {
+		return nil
+	}
+
+	var username, password, token string
+	if cfg.metricsBasicAuthFile != "" {
+		credential, err := readCredential(cfg.metricsBasicAuthFile)
+		if err != nil {
+			return err
+		}
+		items := strings.SplitN(credential, ":", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return fmt.Errorf("the metrics-basic-auth-file: %s must hold username:password", cfg.metricsBasicAuthFile)
+		}
+		username, password = items[0], items[1]
+	}
+	if cfg.metricsBearerTokenFile != "" {
+		credential, err := readCredential(cfg.metricsBearerTokenFile)
+		if err != nil {
+			return err
+		}
+		token = credential
+	}
+	metrics.RequireAuth(username, password, token)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileParts maps the suffixes the pki formats append to the filename of a resource to the part of the
+// certificate held in the file; a -previous suffix written by the grace option is ignored
+var fileParts = map[string]string{
+	".crt":            "cert",
+	".pem":            "cert",
+	"/cert.pem":       "cert",
+	".key":            "key",
+	"-key.pem":        "key",
+	"/key.pem":        "key",
+	".ca":             "ca",
+	"-ca.pem":         "ca",
+	"/ca.pem":         "ca",
+	"-cert-chain.pem": "chain",
+	"-bundle.pem":     "bundle",
+}
+
+// modeTracker applies the per part file modes of the resource being written
+type modeTracker struct {
+	sync.Mutex
+	// the filename the formatter of the resource was given
+	filename string
+	// the mode of each part
+	modes map[string]os.FileMode
+}
+
+// modes applies the file modes of the resource being written
+var modes = &modeTracker{}
+
+// begin applies the modes to the files written under the filename until end is called
+//	filename	: the filename the formatter is given
+//	parts		: the mode of each part
+func (m *modeTracker) begin(filename string, parts map[string]os.FileMode) {
+	m.Lock()
+	defer m.Unlock()
+	m.filename, m.modes = filename, parts
+}
+
+// end stops applying the modes
+func (m *modeTracker) end() {
+	m.Lock()
+	defer m.Unlock()
+	m.filename, m.modes = "", nil
+}
+
+// mode returns the mode of the file, and true when it comes from the mode of a part rather than the default
+//	filename	: the file about to be written
+//	mode		: the mode of the resource
+func (m *modeTracker) mode(filename string, mode os.FileMode) (os.FileMode, bool) {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.modes) == 0 || !strings.HasPrefix(filename, m.filename) {
+		return mode, false
+	}
+	suffix := strings.TrimPrefix(strings.TrimPrefix(filename, m.filename), previousSuffix)
+	if part, found := m.modes[fileParts[suffix]]; found {
+		return part, true
+	}
+
+	return mode, false
+}
+
+// parseFileModes parses the mode option, a comma separated list of a mode for the part, i.e. key:0600, and
+// optionally a mode without a part for the rest of the files; zero is returned when the latter isn't given
+//	value		: the value of the option
+func parseFileModes(value string) (os.FileMode, map[string]os.FileMode, error) {
+	var mode os.FileMode
+	parts := make(map[string]os.FileMode)
+	for _, x := range strings.Split(value, ",") {
+		items := strings.Split(x, ":")
+		if len(items) == 1 {
+			m, err := parseFileMode(items[0])
+			if err != nil {
+				return 0, nil, err
+			}
+			mode = m
+			continue
+		}
+		if len(items) != 2 {
+			return 0, nil, fmt.Errorf("the mode: %s is invalid, should be part:mode, i.e. key:0600", x)
+		}
+		part := strings.TrimSpace(items[0])
+		if !isFilePart(part) {
+			return 0, nil, fmt.Errorf("the part: %s is invalid, should be one of %s", part, strings.Join(filePartNames(), ", "))
+		}
+		m, err := parseFileMode(items[1])
+		if err != nil {
+			return 0, nil, err
+		}
+		parts[part] = m
+	}
+
+	return mode, parts, nil
+}
+
+// parseFileMode parses an octal file mode, i.e. 0644 or 644
+func parseFileMode(value string) (os.FileMode, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "0") {
+		value = "0" + value
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("the file permission: %s is invalid, should be octal 0444 or alike", value)
+	}
+	mode, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file permissions: %s on resource", value)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// isFilePart checks the name is a part of the pki formats
+func isFilePart(name string) bool {
+	for _, x := range fileParts {
+		if x == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filePartNames returns the names of the parts, sorted
+func filePartNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, x := range fileParts {
+		if !seen[x] {
+			seen[x] = true
+			names = append(names, x)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
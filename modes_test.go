@@ -0,0 +1,100 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFileModes(t *testing.T) {
+	mode, parts, err := parseFileModes("0600")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), mode)
+	assert.Empty(t, parts)
+
+	mode, parts, err = parseFileModes("cert:0644,key:600")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0), mode)
+	assert.Equal(t, map[string]os.FileMode{"cert": 0644, "key": 0600}, parts)
+
+	mode, parts, err = parseFileModes("0640,key:0600")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), mode)
+	assert.Equal(t, map[string]os.FileMode{"key": 0600}, parts)
+
+	for _, x := range []string{"secret:0600", "key:06000", "key:0abc", "key:0600:1", "99999"} {
+		_, _, err := parseFileModes(x)
+		assert.Error(t, err, "mode: %s", x)
+	}
+}
+
+func TestPartFileModes(t *testing.T) {
+	defer withOutputDir(t)()
+
+	expected := map[string]map[string]os.FileMode{
+		"cert":   {"cert.crt": 0644, "cert.key": 0600, "cert.ca": 0640},
+		"bundle": {"bundle.pem": 0644, "bundle-key.pem": 0600, "bundle-ca.pem": 0640, "bundle-bundle.pem": 0640},
+	}
+	for format, files := range expected {
+		rn := &VaultResource{
+			Resource:  "pki",
+			Path:      "pki/issue/app",
+			Format:    format,
+			Filename:  format,
+			FileMode:  0640,
+			FileModes: map[string]os.FileMode{"cert": 0644, "key": 0600},
+		}
+		_, err := writeResource(rn, newTestPKI(t))
+		mustNoError(t, err)
+		for name, mode := range files {
+			stat, err := os.Stat(filepath.Join(options.outputDir, name))
+			mustNoError(t, err)
+			assert.Equal(t, mode, stat.Mode().Perm(), "file: %s", name)
+		}
+	}
+
+	// step: the mode of a part is applied to an existing file as well
+	key := filepath.Join(options.outputDir, "cert.key")
+	mustNoError(t, os.Chmod(key, 0666))
+	rn := &VaultResource{
+		Resource:  "pki",
+		Path:      "pki/issue/app",
+		Format:    "cert",
+		Filename:  "cert",
+		FileMode:  0640,
+		FileModes: map[string]os.FileMode{"key": 0600},
+	}
+	_, err := writeResource(rn, newTestPKI(t))
+	mustNoError(t, err)
+	stat, err := os.Stat(key)
+	mustNoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+}
+
+func TestFileModesOption(t *testing.T) {
+	resources := &VaultResources{}
+	mustNoError(t, resources.Set("pki:pki/issue/app:common_name=app§mode=cert:0644,key:0600"))
+	assert.Equal(t, map[string]os.FileMode{"cert": 0644, "key": 0600}, resources.items[0].FileModes)
+	mustNoError(t, resources.Set("pki:pki/issue/app:common_name=app§mode=0640,key:0600"))
+	assert.Equal(t, os.FileMode(0640), resources.items[1].FileMode)
+	assert.Equal(t, map[string]os.FileMode{"key": 0600}, resources.items[1].FileModes)
+	assert.Error(t, resources.Set("pki:pki/issue/app:common_name=app§mode=secret:0644"))
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// mountTuning is the lease tuning of a secrets engine mount
+type mountTuning struct {
+	// the path of the mount
+	path string
+	// the default lease ttl of the mount
+	defaultTTL time.Duration
+	// the max lease ttl of the mount
+	maxTTL time.Duration
+	// the response keys the audit log leaves in the clear, the others being treated as sensitive
+	nonHMACKeys []string
+}
+
+// mountTunings caches the tuning of the mounts by path prefix; a nil entry means the tuning couldn't be read
+var mountTunings = struct {
+	sync.Mutex
+	items map[string]*mountTuning
+}{items: make(map[string]*mountTuning)}
+
+// tuning returns the lease tuning of the mount holding the path, or nil if the token can't read it; the
+// mount is found by trying the prefixes of the path, shortest first
+//	path		: the path of the resource
+func (r VaultService) tuning(path string) *mountTuning {
+	mountTunings.Lock()
+	defer mountTunings.Unlock()
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		prefix := strings.Join(segments[:i], "/")
+		if tuning, found := mountTunings.items[prefix]; found {
+			if tuning != nil {
+				return tuning
+			}
+			continue
+		}
+
+		secret, err := r.client.Logical().Read(fmt.Sprintf("sys/mounts/%s/tune", prefix))
+		if err != nil || secret == nil {
+			glog.V(4).Infof("unable to read the tuning of the mount: %s, error: %v", prefix, err)
+			mountTunings.items[prefix] = nil
+			continue
+		}
+		tuning := &mountTuning{
+			path:       prefix,
+			defaultTTL: tuningSeconds(secret.Data["default_lease_ttl"]),
+			maxTTL:     tuningSeconds(secret.Data["max_lease_ttl"]),
+		}
+		if keys, ok := secret.Data["audit_non_hmac_response_keys"].([]interface{}); ok {
+			for _, x := range keys {
+				tuning.nonHMACKeys = append(tuning.nonHMACKeys, fmt.Sprintf("%v", x))
+			}
+		}
+		glog.V(3).Infof("mount: %s has a default lease ttl: %s, max lease ttl: %s", prefix, tuning.defaultTTL, tuning.maxTTL)
+		mountTunings.items[prefix] = tuning
+
+		return tuning
+	}
+
+	return nil
+}
+
+// cachedTuning returns the tuning of the mount holding the path if it has already been read, without
+// reading it from vault
+//	path		: the path of the resource
+func cachedTuning(path string) *mountTuning {
+	mountTunings.Lock()
+	defer mountTunings.Unlock()
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		if tuning := mountTunings.items[strings.Join(segments[:i], "/")]; tuning != nil {
+			return tuning
+		}
+	}
+
+	return nil
+}
+
+// tuningSeconds converts a ttl in seconds from the tuning of a mount to a duration
+func tuningSeconds(value interface{}) time.Duration {
+	seconds, err := strconv.ParseInt(fmt.Sprintf("%v", value), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// leased checks if a resource has a lease bounded by the tuning of its mount
+func leased(rn *watchedResource) bool {
+	return rn.secret.LeaseID != "" || rn.resource.Resource == "pki"
+}
+
+// applyTuning reads the tuning of the mount for every resource, its audit hints deciding which key names are
+// masked, and records the lease tuning on a leased resource, warning if the update interval exceeds the max ttl,
+// as the secret would expire before it's renewed
+//	rn			: the watched resource
+func (r VaultService) applyTuning(rn *watchedResource) {
+	tuning := r.tuning(rn.resource.Path)
+	if rn.tuning != nil || !leased(rn) {
+		return
+	}
+	rn.tuning = tuning
+	if rn.tuning == nil {
+		rn.tuning = &mountTuning{}
+		return
+	}
+	if rn.tuning.maxTTL > 0 && rn.resource.Update > rn.tuning.maxTTL {
+		glog.Warningf("the update interval: %s of the resource: %s exceeds the max lease ttl: %s of the mount: %s, renewals will be scheduled within the max ttl",
+			rn.resource.Update, rn.resource, rn.tuning.maxTTL, rn.tuning.path)
+	}
+}
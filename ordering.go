@@ -0,0 +1,196 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+)
+
+// heldResource is a retrieved resource held back until the resources it depends on have succeeded
+type heldResource struct {
+	// the resource
+	resource *VaultResource
+	// the secret data of the resource
+	data map[string]interface{}
+}
+
+// dependencyOrder holds back the rendering of resources until the resources they depend on have succeeded,
+// so they are written, and their exec commands run, in dependency order
+type dependencyOrder struct {
+	sync.Mutex
+	// the resource ids each resource depends on
+	requires map[*VaultResource][]string
+	// a map of resource id to the resources depending on it, in the order they were declared
+	dependents map[string][]*VaultResource
+	// the resource ids which have succeeded at least once
+	succeeded map[string]bool
+	// the resources held back, waiting on their dependencies
+	held map[*VaultResource]map[string]interface{}
+}
+
+// ordering is the dependency order of the resources being watched
+var ordering = &dependencyOrder{
+	requires:   make(map[*VaultResource][]string),
+	dependents: make(map[string][]*VaultResource),
+	succeeded:  make(map[string]bool),
+	held:       make(map[*VaultResource]map[string]interface{}),
+}
+
+// watch works out the resources each resource depends on, refusing unknown resources and cycles, and
+// keeping the resources which have already succeeded
+//	items		: the resources being watched
+func (d *dependencyOrder) watch(items []*VaultResource) error {
+	ids := make(map[string]*VaultResource)
+	for _, rn := range items {
+		ids[rn.ID()] = rn
+	}
+	requires := make(map[*VaultResource][]string)
+	dependents := make(map[string][]*VaultResource)
+	for _, rn := range items {
+		for _, id := range rn.DependsOn {
+			id = strings.TrimSpace(id)
+			if _, found := ids[id]; !found {
+				return fmt.Errorf("resource: %s depends on an unknown resource: %s", rn, id)
+			}
+			if id == rn.ID() {
+				return fmt.Errorf("resource: %s cannot depend on itself", rn)
+			}
+			requires[rn] = append(requires[rn], id)
+			dependents[id] = append(dependents[id], rn)
+		}
+	}
+	// step: a cycle would hold its resources back forever
+	visiting := make(map[*VaultResource]bool)
+	visited := make(map[*VaultResource]bool)
+	var visit func(rn *VaultResource) error
+	visit = func(rn *VaultResource) error {
+		if visiting[rn] {
+			return fmt.Errorf("resource: %s is part of a dependency cycle", rn)
+		}
+		if visited[rn] {
+			return nil
+		}
+		visiting[rn] = true
+		for _, id := range requires[rn] {
+			if err := visit(ids[id]); err != nil {
+				return err
+			}
+		}
+		visiting[rn] = false
+		visited[rn] = true
+
+		return nil
+	}
+	for _, rn := range items {
+		if err := visit(rn); err != nil {
+			return err
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.requires = requires
+	d.dependents = dependents
+	for rn := range d.held {
+		if _, found := requires[rn]; !found {
+			delete(d.held, rn)
+		}
+	}
+
+	return nil
+}
+
+// ready checks the resources a resource depends on have all succeeded
+//	rn			: the resource
+func (d *dependencyOrder) ready(rn *VaultResource) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.waiting(rn) == nil
+}
+
+// waiting returns the resource ids a resource is still waiting on; the caller holds the lock
+func (d *dependencyOrder) waiting(rn *VaultResource) []string {
+	var list []string
+	for _, id := range d.requires[rn] {
+		if !d.succeeded[id] {
+			list = append(list, id)
+		}
+	}
+
+	return list
+}
+
+// hold holds a resource back until the resources it depends on have succeeded, returning those it's waiting on
+//	rn			: the resource
+//	data		: the secret data of the resource
+func (d *dependencyOrder) hold(rn *VaultResource, data map[string]interface{}) []string {
+	d.Lock()
+	defer d.Unlock()
+	d.held[rn] = data
+
+	return d.waiting(rn)
+}
+
+// success records a resource has succeeded, returning the held resources which are no longer waiting on
+// anything, in the order they were declared
+//	rn			: the resource which has succeeded
+func (d *dependencyOrder) success(rn *VaultResource) []*heldResource {
+	d.Lock()
+	defer d.Unlock()
+	d.succeeded[rn.ID()] = true
+
+	var released []*heldResource
+	for _, x := range d.dependents[rn.ID()] {
+		data, found := d.held[x]
+		if !found || len(d.waiting(x)) > 0 {
+			continue
+		}
+		delete(d.held, x)
+		released = append(released, &heldResource{resource: x, data: data})
+	}
+
+	return released
+}
+
+// releaseResources renders the resources released by the resources they depend on succeeding, in turn releasing those
+// depending on them
+//	released	: the resources released
+//	collector	: the collector of the files written, if any
+func releaseResources(released []*heldResource, collector *outputCollector) {
+	for len(released) > 0 {
+		x := released[0]
+		released = released[1:]
+		glog.V(3).Infof("resource: %s is no longer waiting on the resources it depends on, rendering it", x.resource)
+		if err := processResource(x.resource, x.data); err != nil {
+			metrics.ResourceErrorCode(x.resource.ID(), errorCode(err))
+			glog.Errorf("failed to write out the update, error: %s", err)
+			continue
+		}
+		if collector != nil {
+			collector.rendered(x.resource)
+		}
+		supervised.rendered(x.resource, x.data)
+		released = append(released, ordering.success(x.resource)...)
+	}
+}
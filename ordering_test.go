@@ -0,0 +1,57 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	db := &VaultResource{Resource: "database", Path: "database/creds/app", Name: "db"}
+	cache := &VaultResource{Resource: "secret", Path: "secret/cache", Name: "cache"}
+	app := &VaultResource{Resource: "secret", Path: "secret/app", Name: "app", DependsOn: []string{"db", "cache"}}
+	worker := &VaultResource{Resource: "secret", Path: "secret/worker", DependsOn: []string{"app"}}
+
+	order := &dependencyOrder{
+		requires:   make(map[*VaultResource][]string),
+		dependents: make(map[string][]*VaultResource),
+		succeeded:  make(map[string]bool),
+		held:       make(map[*VaultResource]map[string]interface{}),
+	}
+	mustNoError(t, order.watch([]*VaultResource{db, cache, app, worker}))
+	assert.True(t, order.ready(db))
+	assert.False(t, order.ready(app))
+
+	assert.Equal(t, []string{"db", "cache"}, order.hold(app, map[string]interface{}{"a": "b"}))
+	assert.Empty(t, order.success(db))
+	released := order.success(cache)
+	if assert.Len(t, released, 1) {
+		assert.Equal(t, app, released[0].resource)
+		assert.Equal(t, map[string]interface{}{"a": "b"}, released[0].data)
+	}
+	assert.True(t, order.ready(app))
+	assert.False(t, order.ready(worker))
+
+	// step: unknown resources and cycles are refused
+	assert.Error(t, order.watch([]*VaultResource{app, cache}))
+	db.DependsOn = []string{"secret/worker"}
+	assert.Error(t, order.watch([]*VaultResource{db, cache, app, worker}))
+	db.DependsOn = []string{"db"}
+	assert.Error(t, order.watch([]*VaultResource{db}))
+}
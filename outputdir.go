@@ -0,0 +1,88 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+)
+
+// defaultDirMode is the mode of the directories created when -dir-mode is not given
+const defaultDirMode = os.FileMode(0755)
+
+// directoryMode returns the mode the directories the sidekick creates are given
+func directoryMode(cfg *config) os.FileMode {
+	if cfg.dirMode.set {
+		return cfg.dirMode.mode
+	}
+
+	return defaultDirMode
+}
+
+// makeDirectory creates the directory and any missing parents, chmod'ing the directory itself to the
+// mode given so it isn't narrowed by the umask
+//	dir			: the directory to create
+//	mode		: the mode of the directory
+func makeDirectory(dir string, mode os.FileMode) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return err
+	}
+
+	return applyFileACL(dir, mode)
+}
+
+// prepareOutputDir applies the umask, creates the output directory when missing and refuses one which is
+// group or world writable when asked to
+//	cfg			: the configuration of the sidekick
+func prepareOutputDir(cfg *config) error {
+	if cfg.umask.set {
+		previous := setUmask(int(cfg.umask.mode))
+		glog.V(3).Infof("changed the umask from: %#04o to: %#04o", previous, cfg.umask.mode)
+	}
+	if cfg.dryRun || cfg.outputDir == stdoutOutput || cfg.serveSocket != "" || cfg.fuseMount != "" {
+		return nil
+	}
+
+	stat, err := os.Stat(cfg.outputDir)
+	if os.IsNotExist(err) {
+		if err := makeDirectory(cfg.outputDir, directoryMode(cfg)); err != nil {
+			return fmt.Errorf("unable to create the output directory: %s, error: %s", cfg.outputDir, err)
+		}
+		glog.Infof("created the output directory: %s, mode: %#04o", cfg.outputDir, directoryMode(cfg))
+		stat, err = os.Stat(cfg.outputDir)
+	}
+	switch {
+	case err != nil:
+		return err
+	case !stat.IsDir():
+		return fmt.Errorf("the output: %s is not a directory", cfg.outputDir)
+	}
+
+	if cfg.refuseWritableOutput && stat.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("the output directory: %s is group or world writable, mode: %#04o", cfg.outputDir, stat.Mode().Perm())
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModeOption(t *testing.T) {
+	m := modeOption{}
+	assert.Equal(t, "", m.String())
+	assert.NoError(t, m.Set("077"))
+	assert.True(t, m.set)
+	assert.Equal(t, os.FileMode(0077), m.mode)
+	assert.Equal(t, "0077", m.String())
+	assert.NoError(t, m.Set("0750"))
+	assert.Equal(t, "0750", m.String())
+	assert.Error(t, m.Set("0999"))
+	assert.NoError(t, m.Set(""))
+	assert.False(t, m.set)
+}
+
+func TestPrepareOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// step: a missing directory is created with the dir mode
+	cfg := &config{outputDir: filepath.Join(dir, "secrets")}
+	mustNoError(t, cfg.dirMode.Set("0710"))
+	assert.NoError(t, prepareOutputDir(cfg))
+	stat, err := os.Stat(cfg.outputDir)
+	mustNoError(t, err)
+	assert.True(t, stat.IsDir())
+	assert.Equal(t, os.FileMode(0710), stat.Mode().Perm())
+
+	// step: a writable directory is only refused when asked to
+	mustNoError(t, os.Chmod(cfg.outputDir, 0777))
+	assert.NoError(t, prepareOutputDir(cfg))
+	cfg.refuseWritableOutput = true
+	assert.Error(t, prepareOutputDir(cfg))
+	mustNoError(t, os.Chmod(cfg.outputDir, 0755))
+	assert.NoError(t, prepareOutputDir(cfg))
+
+	// step: a file in place of the directory is refused
+	file := filepath.Join(dir, "file")
+	mustNoError(t, ioutil.WriteFile(file, nil, 0600))
+	assert.Error(t, prepareOutputDir(&config{outputDir: file}))
+}
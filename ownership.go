@@ -0,0 +1,108 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// ownershipTracker hands the files and directories of the resource being written over to the owner and
+// group of the resource
+type ownershipTracker struct {
+	sync.Mutex
+	// the uid and gid applied, -1 leaving either as it is
+	uid int
+	gid int
+	// whether the ownership is being applied
+	active bool
+}
+
+// owners applies the ownership of the resource being written
+var owners = &ownershipTracker{}
+
+// begin applies the uid and gid to the files written until end is called
+func (o *ownershipTracker) begin(uid, gid int) {
+	o.Lock()
+	defer o.Unlock()
+	o.uid, o.gid = uid, gid
+	o.active = uid >= 0 || gid >= 0
+}
+
+// end stops applying the ownership
+func (o *ownershipTracker) end() {
+	o.Lock()
+	defer o.Unlock()
+	o.active = false
+}
+
+// apply changes the owner and group of the file or directory, when an ownership is being applied
+//	path		: the file or directory written
+func (o *ownershipTracker) apply(path string) error {
+	o.Lock()
+	defer o.Unlock()
+	if !o.active {
+		return nil
+	}
+	if err := os.Lchown(path, o.uid, o.gid); err != nil {
+		return fmt.Errorf("unable to change the ownership of: %s to %d:%d, error: %s", path, o.uid, o.gid, err)
+	}
+	audit.chown(path, o.uid, o.gid)
+
+	return nil
+}
+
+// resolveOwnership resolves the owner and group of a resource to a uid and gid, -1 when not given
+//	owner		: the numeric uid or name of the user
+//	group		: the numeric gid or name of the group
+func resolveOwnership(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return uid, gid, fmt.Errorf("unable to find the user: %s, error: %s", owner, err)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return uid, gid, fmt.Errorf("the user: %s has a non numeric uid: %s", owner, u.Uid)
+			}
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return uid, gid, fmt.Errorf("unable to find the group: %s, error: %s", group, err)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return uid, gid, fmt.Errorf("the group: %s has a non numeric gid: %s", group, g.Gid)
+			}
+		}
+		gid = id
+	}
+	if uid < -1 || gid < -1 {
+		return -1, -1, fmt.Errorf("the owner and group can't be negative")
+	}
+
+	return uid, gid, nil
+}
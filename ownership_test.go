@@ -0,0 +1,98 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveOwnership(t *testing.T) {
+	uid, gid, err := resolveOwnership("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, uid)
+	assert.Equal(t, -1, gid)
+
+	uid, gid, err = resolveOwnership("1000", "2000")
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 2000, gid)
+
+	uid, _, err = resolveOwnership("root", "")
+	if err == nil {
+		assert.Equal(t, 0, uid)
+	}
+
+	_, _, err = resolveOwnership("no-such-user-here", "")
+	assert.Error(t, err)
+	_, _, err = resolveOwnership("", "no-such-group-here")
+	assert.Error(t, err)
+	_, _, err = resolveOwnership("-5", "")
+	assert.Error(t, err)
+}
+
+func TestResourceOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file needs root")
+	}
+	defer withOutputDir(t)()
+
+	rn := &VaultResource{
+		Resource: "pki",
+		Path:     "pki/issue/app",
+		Layout:   layoutDir,
+		Filename: "app",
+		FileMode: 0640,
+		Owner:    "1234",
+		Group:    "4321",
+	}
+	_, err := writeResource(rn, newTestPKI(t))
+	mustNoError(t, err)
+
+	for _, x := range []string{"app", "app/data.json", "app/cert.pem", "app/key.pem", "app/meta.json"} {
+		stat, err := os.Stat(filepath.Join(options.outputDir, x))
+		mustNoError(t, err)
+		assert.Equal(t, uint32(1234), stat.Sys().(*syscall.Stat_t).Uid, "file: %s", x)
+		assert.Equal(t, uint32(4321), stat.Sys().(*syscall.Stat_t).Gid, "file: %s", x)
+	}
+
+	// step: files written without an owner are left to the sidekick
+	rn = &VaultResource{Resource: "secret", Path: "secret/app", Format: "json", Filename: "plain", FileMode: 0600}
+	_, err = writeResource(rn, map[string]interface{}{"password": "secret"})
+	mustNoError(t, err)
+	stat, err := os.Stat(filepath.Join(options.outputDir, "plain"))
+	mustNoError(t, err)
+	assert.Equal(t, uint32(0), stat.Sys().(*syscall.Stat_t).Uid)
+}
+
+func TestResourceOwnershipOptions(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+
+	resources := &VaultResources{}
+	mustNoError(t, resources.Set("secret:secret/app:owner=1000,group=2000"))
+	assert.Equal(t, "1000", resources.items[0].Owner)
+	assert.Equal(t, "2000", resources.items[0].Group)
+	assert.Error(t, resources.Set("secret:secret/app:owner=no-such-user-here"))
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+)
+
+// fetchPacer spreads the first retrieval of the resources, releasing at most the concurrency of them every stagger,
+// so a sidekick watching many resources doesn't hit vault with all of them at once on startup
+type fetchPacer struct {
+	// the most resources released each stagger, zero releasing them all at once
+	concurrency int
+	// the interval between the releases
+	stagger time.Duration
+	// the time of the current release
+	slot time.Time
+	// the resources in the current release
+	released int
+}
+
+// newFetchPacer creates a pacer
+//	concurrency	: the most resources released each stagger
+//	stagger		: the interval between the releases
+func newFetchPacer(concurrency int, stagger time.Duration) *fetchPacer {
+	return &fetchPacer{concurrency: concurrency, stagger: stagger}
+}
+
+// delay returns how long the first retrieval of a resource added now waits
+//	now			: the time now
+func (p *fetchPacer) delay(now time.Time) time.Duration {
+	if p.concurrency <= 0 {
+		return 0
+	}
+	// step: the releases have caught up, start again from now
+	if p.slot.Before(now) {
+		p.slot, p.released = now, 0
+	}
+	if p.released >= p.concurrency {
+		p.slot, p.released = p.slot.Add(p.stagger), 0
+	}
+	p.released++
+
+	return p.slot.Sub(now)
+}
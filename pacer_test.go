@@ -0,0 +1,39 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchPacer(t *testing.T) {
+	now := time.Now()
+	pacer := newFetchPacer(2, 100*time.Millisecond)
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, pacer.delay(now))
+	}
+	assert.Equal(t, []time.Duration{0, 0, 100 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond}, delays)
+
+	// step: once the releases have caught up the next is immediate
+	assert.Equal(t, time.Duration(0), pacer.delay(now.Add(time.Second)))
+
+	assert.Equal(t, time.Duration(0), newFetchPacer(0, time.Second).delay(now))
+}
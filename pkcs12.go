@@ -0,0 +1,323 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+)
+
+// A minimal PKCS#12 (RFC 7292) encoder, producing a password protected store holding a private key, the leaf
+// certificate and its chain. The key and certificates are encrypted with pbeWithSHAAnd3-KeyTripleDES-CBC and the
+// store is integrity protected with a HMAC-SHA1, which is what openssl, java and windows all understand.
+
+const (
+	// pkcs12Iterations is the number of iterations used in the key derivation
+	pkcs12Iterations = 2048
+	// pkcs12SaltSize is the size of the salts
+	pkcs12SaltSize = 8
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidPBEWithSHAAnd3KeyTDES    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidShroudedKeyBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA1                     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pkcs12PFX struct {
+	Version  int
+	AuthSafe pkcs12ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+type pkcs12ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// encodePKCS12 creates a PKCS#12 store from the PKCS#8 der of a private key, and the der of the leaf
+// certificate followed by its chain
+//	key			: the PKCS#8 der of the private key
+//	certs		: the der of the leaf certificate followed by the chain
+//	alias		: the friendly name given to the key and leaf certificate
+//	password	: the password protecting the store
+func encodePKCS12(key []byte, certs [][]byte, alias, password string) ([]byte, error) {
+	bmpPassword := bmpString(password)
+	localKeyID := sha1.Sum(certs[0])
+
+	attributes, err := pkcs12Attributes(alias, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// step: build the certificate bags, the leaf carries the same attributes as the key
+	var certBags []pkcs12SafeBag
+	for i, cert := range certs {
+		content, err := asn1.Marshal(pkcs12CertBag{ID: oidCertTypeX509, Data: cert})
+		if err != nil {
+			return nil, err
+		}
+		bag := pkcs12SafeBag{ID: oidCertBag, Value: explicitTag(content)}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	certAlgorithm, encryptedCerts, err := pkcs12Encrypt(certContents, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	encryptedData, err := asn1.Marshal(pkcs12EncryptedData{
+		EncryptedContentInfo: pkcs12EncryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: certAlgorithm,
+			EncryptedContent:           encryptedCerts,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// step: build the shrouded key bag
+	keyAlgorithm, encryptedKey, err := pkcs12Encrypt(key, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: keyAlgorithm, Data: encryptedKey})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]pkcs12SafeBag{{
+		ID:         oidShroudedKeyBag,
+		Value:      explicitTag(keyInfo),
+		Attributes: attributes,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := asn1.Marshal(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: the authenticated safe holds the encrypted certificates and the key
+	authenticatedSafe, err := asn1.Marshal([]pkcs12ContentInfo{
+		{
+			ContentType: oidEncryptedDataContentType,
+			Content:     explicitTag(encryptedData),
+		},
+		{
+			ContentType: oidDataContentType,
+			Content:     explicitTag(keyData),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafeData, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: integrity protect the authenticated safe
+	macSalt := make([]byte, pkcs12SaltSize)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(macSalt, bmpPassword, pkcs12Iterations, 3, 20))
+	mac.Write(authenticatedSafe)
+
+	return asn1.Marshal(pkcs12PFX{
+		Version: 3,
+		AuthSafe: pkcs12ContentInfo{
+			ContentType: oidDataContentType,
+			Content:     explicitTag(authSafeData),
+		},
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// explicitTag wraps the der in an explicit [0] tag; the asn1 package ignores the field tags of raw values
+func explicitTag(content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+}
+
+// pkcs12Attributes builds the friendly name and local key id attributes of the key and leaf certificate
+func pkcs12Attributes(alias string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	keyID, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{ID: oidLocalKeyID, Value: asn1.RawValue{Tag: asn1.TagSet, Class: asn1.ClassUniversal, IsCompound: true, Bytes: keyID}}}
+	if alias != "" {
+		bmpAlias := bmpString(alias)
+		name, err := asn1.Marshal(asn1.RawValue{Tag: 30, Class: asn1.ClassUniversal, Bytes: bmpAlias[:len(bmpAlias)-2]})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{ID: oidFriendlyName, Value: asn1.RawValue{Tag: asn1.TagSet, Class: asn1.ClassUniversal, IsCompound: true, Bytes: name}})
+	}
+
+	return attributes, nil
+}
+
+// pkcs12Encrypt encrypts the content with pbeWithSHAAnd3-KeyTripleDES-CBC
+func pkcs12Encrypt(content, password []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := make([]byte, pkcs12SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pkcs12PBEParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	block, err := des.NewTripleDESCipher(pkcs12KDF(salt, password, pkcs12Iterations, 1, 24))
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	iv := pkcs12KDF(salt, password, pkcs12Iterations, 2, block.BlockSize())
+
+	// step: pkcs#7 pad the content to the block size
+	padding := block.BlockSize() - len(content)%block.BlockSize()
+	encrypted := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTDES, Parameters: asn1.RawValue{FullBytes: params}}, encrypted, nil
+}
+
+// bmpString encodes the password as a null terminated big endian utf-16 string, as PKCS#12 requires
+func bmpString(value string) []byte {
+	return append(utf16BigEndian(value), 0, 0)
+}
+
+// pkcs12KDF is the key derivation function from RFC 7292 appendix B, using SHA-1
+//	salt		: the salt
+//	password	: the bmp encoded password
+//	iterations	: the number of iterations
+//	id			: the purpose of the key; 1 for encryption keys, 2 for ivs and 3 for mac keys
+//	size		: the number of bytes to derive
+func pkcs12KDF(salt, password []byte, iterations int, id byte, size int) []byte {
+	const u, v = 20, 64
+
+	fill := func(pattern []byte) []byte {
+		if len(pattern) == 0 {
+			return nil
+		}
+		length := v * ((len(pattern) + v - 1) / v)
+		return bytes.Repeat(pattern, (length+len(pattern)-1)/len(pattern))[:length]
+	}
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(password)...)
+
+	var key []byte
+	one := big.NewInt(1)
+	for len(key) < size {
+		h := sha1.Sum(append(append([]byte{}, D...), I...))
+		A := h[:]
+		for j := 1; j < iterations; j++ {
+			h = sha1.Sum(A)
+			A = h[:]
+		}
+		key = append(key, A...)
+
+		// step: I_j = (I_j + B + 1) mod 2^v for each block of I
+		B := new(big.Int).SetBytes(bytes.Repeat(A, (v+u-1)/u)[:v])
+		for j := 0; j < len(I)/v; j++ {
+			Ij := new(big.Int).SetBytes(I[j*v : (j+1)*v])
+			Ij.Add(Ij, B)
+			Ij.Add(Ij, one)
+			Cj := Ij.Bytes()
+			if len(Cj) > v {
+				Cj = Cj[len(Cj)-v:]
+			}
+			block := I[j*v : (j+1)*v]
+			for k := range block {
+				block[k] = 0
+			}
+			copy(block[v-len(Cj):], Cj)
+		}
+	}
+
+	return key[:size]
+}
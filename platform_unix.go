@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultAdminSocket is empty, the admin api, which can rotate the resources, being disabled unless a socket is given
+const defaultAdminSocket = ""
+
+// terminationSignals are the signals which shut the sidekick down, a hangup reloading the resources file
+var terminationSignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT}
+
+// rotationSignals are the signals which force all the resources to be re-fetched
+var rotationSignals = []os.Signal{syscall.SIGUSR2}
+
+// dumpSignals are the signals which log the state of the resources
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
+
+// setUmask sets the umask of the process, returning the previous one
+func setUmask(mask int) int {
+	return syscall.Umask(mask)
+}
+
+// applyFileACL is a no-op, the permissions of the file being those it was created or chmod'ed with
+func applyFileACL(filename string, mode os.FileMode) error {
+	return nil
+}
+
+// signalNames are the signals which can be sent to sibling processes, by name
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal parses a signal given by name, with or without the SIG prefix, or number
+//	name		: the signal, i.e. HUP, SIGUSR1 or 1
+func parseSignal(name string) (syscall.Signal, error) {
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	signal, found := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !found {
+		return 0, fmt.Errorf("unknown signal: %s", name)
+	}
+
+	return signal, nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// the well known sids the permissions of the group and others are granted to, as windows has neither
+const (
+	// sidUsers is BUILTIN\Users, standing in for the group
+	sidUsers = "*S-1-5-32-545"
+	// sidEveryone is Everyone, standing in for others
+	sidEveryone = "*S-1-1-0"
+)
+
+// defaultAdminSocket is empty, the admin api being disabled unless a socket is given, as unix sockets
+// aren't supported on windows by the go release the sidekick is built with
+const defaultAdminSocket = ""
+
+// terminationSignals are the signals which shut the sidekick down; windows delivers only an interrupt
+var terminationSignals = []os.Signal{os.Interrupt}
+
+// rotationSignals is empty, windows having no user signals; the rotate command or trigger files are used instead
+var rotationSignals []os.Signal
+
+// dumpSignals is empty, the status command or the /status endpoint of the admin api are used instead
+var dumpSignals []os.Signal
+
+// parseSignal refuses every signal, windows having none which can be sent to another process
+func parseSignal(name string) (syscall.Signal, error) {
+	return 0, fmt.Errorf("signals can't be sent to other processes on windows")
+}
+
+// setUmask is a no-op, windows having no umask; the acl of each file is set from its mode instead
+func setUmask(mask int) int {
+	glog.Warningf("the umask is not supported on windows, the acl of the files is set from their mode")
+	return 0
+}
+
+// applyFileACL replaces the acl of the file, as chmod only toggles the read only attribute on windows; the
+// owner bits are granted to the user the sidekick runs as, the group bits to BUILTIN\Users and the others
+// bits to Everyone, and the inherited entries are removed
+//	filename	: the file or directory
+//	mode		: the unix mode of the file
+func applyFileACL(filename string, mode os.FileMode) error {
+	args := []string{filename, "/inheritance:r", "/grant:r", fmt.Sprintf("%s:%s", os.Getenv("USERNAME"), aclRights(mode>>6))}
+	if rights := aclRights(mode >> 3); rights != "" {
+		args = append(args, "/grant:r", fmt.Sprintf("%s:%s", sidUsers, rights))
+	}
+	if rights := aclRights(mode); rights != "" {
+		args = append(args, "/grant:r", fmt.Sprintf("%s:%s", sidEveryone, rights))
+	}
+	if output, err := exec.Command("icacls", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to set the acl of: %s, error: %s, output: %s", filename, err, output)
+	}
+
+	return nil
+}
+
+// aclRights maps the read, write and execute bits of a unix mode to the rights of icacls
+func aclRights(bits os.FileMode) string {
+	switch {
+	case bits&06 == 06:
+		return "(M)"
+	case bits&04 != 0 && bits&01 != 0:
+		return "(RX)"
+	case bits&04 != 0:
+		return "(R)"
+	case bits&02 != 0:
+		return "(W)"
+	}
+
+	return ""
+}
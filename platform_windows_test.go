@@ -0,0 +1,40 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLRights(t *testing.T) {
+	cs := []struct {
+		Mode   uint32
+		Rights [3]string
+	}{
+		{Mode: 0600, Rights: [3]string{"(M)", "", ""}},
+		{Mode: 0644, Rights: [3]string{"(M)", "(R)", "(R)"}},
+		{Mode: 0755, Rights: [3]string{"(M)", "(RX)", "(RX)"}},
+		{Mode: 0420, Rights: [3]string{"(R)", "(W)", ""}},
+	}
+	for _, c := range cs {
+		mode := os.FileMode(c.Mode)
+		assert.Equal(t, c.Rights, [3]string{aclRights(mode >> 6), aclRights(mode >> 3), aclRights(mode)}, "mode: %#o", c.Mode)
+	}
+}
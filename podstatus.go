@@ -0,0 +1,135 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// podStatusAnnotation is the annotation of the pod the status of the resources is patched into
+	podStatusAnnotation = "vault-sidekick.io/status"
+	// podStatusInterval is how often the status annotation is patched, when it has changed
+	podStatusInterval = 10 * time.Second
+)
+
+// podResourceStatus is the status of a resource as annotated on the pod
+type podResourceStatus struct {
+	// the id of the resource
+	ID string `json:"id"`
+	// the state of the resource
+	State string `json:"state"`
+	// the last time the resource was written out
+	LastWritten string `json:"last_written,omitempty"`
+	// the time the lease of the resource expires
+	LeaseExpiry string `json:"lease_expiry,omitempty"`
+	// the stable code of the last error, while the resource is failing
+	Error string `json:"error,omitempty"`
+}
+
+// podStatus is the status of the sidekick as annotated on the pod
+type podStatus struct {
+	// whether every resource is written out and fresh, as /readyz
+	Ready bool `json:"ready"`
+	// the ids of the resources which are failing
+	Failing []string `json:"failing,omitempty"`
+	// the status of each of the resources
+	Resources []podResourceStatus `json:"resources"`
+}
+
+// podStatusReporter patches the status of the resources into an annotation of the pod, so the freshness of the
+// secrets can be inspected from the control plane
+type podStatusReporter struct {
+	// the client of the kubernetes api
+	kube *kubeClient
+	// the name of the pod
+	pod string
+	// the annotation last patched
+	last string
+}
+
+// newPodStatusReporter creates a reporter patching the pod the sidekick is running in
+func newPodStatusReporter() (*podStatusReporter, error) {
+	kube, err := newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &podStatusReporter{kube: kube, pod: podName()}, nil
+}
+
+// newPodStatus summarises the state of the resources
+//	registry	: the state of the resources
+//	now			: the time now
+func newPodStatus(registry *statusRegistry, now time.Time) *podStatus {
+	status := &podStatus{Ready: len(registry.unready(now)) == 0, Resources: []podResourceStatus{}}
+	for _, x := range registry.snapshot().Resources {
+		resource := podResourceStatus{ID: x.ID, State: x.State}
+		if !x.LastWritten.IsZero() {
+			resource.LastWritten = x.LastWritten.UTC().Format(time.RFC3339)
+		}
+		if !x.LeaseExpiry.IsZero() {
+			resource.LeaseExpiry = x.LeaseExpiry.UTC().Format(time.RFC3339)
+		}
+		if x.State == resourceStateFailed {
+			resource.Error = x.LastErrorCode
+			status.Failing = append(status.Failing, x.ID)
+		}
+		status.Resources = append(status.Resources, resource)
+	}
+
+	return status
+}
+
+// report patches the status annotation of the pod, if it has changed since last patched
+//	registry	: the state of the resources
+//	now			: the time now
+func (p *podStatusReporter) report(registry *statusRegistry, now time.Time) error {
+	content, err := json.Marshal(newPodStatus(registry, now))
+	if err != nil {
+		return err
+	}
+	if string(content) == p.last {
+		return nil
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{podStatusAnnotation: string(content)},
+		},
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", p.kube.namespace, p.pod)
+	if err := p.kube.do(http.MethodPatch, path, "application/strategic-merge-patch+json", patch, nil); err != nil {
+		return err
+	}
+	p.last = string(content)
+
+	return nil
+}
+
+// watch patches the status annotation every interval, when it has changed
+func (p *podStatusReporter) watch() {
+	for range time.NewTicker(podStatusInterval).C {
+		if err := p.report(statuses, time.Now()); err != nil {
+			glog.Warningf("unable to patch the status annotation of the pod: %s, error: %s", p.pod, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodStatusReporter(t *testing.T) {
+	annotations := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.Equal(t, "/api/v1/namespaces/default/pods/app-0", r.URL.Path)
+		patch := &kubePod{}
+		mustNoError(t, json.NewDecoder(r.Body).Decode(patch))
+		annotations <- patch.Metadata.Annotations[podStatusAnnotation]
+	}))
+	defer server.Close()
+
+	registry := &statusRegistry{resources: make(map[*VaultResource]*resourceStatus)}
+	db := &VaultResource{Resource: "secret", Path: "secret/db"}
+	cert := &VaultResource{Resource: "pki", Path: "pki/issue/app"}
+	registry.success(db, time.Now().Add(time.Hour))
+	registry.written(db)
+	registry.failure(cert, withCode(codeKubeRequest, errors.New("permission denied")))
+
+	reporter := &podStatusReporter{
+		kube: &kubeClient{host: server.URL, namespace: "default", client: http.DefaultClient},
+		pod:  "app-0",
+	}
+	mustNoError(t, reporter.report(registry, time.Now()))
+	status := &podStatus{}
+	mustNoError(t, json.Unmarshal([]byte(<-annotations), status))
+	assert.False(t, status.Ready)
+	assert.Equal(t, []string{cert.ID()}, status.Failing)
+	if assert.Equal(t, 2, len(status.Resources)) {
+		for _, x := range status.Resources {
+			if x.ID == cert.ID() {
+				assert.Equal(t, codeKubeRequest, x.Error)
+			} else {
+				assert.NotEmpty(t, x.LastWritten)
+				assert.NotEmpty(t, x.LeaseExpiry)
+			}
+		}
+	}
+
+	// step: the annotation is only patched again once the status changes
+	mustNoError(t, reporter.report(registry, time.Now()))
+	assert.Equal(t, 0, len(annotations))
+	registry.success(cert, time.Time{})
+	registry.written(cert)
+	mustNoError(t, reporter.report(registry, time.Now()))
+	status = &podStatus{}
+	mustNoError(t, json.Unmarshal([]byte(<-annotations), status))
+	assert.True(t, status.Ready)
+	assert.Empty(t, status.Failing)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findProcesses returns the pids of the processes with the name given, other than the sidekick, matching the name
+// of the command or the base of the first argument; with shareProcessNamespace the containers of the pod see each
+// other's processes
+//	name		: the name of the process
+func findProcesses(name string) ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, x := range entries {
+		pid, err := strconv.Atoi(x.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join("/proc", x.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+			continue
+		}
+		// step: the command name is truncated to 15 characters, the first argument isn't
+		cmdline, err := ioutil.ReadFile(filepath.Join("/proc", x.Name(), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+		if filepath.Base(string(bytes.SplitN(cmdline, []byte{0}, 2)[0])) == name {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
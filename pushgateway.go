@@ -0,0 +1,43 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+	"github.com/golang/glog"
+)
+
+// pushMetrics pushes the metrics to the pushgateway and writes them to the textfile, whichever are asked for,
+// so the final state of a one-shot run can be seen after it exits
+func pushMetrics() {
+	if options.metricsPushGateway != "" {
+		instance, err := os.Hostname()
+		if err != nil {
+			instance = prog
+		}
+		if err := metrics.Push(options.metricsPushGateway, options.metricsPushJob, instance); err != nil {
+			glog.Errorf("failed to push the metrics to: %s, error: %s", options.metricsPushGateway, err)
+		}
+	}
+	if options.metricsTextfile != "" {
+		if err := metrics.WriteTextfile(options.metricsTextfile); err != nil {
+			glog.Errorf("failed to write the metrics to: %s, error: %s", options.metricsTextfile, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// readyFileInterval is how often the resources are checked to create or remove the ready file
+const readyFileInterval = time.Second
+
+// readyFile is a sentinel file which exists only while every resource is written out and fresh, so entrypoints
+// can wait on it without http
+type readyFile struct {
+	sync.Mutex
+	// the path of the sentinel file
+	path string
+	// whether the file has been created
+	ready bool
+}
+
+// sentinel is the ready file, if any
+var sentinel *readyFile
+
+// newReadyFile creates the ready file tracker, removing any file left behind by a previous run
+//	path		: the path of the sentinel file
+func newReadyFile(path string) (*readyFile, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return &readyFile{path: path}, nil
+}
+
+// update creates the file once every resource is written out and fresh, and removes it when one is not
+//	registry	: the state of the resources
+//	now			: the time now
+func (f *readyFile) update(registry *statusRegistry, now time.Time) {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	reasons := registry.unready(now)
+	switch {
+	case len(reasons) == 0 && !f.ready:
+		if err := ioutil.WriteFile(f.path, []byte(now.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+			glog.Errorf("unable to create the ready file: %s, error: %s", f.path, err)
+			return
+		}
+		glog.Infof("all the resources have been written, created the ready file: %s", f.path)
+		f.ready = true
+	case len(reasons) > 0 && f.ready:
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("unable to remove the ready file: %s, error: %s", f.path, err)
+			return
+		}
+		glog.Warningf("removed the ready file: %s, %s", f.path, reasons[0])
+		f.ready = false
+	}
+}
+
+// remove removes the file, as the sidekick is no longer keeping the resources fresh
+func (f *readyFile) remove() {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("unable to remove the ready file: %s, error: %s", f.path, err)
+	}
+	f.ready = false
+}
+
+// watch checks the resources every interval, creating or removing the file
+func (f *readyFile) watch() {
+	for range time.NewTicker(readyFileInterval).C {
+		f.update(statuses, time.Now())
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ready")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".vault-sidekick-ready")
+
+	// step: a file left behind by a previous run is removed
+	mustNoError(t, ioutil.WriteFile(path, []byte("old"), 0644))
+	sentinel, err := newReadyFile(path)
+	mustNoError(t, err)
+	assert.False(t, readyFileExists(t, path))
+
+	registry := &statusRegistry{resources: make(map[*VaultResource]*resourceStatus)}
+	rn := &VaultResource{Resource: "secret", Path: "secret/db"}
+	registry.add(rn)
+	sentinel.update(registry, time.Now())
+	assert.False(t, readyFileExists(t, path))
+
+	registry.success(rn, time.Time{})
+	registry.written(rn)
+	sentinel.update(registry, time.Now())
+	assert.True(t, readyFileExists(t, path))
+
+	// step: the file is removed once the resource goes stale
+	registry.stale(rn)
+	sentinel.update(registry, time.Now())
+	assert.False(t, readyFileExists(t, path))
+
+	registry.success(rn, time.Time{})
+	sentinel.update(registry, time.Now())
+	assert.True(t, readyFileExists(t, path))
+	sentinel.remove()
+	assert.False(t, readyFileExists(t, path))
+}
+
+func readyFileExists(t *testing.T, path string) bool {
+	found, err := fileExists(path)
+	mustNoError(t, err)
+	return found
+}
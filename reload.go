@@ -0,0 +1,284 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// configGeneration is the generation of the configuration, bumped each time a reload changes the resources
+var configGeneration int64 = 1
+
+// resourceChange is a resource whose definition has changed
+type resourceChange struct {
+	// the resource being watched
+	from *VaultResource
+	// the resource replacing it
+	to *VaultResource
+	// the fields which differ, as they're printed by config print-effective
+	fields []string
+}
+
+// resourcePlan is the set of changes a reload makes to the resources
+type resourcePlan struct {
+	// the resources which are new
+	added []*VaultResource
+	// the resources which are no longer wanted
+	removed []*VaultResource
+	// the resources whose definition has changed
+	changed []*resourceChange
+	// the resources once the plan is applied, keeping those unchanged as they are
+	resources []*VaultResource
+}
+
+// resourceKey identifies a resource across reloads, by its type and id
+func resourceKey(rn *VaultResource) string {
+	return fmt.Sprintf("%s:%s", rn.Resource, rn.ID())
+}
+
+// planResources works out the changes between the resources being watched and those wanted
+//	current		: the resources being watched
+//	next		: the resources wanted
+func planResources(current, next []*VaultResource) *resourcePlan {
+	plan := &resourcePlan{}
+	watched := make(map[string]*VaultResource)
+	for _, rn := range current {
+		watched[resourceKey(rn)] = rn
+	}
+	wanted := make(map[string]bool)
+	for _, rn := range next {
+		key := resourceKey(rn)
+		wanted[key] = true
+		previous, found := watched[key]
+		switch {
+		case !found:
+			plan.added = append(plan.added, rn)
+			plan.resources = append(plan.resources, rn)
+		case sameResource(previous, rn):
+			plan.resources = append(plan.resources, previous)
+		default:
+			plan.changed = append(plan.changed, &resourceChange{from: previous, to: rn, fields: resourceDiff(previous, rn)})
+			plan.resources = append(plan.resources, rn)
+		}
+	}
+	for _, rn := range current {
+		if !wanted[resourceKey(rn)] {
+			plan.removed = append(plan.removed, rn)
+		}
+	}
+
+	return plan
+}
+
+// sameResource checks if two resources have the same definition, ignoring the retry state
+func sameResource(a, b *VaultResource) bool {
+	x, y := *a, *b
+	x.Retries, y.Retries = 0, 0
+
+	return reflect.DeepEqual(x, y)
+}
+
+// resourceDiff returns the fields which differ between two resources as "field: from -> to"; secret
+// bearing values are masked, so a change only to them is reported as such
+func resourceDiff(a, b *VaultResource) []string {
+	from, to := effectiveFields(a), effectiveFields(b)
+	var keys []string
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, found := from[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var fields []string
+	for _, key := range keys {
+		x, y := fieldValue(from, key), fieldValue(to, key)
+		if x != y {
+			fields = append(fields, fmt.Sprintf("%s: %s -> %s", key, x, y))
+		}
+	}
+	if len(fields) == 0 {
+		fields = append(fields, "(sensitive values)")
+	}
+
+	return fields
+}
+
+// effectiveFields returns the fields of the printable view of a resource
+func effectiveFields(rn *VaultResource) map[string]interface{} {
+	fields := make(map[string]interface{})
+	content, err := yaml.Marshal(newEffectiveResource(rn))
+	if err == nil {
+		err = yaml.Unmarshal(content, &fields)
+	}
+	if err != nil {
+		glog.Errorf("unable to build the printable view of the resource: %s, error: %s", rn, err)
+	}
+
+	return fields
+}
+
+// fieldValue formats a field for the plan, nested values as flow style yaml
+func fieldValue(fields map[string]interface{}, key string) string {
+	value, found := fields[key]
+	if !found {
+		return "<unset>"
+	}
+	switch value.(type) {
+	case map[interface{}]interface{}, []interface{}:
+		content, err := yaml.Marshal(value)
+		if err == nil {
+			return string(bytes.TrimSpace(bytes.Replace(content, []byte("\n"), []byte(", "), -1)))
+		}
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// watched returns the resources the plan starts watching, those changed and added
+func (p *resourcePlan) watched() []*VaultResource {
+	var list []*VaultResource
+	for _, x := range p.changed {
+		list = append(list, x.to)
+	}
+
+	return append(list, p.added...)
+}
+
+// empty checks if the plan makes no changes
+func (p *resourcePlan) empty() bool {
+	return len(p.added) == 0 && len(p.removed) == 0 && len(p.changed) == 0
+}
+
+// String returns the plan as a summary line followed by each of the changes
+func (p *resourcePlan) String() string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "plan: %d to add, %d to change, %d to remove", len(p.added), len(p.changed), len(p.removed))
+	for _, rn := range p.added {
+		fmt.Fprintf(b, "\n  + %s (%s)", resourceKey(rn), rn.Path)
+	}
+	for _, x := range p.changed {
+		fmt.Fprintf(b, "\n  ~ %s (%s)", resourceKey(x.to), x.to.Path)
+		for _, field := range x.fields {
+			fmt.Fprintf(b, "\n      %s", field)
+		}
+	}
+	for _, rn := range p.removed {
+		fmt.Fprintf(b, "\n  - %s (%s)", resourceKey(rn), rn.Path)
+	}
+
+	return b.String()
+}
+
+// reloadResources re-reads the resources file, logging the plan of the changes before applying them;
+// the resources given on the command line are left as they are. The plan applied and the dependency
+// graph of the resources are returned, a nil graph if nothing changed
+//	vault		: the vault service watching the resources
+func reloadResources(vault *VaultService) (*resourcePlan, *dependencyGraph, error) {
+	next, err := loadResourcesYAML(options.resourcesYAML)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := expandResourceFilenames(next); err != nil {
+		return nil, nil, withCode(codeResourceInvalid, err)
+	}
+	for _, rn := range next {
+		if err := rn.IsValid(); err != nil {
+			return nil, nil, withCode(codeResourceInvalid, err)
+		}
+	}
+
+	return applyResources(vault, options.resourcesYAML, next)
+}
+
+// applyResources replaces the resources read from the resources file or configmap with those given, logging
+// the plan of the changes before applying them; the plan applied and the dependency graph of the resources are
+// returned, a nil graph if nothing changed
+//	vault		: the vault service watching the resources
+//	source		: where the resources were read from
+//	next		: the resources wanted
+func applyResources(vault *VaultService, source string, next []*VaultResource) (*resourcePlan, *dependencyGraph, error) {
+	plan := planResources(options.resourcesFromYAML, next)
+	if plan.empty() {
+		glog.Infof("reloaded the resources from: %s, no changes", source)
+		return plan, nil, nil
+	}
+
+	// step: the command line resources are those not read from the file
+	fromYAML := make(map[*VaultResource]bool)
+	for _, rn := range options.resourcesFromYAML {
+		fromYAML[rn] = true
+	}
+	var items []*VaultResource
+	for _, rn := range options.resources.items {
+		if !fromYAML[rn] {
+			items = append(items, rn)
+		}
+	}
+	items = append(items, plan.resources...)
+
+	graph, err := newDependencyGraph(items)
+	if err != nil {
+		return nil, nil, withCode(codeResourceInvalid, err)
+	}
+	if err := templates.watch(items); err != nil {
+		return nil, nil, withCode(codeResourceInvalid, err)
+	}
+	if err := ordering.watch(items); err != nil {
+		return nil, nil, withCode(codeResourceInvalid, err)
+	}
+	glog.Infof("reloading the resources from: %s, %s", source, plan)
+
+	// step: apply the plan, replacing the changed resources; the files of those replaced are let go, so any the
+	// replacement no longer writes are collected
+	for _, rn := range plan.removed {
+		vault.Unwatch(rn)
+		watchers.stop(rn)
+		writtenFiles.forget(rn)
+	}
+	for _, x := range plan.changed {
+		vault.Unwatch(x.from)
+		watchers.stop(x.from)
+		writtenFiles.forget(x.from)
+	}
+	for _, rn := range plan.watched() {
+		if rn.Env && supervised != nil {
+			glog.Warningf("the resource: %s is passed to the supervised process, which only picks up new env resources on a restart", rn)
+		}
+		vault.Watch(rn)
+		watchers.start(vault, rn, options.triggerInterval)
+	}
+
+	options.resources.items = items
+	options.resourcesFromYAML = plan.resources
+	configGeneration++
+	metrics.ConfigGeneration(configGeneration)
+	metrics.ResourcesConfigured(resourceSpecs(items))
+	glog.Infof("applied the configuration generation: %d", configGeneration)
+
+	return plan, graph, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanResources(t *testing.T) {
+	newResource := func(kind, path string, update time.Duration) *VaultResource {
+		rn := defaultVaultResource()
+		rn.Resource = kind
+		rn.Path = path
+		rn.Update = update
+		return rn
+	}
+	kept := newResource("secret", "secret/kept", time.Hour)
+	changed := newResource("pki", "pki/issue/app", time.Hour)
+	changed.Options["common_name"] = "app.example.com"
+	removed := newResource("secret", "secret/removed", 0)
+	current := []*VaultResource{kept, changed, removed}
+
+	// step: the retry state of a watched resource doesn't count as a change
+	kept.Retries = 2
+
+	next := []*VaultResource{
+		newResource("secret", "secret/kept", time.Hour),
+		newResource("pki", "pki/issue/app", 2*time.Hour),
+		newResource("aws", "aws/creds/app", 0),
+	}
+	next[1].Options["common_name"] = "app.example.com"
+	next[1].Passphrase = "changed"
+
+	plan := planResources(current, next)
+	assert.Equal(t, []*VaultResource{next[2]}, plan.added)
+	assert.Equal(t, []*VaultResource{removed}, plan.removed)
+	if assert.Len(t, plan.changed, 1) {
+		assert.Equal(t, changed, plan.changed[0].from)
+		assert.Equal(t, []string{"passphrase: <unset> -> ********", "update: 1h0m0s -> 2h0m0s"}, plan.changed[0].fields)
+	}
+	assert.Equal(t, []*VaultResource{kept, next[1], next[2]}, plan.resources)
+	assert.Equal(t, `plan: 1 to add, 1 to change, 1 to remove
+  + aws:aws/creds/app (aws/creds/app)
+  ~ pki:pki/issue/app (pki/issue/app)
+      passphrase: <unset> -> ********
+      update: 1h0m0s -> 2h0m0s
+  - secret:secret/removed (secret/removed)`, plan.String())
+
+	assert.True(t, planResources(current, current).empty())
+}
+
+func TestFileWatchersStop(t *testing.T) {
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", TriggerFile: "/nonexistent/trigger"}
+	vault := &VaultService{refreshChannel: make(chan *VaultResource, 1)}
+
+	// step: a resource removed on a reload no longer has its trigger file polled
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		watchTrigger(vault, rn, 10*time.Millisecond, stop)
+		close(stopped)
+	}()
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the trigger watcher was not stopped")
+	}
+
+	watchers.start(vault, rn, time.Hour)
+	watchers.start(vault, rn, time.Hour)
+	assert.Len(t, watchers.stops, 1)
+	watchers.stop(rn)
+	assert.Empty(t, watchers.stops)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// failurePolicyFatal shuts the sidekick down once a resource has exhausted its retries
+	failurePolicyFatal = "fatal"
+	// failurePolicyWarn logs and stops retrying a resource once it has exhausted its retries, the default
+	failurePolicyWarn = "warn"
+	// failurePolicyKeepStale keeps the last good copy of a resource and carries on retrying at the backoff cap
+	failurePolicyKeepStale = "keep-stale"
+)
+
+// parseFailurePolicy checks the policy applied once a resource has exhausted its retries
+//	value		: the policy
+func parseFailurePolicy(value string) (string, error) {
+	switch value {
+	case failurePolicyFatal, failurePolicyWarn, failurePolicyKeepStale:
+		return value, nil
+	}
+
+	return "", fmt.Errorf("should be one of %s, %s or %s", failurePolicyFatal, failurePolicyWarn, failurePolicyKeepStale)
+}
+
+// failurePolicy returns the policy of the resource once it has exhausted its retries
+//	rn			: the resource
+func failurePolicy(rn *VaultResource) string {
+	if rn.OnFailure == "" {
+		return failurePolicyWarn
+	}
+
+	return rn.OnFailure
+}
+
+// maxRetries returns the retries of the resource, or the -max-retries flag when the resource doesn't set any;
+// zero retries the resource indefinitely
+//	rn			: the resource
+func maxRetries(rn *VaultResource) int {
+	if rn.MaxRetries > 0 {
+		return rn.MaxRetries
+	}
+
+	return options.maxRetries
+}
+
+// retriesExhausted checks if the resource has failed more times than it's permitted to retry
+//	rn			: the resource
+func retriesExhausted(rn *VaultResource) bool {
+	max := maxRetries(rn)
+
+	return max > 0 && rn.Retries > max
+}
+
+// givenUp checks if the resource is no longer retried, having exhausted its retries without keeping a stale copy
+//	rn			: the resource
+func givenUp(rn *VaultResource) bool {
+	return retriesExhausted(rn) && failurePolicy(rn) != failurePolicyKeepStale
+}
+
+// firstFetchTimeout returns the deadline for the first successful retrieval of the resource, or the -startup-timeout
+// flag when the resource doesn't set one; zero waits indefinitely
+//	rn			: the resource
+func firstFetchTimeout(rn *VaultResource) time.Duration {
+	if rn.FirstFetchTimeout > 0 {
+		return rn.FirstFetchTimeout
+	}
+
+	return options.startupTimeout
+}
+
+// watchFirstFetch shuts the sidekick down if the resource hasn't been retrieved within its deadline, rather than
+// an init container hanging forever on a misconfigured path
+//	rn			: the resource
+//	timeout		: the deadline for the first successful retrieval
+func watchFirstFetch(rn *VaultResource, timeout time.Duration) {
+	<-time.After(timeout)
+	if !statuses.pending(rn) {
+		return
+	}
+	glog.Errorf("resource: %s has not been retrieved within %s, shutting down", rn, timeout)
+	reportFailures(os.Stderr)
+	exit(1)
+}
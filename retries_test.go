@@ -0,0 +1,72 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	for _, x := range []string{"fatal", "warn", "keep-stale"} {
+		policy, err := parseFailurePolicy(x)
+		mustNoError(t, err)
+		assert.Equal(t, x, policy)
+	}
+	_, err := parseFailurePolicy("crash")
+	assert.Error(t, err)
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	defer func() { options.maxRetries = 0 }()
+	rn := &VaultResource{Retries: 3}
+	assert.False(t, retriesExhausted(rn))
+	assert.Equal(t, failurePolicyWarn, failurePolicy(rn))
+
+	// step: the flag is the default for resources without retries
+	options.maxRetries = 2
+	assert.True(t, retriesExhausted(rn))
+	assert.True(t, givenUp(rn))
+	rn.MaxRetries = 5
+	assert.False(t, retriesExhausted(rn))
+
+	rn.Retries = 6
+	rn.OnFailure = failurePolicyKeepStale
+	assert.True(t, retriesExhausted(rn))
+	assert.False(t, givenUp(rn))
+}
+
+func TestFirstFetchTimeout(t *testing.T) {
+	defer func() { options.startupTimeout = 0 }()
+	rn := &VaultResource{Resource: "secret", Path: "secret/db"}
+	assert.Equal(t, time.Duration(0), firstFetchTimeout(rn))
+
+	options.startupTimeout = time.Minute
+	assert.Equal(t, time.Minute, firstFetchTimeout(rn))
+	rn.FirstFetchTimeout = 30 * time.Second
+	assert.Equal(t, 30*time.Second, firstFetchTimeout(rn))
+
+	// step: only a watched resource yet to be retrieved is pending
+	assert.False(t, statuses.pending(rn))
+	statuses.add(rn)
+	defer statuses.remove(rn)
+	assert.True(t, statuses.pending(rn))
+	statuses.success(rn, time.Time{})
+	assert.False(t, statuses.pending(rn))
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// rotation is an in-flight rotation of a resource and its dependents
+type rotation struct {
+	// the resource which rotated
+	parent *VaultResource
+	// the dependents still to be re-fetched, in order
+	pending []*VaultResource
+	// the resources which have been written, along with the file they were written to
+	written   []*VaultResource
+	filenames []string
+}
+
+// dependencyGraph tracks which resources must be re-fetched when another resource rotates, so
+// the dependents are refreshed in order and a single combined exec is run at the end
+type dependencyGraph struct {
+	sync.Mutex
+	// a map of resource id to the resources which rotate with it, in the order they were declared
+	dependents map[string][]*VaultResource
+	// a map of the resources to the rotation they are taking part in
+	rotations map[*VaultResource]*rotation
+	// the resources which have been retrieved at least once
+	retrieved map[*VaultResource]bool
+}
+
+// newDependencyGraph builds the graph from the rotate-with options of the resources
+//	items		: the resources being watched
+func newDependencyGraph(items []*VaultResource) (*dependencyGraph, error) {
+	graph := &dependencyGraph{
+		dependents: make(map[string][]*VaultResource),
+		rotations:  make(map[*VaultResource]*rotation),
+		retrieved:  make(map[*VaultResource]bool),
+	}
+	ids := make(map[string]bool)
+	for _, rn := range items {
+		ids[rn.ID()] = true
+	}
+	for _, rn := range items {
+		for _, id := range rn.RotateWith {
+			id = strings.TrimSpace(id)
+			if !ids[id] {
+				return nil, fmt.Errorf("resource: %s rotates with an unknown resource: %s", rn, id)
+			}
+			if id == rn.ID() {
+				return nil, fmt.Errorf("resource: %s cannot rotate with itself", rn)
+			}
+			graph.dependents[id] = append(graph.dependents[id], rn)
+		}
+	}
+
+	return graph, nil
+}
+
+// handleSuccess processes a successful retrieval which is part of a rotation, returning false if the
+// resource is not involved in one and should be processed as normal
+//	vault		: the vault service used to re-fetch the dependents
+//	rn			: the resource which has been retrieved
+//	data		: the secret data of the resource
+func (g *dependencyGraph) handleSuccess(vault *VaultService, rn *VaultResource, data map[string]interface{}) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	// step: is the resource a dependent we are waiting on?
+	if rot, found := g.rotations[rn]; found && len(rot.pending) > 0 && rot.pending[0] == rn {
+		g.write(rot, rn, data)
+		rot.pending = rot.pending[1:]
+		delete(g.rotations, rn)
+		g.advance(vault, rot)
+		return true
+	}
+
+	firstRetrieval := !g.retrieved[rn]
+	g.retrieved[rn] = true
+
+	// step: the first retrieval is not a rotation, dependents are retrieved in their own right
+	dependents := g.dependents[rn.ID()]
+	if firstRetrieval || len(dependents) == 0 {
+		return false
+	}
+	if _, found := g.rotations[rn]; found {
+		glog.Warningf("resource: %s rotated again while its dependents are still being refreshed", rn)
+		return false
+	}
+
+	glog.Infof("resource: %s has rotated, refreshing %d dependent resources", rn, len(dependents))
+	rot := &rotation{parent: rn}
+	g.write(rot, rn, data)
+	for _, x := range dependents {
+		if _, found := g.rotations[x]; found {
+			continue
+		}
+		rot.pending = append(rot.pending, x)
+		g.rotations[x] = rot
+	}
+	g.rotations[rn] = rot
+	g.advance(vault, rot)
+
+	return true
+}
+
+// handleFailure skips a dependent which has exhausted its retries, so the rotation is not stalled
+//	vault		: the vault service used to re-fetch the dependents
+//	rn			: the resource which failed
+func (g *dependencyGraph) handleFailure(vault *VaultService, rn *VaultResource) {
+	g.Lock()
+	defer g.Unlock()
+
+	rot, found := g.rotations[rn]
+	if !found || len(rot.pending) == 0 || rot.pending[0] != rn {
+		return
+	}
+	if givenUp(rn) {
+		glog.Errorf("resource: %s failed to refresh after the rotation of: %s, skipping it", rn, rot.parent)
+		rot.pending = rot.pending[1:]
+		delete(g.rotations, rn)
+		g.advance(vault, rot)
+	}
+}
+
+// write writes out the content of a resource taking part in a rotation, deferring the exec
+func (g *dependencyGraph) write(rot *rotation, rn *VaultResource, data map[string]interface{}) {
+	filename, err := writeResource(rn, data)
+	if err == errUnchanged {
+		statuses.written(rn)
+		supervised.stage(rn, data)
+		hooks.rendered(rn, false)
+		return
+	}
+	if err != nil {
+		glog.Errorf("failed to write out the update, error: %s", err)
+		return
+	}
+	statuses.written(rn)
+	rot.written = append(rot.written, rn)
+	rot.filenames = append(rot.filenames, filename)
+	supervised.stage(rn, data)
+	hooks.rendered(rn, true)
+}
+
+// advance refreshes the next pending dependent or, once they are all done, runs the combined exec
+func (g *dependencyGraph) advance(vault *VaultService, rot *rotation) {
+	if len(rot.pending) > 0 {
+		glog.V(3).Infof("refreshing resource: %s following the rotation of: %s", rot.pending[0], rot.parent)
+		go vault.Refresh(rot.pending[0])
+		return
+	}
+	delete(g.rotations, rot.parent)
+	supervised.reload()
+
+	// step: run each distinct exec once, now everything has been written
+	executed := make(map[string]bool)
+	for i, rn := range rot.written {
+		if len(rn.ExecPath) == 0 {
+			if err := siblings.notify(rn); err != nil {
+				glog.Errorf("failed to notify the siblings of resource: %s, error: %s", rn, err)
+			}
+			continue
+		}
+		// step: an exec piped the content of its resource is run for each of them
+		command := strings.Join(rn.ExecPath, " ")
+		if rn.ExecStdin {
+			command += "\x00" + rn.ID()
+		}
+		if executed[command] {
+			continue
+		}
+		executed[command] = true
+		dispatchExec(rn, rot.filenames[i], func(error) {})
+	}
+}
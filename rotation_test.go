@@ -0,0 +1,41 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDependencyGraph(t *testing.T) {
+	ca := &VaultResource{Resource: "secret", Path: "secret/ca", Name: "ca"}
+	leaf := &VaultResource{Resource: "pki", Path: "pki/issue/leaf", RotateWith: []string{"ca"}}
+	other := &VaultResource{Resource: "pki", Path: "pki/issue/other", RotateWith: []string{"ca"}}
+
+	graph, err := newDependencyGraph([]*VaultResource{ca, leaf, other})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*VaultResource{leaf, other}, graph.dependents["ca"])
+	}
+
+	_, err = newDependencyGraph([]*VaultResource{leaf})
+	assert.Error(t, err)
+
+	ca.RotateWith = []string{"ca"}
+	_, err = newDependencyGraph([]*VaultResource{ca})
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// sensitiveKey checks if the name of a key is itself sensitive; it is if it matches one of the sensitive-keys
+// patterns or, when the mount of the resource lists the response keys vault leaves out of the hmac of its
+// audit log, it isn't one of them
+//	rn			: the resource holding the key
+//	key			: the name of the key
+func sensitiveKey(rn *VaultResource, key string) bool {
+	name := strings.ToLower(key)
+	for _, pattern := range options.sensitiveKeys {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	if tuning := cachedTuning(rn.Path); tuning != nil && len(tuning.nonHMACKeys) > 0 {
+		for _, x := range tuning.nonHMACKeys {
+			if x == key {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// keyName returns the name of a key as it can be logged or reported, masked if it's sensitive
+func keyName(rn *VaultResource, key string) string {
+	if sensitiveKey(rn, key) {
+		return maskedValue
+	}
+
+	return key
+}
+
+// keyNames returns the names of the keys as they can be logged or reported, sorted with the masked last
+func keyNames(rn *VaultResource, keys []string) []string {
+	var names []string
+	masked := 0
+	for _, key := range keys {
+		if sensitiveKey(rn, key) {
+			masked++
+			continue
+		}
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	if masked > 0 {
+		names = append(names, fmt.Sprintf("(%d masked)", masked))
+	}
+
+	return names
+}
+
+// changedKeys returns the keys which were added, removed or have a different value between two secrets
+//	previous	: the data of the previous secret
+//	current		: the data of the current secret
+func changedKeys(previous, current map[string]interface{}) []string {
+	var keys []string
+	for key, value := range current {
+		if x, found := previous[key]; !found || !reflect.DeepEqual(x, value) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range previous {
+		if _, found := current[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSensitiveKeys(t *testing.T) {
+	previous := options.sensitiveKeys
+	defer func() { options.sensitiveKeys = previous }()
+	options.sensitiveKeys = listOptions{"customer_*", "INTERNAL"}
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app"}
+	assert.Equal(t, "password", keyName(rn, "password"))
+	assert.Equal(t, maskedValue, keyName(rn, "customer_acme"))
+	assert.Equal(t, maskedValue, keyName(rn, "internal"))
+	assert.Equal(t, []string{"password", "username", "(2 masked)"},
+		keyNames(rn, []string{"username", "customer_acme", "password", "internal"}))
+}
+
+func TestSensitiveKeysAuditHints(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/sys/mounts/hinted/tune": map[string]interface{}{
+			"default_lease_ttl":            0,
+			"max_lease_ttl":                0,
+			"audit_non_hmac_response_keys": []string{"username"},
+		},
+	})
+	defer closer()
+	defer func() {
+		mountTunings.Lock()
+		delete(mountTunings.items, "hinted")
+		mountTunings.Unlock()
+	}()
+
+	rn := &VaultResource{Resource: "secret", Path: "hinted/app"}
+	// step: the hints only apply once the tuning of the mount has been read, which it is for a kv resource too
+	assert.Equal(t, "password", keyName(rn, "password"))
+	watched := &watchedResource{resource: rn, secret: &api.Secret{}}
+	service.applyTuning(watched)
+	assert.Equal(t, "username", keyName(rn, "username"))
+	assert.Equal(t, maskedValue, keyName(rn, "password"))
+	// step: the lease tuning is only kept on the leased resources
+	assert.Nil(t, watched.tuning)
+}
+
+func TestChangedKeys(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, changedKeys(nil, map[string]interface{}{"b": 1, "a": 2}))
+	assert.Equal(t, []string{"password", "removed"}, changedKeys(
+		map[string]interface{}{"username": "app", "password": "first", "removed": true},
+		map[string]interface{}{"username": "app", "password": "second"}))
+	assert.Empty(t, changedKeys(map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1}))
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// memoryStore holds the rendered files of the resources when they are served over a socket rather than
+// written to disk
+type memoryStore struct {
+	sync.RWMutex
+	// the content of the files, by the base of their filename
+	files map[string][]byte
+	// the mode of the files, by the base of their filename
+	modes map[string]os.FileMode
+}
+
+// memoryFiles are the files rendered in memory
+var memoryFiles = &memoryStore{files: make(map[string][]byte), modes: make(map[string]os.FileMode)}
+
+// servesFromMemory checks if the resources are served from memory rather than written to disk
+func servesFromMemory() bool {
+	return options.serveSocket != "" || options.fuseMount != ""
+}
+
+// store renders the content of a file into memory
+//	filename	: the filename the resource would have been written to
+//	mode		: the mode of the file
+//	write		: writes the content
+func (m *memoryStore) store(filename string, mode os.FileMode, write func(io.Writer) error) error {
+	content := &bytes.Buffer{}
+	if err := write(content); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.files[filepath.Base(filename)] = content.Bytes()
+	m.modes[filepath.Base(filename)] = mode
+
+	return nil
+}
+
+// mode returns the mode of a file
+func (m *memoryStore) mode(name string) os.FileMode {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.modes[name]
+}
+
+// get returns the content of a file
+func (m *memoryStore) get(name string) ([]byte, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	content, found := m.files[name]
+
+	return content, found
+}
+
+// names returns the names of the files held, sorted
+func (m *memoryStore) names() []string {
+	m.RLock()
+	defer m.RUnlock()
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// newServeMux creates the handlers serving the files; / lists the names of the files, /NAME returns one
+func newServeMux(store *memoryStore) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(req.URL.Path, "/")
+		if name == "" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(store.names())
+			return
+		}
+		content, found := store.get(name)
+		if !found {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(content)
+	})
+
+	return mux
+}
+
+// peerListener accepts only the connections of the uids allowed, checked from the credentials of the peer
+type peerListener struct {
+	net.Listener
+	// the uids allowed to connect
+	allowed map[int]bool
+}
+
+// Accept returns the next connection of an allowed uid, closing any others
+func (p *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := p.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			glog.Warningf("unable to read the credentials of a peer on the secrets socket, error: %s", err)
+			conn.Close()
+			continue
+		}
+		if !p.allowed[uid] {
+			glog.Warningf("refused a connection to the secrets socket from the uid: %d", uid)
+			conn.Close()
+			continue
+		}
+
+		return conn, nil
+	}
+}
+
+// parseUIDs parses the uids allowed to connect, the uid of the sidekick when none are given
+func parseUIDs(list []string) (map[int]bool, error) {
+	allowed := make(map[int]bool)
+	for _, x := range list {
+		uid, err := strconv.Atoi(x)
+		if err != nil || uid < 0 {
+			return nil, fmt.Errorf("the uid: %s is invalid, should be a positive integer", x)
+		}
+		allowed[uid] = true
+	}
+	if len(allowed) == 0 {
+		allowed[os.Getuid()] = true
+	}
+
+	return allowed, nil
+}
+
+// serveSecrets serves the files rendered in memory over a unix socket, to the uids allowed alone
+//	path		: the path of the unix socket
+//	uids		: the uids allowed to connect
+func serveSecrets(path string, uids []string) error {
+	allowed, err := parseUIDs(uids)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	// step: anyone can connect, the credentials of the peer decide who is answered
+	if err := os.Chmod(path, 0666); err != nil {
+		listener.Close()
+		return err
+	}
+	glog.Infof("serving the secrets from memory on the socket: %s", path)
+	go func() {
+		glog.Fatal(http.Serve(&peerListener{Listener: listener, allowed: allowed}, newServeMux(memoryFiles)))
+	}()
+
+	return nil
+}
+
+// validateServe checks the options allow the resources to be served from memory
+//	cfg			: the configuration of the sidekick
+func validateServe(cfg *config) error {
+	if cfg.serveSocket == "" && cfg.fuseMount == "" {
+		return nil
+	}
+	if cfg.oneShot {
+		return fmt.Errorf("the secrets can't be served from memory in one-shot mode")
+	}
+	if cfg.outputDir == stdoutOutput {
+		return fmt.Errorf("the secrets can't be both served from memory and written to stdout")
+	}
+	if _, err := parseUIDs(cfg.serveUIDs); err != nil {
+		return err
+	}
+	if cfg.resources != nil {
+		for _, rn := range cfg.resources.items {
+			if err := validateFileless(rn, "memory"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateFileless checks a resource doesn't use the options which need files on disk
+//	rn			: the resource
+//	where		: where the resource is written instead, for the error
+func validateFileless(rn *VaultResource, where string) error {
+	switch {
+	case rn.Versions > 0:
+		return fmt.Errorf("resource: %s, versions can't be kept of a resource written to %s", rn.ID(), where)
+	case rn.GracePeriod > 0:
+		return fmt.Errorf("resource: %s, the grace option can't be used with a resource written to %s", rn.ID(), where)
+	case rn.Layout == layoutDir:
+		return fmt.Errorf("resource: %s, the dir layout can't be used with a resource written to %s", rn.ID(), where)
+	}
+
+	return nil
+}
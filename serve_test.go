@@ -0,0 +1,103 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := &memoryStore{files: make(map[string][]byte), modes: make(map[string]os.FileMode)}
+	options.serveSocket = "/unused"
+	previous := memoryFiles
+	memoryFiles = store
+	defer func() { options.serveSocket, memoryFiles = "", previous }()
+	defer withOutputDir(t)()
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", Format: "json", Filename: "app.json", FileMode: 0600}
+	_, err := writeResource(rn, map[string]interface{}{"password": "secret"})
+	mustNoError(t, err)
+
+	content, found := store.get("app.json")
+	assert.True(t, found)
+	assert.Contains(t, string(content), `"password": "secret"`)
+	assert.Equal(t, []string{"app.json"}, store.names())
+	files, _ := ioutil.ReadDir(options.outputDir)
+	assert.Empty(t, files, "nothing should be written to disk")
+}
+
+func TestServeSecrets(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the credentials of a peer are only read on linux")
+	}
+	dir, err := ioutil.TempDir("", "serve")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	memoryFiles.store("app.json", 0600, func(w io.Writer) error {
+		_, err := w.Write([]byte("secret"))
+		return err
+	})
+
+	// step: the uid of the sidekick is allowed by default
+	socket := filepath.Join(dir, "secrets.sock")
+	mustNoError(t, serveSecrets(socket, nil))
+	client := newAdminClient(socket)
+	resp, err := client.Get("http://sidekick/app.json")
+	mustNoError(t, err)
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "secret", string(content))
+
+	resp, err = client.Get("http://sidekick/missing.json")
+	mustNoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// step: a uid not allowed has its connection closed
+	other := filepath.Join(dir, "other.sock")
+	mustNoError(t, serveSecrets(other, []string{strconv.Itoa(os.Getuid() + 1000)}))
+	_, err = newAdminClient(other).Get("http://sidekick/app.json")
+	assert.Error(t, err)
+}
+
+func TestValidateServe(t *testing.T) {
+	cfg := &config{serveSocket: "/tmp/secrets.sock", resources: &VaultResources{items: []*VaultResource{
+		{Resource: "secret", Path: "secret/app"},
+	}}}
+	assert.NoError(t, validateServe(cfg))
+
+	cfg.serveUIDs = listOptions{"app"}
+	assert.Error(t, validateServe(cfg))
+	cfg.serveUIDs = listOptions{"1000"}
+
+	cfg.resources.items[0].Versions = 2
+	assert.Error(t, validateServe(cfg))
+	cfg.resources.items[0].Versions = 0
+
+	cfg.oneShot = true
+	assert.Error(t, validateServe(cfg))
+}
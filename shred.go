@@ -0,0 +1,107 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// shredBlockSize is the size of the blocks of zeros a file is overwritten with
+const shredBlockSize = 32 * 1024
+
+// removeSecret removes a file or directory of secrets, overwriting the content of the files first
+// when -shred is enabled; symlinks are removed, never followed
+//	path		: the file or directory to remove
+func removeSecret(path string) error {
+	if options.shredFiles {
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			if err := shredFile(name, info.Size()); err != nil {
+				glog.Warningf("unable to overwrite the file: %s before removing it, error: %s", name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(path)
+}
+
+// shredFile overwrites the content of the file with zeros and syncs it to the disk; this is best effort, a
+// filesystem which copies on write or a journal may still hold the content, while on tmpfs the pages
+// holding the secret are overwritten
+//	filename	: the file to overwrite
+//	size		: the size of the file
+func shredFile(filename string, size int64) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, shredBlockSize)
+	for written := int64(0); written < size; {
+		n := size - written
+		if n > shredBlockSize {
+			n = shredBlockSize
+		}
+		if _, err := file.Write(zeros[:n]); err != nil {
+			file.Close()
+			return err
+		}
+		written += n
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// list returns the files written by the sidekick, sorted
+func (m *managedFiles) list() []string {
+	m.RLock()
+	defer m.RUnlock()
+	var files []string
+	for x := range m.files {
+		files = append(files, x)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// removeWrittenFiles removes every file written by the sidekick, so the secrets don't outlive it in the
+// volume; shredded first when -shred is enabled
+func removeWrittenFiles() {
+	for _, filename := range writtenFiles.list() {
+		if _, err := os.Lstat(filename); os.IsNotExist(err) {
+			continue
+		}
+		glog.V(3).Infof("removing the file: %s on exit", filename)
+		if err := removeSecret(filename); err != nil {
+			glog.Errorf("failed to remove the file: %s on exit, error: %s", filename, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShredFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shred")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "secret")
+	content := make([]byte, shredBlockSize+100)
+	for i := range content {
+		content[i] = 's'
+	}
+	mustNoError(t, ioutil.WriteFile(filename, content, 0600))
+	assert.NoError(t, shredFile(filename, int64(len(content))))
+
+	shredded, err := ioutil.ReadFile(filename)
+	mustNoError(t, err)
+	assert.Equal(t, make([]byte, len(content)), shredded)
+}
+
+func TestRemoveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shred")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	options.shredFiles = true
+	defer func() { options.shredFiles = false }()
+
+	// step: a symlink is removed without touching its target
+	target := filepath.Join(dir, "target")
+	mustNoError(t, ioutil.WriteFile(target, []byte("keep"), 0600))
+	version := filepath.Join(dir, "version")
+	mustNoError(t, os.Mkdir(version, 0700))
+	mustNoError(t, ioutil.WriteFile(filepath.Join(version, "secret"), []byte("secret"), 0600))
+	mustNoError(t, os.Symlink(target, filepath.Join(version, "link")))
+
+	assert.NoError(t, removeSecret(version))
+	_, err = os.Stat(version)
+	assert.True(t, os.IsNotExist(err))
+	content, err := ioutil.ReadFile(target)
+	mustNoError(t, err)
+	assert.Equal(t, "keep", string(content))
+}
+
+func TestRemoveWrittenFiles(t *testing.T) {
+	defer withOutputDir(t)()
+	written := writtenFiles
+	writtenFiles = newManagedFiles()
+	defer func() { writtenFiles = written }()
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", Format: "json", Filename: "app", FileMode: 0600}
+	_, err := writeResource(rn, map[string]interface{}{"password": "secret"})
+	mustNoError(t, err)
+	other := filepath.Join(options.outputDir, "other")
+	mustNoError(t, ioutil.WriteFile(other, nil, 0600))
+
+	removeWrittenFiles()
+	_, err = os.Stat(filepath.Join(options.outputDir, "app"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(other)
+	assert.NoError(t, err, "files not written by the sidekick should be left")
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// defaultSignal is the signal sent to sibling processes when the resource doesn't give one
+	defaultSignal = "HUP"
+	// restartedAtAnnotation is the annotation of the pod template patched to restart a workload
+	restartedAtAnnotation = "vault-sidekick.io/restartedAt"
+)
+
+// workloadKinds are the kinds of workload which can be restarted, and the collection of each
+var workloadKinds = map[string]string{
+	"deployment":  "deployments",
+	"statefulset": "statefulsets",
+	"daemonset":   "daemonsets",
+}
+
+// siblingNotifier signals the sibling processes and restarts the workloads of resources when they rotate
+type siblingNotifier struct {
+	sync.Mutex
+	// the resources which have been rendered at least once
+	rendered map[string]bool
+	// the client of the kubernetes api, created on the first restart
+	kube *kubeClient
+}
+
+// siblings notifies the siblings of the resources
+var siblings = &siblingNotifier{rendered: make(map[string]bool)}
+
+// parseWorkload parses a workload given as kind/name, i.e. deployment/web
+//	workload	: the workload
+func parseWorkload(workload string) (string, string, error) {
+	items := strings.SplitN(workload, "/", 2)
+	if len(items) != 2 || items[1] == "" {
+		return "", "", fmt.Errorf("the workload should be given as kind/name")
+	}
+	collection, found := workloadKinds[strings.ToLower(items[0])]
+	if !found {
+		return "", "", fmt.Errorf("unsupported workload kind: %s, should be deployment, statefulset or daemonset", items[0])
+	}
+
+	return collection, items[1], nil
+}
+
+// notify signals the sibling process and restarts the workload of the resource, if any; the first render of
+// each resource is skipped, the siblings having started with it
+//	rn			: the vault resource which has been rendered
+func (s *siblingNotifier) notify(rn *VaultResource) error {
+	if rn.SignalProcess == "" && rn.Restart == "" {
+		return nil
+	}
+	s.Lock()
+	first := !s.rendered[rn.ID()]
+	s.rendered[rn.ID()] = true
+	s.Unlock()
+	if first {
+		return nil
+	}
+
+	if rn.SignalProcess != "" && rn.RestartOnChange {
+		if err := terminateSiblings(rn, options.childKillTimeout); err != nil {
+			return err
+		}
+	} else if rn.SignalProcess != "" {
+		if err := signalSiblings(rn); err != nil {
+			return err
+		}
+	}
+	if rn.Restart != "" {
+		if err := s.restart(rn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signalSiblings sends the signal of the resource to the sibling processes named by it
+//	rn			: the vault resource
+func signalSiblings(rn *VaultResource) error {
+	name := rn.Signal
+	if name == "" {
+		name = defaultSignal
+	}
+	signal, err := parseSignal(name)
+	if err != nil {
+		return err
+	}
+	pids, err := findProcesses(rn.SignalProcess)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("no process named: %s was found, is shareProcessNamespace enabled on the pod?", rn.SignalProcess)
+	}
+	for _, pid := range pids {
+		if execDryRun(rn) {
+			glog.Infof("dry run, resource: %s would send the signal: %s to the process: %s, pid: %d", rn, name, rn.SignalProcess, pid)
+			continue
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		glog.V(3).Infof("sending the signal: %s to the process: %s, pid: %d for resource: %s", name, rn.SignalProcess, pid, rn)
+		if err := process.Signal(signal); err != nil {
+			return fmt.Errorf("unable to signal the process: %s, pid: %d, error: %s", rn.SignalProcess, pid, err)
+		}
+	}
+
+	return nil
+}
+
+// terminateSiblings asks the sibling processes named by the resource to exit, killing any which haven't by the
+// kill timeout, for their containers to be restarted with the new secrets
+//	rn			: the vault resource
+//	killTimeout	: how long the processes are given to exit before they're killed
+func terminateSiblings(rn *VaultResource, killTimeout time.Duration) error {
+	pids, err := findProcesses(rn.SignalProcess)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("no process named: %s was found, is shareProcessNamespace enabled on the pod?", rn.SignalProcess)
+	}
+	var processes []*os.Process
+	for _, pid := range pids {
+		if execDryRun(rn) {
+			glog.Infof("dry run, resource: %s would restart the process: %s, pid: %d", rn, rn.SignalProcess, pid)
+			continue
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		glog.V(3).Infof("terminating the process: %s, pid: %d for resource: %s", rn.SignalProcess, pid, rn)
+		if err := process.Signal(syscall.SIGTERM); err != nil {
+			return fmt.Errorf("unable to terminate the process: %s, pid: %d, error: %s", rn.SignalProcess, pid, err)
+		}
+		processes = append(processes, process)
+	}
+
+	// step: wait for the processes to exit, killing those still running at the kill timeout
+	deadline := time.Now().Add(killTimeout)
+	for _, process := range processes {
+		for processRunning(process) && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !processRunning(process) {
+			continue
+		}
+		glog.Warningf("the process: %s, pid: %d did not exit within %s, killing it", rn.SignalProcess, process.Pid, killTimeout)
+		if err := process.Kill(); err != nil {
+			return fmt.Errorf("unable to kill the process: %s, pid: %d, error: %s", rn.SignalProcess, process.Pid, err)
+		}
+	}
+
+	return nil
+}
+
+// processRunning checks if the process is still running, which a null signal can be delivered to
+func processRunning(process *os.Process) bool {
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// restart triggers a rolling restart of the workload of the resource, patching the annotations of its pod template
+//	rn			: the vault resource
+func (s *siblingNotifier) restart(rn *VaultResource) error {
+	collection, name, err := parseWorkload(rn.Restart)
+	if err != nil {
+		return err
+	}
+	if execDryRun(rn) {
+		glog.Infof("dry run, resource: %s would restart the workload: %s", rn, rn.Restart)
+		return nil
+	}
+	s.Lock()
+	if s.kube == nil {
+		if s.kube, err = newKubeClient(); err != nil {
+			s.Unlock()
+			return err
+		}
+	}
+	kube := s.kube
+	s.Unlock()
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						restartedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+	glog.V(3).Infof("restarting the workload: %s for resource: %s", rn.Restart, rn)
+	path := fmt.Sprintf("/apis/apps/v1/namespaces/%s/%s/%s", kube.namespace, collection, name)
+	if err := kube.do(http.MethodPatch, path, "application/strategic-merge-patch+json", patch, nil); err != nil {
+		return wrapError(codeKubeRequest, err, "unable to restart the workload: %s, error: %s", rn.Restart)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetSiblingOptions(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+	var items VaultResources
+	assert.Nil(t, items.Set("pki:pki/issue/app:common_name=app,signal-process=nginx,signal=USR1,restart=deployment/web"))
+	assert.Equal(t, "nginx", items.items[0].SignalProcess)
+	assert.Equal(t, "USR1", items.items[0].Signal)
+	assert.Equal(t, "deployment/web", items.items[0].Restart)
+
+	assert.NotNil(t, items.Set("secret:secret/db:signal=NOPE"))
+	assert.NotNil(t, items.Set("secret:secret/db:restart=web"))
+	assert.NotNil(t, items.Set("secret:secret/db:restart=job/web"))
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"HUP", "SIGHUP", "hup", "1"} {
+		signal, err := parseSignal(name)
+		mustNoError(t, err)
+		assert.Equal(t, 1, int(signal))
+	}
+	_, err := parseSignal("SIGNOPE")
+	assert.NotNil(t, err)
+}
+
+func TestParseWorkload(t *testing.T) {
+	collection, name, err := parseWorkload("StatefulSet/db")
+	mustNoError(t, err)
+	assert.Equal(t, "statefulsets", collection)
+	assert.Equal(t, "db", name)
+
+	for _, workload := range []string{"deployment", "deployment/", "pod/web"} {
+		_, _, err := parseWorkload(workload)
+		assert.NotNil(t, err, workload)
+	}
+}
+
+func TestSiblingsRestart(t *testing.T) {
+	patches := make(chan map[string]interface{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.Equal(t, "/apis/apps/v1/namespaces/default/deployments/web", r.URL.Path)
+		assert.Equal(t, "application/strategic-merge-patch+json", r.Header.Get("Content-Type"))
+		patch := make(map[string]interface{})
+		mustNoError(t, json.NewDecoder(r.Body).Decode(&patch))
+		patches <- patch
+	}))
+	defer server.Close()
+
+	notifier := &siblingNotifier{
+		rendered: make(map[string]bool),
+		kube:     &kubeClient{host: server.URL, namespace: "default", client: http.DefaultClient},
+	}
+	rn := &VaultResource{Resource: "secret", Path: "secret/db", Restart: "deployment/web"}
+
+	// step: the first render is skipped, the workload having started with it
+	mustNoError(t, notifier.notify(rn))
+	assert.Equal(t, 0, len(patches))
+
+	mustNoError(t, notifier.notify(rn))
+	patch := <-patches
+	annotations := patch["spec"].(map[string]interface{})["template"].(map[string]interface{})["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	assert.NotEmpty(t, annotations[restartedAtAnnotation])
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// sidekickState is the token and leases persisted across restarts
+type sidekickState struct {
+	// the vault token
+	Token string `json:"token,omitempty"`
+	// the leases of the resources, by the id of the resource
+	Leases map[string]*persistedLease `json:"leases,omitempty"`
+}
+
+// persistedLease is the lease of a resource persisted across restarts
+type persistedLease struct {
+	// the path of the resource, so a lease isn't resumed by a resource which has changed
+	Path string `json:"path"`
+	// the id of the lease
+	LeaseID string `json:"lease_id"`
+	// the duration of the lease in seconds
+	LeaseDuration int `json:"lease_duration"`
+	// when the lease was last retrieved or renewed
+	Updated time.Time `json:"updated"`
+	// when the lease expires
+	Expires time.Time `json:"expires"`
+	// the data of the secret
+	Data map[string]interface{} `json:"data"`
+}
+
+// stateTracker persists the vault token and the renewable leases of the resources to an encrypted file, so a
+// restarted sidekick resumes renewing the credentials it had rather than issuing a fresh set
+type stateTracker struct {
+	sync.Mutex
+	// the path of the state file, empty when the state isn't persisted
+	path string
+	// the aes-256 key the state is encrypted with
+	key []byte
+	// the state
+	state sidekickState
+}
+
+// state is the persisted state of the sidekick
+var state = &stateTracker{}
+
+// open loads the state file, if it exists, and persists any changes to it from here on
+//	path		: the path of the state file
+//	keyFile		: the file holding the key the state is encrypted with
+func (s *stateTracker) open(path, keyFile string) error {
+	key, err := readStateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.path, s.key = path, key
+	s.state = sidekickState{Leases: make(map[string]*persistedLease)}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := decryptState(key, content)
+	if err != nil {
+		// step: a state we can't read is started afresh rather than refusing to start
+		glog.Warningf("unable to decrypt the state file: %s, starting afresh, error: %s", path, err)
+		return nil
+	}
+	if err := json.Unmarshal(plain, &s.state); err != nil {
+		glog.Warningf("unable to decode the state file: %s, starting afresh, error: %s", path, err)
+		return nil
+	}
+	if s.state.Leases == nil {
+		s.state.Leases = make(map[string]*persistedLease)
+	}
+	glog.Infof("loaded the state file: %s, %d leases", path, len(s.state.Leases))
+
+	return nil
+}
+
+// token returns the persisted vault token, empty if there isn't one
+func (s *stateTracker) token() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.state.Token
+}
+
+// setToken records the vault token
+//	token		: the vault token
+func (s *stateTracker) setToken(token string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.path == "" || s.state.Token == token {
+		return
+	}
+	s.state.Token = token
+	s.save()
+}
+
+// record records the lease of a resource once retrieved or renewed, only renewable leases of resources with the
+// renew option being worth resuming
+//	rn			: the watched resource
+func (s *stateTracker) record(rn *watchedResource) {
+	s.Lock()
+	defer s.Unlock()
+	if s.path == "" {
+		return
+	}
+	if !rn.resource.Renewable || rn.secret == nil || !rn.secret.Renewable || rn.secret.LeaseID == "" {
+		if _, found := s.state.Leases[rn.resource.ID()]; found {
+			delete(s.state.Leases, rn.resource.ID())
+			s.save()
+		}
+		return
+	}
+	s.state.Leases[rn.resource.ID()] = &persistedLease{
+		Path:          rn.resource.Path,
+		LeaseID:       rn.secret.LeaseID,
+		LeaseDuration: rn.secret.LeaseDuration,
+		Updated:       rn.lastUpdated,
+		Expires:       rn.leaseExpireTime,
+		Data:          rn.secret.Data,
+	}
+	s.save()
+}
+
+// forget removes the lease of a resource which is no longer watched
+//	rn			: the resource
+func (s *stateTracker) forget(rn *VaultResource) {
+	s.Lock()
+	defer s.Unlock()
+	if _, found := s.state.Leases[rn.ID()]; !found || s.path == "" {
+		return
+	}
+	delete(s.state.Leases, rn.ID())
+	s.save()
+}
+
+// restore fills in the watched resource from its persisted lease, false if there isn't one still valid
+//	rn			: the watched resource
+func (s *stateTracker) restore(rn *watchedResource) bool {
+	s.Lock()
+	defer s.Unlock()
+	lease, found := s.state.Leases[rn.resource.ID()]
+	if !found || !rn.resource.Renewable || lease.Path != rn.resource.Path || !time.Now().Before(lease.Expires) {
+		return false
+	}
+	rn.secret = &api.Secret{
+		LeaseID:       lease.LeaseID,
+		LeaseDuration: lease.LeaseDuration,
+		Renewable:     true,
+		Data:          lease.Data,
+	}
+	rn.lastUpdated = lease.Updated
+	rn.leaseExpireTime = lease.Expires
+
+	return true
+}
+
+// save writes the state to the file, encrypted, replacing the previous one
+func (s *stateTracker) save() {
+	plain, err := json.Marshal(s.state)
+	if err != nil {
+		glog.Errorf("unable to encode the state, error: %s", err)
+		return
+	}
+	content, err := encryptState(s.key, plain)
+	if err != nil {
+		glog.Errorf("unable to encrypt the state, error: %s", err)
+		return
+	}
+	temp := stateTempFile(s.path)
+	if err := ioutil.WriteFile(temp, content, 0600); err != nil {
+		glog.Errorf("unable to write the state file: %s, error: %s", s.path, err)
+		return
+	}
+	if err := os.Rename(temp, s.path); err != nil {
+		os.Remove(temp)
+		glog.Errorf("unable to write the state file: %s, error: %s", s.path, err)
+	}
+}
+
+// stateTempFile is the file the state is written to before being renamed over the state file
+func stateTempFile(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+}
+
+// files returns the state file and the file it's written through, none when the state isn't persisted
+func (s *stateTracker) files() []string {
+	s.Lock()
+	defer s.Unlock()
+	if s.path == "" {
+		return nil
+	}
+
+	return []string{s.path, stateTempFile(s.path)}
+}
+
+// readStateKey reads the aes-256 key the state is encrypted with, 32 bytes either raw or base64 encoded
+//	path		: the path of the key file
+func readStateKey(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 32 {
+		return content, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("the state key: %s should hold 32 bytes, raw or base64 encoded", path)
+	}
+
+	return key, nil
+}
+
+// encryptState encrypts the state with aes-gcm, prefixing the nonce
+//	key			: the aes-256 key
+//	plain		: the state
+func encryptState(key, plain []byte) ([]byte, error) {
+	aead, err := newStateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// decryptState decrypts the state encrypted by encryptState
+//	key			: the aes-256 key
+//	content		: the encrypted state
+func decryptState(key, content []byte) ([]byte, error) {
+	aead, err := newStateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < aead.NonceSize() {
+		return nil, fmt.Errorf("the state is truncated")
+	}
+
+	return aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], nil)
+}
+
+// newStateCipher creates the aes-gcm cipher of the key
+func newStateCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
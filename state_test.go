@@ -0,0 +1,98 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "state.key")
+	mustNoError(t, ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, 32))+"\n"), 0600))
+	path := filepath.Join(dir, "state")
+
+	first := &stateTracker{}
+	mustNoError(t, first.open(path, keyFile))
+	first.setToken("s.token")
+	rn := &VaultResource{Resource: "database", Path: "database/creds/app", Renewable: true}
+	watched := &watchedResource{
+		resource:        rn,
+		lastUpdated:     time.Now(),
+		leaseExpireTime: time.Now().Add(time.Hour),
+		secret: &api.Secret{
+			LeaseID:       "database/creds/app/1",
+			LeaseDuration: 3600,
+			Renewable:     true,
+			Data:          map[string]interface{}{"username": "v-app-1", "password": "secret"},
+		},
+	}
+	first.record(watched)
+
+	// step: the state is encrypted at rest
+	content, err := ioutil.ReadFile(path)
+	mustNoError(t, err)
+	assert.NotContains(t, string(content), "v-app-1")
+	info, err := os.Stat(path)
+	mustNoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	second := &stateTracker{}
+	mustNoError(t, second.open(path, keyFile))
+	assert.Equal(t, "s.token", second.token())
+	restored := &watchedResource{resource: rn}
+	if assert.True(t, second.restore(restored)) {
+		assert.Equal(t, "database/creds/app/1", restored.secret.LeaseID)
+		assert.Equal(t, "v-app-1", restored.secret.Data["username"])
+	}
+	// step: a resource whose path has changed doesn't resume the lease
+	assert.False(t, second.restore(&watchedResource{resource: &VaultResource{Resource: "database", Path: "database/creds/other", Renewable: true, Name: rn.ID()}}))
+
+	second.forget(rn)
+	third := &stateTracker{}
+	mustNoError(t, third.open(path, keyFile))
+	assert.False(t, third.restore(&watchedResource{resource: rn}))
+
+	// step: a state encrypted under another key is started afresh
+	mustNoError(t, ioutil.WriteFile(keyFile, []byte("0123456789abcdef0123456789abcdef"), 0600))
+	fourth := &stateTracker{}
+	mustNoError(t, fourth.open(path, keyFile))
+	assert.Equal(t, "", fourth.token())
+}
+
+func TestReadStateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "state.key")
+
+	mustNoError(t, ioutil.WriteFile(keyFile, []byte("too short"), 0600))
+	_, err = readStateKey(keyFile)
+	assert.Error(t, err)
+	_, err = readStateKey(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+	"github.com/golang/glog"
+)
+
+const (
+	// resourceStatePending is a resource which has not been retrieved yet
+	resourceStatePending = "pending"
+	// resourceStateOK is a resource which was last retrieved successfully
+	resourceStateOK = "ok"
+	// resourceStateFailed is a resource whose last retrieval failed
+	resourceStateFailed = "failed"
+)
+
+// resourceStatus is the current state of a watched resource
+type resourceStatus struct {
+	// the id of the resource
+	ID string `json:"id"`
+	// the type of the resource
+	Resource string `json:"resource"`
+	// the path of the resource
+	Path string `json:"path"`
+	// the state of the resource
+	State string `json:"state"`
+	// the number of retries since the last success
+	Retries int `json:"retries"`
+	// the last time the resource was retrieved successfully
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	// the last time the resource was written out, or found unchanged on disk
+	LastWritten time.Time `json:"last_written,omitempty"`
+	// whether the resource has exhausted its retries, the last good copy being kept
+	Stale bool `json:"stale,omitempty"`
+	// the last time the resource failed to be retrieved
+	LastFailure time.Time `json:"last_failure,omitempty"`
+	// the last error encountered
+	LastError string `json:"last_error,omitempty"`
+	// the stable code of the last error encountered
+	LastErrorCode string `json:"last_error_code,omitempty"`
+	// the keys of the secret, those sensitive masked
+	Keys []string `json:"keys,omitempty"`
+	// the keys which changed on the last retrieval, those sensitive masked
+	ChangedKeys []string `json:"changed_keys,omitempty"`
+	// the time the resource is next due to be renewed
+	NextRenewal time.Time `json:"next_renewal,omitempty"`
+	// the time the lease of the resource expires
+	LeaseExpiry time.Time `json:"lease_expiry,omitempty"`
+}
+
+// sidekickStatus is the current state of the sidekick
+type sidekickStatus struct {
+	// the version of the sidekick
+	Version string `json:"version"`
+	// the time the status was taken
+	Time time.Time `json:"time"`
+	// the time the vault token expires, if known
+	TokenExpiry time.Time `json:"token_expiry,omitempty"`
+	// the status of each of the resources
+	Resources []resourceStatus `json:"resources"`
+}
+
+// statusRegistry tracks the state of the watched resources
+type statusRegistry struct {
+	sync.RWMutex
+	// a map of the resources to their status
+	resources map[*VaultResource]*resourceStatus
+	// the time the vault token expires
+	tokenExpiry time.Time
+}
+
+// statuses is the state of the resources watched by the sidekick
+var statuses = &statusRegistry{resources: make(map[*VaultResource]*resourceStatus)}
+
+// get returns the status of the resource, creating it if required; the lock must be held
+func (s *statusRegistry) get(rn *VaultResource) *resourceStatus {
+	x, found := s.resources[rn]
+	if !found {
+		x = &resourceStatus{
+			ID:       rn.ID(),
+			Resource: rn.Resource,
+			Path:     rn.Path,
+			State:    resourceStatePending,
+		}
+		s.resources[rn] = x
+	}
+
+	return x
+}
+
+// add registers a resource as being watched
+func (s *statusRegistry) add(rn *VaultResource) {
+	s.Lock()
+	defer s.Unlock()
+	s.get(rn)
+}
+
+// remove stops tracking a resource which is no longer watched
+func (s *statusRegistry) remove(rn *VaultResource) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.resources, rn)
+}
+
+// success records a successful retrieval of the resource
+func (s *statusRegistry) success(rn *VaultResource, leaseExpiry time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	x := s.get(rn)
+	x.State = resourceStateOK
+	x.Retries = 0
+	x.Stale = false
+	x.LastSuccess = time.Now()
+	x.LeaseExpiry = leaseExpiry
+}
+
+// written records the resource has been written out
+func (s *statusRegistry) written(rn *VaultResource) {
+	s.Lock()
+	defer s.Unlock()
+	s.get(rn).LastWritten = time.Now()
+}
+
+// stale records the resource has exhausted its retries, the last good copy being kept
+func (s *statusRegistry) stale(rn *VaultResource) {
+	s.Lock()
+	defer s.Unlock()
+	s.get(rn).Stale = true
+}
+
+// failure records a failed retrieval of the resource
+func (s *statusRegistry) failure(rn *VaultResource, err error) {
+	s.Lock()
+	defer s.Unlock()
+	x := s.get(rn)
+	x.State = resourceStateFailed
+	x.Retries = rn.Retries
+	x.LastFailure = time.Now()
+	x.LastError = err.Error()
+	x.LastErrorCode = errorCode(err)
+}
+
+// keys records the key names of the secret of the resource, as they can be reported
+func (s *statusRegistry) keys(rn *VaultResource, keys, changed []string) {
+	s.Lock()
+	defer s.Unlock()
+	x := s.get(rn)
+	x.Keys = keys
+	x.ChangedKeys = changed
+}
+
+// pending checks if the resource is still watched and has yet to be retrieved successfully
+func (s *statusRegistry) pending(rn *VaultResource) bool {
+	s.RLock()
+	defer s.RUnlock()
+	x, found := s.resources[rn]
+
+	return found && x.LastSuccess.IsZero()
+}
+
+// leaseExpiry returns when the lease of the resource last retrieved expires, the zero time if unknown
+func (s *statusRegistry) leaseExpiry(rn *VaultResource) time.Time {
+	s.RLock()
+	defer s.RUnlock()
+	if x, found := s.resources[rn]; found {
+		return x.LeaseExpiry
+	}
+
+	return time.Time{}
+}
+
+// scheduled records when the resource is next due to be renewed
+func (s *statusRegistry) scheduled(rn *VaultResource, next time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.get(rn).NextRenewal = next
+	metrics.ResourceNextRenewal(rn.ID(), next)
+}
+
+// token records the expiry of the vault token
+func (s *statusRegistry) token(ttl time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.tokenExpiry = time.Now().Add(ttl)
+}
+
+// snapshot returns a copy of the current status, sorted by resource id
+func (s *statusRegistry) snapshot() *sidekickStatus {
+	s.RLock()
+	defer s.RUnlock()
+
+	status := &sidekickStatus{
+		Version:     release,
+		Time:        time.Now(),
+		TokenExpiry: s.tokenExpiry,
+	}
+	for _, x := range s.resources {
+		status.Resources = append(status.Resources, *x)
+	}
+	sort.Slice(status.Resources, func(i, j int) bool {
+		return status.Resources[i].ID < status.Resources[j].ID
+	})
+
+	return status
+}
+
+// reportFailures writes the resources which failed, with their last error, as the exit report
+//	w			: the writer to report to
+func reportFailures(w io.Writer) {
+	for _, x := range statuses.snapshot().Resources {
+		if x.State == resourceStateFailed {
+			fmt.Fprintf(w, "[error] resource: %s failed, %s\n", x.ID, x.LastError)
+		}
+	}
+}
+
+// statusLines renders the status as the lines of a table, followed by the full last error of each resource which has one
+func statusLines(status *sidekickStatus) []string {
+	var buf bytes.Buffer
+	renderStatus(&buf, status)
+	for _, x := range status.Resources {
+		if x.LastError != "" {
+			fmt.Fprintf(&buf, "resource: %s, last error (%s ago): %s\n", x.ID,
+				status.Time.Sub(x.LastFailure).Round(time.Second), x.LastError)
+		}
+	}
+
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// logStatus writes the status to the log, line by line, so it can be read without access to the admin api
+func logStatus(status *sidekickStatus) {
+	for _, line := range statusLines(status) {
+		glog.Info(line)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusLines(t *testing.T) {
+	now := time.Now()
+	status := &sidekickStatus{
+		Version: "v0.0.0",
+		Time:    now,
+		Resources: []resourceStatus{
+			{ID: "db", Resource: "database", State: resourceStateOK, LastSuccess: now.Add(-time.Minute), NextRenewal: now.Add(time.Hour)},
+			{ID: "web", Resource: "pki", State: resourceStateFailed, Retries: 3, LastFailure: now.Add(-10 * time.Second),
+				LastError: "permission denied, the token is missing the policy granting access to pki/issue/web"},
+		},
+	}
+
+	lines := statusLines(status)
+	if !assert.Len(t, lines, 6) {
+		return
+	}
+	assert.Contains(t, lines[0], "2 resources")
+	assert.Contains(t, lines[3], "in 1h0m0s")
+	assert.Contains(t, lines[4], "failed")
+	assert.Equal(t, "resource: web, last error (10s ago): permission denied, the token is missing the policy granting access to pki/issue/web", lines[5])
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+const (
+	// stdoutFilename is the filename of a resource written to stdout
+	stdoutFilename = "-"
+	// stdoutOutput is the output directory writing every resource to stdout
+	stdoutOutput = "stdout"
+)
+
+// isStdout checks if the file is written to stdout, including the files a format derives from the
+// filename, i.e. -.crt and -.key
+func isStdout(filename string) bool {
+	if filename == stdoutFilename {
+		return true
+	}
+	for _, x := range []string{".", "-", "/"} {
+		if strings.HasPrefix(filename, stdoutFilename+x) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writesToStdout checks if any of the resources are written to stdout
+func writesToStdout(cfg *config) bool {
+	if cfg.outputDir == stdoutOutput {
+		return true
+	}
+	if cfg.resources != nil {
+		for _, rn := range cfg.resources.items {
+			if rn.Filename == stdoutFilename {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// validateStdout checks the options allow the resources to be written to stdout, and sends the logs to
+// stderr so stdout holds nothing but the secrets
+//	cfg			: the configuration of the sidekick
+func validateStdout(cfg *config) error {
+	if !writesToStdout(cfg) {
+		return nil
+	}
+	if !cfg.oneShot {
+		return fmt.Errorf("writing the resources to stdout is only supported in one-shot mode")
+	}
+	if cfg.resources != nil {
+		for _, rn := range cfg.resources.items {
+			if cfg.outputDir != stdoutOutput && rn.Filename != stdoutFilename {
+				continue
+			}
+			if err := validateFileless(rn, "stdout"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flag.Set("logtostderr", "true")
+}
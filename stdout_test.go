@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStdout(t *testing.T) {
+	for _, x := range []string{"-", "-.crt", "-.key", "--bundle.pem", "-/data.json"} {
+		assert.True(t, isStdout(x), "filename: %s", x)
+	}
+	for _, x := range []string{"", "/etc/secrets/-", "-file", "app.json"} {
+		assert.False(t, isStdout(x), "filename: %s", x)
+	}
+}
+
+func TestValidateStdout(t *testing.T) {
+	cfg := &config{outputDir: "/etc/secrets", resources: &VaultResources{items: []*VaultResource{
+		{Resource: "secret", Path: "secret/app", Filename: "-"},
+	}}}
+	assert.Error(t, validateStdout(cfg), "stdout needs one-shot mode")
+	cfg.oneShot = true
+	assert.NoError(t, validateStdout(cfg))
+	defer flag.Set("logtostderr", "false")
+
+	cfg.resources.items[0].Versions = 2
+	assert.Error(t, validateStdout(cfg))
+	cfg.resources.items[0].Versions = 0
+
+	cfg.outputDir = stdoutOutput
+	cfg.resources.items = append(cfg.resources.items, &VaultResource{Resource: "secret", Path: "secret/other", Layout: layoutDir})
+	assert.Error(t, validateStdout(cfg))
+}
+
+func TestWriteResourceStdout(t *testing.T) {
+	defer withOutputDir(t)()
+	reader, writer, err := os.Pipe()
+	mustNoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", Format: "env", Filename: "-", FileMode: 0600}
+	filename, err := writeResource(rn, map[string]interface{}{"password": "secret"})
+	os.Stdout = stdout
+	writer.Close()
+	mustNoError(t, err)
+	assert.Equal(t, stdoutFilename, filename)
+
+	content, err := ioutil.ReadAll(reader)
+	mustNoError(t, err)
+	assert.Equal(t, "PASSWORD='secret'\n", string(content))
+	files, _ := ioutil.ReadDir(options.outputDir)
+	assert.Empty(t, files, "nothing should be written to the output directory")
+}
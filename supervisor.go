@@ -0,0 +1,347 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// childRestart re-executes the supervised process when its secrets rotate
+	childRestart = "restart"
+	// childSignal signals the supervised process when its secrets rotate, leaving its environment as it was
+	childSignal = "signal"
+	// childNone leaves the supervised process alone when its secrets rotate
+	childNone = "none"
+	// envOnly is the value of the env option which passes the secret to the supervised process alone
+	envOnly = "only"
+)
+
+// supervisor runs a child process with the secrets of the env resources in its environment, re-executing
+// it with the refreshed environment whenever they rotate; a process's environment can't be changed from
+// outside, so a restart is the only way an env only application picks up a rotation
+type supervisor struct {
+	sync.Mutex
+	// the command and arguments of the child
+	command []string
+	// how long the child is given to exit before it's killed
+	killTimeout time.Duration
+	// what is done to the child when the environment changes, restart, signal or none
+	onChange string
+	// the signal sent to the child when on change is signal
+	signal syscall.Signal
+	// the env resources in the order they were declared
+	resources []*VaultResource
+	// the environment variables of each of the env resources
+	env map[*VaultResource]map[string]string
+	// the env resources which have not been rendered yet
+	pending map[*VaultResource]bool
+	// whether the environment has changed since the child was started
+	changed bool
+	// whether a resource the child restarts with has changed
+	restart bool
+	// the signals queued for the child by the resources it reloads with
+	signals []syscall.Signal
+	// the running child
+	cmd *exec.Cmd
+	// closed when the running child exits
+	exited chan struct{}
+	// receives the exit code of the child when it exits by itself
+	exits chan int
+}
+
+// supervised is the supervised child process, if any
+var supervised *supervisor
+
+// newSupervisor creates a supervisor for the command, started once all the env resources are rendered
+//	command		: the command and arguments of the child
+//	items		: the resources, those with the env option are passed to the child
+//	killTimeout	: how long the child is given to exit before it's killed
+//	onChange	: what is done to the child when its secrets rotate, restart, signal or none
+//	signal		: the signal sent to the child when on change is signal
+func newSupervisor(command []string, items []*VaultResource, killTimeout time.Duration, onChange string, signal syscall.Signal) *supervisor {
+	s := &supervisor{
+		command:     command,
+		killTimeout: killTimeout,
+		onChange:    onChange,
+		signal:      signal,
+		env:         make(map[*VaultResource]map[string]string),
+		pending:     make(map[*VaultResource]bool),
+		exits:       make(chan int, 1),
+	}
+	for _, rn := range items {
+		if rn.Env {
+			s.resources = append(s.resources, rn)
+			s.pending[rn] = true
+		}
+	}
+
+	return s
+}
+
+// stage records the secret of a resource, without restarting the child; a change to an env resource changes
+// the environment, while a change to a resource with the reload-signal or restart-on-change option queues the
+// signal or restart of the child
+//	rn			: the resource which has been rendered
+//	data		: the secret data of the resource
+func (s *supervisor) stage(rn *VaultResource, data map[string]interface{}) {
+	if s == nil || !reloadsSupervised(rn) {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	env := make(map[string]string)
+	for key, value := range data {
+		env[dotEnvKey(key)] = fmt.Sprintf("%v", value)
+	}
+	previous, found := s.env[rn]
+	if equalEnv(previous, env) {
+		delete(s.pending, rn)
+		return
+	}
+	s.env[rn] = env
+	delete(s.pending, rn)
+	if rn.Env {
+		s.changed = true
+	}
+	// step: the child started with the first render of the resource, only a change to it reloads the child
+	if !found {
+		return
+	}
+	if rn.RestartOnChange {
+		glog.V(3).Infof("resource: %s has changed, the supervised process will be restarted", rn)
+		s.restart = true
+	}
+	if rn.ReloadSignal != "" {
+		signal, err := parseSignal(rn.ReloadSignal)
+		if err != nil {
+			glog.Errorf("resource: %s has an invalid reload-signal: %s, error: %s", rn, rn.ReloadSignal, err)
+			return
+		}
+		s.queueSignal(signal)
+	}
+}
+
+// queueSignal queues a signal to send to the child, each signal being sent once; the lock must be held
+func (s *supervisor) queueSignal(signal syscall.Signal) {
+	for _, x := range s.signals {
+		if x == signal {
+			return
+		}
+	}
+	s.signals = append(s.signals, signal)
+}
+
+// reload starts the child once all the env resources are rendered, or re-executes or signals it if the
+// environment or the resources it reloads with have changed
+func (s *supervisor) reload() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.pending) > 0 {
+		return
+	}
+	if s.cmd != nil {
+		if s.changed {
+			switch s.onChange {
+			case childSignal:
+				s.queueSignal(s.signal)
+			case childNone:
+				glog.Infof("the secrets of the supervised process have rotated, leaving it running")
+			default:
+				s.restart = true
+			}
+			s.changed = false
+		}
+		if !s.restart {
+			s.sendSignals()
+			return
+		}
+		glog.Infof("the secrets of the supervised process have rotated, re-executing: %s", s.command[0])
+		s.terminate()
+	}
+	if err := s.start(); err != nil {
+		glog.Errorf("failed to start the supervised process: %s, error: %s", s.command[0], err)
+	}
+}
+
+// sendSignals sends the queued signals to the child; the lock must be held
+func (s *supervisor) sendSignals() {
+	for _, signal := range s.signals {
+		glog.Infof("the secrets of the supervised process have rotated, sending it: %s", signal)
+		if err := s.cmd.Process.Signal(signal); err != nil {
+			glog.Errorf("failed to signal the supervised process, pid: %d, error: %s", s.cmd.Process.Pid, err)
+		}
+	}
+	s.signals = nil
+}
+
+// rendered records the secret of a resource and reloads the child if required
+func (s *supervisor) rendered(rn *VaultResource, data map[string]interface{}) {
+	s.stage(rn, data)
+	s.reload()
+}
+
+// exitCodes returns a channel receiving the exit code of the child when it exits by itself, rather than
+// being re-executed or stopped; nil, which is never ready, when nothing is supervised
+func (s *supervisor) exitCodes() <-chan int {
+	if s == nil {
+		return nil
+	}
+
+	return s.exits
+}
+
+// stop terminates the child, if it's running
+func (s *supervisor) stop() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.terminate()
+}
+
+// start runs the child with the current environment; the lock must be held
+func (s *supervisor) start() error {
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Env = append(os.Environ(), s.environ()...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = logs.stderr
+	if err := cmd.Start(); err != nil {
+		return withCode(codeSupervisorFailed, err)
+	}
+	glog.Infof("started the supervised process: %s, pid: %d", s.command[0], cmd.Process.Pid)
+
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		s.Lock()
+		defer s.Unlock()
+		if s.cmd != cmd {
+			return
+		}
+		s.cmd = nil
+		code := exitCode(cmd.ProcessState)
+		glog.Warningf("the supervised process: %s exited with: %d, error: %v", s.command[0], code, err)
+		select {
+		case s.exits <- code:
+		default:
+		}
+	}()
+	s.cmd = cmd
+	s.exited = exited
+	s.changed = false
+	s.restart = false
+	s.signals = nil
+
+	return nil
+}
+
+// terminate asks the child to exit, killing it if it hasn't by the kill timeout; the lock must be held
+func (s *supervisor) terminate() {
+	if s.cmd == nil {
+		return
+	}
+	cmd, exited := s.cmd, s.exited
+	s.cmd = nil
+
+	// step: windows can't deliver a SIGTERM, the process can only be killed
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		glog.Errorf("failed to signal the supervised process, pid: %d, error: %s, killing it", cmd.Process.Pid, err)
+		cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(s.killTimeout):
+		glog.Warningf("the supervised process, pid: %d did not exit within %s, killing it", cmd.Process.Pid, s.killTimeout)
+		if err := cmd.Process.Kill(); err != nil {
+			glog.Errorf("failed to kill the supervised process, pid: %d, error: %s", cmd.Process.Pid, err)
+		}
+		<-exited
+	}
+}
+
+// environ returns the environment variables of the env resources, later resources taking precedence
+func (s *supervisor) environ() []string {
+	var list []string
+	for _, rn := range s.resources {
+		env := s.env[rn]
+		var keys []string
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			list = append(list, fmt.Sprintf("%s=%s", key, env[key]))
+		}
+	}
+
+	return list
+}
+
+// reloadsSupervised checks if the resource is passed to, or reloads, the supervised process
+func reloadsSupervised(rn *VaultResource) bool {
+	return rn.Env || rn.ReloadSignal != "" || rn.RestartOnChange
+}
+
+// exitCode returns the exit code of a process, 128 plus the signal when it was killed by one as a shell would
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return 1
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		if state.Success() {
+			return 0
+		}
+		return 1
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return status.ExitStatus()
+}
+
+// equalEnv checks if two sets of environment variables are the same
+func equalEnv(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if x, found := b[key]; !found || x != value {
+			return false
+		}
+	}
+
+	return true
+}
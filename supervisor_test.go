@@ -0,0 +1,176 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorReexecOnRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "started")
+	env := &VaultResource{Resource: "secret", Path: "db", Env: true}
+	file := &VaultResource{Resource: "secret", Path: "config"}
+	s := newSupervisor([]string{"sh", "-c", `echo "$DB_PASSWORD" >> ` + output + `; exec sleep 30`},
+		[]*VaultResource{env, file}, time.Second, childRestart, 0)
+	defer s.stop()
+
+	// step: the child is not started until the env resources are rendered
+	s.reload()
+	s.rendered(file, map[string]interface{}{"other": "value"})
+	assert.Nil(t, s.cmd)
+
+	s.rendered(env, map[string]interface{}{"db_password": "first"})
+	waitForContent(t, output, "first\n")
+
+	// step: an unchanged secret does not restart the child
+	pid := s.cmd.Process.Pid
+	s.rendered(env, map[string]interface{}{"db_password": "first"})
+	assert.Equal(t, pid, s.cmd.Process.Pid)
+
+	// step: a rotation re-executes the child with the new environment
+	s.rendered(env, map[string]interface{}{"db_password": "second"})
+	waitForContent(t, output, "first\nsecond\n")
+	assert.NotEqual(t, pid, s.cmd.Process.Pid)
+}
+
+func TestSupervisorSignalOnRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "signalled")
+	env := &VaultResource{Resource: "secret", Path: "db", Env: true}
+	s := newSupervisor([]string{"sh", "-c", `trap 'echo "$DB_PASSWORD" >> ` + output + `' HUP; echo started >> ` + output +
+		`; while true; do sleep 0.1; done`}, []*VaultResource{env}, time.Second, childSignal, syscall.SIGHUP)
+	defer s.stop()
+
+	s.rendered(env, map[string]interface{}{"db_password": "first"})
+	waitForContent(t, output, "started\n")
+
+	// step: a rotation signals the child, which keeps the environment it was started with
+	pid := s.cmd.Process.Pid
+	s.rendered(env, map[string]interface{}{"db_password": "second"})
+	waitForContent(t, output, "started\nfirst\n")
+	assert.Equal(t, pid, s.cmd.Process.Pid)
+}
+
+func TestSupervisorReloadOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "reloaded")
+	tls := &VaultResource{Resource: "pki", Path: "pki/issue/app", ReloadSignal: "USR1"}
+	config := &VaultResource{Resource: "secret", Path: "config", RestartOnChange: true}
+	s := newSupervisor([]string{"sh", "-c", `trap 'echo usr1 >> ` + output + `' USR1; echo started >> ` + output +
+		`; while true; do sleep 0.1; done`}, []*VaultResource{tls, config}, time.Second, childRestart, 0)
+	defer s.stop()
+
+	// step: with no env resources the child starts straight away, the first render of the others reloading nothing
+	s.reload()
+	waitForContent(t, output, "started\n")
+	s.rendered(tls, map[string]interface{}{"certificate": "first"})
+	s.rendered(config, map[string]interface{}{"debug": "false"})
+	pid := s.cmd.Process.Pid
+
+	// step: a change to the certificate signals the child with its reload-signal
+	s.rendered(tls, map[string]interface{}{"certificate": "second"})
+	waitForContent(t, output, "started\nusr1\n")
+	assert.Equal(t, pid, s.cmd.Process.Pid)
+
+	// step: a change to the config restarts the child
+	s.rendered(config, map[string]interface{}{"debug": "true"})
+	waitForContent(t, output, "started\nusr1\nstarted\n")
+	assert.NotEqual(t, pid, s.cmd.Process.Pid)
+}
+
+func TestSetReloadOptions(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+	var items VaultResources
+	assert.Nil(t, items.Set("pki:pki/issue/app:common_name=app,reload-signal=SIGHUP,restart-on-change=true"))
+	assert.Equal(t, "SIGHUP", items.items[0].ReloadSignal)
+	assert.True(t, items.items[0].RestartOnChange)
+
+	assert.NotNil(t, items.Set("secret:secret/db:reload-signal=NOPE"))
+	assert.NotNil(t, items.Set("secret:secret/db:restart-on-change=maybe"))
+}
+
+func TestSupervisorExitCode(t *testing.T) {
+	env := &VaultResource{Resource: "secret", Path: "db", Env: true}
+	s := newSupervisor([]string{"sh", "-c", "exit 3"}, []*VaultResource{env}, time.Second, childRestart, 0)
+	defer s.stop()
+
+	s.rendered(env, map[string]interface{}{"db_password": "first"})
+	select {
+	case code := <-s.exitCodes():
+		assert.Equal(t, 3, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the exit of the supervised process was not propagated")
+	}
+
+	// step: nothing is received when nothing is supervised
+	var none *supervisor
+	assert.Nil(t, none.exitCodes())
+}
+
+func TestEnvOnlyResource(t *testing.T) {
+	defer withOutputDir(t)()
+
+	items := &VaultResources{}
+	if !assert.NoError(t, items.Set("secret:secret/db:env=only")) {
+		return
+	}
+	rn := items.items[0]
+	assert.True(t, rn.Env)
+	assert.True(t, rn.EnvOnly)
+
+	filename, err := writeResource(rn, map[string]interface{}{"password": "hunter22"})
+	assert.NoError(t, err)
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// waitForContent waits for the file to hold the expected content
+func waitForContent(t *testing.T, filename, expected string) {
+	t.Helper()
+	var content []byte
+	for i := 0; i < 50; i++ {
+		if content, _ = ioutil.ReadFile(filename); string(content) == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, expected, string(content))
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"text/template/parse"
+
+	"github.com/golang/glog"
+)
+
+// templateContext holds the latest secrets of the named resources, so a template can be rendered from
+// several of them; the templates referring to a resource are re-rendered whenever it changes
+type templateContext struct {
+	sync.RWMutex
+	// the latest secret data of each resource id
+	secrets map[string]map[string]interface{}
+	// the latest secret data of each templated resource, used when re-rendering it
+	data map[*VaultResource]map[string]interface{}
+	// a map of resource id to the templated resources referring to it
+	dependents map[string][]*VaultResource
+	// the resource ids each templated resource refers to
+	references map[*VaultResource][]string
+}
+
+// templates is the context of the templates of the resources being watched
+var templates = &templateContext{
+	secrets:    make(map[string]map[string]interface{}),
+	data:       make(map[*VaultResource]map[string]interface{}),
+	dependents: make(map[string][]*VaultResource),
+	references: make(map[*VaultResource][]string),
+}
+
+// watch works out the resources each template refers to, keeping the secrets already retrieved
+//	items		: the resources being watched
+func (c *templateContext) watch(items []*VaultResource) error {
+	ids := make(map[string]bool)
+	for _, rn := range items {
+		ids[rn.ID()] = true
+	}
+	dependents := make(map[string][]*VaultResource)
+	references := make(map[*VaultResource][]string)
+	for _, rn := range items {
+		if rn.Format != "template" || rn.TemplateFile == "" || rn.TemplateSyntax == templateSyntaxConsul {
+			continue
+		}
+		refs, err := templateReferences(rn.TemplateFile)
+		if err != nil {
+			return err
+		}
+		for _, id := range refs {
+			if !ids[id] {
+				return fmt.Errorf("the template of resource: %s refers to an unknown resource: %s", rn, id)
+			}
+			dependents[id] = append(dependents[id], rn)
+		}
+		references[rn] = refs
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.dependents = dependents
+	c.references = references
+	for rn := range c.data {
+		if _, found := references[rn]; !found {
+			delete(c.data, rn)
+		}
+	}
+
+	return nil
+}
+
+// update records the latest secret of a resource
+//	rn			: the resource which has been retrieved
+//	data		: the secret data of the resource
+func (c *templateContext) update(rn *VaultResource, data map[string]interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	c.secrets[rn.ID()] = data
+	if _, found := c.references[rn]; found {
+		c.data[rn] = data
+	}
+}
+
+// ready checks the resources a template refers to have all been retrieved
+func (c *templateContext) ready(rn *VaultResource) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, id := range c.references[rn] {
+		if _, found := c.secrets[id]; !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rendered re-renders the templates referring to a resource which has changed, returning the
+// templated resources which were rendered and any error
+//	rn			: the resource which has changed
+func (c *templateContext) rendered(rn *VaultResource) ([]*VaultResource, error) {
+	c.RLock()
+	var list []*VaultResource
+	for _, x := range c.dependents[rn.ID()] {
+		if _, found := c.data[x]; found && x != rn {
+			list = append(list, x)
+		}
+	}
+	c.RUnlock()
+
+	var rendered []*VaultResource
+	for _, x := range list {
+		if !c.ready(x) {
+			continue
+		}
+		c.RLock()
+		data := c.data[x]
+		c.RUnlock()
+		if !ordering.ready(x) {
+			ordering.hold(x, data)
+			continue
+		}
+		glog.V(3).Infof("resource: %s has changed, re-rendering the template of resource: %s", rn, x)
+		if err := processResource(x, data); err != nil {
+			return rendered, fmt.Errorf("unable to re-render resource: %s, error: %s", x, err)
+		}
+		rendered = append(rendered, x)
+	}
+
+	return rendered, nil
+}
+
+// secret returns the secret of a named resource for the secret template function, the whole of it
+// or the value of a key
+//	id			: the name, or the path, of the resource
+//	keys		: optionally the key of the value
+func (c *templateContext) secret(id string, keys ...string) (interface{}, error) {
+	c.RLock()
+	defer c.RUnlock()
+	data, found := c.secrets[id]
+	if !found {
+		return nil, fmt.Errorf("the resource: %s has not been retrieved", id)
+	}
+	switch len(keys) {
+	case 0:
+		return data, nil
+	case 1:
+		value, found := data[keys[0]]
+		if !found {
+			return nil, fmt.Errorf("the resource: %s has no key: %s", id, keys[0])
+		}
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("secret takes the resource and optionally a key")
+}
+
+// templateReferences returns the resources a template refers to with the secret function; only those
+// named by a literal string are found
+//	filename	: the path of the template
+func templateReferences(filename string) ([]string, error) {
+	tpl, err := parseTemplate(filename, templateSyntaxGo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the template: %s, error: %s", filename, err)
+	}
+	found := make(map[string]bool)
+	var refs []string
+	for _, x := range tpl.Templates() {
+		if x.Tree == nil {
+			continue
+		}
+		walkTemplate(x.Tree.Root, func(cmd *parse.CommandNode) {
+			if len(cmd.Args) < 2 {
+				return
+			}
+			if fn, ok := cmd.Args[0].(*parse.IdentifierNode); !ok || fn.Ident != "secret" {
+				return
+			}
+			if id, ok := cmd.Args[1].(*parse.StringNode); ok && !found[id.Text] {
+				found[id.Text] = true
+				refs = append(refs, id.Text)
+			}
+		})
+	}
+
+	return refs, nil
+}
+
+// walkTemplate visits each of the commands in the parse tree of a template
+func walkTemplate(node parse.Node, visit func(*parse.CommandNode)) {
+	switch x := node.(type) {
+	case *parse.ListNode:
+		if x == nil {
+			return
+		}
+		for _, n := range x.Nodes {
+			walkTemplate(n, visit)
+		}
+	case *parse.ActionNode:
+		walkTemplate(x.Pipe, visit)
+	case *parse.PipeNode:
+		if x == nil {
+			return
+		}
+		for _, cmd := range x.Cmds {
+			visit(cmd)
+			for _, arg := range cmd.Args {
+				walkTemplate(arg, visit)
+			}
+		}
+	case *parse.IfNode:
+		walkTemplate(x.Pipe, visit)
+		walkTemplate(x.List, visit)
+		walkTemplate(x.ElseList, visit)
+	case *parse.RangeNode:
+		walkTemplate(x.Pipe, visit)
+		walkTemplate(x.List, visit)
+		walkTemplate(x.ElseList, visit)
+	case *parse.WithNode:
+		walkTemplate(x.Pipe, visit)
+		walkTemplate(x.List, visit)
+		walkTemplate(x.ElseList, visit)
+	case *parse.TemplateNode:
+		walkTemplate(x.Pipe, visit)
+	}
+}
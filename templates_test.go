@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	templateFile := filepath.Join(dir, "app.tmpl")
+	mustNoError(t, ioutil.WriteFile(templateFile, []byte(`password: {{ secret "db" "password" }}
+{{- if .debug }}{{ range $k, $v := secret "flags" }}{{ $k }}={{ $v }}{{ end }}{{ end }}
+{{- with secret "db" "username" | upper }}{{ . }}{{ end }}`), 0600))
+
+	refs, err := templateReferences(templateFile)
+	mustNoError(t, err)
+	assert.Equal(t, []string{"db", "flags"}, refs)
+}
+
+func TestTemplateContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+
+	templateFile := filepath.Join(dir, "app.tmpl")
+	mustNoError(t, ioutil.WriteFile(templateFile, []byte(`dsn: {{ secret "db" "username" }}:{{ secret "db" "password" }}
+cert: {{ secret "tls" "certificate" }}`), 0600))
+
+	db := &VaultResource{Resource: "secret", Path: "secret/db", Name: "db", Format: "yaml"}
+	tls := &VaultResource{Resource: "pki", Path: "pki/issue/app", Name: "tls", Format: "cert"}
+	app := &VaultResource{Resource: "tpl", Path: "app", Format: "template", TemplateFile: templateFile,
+		Filename: filepath.Join(dir, "app.conf"), FileMode: 0600}
+
+	previous := templates
+	defer func() { templates = previous }()
+	templates = &templateContext{
+		secrets: make(map[string]map[string]interface{}),
+		data:    make(map[*VaultResource]map[string]interface{}),
+	}
+	mustNoError(t, templates.watch([]*VaultResource{db, tls, app}))
+	assert.Error(t, templates.watch([]*VaultResource{db, app}))
+	mustNoError(t, templates.watch([]*VaultResource{db, tls, app}))
+
+	// step: the template waits on the resources it refers to
+	templates.update(app, map[string]interface{}{})
+	assert.False(t, templates.ready(app))
+	templates.update(db, map[string]interface{}{"username": "app", "password": "first"})
+	rendered, err := templates.rendered(db)
+	mustNoError(t, err)
+	assert.Empty(t, rendered)
+
+	templates.update(tls, map[string]interface{}{"certificate": "CERT"})
+	assert.True(t, templates.ready(app))
+	rendered, err = templates.rendered(tls)
+	mustNoError(t, err)
+	assert.Equal(t, []*VaultResource{app}, rendered)
+	content, err := ioutil.ReadFile(app.Filename)
+	mustNoError(t, err)
+	assert.Equal(t, "dsn: app:first\ncert: CERT", string(content))
+
+	// step: a change to any of the resources re-renders the file
+	templates.update(db, map[string]interface{}{"username": "app", "password": "second"})
+	_, err = templates.rendered(db)
+	mustNoError(t, err)
+	content, err = ioutil.ReadFile(app.Filename)
+	mustNoError(t, err)
+	assert.Equal(t, "dsn: app:second\ncert: CERT", string(content))
+
+	_, err = templates.secret("db", "missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// requireTmpfs checks if the resource must be written to a filesystem held in memory, the option of the
+// resource overriding the -require-tmpfs flag
+func requireTmpfs(rn *VaultResource) bool {
+	if rn.RequireTmpfs != nil {
+		return *rn.RequireTmpfs
+	}
+
+	return options.requireTmpfs
+}
+
+// checkTmpfs refuses a file which would be written to persistent storage
+//	filename	: the file about to be written
+func checkTmpfs(filename string) error {
+	dir := filepath.Dir(filename)
+	name, memory, err := filesystemType(dir)
+	if err != nil {
+		return fmt.Errorf("unable to determine the filesystem of: %s, error: %s", dir, err)
+	}
+	if !memory {
+		return fmt.Errorf("the directory: %s is on a %s filesystem rather than tmpfs or ramfs, refusing to write secrets to it", dir, name)
+	}
+
+	return nil
+}
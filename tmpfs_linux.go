@@ -0,0 +1,57 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// the magic numbers of the filesystems statfs reports, see statfs(2)
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// filesystemNames are the names of the common filesystems, used to explain a refusal
+var filesystemNames = map[int64]string{
+	0xef53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+	0x794c7630: "overlayfs",
+	0x6969:     "nfs",
+	0x65735546: "fuse",
+}
+
+// filesystemType returns the name of the filesystem holding the path, and whether it's held in memory
+func filesystemType(path string) (string, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return "", false, err
+	}
+	switch int64(stat.Type) {
+	case tmpfsMagic:
+		return "tmpfs", true, nil
+	case ramfsMagic:
+		return "ramfs", true, nil
+	}
+	if name, found := filesystemNames[int64(stat.Type)]; found {
+		return name, false, nil
+	}
+
+	return fmt.Sprintf("%#x", stat.Type), false, nil
+}
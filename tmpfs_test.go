@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireTmpfs(t *testing.T) {
+	enabled, disabled := true, false
+	rn := &VaultResource{}
+	assert.False(t, requireTmpfs(rn))
+	rn.RequireTmpfs = &enabled
+	assert.True(t, requireTmpfs(rn))
+
+	options.requireTmpfs = true
+	defer func() { options.requireTmpfs = false }()
+	rn.RequireTmpfs = nil
+	assert.True(t, requireTmpfs(rn))
+	rn.RequireTmpfs = &disabled
+	assert.False(t, requireTmpfs(rn))
+}
+
+func TestCheckTmpfs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the filesystem type is only determined on linux")
+	}
+	if _, memory, err := filesystemType("/dev/shm"); err != nil || !memory {
+		t.Skip("/dev/shm is not a tmpfs")
+	}
+	dir, err := ioutil.TempDir("/dev/shm", "tmpfs")
+	mustNoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, checkTmpfs(filepath.Join(dir, "secret")))
+
+	if _, memory, _ := filesystemType(os.TempDir()); memory {
+		t.Skip("the temporary directory is a tmpfs")
+	}
+	defer withOutputDir(t)()
+	options.requireTmpfs = true
+	defer func() { options.requireTmpfs = false }()
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", Format: "json", Filename: "app", FileMode: 0600}
+	_, err = writeResource(rn, map[string]interface{}{"password": "secret"})
+	assert.Error(t, err)
+	assert.Equal(t, codeWritePersistent, errorCode(err))
+	_, err = os.Stat(filepath.Join(options.outputDir, "app"))
+	assert.True(t, os.IsNotExist(err))
+
+	disabled := false
+	rn.RequireTmpfs = &disabled
+	_, err = writeResource(rn, map[string]interface{}{"password": "secret"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// tracingPath is the path the otlp collector receives spans on
+	tracingPath = "/v1/traces"
+	// tracingInterval is how often the finished spans are exported
+	tracingInterval = 5 * time.Second
+	// tracingMaxPending is the most spans held while the collector can't be reached, the oldest dropped first
+	tracingMaxPending = 2048
+)
+
+// span is a timed operation in the rotation of a resource
+type span struct {
+	// the trace the span is part of
+	traceID [16]byte
+	// the id of the span
+	spanID [8]byte
+	// the span this is a child of, zero for the root
+	parentID [8]byte
+	// the name of the operation
+	name string
+	// when the operation started and ended
+	start, end time.Time
+	// the attributes of the span
+	attributes map[string]string
+	// the error the operation ended with
+	err error
+	// the tracer the span is exported by
+	tracer *tracer
+}
+
+// tracer records the spans of the fetch, write and exec of each rotation, exporting them to an otlp collector
+type tracer struct {
+	sync.Mutex
+	// the url of the collector, tracing being disabled when empty
+	endpoint string
+	// the service name the spans are reported under
+	service string
+	// the client the spans are exported with
+	client *http.Client
+	// the root span of the rotation in flight for each resource
+	active map[*VaultResource]*span
+	// the finished spans waiting to be exported
+	pending []*span
+}
+
+// traces is the tracer of the rotations
+var traces = &tracer{active: make(map[*VaultResource]*span)}
+
+// tracingURL returns the url the spans are sent to, the otlp path added to a collector given by its address
+func tracingURL(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.HasSuffix(endpoint, tracingPath) {
+		return endpoint
+	}
+
+	return endpoint + tracingPath
+}
+
+// start enables the tracer and exports the spans in the background
+//	endpoint	: the url of the otlp collector
+//	service		: the service name the spans are reported under
+func (t *tracer) start(endpoint, service string) {
+	t.Lock()
+	t.endpoint = tracingURL(endpoint)
+	t.service = service
+	t.client = &http.Client{Timeout: 10 * time.Second}
+	t.Unlock()
+
+	go func() {
+		for range time.NewTicker(tracingInterval).C {
+			t.flush()
+		}
+	}()
+}
+
+// enabled checks if the spans are being recorded
+func (t *tracer) enabled() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.endpoint != ""
+}
+
+// newSpan creates a span, a root one when the parent is nil
+func (t *tracer) newSpan(name string, parent *span, attributes map[string]string) *span {
+	s := &span{name: name, start: time.Now(), attributes: attributes, tracer: t}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+
+	return s
+}
+
+// begin starts the rotation of a resource, the root span of the fetch, write and exec which follow
+//	rn			: the resource being rotated
+//	name		: the name of the operation, i.e. vault.fetch or vault.renew
+func (t *tracer) begin(rn *VaultResource, name string) *span {
+	if !t.enabled() {
+		return nil
+	}
+	root := t.newSpan("rotate", nil, map[string]string{
+		"resource.id":   rn.ID(),
+		"resource.type": rn.Resource,
+		"vault.path":    rn.Path,
+	})
+
+	t.Lock()
+	previous := t.active[rn]
+	t.active[rn] = root
+	t.Unlock()
+	// step: a rotation overtaken by the next one is closed off as it was
+	previous.finish(nil)
+
+	return t.newSpan(name, root, map[string]string{"vault.path": rn.Path})
+}
+
+// child starts a span within the rotation in flight for the resource, nil when there's none
+func (t *tracer) child(rn *VaultResource, name string) *span {
+	if !t.enabled() {
+		return nil
+	}
+	t.Lock()
+	root := t.active[rn]
+	t.Unlock()
+	if root == nil {
+		return nil
+	}
+
+	return t.newSpan(name, root, map[string]string{"resource.id": rn.ID()})
+}
+
+// end finishes the rotation in flight for the resource
+//	rn			: the resource being rotated
+//	err			: the error the rotation failed with, if any
+func (t *tracer) end(rn *VaultResource, err error) {
+	t.Lock()
+	root := t.active[rn]
+	delete(t.active, rn)
+	t.Unlock()
+	root.finish(err)
+}
+
+// finish ends the span, queueing it to be exported; a nil span, tracing being disabled, is ignored
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	s.tracer.Lock()
+	defer s.tracer.Unlock()
+	s.tracer.pending = append(s.tracer.pending, s)
+	if len(s.tracer.pending) > tracingMaxPending {
+		s.tracer.pending = s.tracer.pending[len(s.tracer.pending)-tracingMaxPending:]
+	}
+}
+
+// otlpAttributes encodes the attributes in the json encoding of otlp
+func otlpAttributes(attributes map[string]string) []map[string]interface{} {
+	var list []map[string]interface{}
+	for key, value := range attributes {
+		list = append(list, map[string]interface{}{"key": key, "value": map[string]string{"stringValue": value}})
+	}
+
+	return list
+}
+
+// encodeSpans encodes the spans as an otlp export request, in its json encoding
+//	service		: the service name the spans are reported under
+//	spans		: the spans to export
+func encodeSpans(service string, spans []*span) ([]byte, error) {
+	var encoded []map[string]interface{}
+	for _, s := range spans {
+		x := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+			"status":            map[string]interface{}{"code": 1},
+		}
+		if s.parentID != [8]byte{} {
+			x["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			x["status"] = map[string]interface{}{"code": 2, "message": s.err.Error()}
+		}
+		encoded = append(encoded, x)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": service, "service.version": release}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": prog, "version": release},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	})
+}
+
+// flush exports the finished spans, keeping them for the next attempt if the collector can't be reached
+func (t *tracer) flush() {
+	t.Lock()
+	spans, endpoint, service, client := t.pending, t.endpoint, t.service, t.client
+	t.pending = nil
+	t.Unlock()
+	if endpoint == "" || len(spans) == 0 {
+		return
+	}
+
+	err := func() error {
+		content, err := encodeSpans(service, spans)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected response from the collector: %s", resp.Status)
+		}
+
+		return nil
+	}()
+	if err != nil {
+		glog.Warningf("failed to export %d spans to: %s, error: %s", len(spans), endpoint, err)
+		t.Lock()
+		t.pending = append(spans, t.pending...)
+		if len(t.pending) > tracingMaxPending {
+			t.pending = t.pending[len(t.pending)-tracingMaxPending:]
+		}
+		t.Unlock()
+	}
+}
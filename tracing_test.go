@@ -0,0 +1,133 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// otlpSpan is the part of an exported span the tests look at
+type otlpSpan struct {
+	TraceID  string `json:"traceId"`
+	SpanID   string `json:"spanId"`
+	ParentID string `json:"parentSpanId"`
+	Name     string `json:"name"`
+	Status   struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func TestTracingURL(t *testing.T) {
+	assert.Equal(t, "http://collector:4318/v1/traces", tracingURL("http://collector:4318"))
+	assert.Equal(t, "http://collector:4318/v1/traces", tracingURL("http://collector:4318/"))
+	assert.Equal(t, "http://collector:4318/v1/traces", tracingURL("http://collector:4318/v1/traces"))
+}
+
+func TestTracerDisabled(t *testing.T) {
+	tracer := &tracer{active: make(map[*VaultResource]*span)}
+	rn := &VaultResource{Resource: "secret", Path: "secret/db"}
+
+	fetch := tracer.begin(rn, "vault.fetch")
+	assert.Nil(t, fetch)
+	fetch.finish(nil)
+	tracer.child(rn, "write").finish(nil)
+	tracer.end(rn, nil)
+	assert.Empty(t, tracer.pending)
+}
+
+func TestTracerExport(t *testing.T) {
+	var exported []otlpSpan
+	var service string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/traces", req.URL.Path)
+		content, _ := ioutil.ReadAll(req.Body)
+		var request struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []struct {
+						Key   string            `json:"key"`
+						Value map[string]string `json:"value"`
+					} `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if assert.NoError(t, json.Unmarshal(content, &request)) {
+			for _, x := range request.ResourceSpans[0].Resource.Attributes {
+				if x.Key == "service.name" {
+					service = x.Value["stringValue"]
+				}
+			}
+			exported = append(exported, request.ResourceSpans[0].ScopeSpans[0].Spans...)
+		}
+	}))
+	defer server.Close()
+
+	tracer := &tracer{active: make(map[*VaultResource]*span)}
+	tracer.start(server.URL, "app-sidekick")
+	rn := &VaultResource{Resource: "database", Path: "database/creds/app"}
+
+	tracer.begin(rn, "vault.fetch").finish(nil)
+	tracer.child(rn, "write").finish(nil)
+	tracer.child(rn, "exec").finish(errors.New("exit status 1"))
+	tracer.end(rn, errors.New("exit status 1"))
+	assert.Nil(t, tracer.child(rn, "write"))
+	tracer.flush()
+
+	assert.Equal(t, "app-sidekick", service)
+	if !assert.Len(t, exported, 4) {
+		return
+	}
+	root := exported[3]
+	assert.Equal(t, "rotate", root.Name)
+	assert.Empty(t, root.ParentID)
+	assert.Equal(t, 2, root.Status.Code)
+	for i, name := range []string{"vault.fetch", "write", "exec"} {
+		assert.Equal(t, name, exported[i].Name)
+		assert.Equal(t, root.TraceID, exported[i].TraceID)
+		assert.Equal(t, root.SpanID, exported[i].ParentID)
+	}
+	assert.Equal(t, 1, exported[1].Status.Code)
+	assert.Equal(t, "exit status 1", exported[2].Status.Message)
+	assert.Empty(t, tracer.pending)
+}
+
+func TestTracerRetainsOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tracer := &tracer{active: make(map[*VaultResource]*span)}
+	tracer.start(server.URL, prog)
+	rn := &VaultResource{Resource: "secret", Path: "secret/db"}
+	tracer.begin(rn, "vault.fetch").finish(nil)
+	tracer.end(rn, nil)
+	tracer.flush()
+
+	assert.Len(t, tracer.pending, 2)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// fileWatchers holds the stop channels of the goroutines polling the trigger and csi files of the resources
+type fileWatchers struct {
+	sync.Mutex
+	// closed to stop the watchers of each resource
+	stops map[*VaultResource]chan struct{}
+}
+
+// watchers are the file watchers of the resources
+var watchers = &fileWatchers{stops: make(map[*VaultResource]chan struct{})}
+
+// start polls the trigger file or csi files of the resource, if it has any
+//	vault		: the vault service used to re-fetch the resource
+//	rn			: the resource
+//	interval	: how often to check the files
+func (w *fileWatchers) start(vault *VaultService, rn *VaultResource, interval time.Duration) {
+	if rn.TriggerFile == "" && rn.Resource != "csi" {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	if _, found := w.stops[rn]; found {
+		return
+	}
+	stop := make(chan struct{})
+	w.stops[rn] = stop
+	if rn.TriggerFile != "" {
+		go watchTrigger(vault, rn, interval, stop)
+	}
+	if rn.Resource == "csi" {
+		go watchCSIFiles(vault, rn, interval, stop)
+	}
+}
+
+// stop stops polling the files of a resource no longer watched
+//	rn			: the resource removed or replaced
+func (w *fileWatchers) stop(rn *VaultResource) {
+	w.Lock()
+	defer w.Unlock()
+	if stop, found := w.stops[rn]; found {
+		close(stop)
+		delete(w.stops, rn)
+	}
+}
+
+// watchTrigger polls the trigger file of a resource and forces a re-fetch of the resource whenever the
+// file is created, touched or replaced
+//	vault		: the vault service used to re-fetch the resource
+//	rn			: the resource with a trigger file
+//	interval	: how often to check the trigger file
+//	stop		: closed once the resource is no longer watched
+func watchTrigger(vault *VaultService, rn *VaultResource, interval time.Duration, stop <-chan struct{}) {
+	glog.V(3).Infof("watching the trigger file: %s for resource: %s", rn.TriggerFile, rn)
+	last, _ := os.Stat(rn.TriggerFile)
+	for {
+		select {
+		case <-stop:
+			glog.V(3).Infof("no longer watching the trigger file: %s for resource: %s", rn.TriggerFile, rn)
+			return
+		case <-time.After(interval):
+		}
+		current, err := os.Stat(rn.TriggerFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Warningf("unable to check the trigger file: %s, error: %s", rn.TriggerFile, err)
+			}
+			last = nil
+			continue
+		}
+		if triggerChanged(last, current) {
+			glog.Infof("the trigger file: %s has changed, refreshing resource: %s", rn.TriggerFile, rn)
+			vault.Refresh(rn)
+		}
+		last = current
+	}
+}
+
+// triggerChanged checks if the trigger file has been created, modified or replaced
+func triggerChanged(last, current os.FileInfo) bool {
+	if last == nil {
+		return true
+	}
+	if !os.SameFile(last, current) {
+		return true
+	}
+
+	return !last.ModTime().Equal(current.ModTime()) || last.Size() != current.Size()
+}
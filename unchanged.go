@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// errUnchanged is returned when none of the files of a resource needed rewriting
+var errUnchanged = errors.New("the content of the resource is unchanged")
+
+// changeTracker counts the files of the resource being written which were rewritten and which were
+// skipped, their content being the same as that on disk
+type changeTracker struct {
+	sync.Mutex
+	// whether the tracker is counting
+	active bool
+	// the number of files rewritten and skipped
+	written int
+	skipped int
+}
+
+// changes tracks the files of the resource being written
+var changes = &changeTracker{}
+
+// begin starts counting the files written
+func (c *changeTracker) begin() {
+	c.Lock()
+	defer c.Unlock()
+	c.active = true
+	c.written = 0
+	c.skipped = 0
+}
+
+// end stops counting, returning true if files were skipped and none were rewritten
+func (c *changeTracker) end() bool {
+	c.Lock()
+	defer c.Unlock()
+	c.active = false
+
+	return c.written == 0 && c.skipped > 0
+}
+
+// unchanged checks if the file on disk already holds the content, counting the file as either skipped or
+// written; the content is rendered into the hash alone, so nothing more is held in memory
+//	filename	: the file about to be written
+//	write		: writes the content
+func (c *changeTracker) unchanged(filename string, write func(io.Writer) error) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.active {
+		return false, nil
+	}
+	rendered := sha256.New()
+	if err := write(rendered); err != nil {
+		return false, err
+	}
+	same := false
+	if file, err := os.Open(filename); err == nil {
+		existing := sha256.New()
+		_, err = io.Copy(existing, file)
+		file.Close()
+		same = err == nil && bytes.Equal(existing.Sum(nil), rendered.Sum(nil))
+	}
+	if same {
+		c.skipped++
+	} else {
+		c.written++
+	}
+
+	return same, nil
+}
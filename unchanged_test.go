@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	defer withOutputDir(t)()
+	options.skipUnchanged = true
+	defer func() { options.skipUnchanged = false }()
+
+	marker := filepath.Join(options.outputDir, "reloaded")
+	rn := &VaultResource{
+		Resource: "pki",
+		Path:     "pki/issue/app",
+		Format:   "cert",
+		Filename: "tls",
+		FileMode: 0600,
+		ExecPath: []string{"touch", marker},
+	}
+	data := newTestPKI(t)
+	mustNoError(t, processResource(rn, data))
+	pendingExecs.Wait()
+	_, err := os.Stat(marker)
+	mustNoError(t, err)
+	mustNoError(t, os.Remove(marker))
+
+	// step: the same content is neither rewritten nor the exec run
+	filename, err := writeResource(rn, data)
+	assert.Equal(t, errUnchanged, err)
+	mustNoError(t, processResource(rn, data))
+	pendingExecs.Wait()
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+
+	// step: a change to any one of the files rewrites them and runs the exec
+	changed := newTestPKI(t)
+	changed["issuing_ca"] = data["issuing_ca"]
+	mustNoError(t, processResource(rn, changed))
+	pendingExecs.Wait()
+	_, err = os.Stat(marker)
+	mustNoError(t, err)
+	content, err := ioutil.ReadFile(filename + ".crt")
+	mustNoError(t, err)
+	assert.Equal(t, changed["certificate"], string(content))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -24,6 +25,8 @@ import (
 	"math/rand"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,16 +42,26 @@ func init() {
 	rand.Seed(int64(time.Now().Nanosecond()))
 }
 
+// exit exports the pending spans, pushes the metrics and stops the log relay, so neither a span, the final
+// metrics nor a log line is lost, and exits with the code
+func exit(code int) {
+	traces.flush()
+	pushMetrics()
+	logs.stop()
+	os.Exit(code)
+}
+
 // showUsage prints the command usage and exits
 //	message		: an error message to display if exiting with an error
 func showUsage(message string, args ...interface{}) {
 	flag.PrintDefaults()
+	fmt.Printf("\ncommands:\n%s\n", commandUsage())
 	if message != "" {
 		fmt.Printf("\n[error] "+message+"\n", args...)
-		os.Exit(1)
+		exit(1)
 	}
 
-	os.Exit(0)
+	exit(0)
 }
 
 // hasKey checks to see if a key is present
@@ -59,6 +72,14 @@ func hasKey(key string, data map[string]interface{}) bool {
 	return found
 }
 
+// sortedKeys retrieves a sorted list of keys from the map
+// 	data		: the map which you wish to extract the keys from
+func sortedKeys(data map[string]interface{}) []string {
+	list := getKeys(data)
+	sort.Strings(list)
+	return list
+}
+
 // getKeys retrieves a list of keys from the map
 // 	data		: the map which you wish to extract the keys from
 func getKeys(data map[string]interface{}) []string {
@@ -143,6 +164,17 @@ func getDurationWithin(min, max int) time.Duration {
 	return time.Duration(duration) * time.Second
 }
 
+// parsePercentage parses a percentage between 0 and 100, exclusive, i.e. 75%, returning it as a fraction
+//	value		: the percentage
+func parsePercentage(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percent <= 0 || percent >= 100 {
+		return 0, fmt.Errorf("should be a percentage between 0 and 100, i.e. 75%%")
+	}
+
+	return percent / 100, nil
+}
+
 // getEnv checks to see if an environment variable exists otherwise uses the default
 //	env			: the name of the environment variable you are checking for
 //	value		: the default value to return if the value is not there
@@ -171,86 +203,469 @@ func fileExists(filename string) (bool, error) {
 // 	rn		: a point to the vault resource
 //	data		: a map of the related secret associated to the resource
 func processResource(rn *VaultResource, data map[string]interface{}) (err error) {
-	// step: determine the resource path
+	write := traces.child(rn, "write")
+	filename, err := writeResource(rn, data)
+	if err == errUnchanged {
+		write.finish(nil)
+		statuses.written(rn)
+		hooks.rendered(rn, false)
+		return nil
+	}
+	write.finish(err)
+	if err != nil {
+		return err
+	}
+	statuses.written(rn)
+
+	hook := traces.child(rn, "exec")
+	dispatchExec(rn, filename, func(err error) {
+		hook.finish(err)
+		hooks.rendered(rn, true)
+	})
+
+	return nil
+}
+
+// dispatchExec runs the exec of the resource in the background, off the lock the resources are processed under, so
+// the updates arriving while it runs are written and their execs coalesced into a single run after it
+//	rn			: the vault resource
+//	filename	: the file the resource was written to
+//	done		: called with the error of the exec once it has run
+func dispatchExec(rn *VaultResource, filename string, done func(error)) {
+	pendingExecs.Add(1)
+	run := execCommand(rn, filename)
+	go func() {
+		defer pendingExecs.Done()
+		err := run()
+		if err != nil {
+			metrics.ResourceErrorCode(rn.ID(), errorCode(err))
+			withResource(rn).withError(err).Errorf("failed to execute the command of the resource")
+		}
+		done(err)
+	}()
+}
+
+// resourceFilename determines the full path the resource should be written to
+// 	rn		: a point to the vault resource
+func resourceFilename(rn *VaultResource) string {
 	filename := rn.GetFilename()
-	if !strings.HasPrefix(filename, "/") {
-		filename = fmt.Sprintf("%s/%s", options.outputDir, filepath.Base(filename))
+	if filename == stdoutFilename || options.outputDir == stdoutOutput {
+		return stdoutFilename
+	}
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(options.outputDir, filepath.Base(filename))
+	}
+
+	return filename
+}
+
+// writeResource formats the secret and writes the content of the resource, returning the filename
+// 	rn		: a point to the vault resource
+//	data		: a map of the related secret associated to the resource
+func writeResource(rn *VaultResource, data map[string]interface{}) (filename string, err error) {
+	// step: determine the resource path
+	filename = resourceFilename(rn)
+
+	// step: a secret only passed to the supervised process never touches the disk
+	if rn.EnvOnly {
+		return filename, nil
+	}
+
+	// step: render only the keys selected, under the names given
+	if data, err = selectKeys(rn, data); err != nil {
+		metrics.ResourceProcessError(rn.ID(), "disk_write")
+		return filename, withCode(codeWriteFailed, fmt.Errorf("resource: %s, %s", rn.ID(), err))
+	}
+
+	// step: refuse values too large to render within the memory we're given
+	if err := checkValueSizes(rn, data, options.maxValueSize); err != nil {
+		metrics.ResourceProcessError(rn.ID(), "disk_write")
+		return filename, withCode(codeWriteTooLarge, fmt.Errorf("resource: %s, %s", rn.ID(), err))
+	}
+
+	// step: refuse to write the secret to persistent storage
+	if requireTmpfs(rn) && !rn.ExecStdin && !options.dryRun && !isStdout(filename) && !servesFromMemory() {
+		if err := checkTmpfs(filename); err != nil {
+			metrics.ResourceProcessError(rn.ID(), "disk_write")
+			return filename, withCode(codeWritePersistent, fmt.Errorf("resource: %s, %s", rn.ID(), err))
+		}
+	}
+
+	// step: warn on any suspicious looking content
+	if options.lintSecrets {
+		lintResource(rn, data)
 	}
 
 	metrics.ResourceProcessTotal(rn.ID(), "disk_write")
 
-	// step: format and write the file
-	switch rn.Format {
-	case "yaml":
-		fallthrough
-	case "yml":
-		err = writeYAMLFile(filename, data, rn.FileMode)
-	case "json":
-		err = writeJSONFile(filename, data, rn.FileMode)
-	case "ini":
-		err = writeIniFile(filename, data, rn.FileMode)
-	case "csv":
-		err = writeCSVFile(filename, data, rn.FileMode)
-	case "env":
-		err = writeEnvFile(filename, data, rn.FileMode)
-	case "rootca":
-		err = writeRootCAFile(filename, data, rn.FileMode)
-	case "cert":
-		err = writeCertificateFile(filename, data, rn.FileMode)
-	case "certchain":
-		err = writeCertificateChainFile(filename, data, rn.FileMode)
-	case "txt":
-		err = writeTxtFile(filename, data, rn.FileMode)
-	case "bundle":
-		err = writeCertificateBundleFile(filename, data, rn.FileMode)
-	case "credential":
-		err = writeCredentialFile(filename, data, rn.FileMode)
-	case "template":
-		err = writeTemplateFile(filename, data, rn.FileMode, rn.TemplateFile)
-	case "aws":
-		err = writeAwsCredentialFile(filename, data, rn.FileMode)
-	default:
+	// step: encrypt the private key, the keystore formats are protected by their own passphrase
+	if rn.KeyPassphrase != "" && rn.Format != "p12" && rn.Format != "pkcs12" && rn.Format != "jks" {
+		if data, err = encryptResourceKey(data, rn.KeyPassphrase); err != nil {
+			metrics.ResourceProcessError(rn.ID(), "disk_write")
+			return filename, withCode(codeWriteFailed, err)
+		}
+	}
+
+	// step: hand the files and directories written over to the owner of the resource
+	uid, gid, err := resolveOwnership(rn.Owner, rn.Group)
+	if err != nil {
 		metrics.ResourceProcessError(rn.ID(), "disk_write")
-		return fmt.Errorf("unknown output format: %s", rn.Format)
+		return filename, withCode(codeWriteFailed, fmt.Errorf("resource: %s, %s", rn.ID(), err))
+	}
+	owners.begin(uid, gid)
+	defer owners.end()
+	writtenFiles.begin(rn)
+	defer writtenFiles.end()
+
+	// step: attribute the files written to the resource in the audit log, stdout and memory aren't audited
+	if !rn.ExecStdin && !options.dryRun && !isStdout(filename) && !servesFromMemory() {
+		audit.begin(rn)
+		defer func() {
+			audit.end()
+			audit.render(rn, err)
+		}()
+	}
+
+	formatter, found := lookupFormatter(rn.Format)
+	if rn.Layout == layoutDir {
+		formatter, found = dirLayout{}, true
+	}
+	if !found {
+		metrics.ResourceProcessError(rn.ID(), "disk_write")
+		return filename, withCode(codeWriteFormat, fmt.Errorf("unknown output format: %s", rn.Format))
+	}
+
+	// step: render a resource piped to its exec into memory, never writing it to disk
+	if rn.ExecStdin {
+		if err := hookInputs.render(rn, formatter, filename, data); err != nil {
+			metrics.ResourceProcessError(rn.ID(), "disk_write")
+			return filename, withCode(codeWriteFailed, err)
+		}
+		metrics.ResourceProcessSuccess(rn.ID(), "disk_write")
+		return filename, nil
+	}
+
+	// step: write a versioned resource into a new version, removed again if anything fails
+	live := filename
+	if rn.Versions > 0 {
+		if filename, err = newVersion(live); err != nil {
+			metrics.ResourceProcessError(rn.ID(), "disk_write")
+			return live, withCode(codeWriteFailed, err)
+		}
+		defer func(version string) {
+			if err != nil && !options.dryRun {
+				os.RemoveAll(filepath.Dir(version))
+			}
+		}(filename)
+	}
+
+	// step: write the parts of a pki resource with the modes given to them
+	modes.begin(filename, rn.FileModes)
+	defer modes.end()
+
+	// step: keep the previous content of the files so a failed verification can be rolled back
+	verify := len(rn.VerifyExecPath) > 0 && !options.dryRun
+	if verify {
+		journal.begin()
+	}
+
+	// step: keep the certificate being replaced for the grace period of the resource
+	if rn.GracePeriod > 0 {
+		if err := graces.rotate(rn, formatter, filename, data); err != nil {
+			metrics.ResourceProcessError(rn.ID(), "disk_write")
+			if verify {
+				journal.rollback()
+			}
+
+			return filename, withCode(codeWriteFailed, err)
+		}
+	}
+
+	// step: format and write the file
+	if options.skipUnchanged {
+		changes.begin()
+	}
+	if checksumFiles(rn) && !options.dryRun {
+		checksums.begin()
 	}
+	err = formatter.Write(filename, rn, data)
+	if err == nil && checksumFiles(rn) && !options.dryRun {
+		err = checksums.writeChecksums(rn.FileMode)
+	}
+	checksums.end()
+	unchanged := options.skipUnchanged && changes.end()
 	// step: check for an error
 	if err != nil {
 		metrics.ResourceProcessError(rn.ID(), "disk_write")
+		if verify {
+			journal.rollback()
+		}
 
-		return err
+		return filename, withCode(codeWriteFailed, err)
 	}
 
 	metrics.ResourceProcessSuccess(rn.ID(), "disk_write")
 
-	// step: check if we need to execute a command
-	if len(rn.ExecPath) > 0 {
+	// step: nothing to verify, publish or run when the files are as they were
+	if unchanged {
+		glog.V(3).Infof("the content of resource: %s is unchanged, skipping the rewrite", rn.ID())
+		metrics.ResourceUnchanged(rn.ID())
+		if verify {
+			journal.commit()
+		}
+
+		return filename, errUnchanged
+	}
+
+	// step: verify the files written, restoring the previous content if they fail
+	if verify {
+		if err := verifyResource(rn, filename); err != nil {
+			err = withCode(codeVerifyFailed, err)
+			glog.Errorf("resource: %s failed verification, rolling back, error: %s", rn.ID(), err)
+			if rerr := journal.rollback(); rerr != nil {
+				return filename, withCode(codeWriteRollback, fmt.Errorf("%s, %s", err, rerr))
+			}
+
+			return filename, err
+		}
+		journal.commit()
+	}
+	if rn.GracePeriod > 0 {
+		graces.written(rn, data)
+	}
+
+	// step: point the current symlink at the version written
+	if rn.Versions > 0 {
+		if filename, err = publishVersion(live, filename, rn.Versions); err != nil {
+			metrics.ResourceProcessError(rn.ID(), "disk_write")
+			return filename, withCode(codeWriteFailed, err)
+		}
+	}
+
+	return filename, nil
+}
+
+// selectKeys filters the secret down to the keys selected by the resource and renames them, returning
+// the secret as it is when neither is given
+//	rn			: the resource the secret belongs to
+//	data		: the secret data
+func selectKeys(rn *VaultResource, data map[string]interface{}) (map[string]interface{}, error) {
+	if len(rn.Keys) == 0 && len(rn.Rename) == 0 {
+		return data, nil
+	}
+	selected := make(map[string]interface{})
+	if len(rn.Keys) > 0 {
+		for _, key := range rn.Keys {
+			value, found := data[key]
+			if !found {
+				return nil, fmt.Errorf("the selected key: %s is not in the secret", keyName(rn, key))
+			}
+			selected[key] = value
+		}
+	} else {
+		for key, value := range data {
+			selected[key] = value
+		}
+	}
+	for from := range rn.Rename {
+		if _, found := selected[from]; !found {
+			return nil, fmt.Errorf("the renamed key: %s is not in the secret", keyName(rn, from))
+		}
+	}
+	renamed := make(map[string]interface{}, len(selected))
+	for key, value := range selected {
+		if name, found := rn.Rename[key]; found {
+			key = name
+		}
+		if _, found := renamed[key]; found {
+			return nil, fmt.Errorf("more than one key is rendered as: %s", keyName(rn, key))
+		}
+		renamed[key] = value
+	}
+
+	return renamed, nil
+}
+
+// checkValueSizes checks none of the values of a secret, including those nested, are larger than the limit
+//	rn			: the resource the secret belongs to
+//	data		: the secret data
+//	limit		: the largest value in bytes, zero for no limit
+func checkValueSizes(rn *VaultResource, data map[string]interface{}, limit int64) error {
+	if limit <= 0 {
+		return nil
+	}
+	if key, size := largestValue(data, ""); size > limit {
+		return fmt.Errorf("the value of the key: %s is %d bytes, larger than the max-value-size of %d", keyName(rn, key), size, limit)
+	}
+
+	return nil
+}
+
+// largestValue returns the key and size of the largest string value of a secret, nested keys joined by a dot
+func largestValue(data map[string]interface{}, prefix string) (string, int64) {
+	var largest string
+	var largestSize int64
+	for key, value := range data {
+		key = prefix + key
+		var size int64
+		switch x := value.(type) {
+		case string:
+			size = int64(len(x))
+		case []byte:
+			size = int64(len(x))
+		case map[string]interface{}:
+			key, size = largestValue(x, key+".")
+		}
+		if size > largestSize {
+			largest, largestSize = key, size
+		}
+	}
+
+	return largest, largestSize
+}
+
+// execResource runs the exec command of the resource, if any, once the content has been written
+// 	rn			: a point to the vault resource
+//	filename	: the file the resource was written to
+func execResource(rn *VaultResource, filename string) error {
+	return execCommand(rn, filename)()
+}
+
+// execCommand prepares the exec command of the resource, returning what runs it; the content piped to the command is
+// taken when it's prepared, so a later render can't change it
+// 	rn			: a point to the vault resource
+//	filename	: the file the resource was written to
+func execCommand(rn *VaultResource, filename string) func() error {
+	if len(rn.ExecPath) <= 0 {
+		return func() error {
+			return siblings.notify(rn)
+		}
+	}
+	var args []string
+	if len(rn.ExecPath) > 1 {
+		args = rn.ExecPath[1:]
+	} else if !rn.ExecStdin {
+		args = []string{filename}
+	}
+
+	cmd := exec.Command(rn.ExecPath[0], args...)
+	if rn.ExecStdin {
+		cmd.Stdin = bytes.NewReader(hookInputs.take(rn))
+	}
+
+	return func() error {
+		if execDryRun(rn) {
+			logHook(rn, "exec", cmd, nil)
+			return siblings.notify(rn)
+		}
 		metrics.ResourceProcessTotal(rn.ID(), "exec")
 
-		glog.V(10).Infof("executing the command: %s for resource: %s", rn.ExecPath, filename)
-		var args []string
-		if len(rn.ExecPath) > 1 {
-			args = rn.ExecPath[1:]
-		} else {
-			args = []string{filename}
+		// step: the same command is never run twice at once, the invocations waiting on it being coalesced
+		err := execs.run(execKey(cmd, rn), func() error {
+			glog.V(10).Infof("executing the command: %s for resource: %s", rn.ExecPath, filename)
+			started := time.Now()
+			cmd.Start()
+			timer := time.AfterFunc(options.execTimeout, func() {
+				if err := cmd.Process.Kill(); err != nil {
+					glog.Errorf("failed to kill the command, pid: %d, error: %s", cmd.Process.Pid, err)
+				}
+			})
+			// step: wait for the command to finish
+			err := cmd.Wait()
+			timer.Stop()
+			audit.exec(rn, "exec", cmd.Args, time.Since(started), err)
+
+			return err
+		})
+		if err != nil {
+			metrics.ResourceProcessError(rn.ID(), "exec")
+			return withCode(codeExecFailed, err)
 		}
+		metrics.ResourceProcessSuccess(rn.ID(), "exec")
+		metrics.ResourceLastReload(rn.ID(), rn.ExecPath[0], time.Now())
+
+		// step: let the sibling processes or workload know the resource has rotated
+		return siblings.notify(rn)
+	}
+}
 
-		cmd := exec.Command(rn.ExecPath[0], args...)
-		cmd.Start()
+// deletedResource runs the on-delete command of a resource whose secret has been deleted in vault
+//	rn			: the vault resource
+//	deleted		: the details of the deletion
+func deletedResource(rn *VaultResource, deleted *deletedSecretError) error {
+	if len(rn.OnDeletePath) <= 0 {
+		return nil
+	}
+
+	filename := resourceFilename(rn)
+	var args []string
+	if len(rn.OnDeletePath) > 1 {
+		args = rn.OnDeletePath[1:]
+	} else {
+		args = []string{filename}
+	}
+
+	env := []string{
+		"VAULT_SIDEKICK_RESOURCE=" + rn.ID(),
+		"VAULT_SIDEKICK_SECRET_STATE=" + deleted.state(),
+		fmt.Sprintf("VAULT_SIDEKICK_SECRET_VERSION=%d", deleted.version),
+	}
+	cmd := exec.Command(rn.OnDeletePath[0], args...)
+	cmd.Env = append(os.Environ(), env...)
+	if execDryRun(rn) {
+		logHook(rn, "on-delete", cmd, env)
+		return nil
+	}
+	metrics.ResourceProcessTotal(rn.ID(), "on-delete")
+
+	glog.V(10).Infof("executing the on-delete command: %s for resource: %s", rn.OnDeletePath, filename)
+	err := execs.limit(func() error {
+		started := time.Now()
+		if err := cmd.Start(); err != nil {
+			audit.exec(rn, "on-delete", cmd.Args, 0, err)
+			return err
+		}
 		timer := time.AfterFunc(options.execTimeout, func() {
-			if err = cmd.Process.Kill(); err != nil {
-				glog.Errorf("failed to kill the command, pid: %d, error: %s", cmd.Process.Pid, err)
+			if err := cmd.Process.Kill(); err != nil {
+				glog.Errorf("failed to kill the on-delete command, pid: %d, error: %s", cmd.Process.Pid, err)
 			}
 		})
-		// step: wait for the command to finish
-		err = cmd.Wait()
+		err := cmd.Wait()
 		timer.Stop()
+		audit.exec(rn, "on-delete", cmd.Args, time.Since(started), err)
 
-		if err == nil {
-			metrics.ResourceProcessSuccess(rn.ID(), "exec")
-		} else {
-			metrics.ResourceProcessError(rn.ID(), "exec")
-		}
+		return err
+	})
+	if err != nil {
+		metrics.ResourceProcessError(rn.ID(), "on-delete")
+		return withCode(codeOnDeleteFailed, err)
 	}
+	metrics.ResourceProcessSuccess(rn.ID(), "on-delete")
 
-	return err
+	return nil
+}
+
+// execDryRun checks if the hooks of the resource are logged rather than run
+func execDryRun(rn *VaultResource) bool {
+	return options.execDryRun || rn.ExecDryRun
+}
+
+// logHook logs what a hook would run, rather than running it; the values of the environment inherited by
+// the command are left out, only the variables the sidekick adds are logged in full
+//	rn			: the vault resource
+//	hook		: the name of the hook, i.e. exec
+//	cmd			: the command which would be run
+//	env			: the variables the sidekick adds to the environment of the command
+func logHook(rn *VaultResource, hook string, cmd *exec.Cmd, env []string) {
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	path, err := exec.LookPath(cmd.Args[0])
+	if err != nil {
+		glog.Warningf("exec-dry-run: resource: %s, the %s command: %s cannot be found, error: %s", rn.ID(), hook, cmd.Args[0], err)
+		path = cmd.Args[0]
+	}
+	glog.Infof("exec-dry-run: resource: %s, would run the %s command: %s, args: %q, dir: %s, env: %q, inheriting %d variables",
+		rn.ID(), hook, path, cmd.Args[1:], dir, env, len(os.Environ()))
 }
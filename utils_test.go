@@ -2,6 +2,8 @@ package main
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestReadConfigFileKubernetesVault(t *testing.T) {
@@ -121,3 +123,42 @@ func TestGetDurationWithin(t *testing.T) {
 		t.Errorf("Expected duration to be higher than 0 got %d", duration)
 	}
 }
+
+func TestSelectKeys(t *testing.T) {
+	data := map[string]interface{}{"username": "app", "password": "secret", "notes": "unrelated"}
+	rn := &VaultResource{}
+	selected, err := selectKeys(rn, data)
+	mustNoError(t, err)
+	assert.Equal(t, data, selected)
+
+	rn.Keys = []string{"password", "username"}
+	rn.Rename = map[string]string{"password": "DB_PASS"}
+	selected, err = selectKeys(rn, data)
+	mustNoError(t, err)
+	assert.Equal(t, map[string]interface{}{"username": "app", "DB_PASS": "secret"}, selected)
+
+	rn.Keys = nil
+	rn.Rename = map[string]string{"password": "username", "username": "password"}
+	selected, err = selectKeys(rn, data)
+	mustNoError(t, err)
+	assert.Equal(t, map[string]interface{}{"username": "secret", "password": "app", "notes": "unrelated"}, selected)
+
+	rn.Rename = map[string]string{"password": "username"}
+	_, err = selectKeys(rn, data)
+	assert.Error(t, err)
+
+	rn.Keys = []string{"missing"}
+	rn.Rename = nil
+	_, err = selectKeys(rn, data)
+	assert.Error(t, err)
+}
+
+func TestParseRename(t *testing.T) {
+	rename, err := parseRename("password:DB_PASS,username:DB_USER")
+	mustNoError(t, err)
+	assert.Equal(t, map[string]string{"password": "DB_PASS", "username": "DB_USER"}, rename)
+	for _, x := range []string{"password", "password:", ":DB_PASS", "password:DB_PASS,"} {
+		_, err := parseRename(x)
+		assert.Error(t, err, x)
+	}
+}
@@ -23,6 +23,9 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -31,6 +34,9 @@ import (
 	"github.com/UKHomeOffice/vault-sidekick/metrics"
 )
 
+// shutdownTimeout is how long the leases of the resources are given to be revoked on shutdown
+const shutdownTimeout = 10 * time.Second
+
 // AuthInterface is the authentication interface
 type AuthInterface interface {
 	// Create and handle renewals of the token
@@ -51,6 +57,12 @@ type VaultService struct {
 	listeners []chan VaultEvent
 	// a channel to inform of a new resource to processor
 	resourceChannel chan *watchedResource
+	// a channel to request an immediate re-fetch of a watched resource
+	refreshChannel chan *VaultResource
+	// a channel to stop watching a resource
+	unwatchChannel chan *VaultResource
+	// a channel to revoke the leases of the resources on shutdown, closing the channel given once done
+	shutdownChannel chan chan struct{}
 }
 
 // VaultEvent is the definition which captures a change
@@ -59,8 +71,18 @@ type VaultEvent struct {
 	Resource *VaultResource
 	// the secret associated
 	Secret map[string]interface{}
-	// type of this event (success or failure)
+	// type of this event (success, failure, deleted or one of the token events)
 	Type EventType
+	// the error which caused a failure, deleted or token expired event
+	Err error
+	// the ttl of the token, for the token events
+	TokenTTL time.Duration
+	// the lease the secret replaces, revoked once the secret has been rendered
+	Superseded string
+	// whether the secret replaces one retrieved before, rather than being renewed or retrieved the first time
+	Rotated bool
+	// when the secret expires or, for one without an expiry, is next re-read; the zero time if neither is known
+	Expiry time.Time
 }
 
 type EventType int
@@ -68,8 +90,65 @@ type EventType int
 const (
 	EventTypeSuccess EventType = iota
 	EventTypeFailure EventType = iota
+	EventTypeDeleted EventType = iota
+	// the token events have no resource; acquired is raised when a login gives a new token, renewed when
+	// the token is refreshed and remains the same, and expired when the token has outlived its ttl without
+	// being refreshed
+	EventTypeTokenAcquired EventType = iota
+	EventTypeTokenRenewed  EventType = iota
+	EventTypeTokenExpired  EventType = iota
 )
 
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventTypeSuccess:
+		return "success"
+	case EventTypeFailure:
+		return "failure"
+	case EventTypeDeleted:
+		return "deleted"
+	case EventTypeTokenAcquired:
+		return "token-acquired"
+	case EventTypeTokenRenewed:
+		return "token-renewed"
+	case EventTypeTokenExpired:
+		return "token-expired"
+	}
+
+	return "unknown"
+}
+
+// deletedSecretError is returned when the version of a kv v2 secret has been deleted or destroyed
+type deletedSecretError struct {
+	// the path of the secret
+	path string
+	// the version which was deleted
+	version int
+	// whether the version was destroyed rather than soft deleted
+	destroyed bool
+	// when the version was deleted
+	deletionTime string
+}
+
+// state returns whether the version was deleted or destroyed
+func (e *deletedSecretError) state() string {
+	if e.destroyed {
+		return "destroyed"
+	}
+
+	return "deleted"
+}
+
+func (e *deletedSecretError) Error() string {
+	return fmt.Sprintf("%s: version %d of the secret: %s has been %s", e.Code(), e.version, e.path, e.state())
+}
+
+// Code returns the code of the error
+func (e *deletedSecretError) Code() string {
+	return codeVaultDeleted
+}
+
 // NewVaultService creates a new implementation to speak to vault and retrieve the resources
 //	url			: the url of the vault service
 func NewVaultService(url string) (*VaultService, error) {
@@ -82,9 +161,14 @@ func NewVaultService(url string) (*VaultService, error) {
 
 	// step: create the service processor channels
 	service.resourceChannel = make(chan *watchedResource, 20)
+	service.refreshChannel = make(chan *VaultResource, 20)
+	service.unwatchChannel = make(chan *VaultResource, 20)
+	service.shutdownChannel = make(chan chan struct{})
 
 	// step: retrieve a vault client
-	service.client, err = newVaultClient(&options)
+	service.client, err = newVaultClient(&options, func(event VaultEvent) {
+		service.upstream(event)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +191,34 @@ func (r VaultService) Watch(rn *VaultResource) {
 	r.resourceChannel <- &watchedResource{resource: rn}
 }
 
+// Refresh forces an immediate re-fetch of a watched resource, regardless of its renewal schedule
+func (r VaultService) Refresh(rn *VaultResource) {
+	r.refreshChannel <- rn
+}
+
+// Unwatch stops watching a resource, dropping any pending retrieval or renewal
+func (r VaultService) Unwatch(rn *VaultResource) {
+	r.unwatchChannel <- rn
+}
+
+// Shutdown stops the service processor, revoking the leases and certificates of the resources with the revoke
+// option so they don't outlive the sidekick
+//	timeout		: how long to wait on the revocations
+func (r VaultService) Shutdown(timeout time.Duration) {
+	done := make(chan struct{})
+	select {
+	case r.shutdownChannel <- done:
+	case <-time.After(timeout):
+		glog.Warningf("timed out waiting on the service processor to shut down")
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		glog.Warningf("timed out revoking the leases of the resources after %s", timeout)
+	}
+}
+
 // vaultServiceProcessor is the background routine responsible for retrieving the resources, renewing when required and
 // informing those who are watching the resource that something has changed
 func (r *VaultService) vaultServiceProcessor() {
@@ -119,6 +231,10 @@ func (r *VaultService) vaultServiceProcessor() {
 		retrieveChannel := make(chan *watchedResource, 10)
 		revokeChannel := make(chan *watchedResource, 10)
 		statsChannel := time.NewTicker(options.statsInterval)
+		// the pacer spreading the first retrieval of the resources
+		pacer := newFetchPacer(options.fetchConcurrency, options.fetchStagger)
+		// the breaker holding requests back while vault is failing
+		breaker := newCircuitBreaker(options.breakerThreshold, options.breakerCooldown)
 
 		for {
 			select {
@@ -128,17 +244,59 @@ func (r *VaultService) vaultServiceProcessor() {
 				glog.V(4).Infof("adding a resource into the service processor, resource: %s", x.resource)
 				// step: add to the list of resources
 				items = append(items, x)
-				// step: push into the retrieval channel
-				r.scheduleNow(x, retrieveChannel)
+				statuses.add(x.resource)
+				// step: a lease persisted by a previous run is renewed rather than issuing new credentials
+				if state.restore(x) {
+					glog.Infof("resuming the lease: %s of resource: %s", x.secret.LeaseID, x.resource)
+					r.scheduleNow(x, renewChannel)
+					break
+				}
+				// step: push into the retrieval channel, paced so many resources don't all hit vault at once
+				r.scheduleIn(x, retrieveChannel, pacer.delay(time.Now()))
+
+			// A watched resource has been asked to be re-fetched immediately
+			case rn := <-r.refreshChannel:
+				for _, x := range items {
+					if x.resource == rn {
+						glog.V(4).Infof("refreshing the resource: %s on request", x.resource)
+						reads.invalidate(x.resource)
+						r.scheduleNow(x, retrieveChannel)
+					}
+				}
+
+			// A watched resource is no longer wanted
+			//  - remove it from the list, marking it so any retrieval or renewal already scheduled is dropped
+			//  - if the option is to revoke, the current lease is revoked
+			case rn := <-r.unwatchChannel:
+				for i, x := range items {
+					if x.resource != rn {
+						continue
+					}
+					glog.V(4).Infof("removing the resource: %s from the service processor", x.resource)
+					items = append(items[:i], items[i+1:]...)
+					x.removed = true
+					atomic.AddInt64(&x.generation, 1)
+					statuses.remove(x.resource)
+					state.forget(x.resource)
+					expiries.remove(x.resource)
+					if x.resource.Revoked && x.secret != nil && x.secret.LeaseID != "" {
+						r.scheduleNow(&watchedResource{secret: &api.Secret{LeaseID: x.secret.LeaseID}}, revokeChannel)
+					}
+					break
+				}
 
 			// Retrieve a resource from vault
 			//  - we retrieve the resource from vault
 			//  - if we error attempting to retrieve the secret, we background and reschedule an attempt to add it
 			//  - if ok, we grab the lease it and lease time, we setup a notification on renewal
 			case x := <-retrieveChannel:
+				// step: skip this resource if it's no longer watched
+				if x.removed {
+					break
+				}
 				// step: skip this resource if it's reached maxRetries
-				if x.resource.MaxRetries > 0 && x.resource.Retries > x.resource.MaxRetries {
-					glog.V(4).Infof("skipping resource %s as it's failed %d/%d times", x.resource.Retries, x.resource.MaxRetries+1)
+				if givenUp(x.resource) {
+					glog.V(4).Infof("skipping resource %s as it's failed %d/%d times", x.resource, x.resource.Retries, maxRetries(x.resource)+1)
 					break
 				}
 
@@ -149,59 +307,105 @@ func (r *VaultService) vaultServiceProcessor() {
 					glog.V(10).Infof("resource: %s has a previous lease: %s", x.resource, leaseID)
 				}
 
+				// step: hold the request back while the circuit breaker is open
+				if wait := breaker.allow(time.Now()); wait > 0 {
+					glog.V(4).Infof("the circuit breaker is %s, holding back resource: %s for %s", breaker.state, x.resource, wait)
+					r.scheduleIn(x, retrieveChannel, wait)
+					break
+				}
+
 				metrics.ResourceTotal(x.resource.ID())
 
-				err := r.get(x)
+				var previous map[string]interface{}
+				if x.secret != nil {
+					previous = x.secret.Data
+				}
+				fetch, started := traces.begin(x.resource, "vault.fetch"), time.Now()
+				err := withCode(codeVaultRequest, r.get(x))
+				fetch.finish(err)
+				metrics.ResourceDuration(x.resource.ID(), time.Since(started))
+				breaker.record(time.Now(), err)
 				if err != nil {
+					traces.end(x.resource, err)
 					metrics.ResourceError(x.resource.ID())
-					glog.Errorf("failed to retrieve the resource: %s from vault, error: %s", x.resource, err)
+					metrics.ResourceErrorCode(x.resource.ID(), errorCode(err))
+					withResource(x.resource).withError(err).Errorf("failed to retrieve the resource from vault")
 					// reschedule the attempt for later
 					retryDuration := x.calculateRetry()
 					glog.V(3).Infof("rescheduling next get attempt for resource: %s in %s", x.resource, retryDuration)
+					metrics.ResourceBackoff(x.resource.ID(), retryDuration)
 					r.scheduleIn(x, retrieveChannel, retryDuration)
 					x.resource.Retries++
-					r.upstream(VaultEvent{
+					statuses.failure(x.resource, err)
+					statuses.scheduled(x.resource, time.Now().Add(retryDuration))
+					event := VaultEvent{
 						Resource: x.resource,
 						Type:     EventTypeFailure,
-					})
+						Err:      err,
+					}
+					// step: a deleted secret keeps the last known good copy, raising the deleted event once
+					if deleted, ok := err.(*deletedSecretError); ok {
+						metrics.ResourceDeleted(x.resource.ID(), deleted.state())
+						if !x.deleted {
+							withResource(x.resource).Warningf("the resource has been %s in vault, keeping the last known good copy", deleted.state())
+							event.Type = EventTypeDeleted
+						}
+						x.deleted = true
+					}
+					r.upstream(event)
 					break
 				}
+				x.deleted = false
+				r.applyTuning(x)
+				changed := changedKeys(previous, x.secret.Data)
+				if previous != nil && len(changed) > 0 {
+					withResource(x.resource).Infof("the resource has changed, keys: %s", strings.Join(keyNames(x.resource, changed), ", "))
+				}
+				statuses.keys(x.resource, keyNames(x.resource, getKeys(x.secret.Data)), keyNames(x.resource, changed))
 
 				metrics.ResourceSuccess(x.resource.ID())
 
-				glog.V(4).Infof("successfully retrieved resource: %s, leaseID: %s", x.resource, x.secret.LeaseID)
+				if glog.V(4) {
+					withResource(x.resource).Infof("successfully retrieved the resource, leaseID: %s", x.secret.LeaseID)
+				}
 				x.resource.Retries = 0
-
-				// step: if we had a previous lease and the option is to revoke, lets throw into the revoke channel
-				if leaseID != "" && x.resource.Revoked {
-					// step: make a rough copy
-					copy := &watchedResource{
-						secret: &api.Secret{
-							LeaseID: x.secret.LeaseID,
-						},
-					}
-
-					r.scheduleIn(copy, revokeChannel, x.resource.RevokeDelay)
+				metrics.ResourceBackoff(x.resource.ID(), 0)
+				statuses.success(x.resource, x.leaseExpireTime)
+				state.record(x)
+				expiries.update(x.resource, x.expiry())
+
+				// step: if we had a previous lease and the option is to revoke, or the lease was re-issued at its max
+				// ttl, it's handed upstream to revoke once the new secret has been rendered
+				event := VaultEvent{
+					Resource: x.resource,
+					Secret:   x.secret.Data,
+					Type:     EventTypeSuccess,
+					Rotated:  previous != nil,
+					Expiry:   x.refreshAt(),
 				}
+				if leaseID != "" && leaseID != x.secret.LeaseID && (x.resource.Revoked || x.atMaxTTL) {
+					event.Superseded = leaseID
+				}
+				x.atMaxTTL = false
 
 				// step: setup a timer for renewal
 				x.notifyOnRenewal(renewChannel)
 
 				// step: update the upstream consumers
-				r.upstream(VaultEvent{
-					Resource: x.resource,
-					Secret:   x.secret.Data,
-					Type:     EventTypeSuccess,
-				})
+				r.upstream(event)
 
 			// A watched resource is coming up for renewal
 			// 	- we attempt to renew the resource from vault
 			//	- if we encounter an error, we reschedule the attempt for the future
 			//	- if we're ok, we update the watchedResource and we send a notification of the change upstream
 			case x := <-renewChannel:
+				// step: skip this resource if it's no longer watched
+				if x.removed {
+					break
+				}
 				// step: skip this resource if it's reached maxRetries
-				if x.resource.MaxRetries > 0 && x.resource.Retries > x.resource.MaxRetries {
-					glog.V(4).Infof("skipping resource %s as it's failed %d/%d times", x.resource.Retries, x.resource.MaxRetries+1)
+				if givenUp(x.resource) {
+					glog.V(4).Infof("skipping resource %s as it's failed %d/%d times", x.resource, x.resource.Retries, maxRetries(x.resource)+1)
 					break
 				}
 
@@ -209,7 +413,7 @@ func (r *VaultService) vaultServiceProcessor() {
 					x.secret.LeaseID, x.resource.Renewable, x.resource.Revoked)
 
 				// step: we need to check if the lease has expired?
-				if time.Now().Before(x.leaseExpireTime) {
+				if !time.Now().Before(x.leaseExpireTime) {
 					glog.V(3).Infof("the lease on resource: %s has expired, we need to get a new lease", x.resource)
 					// push into the retrieval channel and break
 					r.scheduleNow(x, retrieveChannel)
@@ -220,34 +424,73 @@ func (r *VaultService) vaultServiceProcessor() {
 				if x.resource.Renewable {
 					metrics.ResourceTotal(x.resource.ID())
 
-					// step: is the underlining resource even renewable? - otherwise we can just grab a new lease
-					if !x.secret.Renewable {
+					// step: is the underlining resource even renewable? - otherwise we can just grab a new lease,
+					// kv reads and the like having no lease to renew at all
+					if !x.secret.Renewable || x.secret.LeaseID == "" {
 						glog.V(10).Infof("the resource: %s is not renewable, retrieving a new lease instead", x.resource)
 						r.scheduleNow(x, retrieveChannel)
 						break
 					}
 
+					// step: a lease cut short by its max ttl can't be extended, a replacement is issued while it's
+					// still valid, the old lease being revoked once the replacement has been rendered
+					if x.atMaxTTL {
+						glog.Infof("the lease of resource: %s has reached its max ttl, issuing a replacement before it expires at %s",
+							x.resource, x.leaseExpireTime)
+						r.scheduleNow(x, retrieveChannel)
+						break
+					}
+
+					// step: hold the renewal back while the circuit breaker is open
+					if wait := breaker.allow(time.Now()); wait > 0 {
+						glog.V(4).Infof("the circuit breaker is %s, holding back resource: %s for %s", breaker.state, x.resource, wait)
+						r.scheduleIn(x, renewChannel, wait)
+						break
+					}
+
 					// step: lets renew the resource
+					renewal := traces.begin(x.resource, "vault.renew")
 					err := r.renew(x)
+					renewal.finish(err)
+					breaker.record(time.Now(), err)
+					// step: a lease vault refuses to renew is re-read in full rather than retried
+					if err != nil && leaseNotRenewable(err) {
+						withResource(x.resource).withError(err).Warningf("the lease can't be renewed, retrieving a new lease instead")
+						x.secret.Renewable = false
+						traces.end(x.resource, err)
+						r.scheduleNow(x, retrieveChannel)
+						break
+					}
 					if err != nil {
+						traces.end(x.resource, err)
 						metrics.ResourceError(x.resource.ID())
-						glog.Errorf("failed to renew the resource: %s for renewal, error: %s", x.resource, err)
+						withResource(x.resource).withError(err).Errorf("failed to renew the resource")
 						// reschedule the attempt for later
 						retryDuration := x.calculateRetry()
 						glog.V(3).Infof("rescheduling next renew attempt for resource: %s in %s", x.resource, retryDuration)
+						metrics.ResourceBackoff(x.resource.ID(), retryDuration)
 						r.scheduleIn(x, renewChannel, retryDuration)
 						x.resource.Retries++
+						statuses.failure(x.resource, err)
+						statuses.scheduled(x.resource, time.Now().Add(retryDuration))
 						r.upstream(VaultEvent{
 							Resource: x.resource,
 							Type:     EventTypeFailure,
+							Err:      err,
 						})
 						break
 					}
 
 					metrics.ResourceSuccess(x.resource.ID())
 
-					glog.V(4).Infof("successfully renewed resource: %s, leaseID: %s", x.resource, x.secret.LeaseID)
+					if glog.V(4) {
+						withResource(x.resource).Infof("successfully renewed the resource, leaseID: %s", x.secret.LeaseID)
+					}
 					x.resource.Retries = 0
+					metrics.ResourceBackoff(x.resource.ID(), 0)
+					statuses.success(x.resource, x.leaseExpireTime)
+					state.record(x)
+					expiries.update(x.resource, x.expiry())
 				}
 
 				// step: the option for this resource is not to renew the secret but regenerate a new secret
@@ -265,8 +508,24 @@ func (r *VaultService) vaultServiceProcessor() {
 					Resource: x.resource,
 					Secret:   x.secret.Data,
 					Type:     EventTypeSuccess,
+					Expiry:   x.refreshAt(),
 				})
 
+			// The sidekick is shutting down
+			//  - revoke the leases and certificates of the resources with the revoke option
+			//  - stop processing, so nothing is retrieved or renewed once revoked
+			case done := <-r.shutdownChannel:
+				for _, x := range items {
+					if !x.resource.Revoked {
+						continue
+					}
+					if err := r.revokeResource(x); err != nil {
+						withResource(x.resource).withError(err).Errorf("failed to revoke the resource on shutdown")
+					}
+				}
+				close(done)
+				return
+
 			// We receive a lease ID along on the channel, just revoke the lease when you can
 			case x := <-revokeChannel:
 				err := r.revoke(x.secret.LeaseID)
@@ -312,6 +571,9 @@ func (r VaultService) scheduleIn(rn *watchedResource, ch chan *watchedResource,
 // upstream ... the resource has changed thus we notify the upstream listener
 //	item		: the item which has changed
 func (r VaultService) upstream(item VaultEvent) {
+	if item.Secret != nil {
+		logs.redactSecret(item.Resource.ID(), item.Secret)
+	}
 	// step: chunk this into a go-routine not to block us
 	for _, listener := range r.listeners {
 		go func(ch chan VaultEvent) {
@@ -334,9 +596,16 @@ func (r VaultService) renew(rn *watchedResource) error {
 		return err
 	}
 
-	// step: update the resource
+	// step: update the resource, vault may cut the lease short as it nears the max ttl
+	previous := rn.secret.LeaseDuration
 	rn.lastUpdated = time.Now()
-	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration))
+	rn.secret.LeaseDuration = secret.LeaseDuration
+	rn.secret.Renewable = secret.Renewable
+	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration) * time.Second)
+	if secret.LeaseDuration < previous {
+		rn.leaseReduced(time.Duration(previous) * time.Second)
+		rn.atMaxTTL = true
+	}
 
 	glog.V(3).Infof("renewed resource: %s, leaseId: %s, lease_time: %s, expiration: %s",
 		rn.resource, rn.secret.LeaseID, rn.secret.LeaseID, rn.leaseExpireTime)
@@ -344,6 +613,19 @@ func (r VaultService) renew(rn *watchedResource) error {
 	return nil
 }
 
+// leaseNotRenewable checks if vault refused to renew a lease as it can't be renewed, rather than failing to
+//	err			: the error from the renewal
+func leaseNotRenewable(err error) bool {
+	message := err.Error()
+	for _, x := range []string{"lease is not renewable", "lease not found", "invalid lease"} {
+		if strings.Contains(message, x) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // revoke attempts to revoke the lease of a resource
 //	lease		: the lease lease which was given when you got it
 func (r VaultService) revoke(lease string) error {
@@ -358,6 +640,70 @@ func (r VaultService) revoke(lease string) error {
 	return nil
 }
 
+// supersede revokes a lease which has been replaced by a new one, once the new one has been rendered
+//	lease		: the lease which has been replaced
+//	delay		: how long to wait before revoking it
+func (r VaultService) supersede(lease string, delay time.Duration) {
+	if delay > 0 {
+		<-time.After(delay)
+	}
+	if err := r.revoke(lease); err != nil {
+		glog.Errorf("failed to revoke the superseded lease: %s, error: %s", lease, err)
+	}
+}
+
+// revokeResource revokes the current lease of a resource, or the certificate of a pki resource issued without one
+//	rn			: the watched resource
+func (r VaultService) revokeResource(rn *watchedResource) error {
+	if rn.secret == nil {
+		return nil
+	}
+	if rn.secret.LeaseID != "" {
+		return r.revoke(rn.secret.LeaseID)
+	}
+	serial, found := rn.secret.Data["serial_number"].(string)
+	if rn.resource.Resource != "pki" || !found || serial == "" {
+		return nil
+	}
+	mount := pkiMount(rn.resource.Path)
+	if mount == "" {
+		return fmt.Errorf("unable to find the pki mount of the path: %s", rn.resource.Path)
+	}
+	glog.V(3).Infof("attemping to revoke the certificate: %s of resource: %s", serial, rn.resource)
+	if _, err := r.client.Logical().Write(mount+"/revoke", map[string]interface{}{"serial_number": serial}); err != nil {
+		return err
+	}
+	glog.V(3).Infof("successfully revoked the certificate: %s", serial)
+
+	return nil
+}
+
+// pkiMount returns the mount of a pki issue or sign path, i.e. pki of pki/issue/web, empty if it isn't one
+//	path		: the path of the resource
+func pkiMount(path string) string {
+	for _, x := range []string{"/issue/", "/sign/"} {
+		if i := strings.Index(path, x); i > 0 {
+			return path[:i]
+		}
+	}
+
+	return ""
+}
+
+// read retrieves the data of a secret from vault, outside of the watched resources
+//	path		: the path of the secret
+func (r VaultService) read(path string) (map[string]interface{}, error) {
+	secret, err := r.client.Logical().Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("the secret: %s does not exist", path)
+	}
+
+	return secret.Data, nil
+}
+
 // get retrieves a secret from the vault
 //	rn			: the watched resource
 func (r VaultService) get(rn *watchedResource) error {
@@ -369,7 +715,9 @@ func (r VaultService) get(rn *watchedResource) error {
 	for k, v := range rn.resource.Options {
 		params[k] = interface{}(v)
 	}
-	glog.V(10).Infof("resource: %s, path: %s, params: %v", rn.resource.Resource, rn.resource.Path, params)
+	if glog.V(10) {
+		withResource(rn.resource).Infof("retrieving the resource, params: %v", params)
+	}
 
 	glog.V(5).Infof("attempting to retrieve the resource: %s from vault", rn.resource)
 	// step: perform a request to vault
@@ -381,7 +729,7 @@ func (r VaultService) get(rn *watchedResource) error {
 		}
 		resp, err := r.client.RawRequest(request)
 		if err != nil {
-			return err
+			return withCode(vaultErrorCode(err), err)
 		}
 		// step: read the response
 		content, err := ioutil.ReadAll(resp.Body)
@@ -396,15 +744,46 @@ func (r VaultService) get(rn *watchedResource) error {
 				"content": fmt.Sprintf("%s", content),
 			},
 		}
-		if rn.resource.Update > 0 {
-			secret.LeaseDuration = int(rn.resource.Update.Seconds())
-		} else {
-			secret.LeaseDuration = int((time.Duration(24) * time.Hour).Seconds())
+		secret.LeaseDuration = scheduledLease(rn.resource)
+	case "policy":
+		secret, err = r.policies(rn.resource.Path)
+		if err == nil && secret != nil {
+			secret.LeaseDuration = scheduledLease(rn.resource)
+		}
+	case "config":
+		secret, err = reads.read(rn.resource, func() (*api.Secret, error) {
+			return r.client.Logical().Read(rn.resource.Path)
+		})
+		if err == nil && secret != nil {
+			// step: metadata has no lease, so it's re-read on the update schedule instead
+			secret = &api.Secret{
+				LeaseID:       "config",
+				Data:          secret.Data,
+				LeaseDuration: scheduledLease(rn.resource),
+			}
+		}
+	case "csi":
+		// step: the files are delivered by the secrets store csi driver, the sidekick only processes them
+		data, err := readCSIFiles(rn.resource.Path)
+		if err != nil {
+			return err
+		}
+		secret = &api.Secret{
+			LeaseID:       "csi",
+			Data:          data,
+			LeaseDuration: scheduledLease(rn.resource),
+		}
+	case "tpl":
+		// step: a template resource has no secret of its own, it's rendered from those it refers to
+		secret = &api.Secret{
+			LeaseID:       "tpl",
+			Data:          make(map[string]interface{}),
+			LeaseDuration: scheduledLease(rn.resource),
 		}
 	case "pki":
-		secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.Path), params)
+		secret, err = r.client.Logical().Write(rn.resource.Path, params)
 	case "transit":
-		secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.Path), params)
+		secret, err = r.client.Logical().Write(rn.resource.Path, params)
 	case "aws":
 		fallthrough
 	case "cubbyhole":
@@ -418,20 +797,30 @@ func (r VaultService) get(rn *watchedResource) error {
 	case "database":
 		fallthrough
 	case "secret":
-		secret, err = r.client.Logical().Read(rn.resource.Path)
+		secret, err = reads.read(rn.resource, func() (*api.Secret, error) {
+			return r.readSecret(rn.resource.Path)
+		})
+		// step: a deleted version is recreated like a missing secret when we have the create flag
+		if _, deleted := err.(*deletedSecretError); deleted && rn.resource.Create {
+			secret, err = nil, nil
+		}
 		// We must generate the secret if we have the create flag
 		if rn.resource.Create && secret == nil && err == nil {
 			glog.V(3).Infof("Create param specified, creating resource: %s", rn.resource.Path)
-			params["value"] = newPassword(int(rn.resource.Size))
-			secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.Path), params)
+			params["value"], err = r.generate(rn.resource)
+			if err != nil {
+				return err
+			}
+			secret, err = r.client.Logical().Write(rn.resource.Path, params)
 			glog.V(3).Infof("Secret created: %s", rn.resource.Path)
 			if err == nil {
 				// Populate the secret data as stored in Vault...
+				reads.invalidate(rn.resource)
 				secret, err = r.client.Logical().Read(rn.resource.Path)
 			}
 		}
 		// if there is a top-level metadata key this is from a v2 kv store
-		if err == nil {
+		if err == nil && secret != nil {
 			if _, ok := secret.Data["metadata"]; ok {
 				secret.Data = secret.Data["data"].(map[string]interface{})
 			}
@@ -440,7 +829,7 @@ func (r VaultService) get(rn *watchedResource) error {
 		publicKeyData, err := ioutil.ReadFile(params["public_key_path"].(string))
 
 		if err != nil {
-			return fmt.Errorf("could not read data at specified public_key_path")
+			return withCode(codeResourceInvalid, fmt.Errorf("could not read data at specified public_key_path"))
 		}
 
 		publicKeyDataString := string(publicKeyData)
@@ -450,24 +839,23 @@ func (r VaultService) get(rn *watchedResource) error {
 			"cert_type":  params["cert_type"].(string),
 		}
 
-		secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.Path), sshParams)
+		secret, err = r.client.Logical().Write(rn.resource.Path, sshParams)
 	}
 	// step: check the error if any
 	if err != nil {
-		return err
-	}
-	if secret == nil && err == nil {
-		return fmt.Errorf("the resource does not exist")
+		return withCode(vaultErrorCode(err), err)
 	}
-
 	if secret == nil {
-		return fmt.Errorf("unable to retrieve the secret")
+		return withCode(codeVaultNotFound, fmt.Errorf("the resource does not exist"))
 	}
 
 	// step: update the watched resource
 	rn.lastUpdated = time.Now()
 	rn.secret = secret
-	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration))
+	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration) * time.Second)
+	if update := rn.resource.Update; update > 0 && secret.LeaseDuration > 0 && time.Duration(secret.LeaseDuration)*time.Second < update {
+		rn.leaseReduced(update)
+	}
 
 	glog.V(3).Infof("retrieved resource: %s, leaseId: %s, lease_time: %s",
 		rn.resource, rn.secret.LeaseID, time.Duration(rn.secret.LeaseDuration)*time.Second)
@@ -475,6 +863,125 @@ func (r VaultService) get(rn *watchedResource) error {
 	return err
 }
 
+// readSecret reads a secret, returning a deletedSecretError if it's a kv v2 version which has been deleted
+// or destroyed; vault answers these with a not found, so the metadata of the secret is checked
+//	path		: the path of the secret
+func (r VaultService) readSecret(path string) (*api.Secret, error) {
+	secret, err := r.client.Logical().Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok && secret.Data["data"] == nil {
+			return nil, versionDeletion(path, metadata, metadata["version"])
+		}
+		return secret, nil
+	}
+	if !strings.Contains(path, "/data/") {
+		return nil, nil
+	}
+
+	// step: the token may not be able to read the metadata, in which case it's just not found
+	metadata, err := r.client.Logical().Read(strings.Replace(path, "/data/", "/metadata/", 1))
+	if err != nil || metadata == nil {
+		return nil, nil
+	}
+	versions, _ := metadata.Data["versions"].(map[string]interface{})
+	version := metadata.Data["current_version"]
+	current, _ := versions[fmt.Sprintf("%v", version)].(map[string]interface{})
+
+	return nil, versionDeletion(path, current, version)
+}
+
+// versionDeletion checks the metadata of a kv v2 version, returning a deletedSecretError if it's been
+// deleted or destroyed
+//	path		: the path of the secret
+//	metadata	: the metadata of the version
+//	version		: the version number
+func versionDeletion(path string, metadata map[string]interface{}, version interface{}) error {
+	destroyed, _ := metadata["destroyed"].(bool)
+	deletionTime, _ := metadata["deletion_time"].(string)
+	if !destroyed && deletionTime == "" {
+		return nil
+	}
+	number, _ := strconv.Atoi(fmt.Sprintf("%v", version))
+
+	return &deletedSecretError{path: path, version: number, destroyed: destroyed, deletionTime: deletionTime}
+}
+
+// policies reads the acl policies from vault, either the one named or all those visible to the token when
+// the name is *; the data is keyed by the policy name
+//	name		: the name of the policy
+func (r VaultService) policies(name string) (*api.Secret, error) {
+	var names []string
+	if name != "*" {
+		names = []string{name}
+	} else {
+		list, err := r.client.Logical().List("sys/policies/acl")
+		if err != nil {
+			return nil, err
+		}
+		if list == nil {
+			return nil, fmt.Errorf("unable to list the acl policies")
+		}
+		keys, _ := list.Data["keys"].([]interface{})
+		for _, x := range keys {
+			names = append(names, fmt.Sprintf("%v", x))
+		}
+	}
+
+	data := make(map[string]interface{}, len(names))
+	for _, x := range names {
+		policy, err := r.client.Logical().Read("sys/policies/acl/" + x)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the policy: %s, error: %s", x, err)
+		}
+		if policy == nil {
+			if name != "*" {
+				return nil, nil
+			}
+			// step: the policy was deleted between the list and read
+			continue
+		}
+		data[x] = policy.Data["policy"]
+	}
+
+	return &api.Secret{LeaseID: "policy", Data: data}, nil
+}
+
+// scheduledLease returns the lease in seconds of a resource without one, which is re-read on its update
+// interval or otherwise daily
+//	rn			: the resource
+func scheduledLease(rn *VaultResource) int {
+	if rn.Update > 0 {
+		return int(rn.Update.Seconds())
+	}
+
+	return int((time.Duration(24) * time.Hour).Seconds())
+}
+
+// generate produces the value for a secret being created, either from a vault password policy or locally
+//	rn			: the resource being created
+func (r VaultService) generate(rn *VaultResource) (string, error) {
+	if rn.PasswordPolicy == "" {
+		return newPasswordFromCharset(int(rn.Size), rn.Charset), nil
+	}
+
+	secret, err := r.client.Logical().Read(fmt.Sprintf("sys/policies/password/%s/generate", rn.PasswordPolicy))
+	if err != nil {
+		return "", fmt.Errorf("unable to generate a password from the policy: %s, error: %s", rn.PasswordPolicy, err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("the password policy: %s does not exist", rn.PasswordPolicy)
+	}
+	password, ok := secret.Data["password"].(string)
+	if !ok {
+		return "", fmt.Errorf("the password policy: %s did not return a password", rn.PasswordPolicy)
+	}
+
+	return password, nil
+}
+
 func getVaultClientToken(client *api.Client, opts *config) error {
 	metrics.TokenTotal()
 	var err error
@@ -500,15 +1007,16 @@ func getVaultClientToken(client *api.Client, opts *config) error {
 		token, err = NewUserTokenPlugin(client).Create(opts.vaultAuthOptions)
 	default:
 		metrics.TokenError()
-		return fmt.Errorf("unsupported authentication plugin: %s", plugin)
+		return withCode(codeAuthUnsupported, fmt.Errorf("unsupported authentication plugin: %s", plugin))
 	}
 	if err != nil {
 		metrics.TokenError()
-		return err
+		return withCode(codeAuthFailed, err)
 	}
 
 	// step: set the token for the client
 	client.SetToken(token)
+	logs.redactSecret("token", token)
 
 	metrics.TokenSuccess()
 	return nil
@@ -517,54 +1025,93 @@ func getVaultClientToken(client *api.Client, opts *config) error {
 func getVaultClientTokenTTL(client *api.Client) (time.Duration, error) {
 	tokeninfo, err := client.Auth().Token().LookupSelf()
 	if err != nil {
-		return 0, fmt.Errorf("failed to lookup token info: %s", err)
+		return 0, withCode(codeAuthTokenLookup, fmt.Errorf("failed to lookup token info: %s", err))
 	}
 
 	tokenttl, err := tokeninfo.TokenTTL()
 	if err != nil {
-		return 0, fmt.Errorf("failed to lookup token ttl: %s", err)
+		return 0, withCode(codeAuthTokenLookup, fmt.Errorf("failed to lookup token ttl: %s", err))
 	}
 	glog.Infof("token ttl is %v", tokenttl)
+	statuses.token(tokenttl)
+	metrics.TokenTTL(tokenttl)
 
 	return tokenttl, nil
 }
 
-// newVaultClient creates and authenticates a vault client
-func newVaultClient(opts *config) (*api.Client, error) {
+// tokenRenewPeriod returns how long until the token is refreshed, leaving the margin of its ttl, half of it
+// when no margin is set
+//	ttl			: the ttl of the token
+//	margin		: the fraction of the ttl left when it's refreshed
+func tokenRenewPeriod(ttl time.Duration, margin float64) time.Duration {
+	if margin <= 0 || margin >= 1 {
+		margin = 0.5
+	}
+
+	return time.Duration(float64(ttl) * (1 - margin))
+}
+
+// resumeToken sets the token on the client if it's still valid, leaving the client without one otherwise
+//	client		: the vault client
+//	token		: the token
+func resumeToken(client *api.Client, token string) bool {
+	client.SetToken(token)
+	logs.redactSecret("token", token)
+	if _, err := client.Auth().Token().LookupSelf(); err != nil {
+		glog.Warningf("the vault token of the previous run is no longer valid, logging in afresh, error: %s", err)
+		client.ClearToken()
+		return false
+	}
+
+	return true
+}
+
+// newVaultClient creates and authenticates a vault client, keeping the token refreshed if asked to
+//	opts		: the configuration of the sidekick
+//	publish		: raises the token events
+func newVaultClient(opts *config, publish func(VaultEvent)) (*api.Client, error) {
 	var err error
 
 	config := api.DefaultConfig()
 	config.Address = opts.vaultURL
 
-	config.HttpClient.Transport, err = buildHTTPTransport(opts)
+	transport, err := buildHTTPTransport(opts)
 	if err != nil {
 		return nil, err
 	}
+	config.HttpClient.Transport = &timedTransport{next: transport}
 
 	// step: create the actual client
 	client, err := api.NewClient(config)
 	if err != nil {
-		return nil, err
+		return nil, withCode(codeAuthClient, err)
 	}
 
-	err = getVaultClientToken(client, opts)
-	if err != nil {
+	// step: a token persisted by a previous run is resumed while it's still valid
+	if token := state.token(); token != "" && resumeToken(client, token) {
+		glog.Infof("resumed the vault token of the previous run")
+	} else if err = getVaultClientToken(client, opts); err != nil {
 		return nil, err
 	}
+	state.setToken(client.Token())
+	publish(VaultEvent{Type: EventTypeTokenAcquired})
 
 	if opts.vaultRenewToken {
 		tokenttl, err := getVaultClientTokenTTL(client)
 		if err != nil {
 			return nil, err
 		}
-		renewPeriod := tokenttl / 2
+		renewPeriod := tokenRenewPeriod(tokenttl, float64(opts.tokenRenewalMargin))
+		expiry := time.Now().Add(tokenttl)
 
 		go func() {
+			expired := false
 			for {
 				glog.Infof("scheduling token renew in %v", renewPeriod)
 				<-time.After(renewPeriod)
 
 				glog.Infof("attempting token refresh")
+				previous := client.Token()
 				err = getVaultClientToken(client, opts)
 				if err != nil {
 					renewPeriod = renewPeriod / 2
@@ -577,16 +1124,31 @@ func newVaultClient(opts *config) (*api.Client, error) {
 						renewPeriod = 30*time.Second + getDurationWithin(0, 15)
 					}
 
+					metrics.Error(errorCode(err))
 					glog.Warningf("error: failed to renew token, retrying in %v: %v", renewPeriod, err)
+					// step: raise the expiry once, when the token outlives its ttl
+					if !expired && time.Now().After(expiry) {
+						expired = true
+						publish(VaultEvent{Type: EventTypeTokenExpired, Err: err})
+					}
 					continue
 				}
+				expired = false
+				state.setToken(client.Token())
 
 				tokenttl, err = getVaultClientTokenTTL(client)
 				if err != nil {
+					metrics.Error(errorCode(err))
 					glog.Warningf("error: failed to get new token ttl, using previous value %s: %s", renewPeriod, err)
 				} else {
-					renewPeriod = tokenttl / 2
+					renewPeriod = tokenRenewPeriod(tokenttl, float64(opts.tokenRenewalMargin))
+					expiry = time.Now().Add(tokenttl)
+				}
+				event := VaultEvent{Type: EventTypeTokenRenewed, TokenTTL: tokenttl}
+				if client.Token() != previous {
+					event.Type = EventTypeTokenAcquired
 				}
+				publish(event)
 			}
 		}()
 	}
@@ -616,7 +1178,7 @@ func buildHTTPTransport(opts *config) (*http.Transport, error) {
 		glog.V(3).Infof("loading the ca certificate: %s", opts.vaultCaFile)
 		caCert, err := ioutil.ReadFile(opts.vaultCaFile)
 		if err != nil {
-			return nil, fmt.Errorf("unable to read in the ca: %s, reason: %s", opts.vaultCaFile, err)
+			return nil, withCode(codeCACert, fmt.Errorf("unable to read in the ca: %s, reason: %s", opts.vaultCaFile, err))
 		}
 		caCertPool := x509.NewCertPool()
 		caCertPool.AppendCertsFromPEM(caCert)
@@ -19,7 +19,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"regexp"
 	"time"
 )
 
@@ -30,6 +29,10 @@ const (
 	optionFormat = "fmt"
 	// optionTemplatePath is the full path to a template
 	optionTemplatePath = "tpl"
+	// optionTemplate is the full path to a template, implying the template format
+	optionTemplate = "template"
+	// optionTemplateSyntax is the syntax of the template, go or consul
+	optionTemplateSyntax = "template-syntax"
 	// optionRenewal sets the duration to renew the resource
 	optionRenewal = "renew"
 	// optionRevoke revokes an old lease when retrieving a new one
@@ -48,17 +51,102 @@ const (
 	optionMode = "mode"
 	// optionMaxRetries is the maximum number of retries that should be attempted
 	optionMaxRetries = "retries"
+	// optionOnFailure is the policy once the resource has exhausted its retries, fatal, warn or keep-stale
+	optionOnFailure = "on-failure"
+	// optionFirstFetchTimeout is the deadline for the first successful retrieval of the resource
+	optionFirstFetchTimeout = "first-fetch-timeout"
 	// optionMaxJitter is the maximum amount of jitter that should be applied
 	// to updates for this resource. If non-zero, a random value between 0 and
-	// maxJitter will be subtracted from the update period.
+	// maxJitter will be subtracted from the update period. A percentage is taken
+	// of the renewal time.
 	optionMaxJitter = "jitter"
+	// optionBackoff is the first delay before retrying a failed resource, doubled on each failure
+	optionBackoff = "backoff"
+	// optionBackoffMax caps the delay before retrying a failed resource
+	optionBackoffMax = "backoff-max"
+	// optionRenewalPercentage renews the resource at a percentage of its ttl, i.e. 75%
+	optionRenewalPercentage = "renewal"
+	// optionCharset is the character set used when creating a secret
+	optionCharset = "charset"
+	// optionPasswordPolicy is a vault password policy used to generate the secret when creating it
+	optionPasswordPolicy = "policy"
+	// optionSection places the keys under a named section in the ini and toml formats
+	optionSection = "section"
+	// optionCacheTTL shares the reads of the resource with others reading the same path within the ttl
+	optionCacheTTL = "cache-ttl"
+	// optionDBHost is the hostname of the server in the pgpass and mycnf formats
+	optionDBHost = "db-host"
+	// optionDBPort is the port of the server in the pgpass and mycnf formats
+	optionDBPort = "db-port"
+	// optionDBName is the name of the database in the pgpass and mycnf formats
+	optionDBName = "db-name"
+	// optionExport prefixes the lines of the dotenv format with export
+	optionExport = "export"
+	// optionTrigger is a file which when touched forces a re-fetch of the resource
+	optionTrigger = "trigger"
+	// optionName gives the resource a name other resources can refer to
+	optionName = "name"
+	// optionRotateWith forces a re-fetch of the resource whenever the named resources rotate
+	optionRotateWith = "rotate-with"
+	// optionDependsOn holds the resource back until the named resources have succeeded
+	optionDependsOn = "depends_on"
+	// optionBundle selects how a pki resource is bundled, i.e. pkcs12
+	optionBundle = "bundle"
+	// optionPassphrase is the passphrase protecting a keystore, or where to find it
+	optionPassphrase = "passphrase"
+	// optionAlias is the alias of the key in a keystore
+	optionAlias = "alias"
+	// optionCAAlias is the alias of the ca certificates in a truststore
+	optionCAAlias = "ca-alias"
+	// optionVerifyExec runs a command to verify the files written, rolling them back if it fails
+	optionVerifyExec = "verify-exec"
+	// optionOnDelete runs a command when the kv v2 version of the secret is found deleted or destroyed
+	optionOnDelete = "on-delete"
+	// optionEnv passes the secret to the supervised process as environment variables, or only to it with env=only
+	optionEnv = "env"
+	// optionKeyPassphrase encrypts the private key of a pki resource with the passphrase
+	optionKeyPassphrase = "key_passphrase"
+	// optionOrder is the order of the key, certificate and chain in the combined format
+	optionOrder = "order"
+	// optionChain controls the ordering and inclusion of the certificate chain
+	optionChain = "chain"
+	// optionGrace keeps the certificate a pki resource rotated away from under a -previous suffix for the duration
+	optionGrace = "grace"
+	// optionKeys selects the keys of the secret which are rendered
+	optionKeys = "keys"
+	// optionRename renames keys of the secret in the output, i.e. password:DB_PASS
+	optionRename = "rename"
+	// optionLayout is how the files of the resource are laid out, file or dir
+	optionLayout = "layout"
+	// optionVersions writes each render into a directory of its own, keeping the number of versions given
+	optionVersions = "versions"
+	// optionExecDryRun logs the hooks of the resource rather than running them
+	optionExecDryRun = "exec-dry-run"
+	// optionOwner is the user, by uid or name, the files of the resource are owned by
+	optionOwner = "owner"
+	// optionGroup is the group, by gid or name, the files of the resource are owned by
+	optionGroup = "group"
+	// optionRequireTmpfs overrides the -require-tmpfs flag for the resource
+	optionRequireTmpfs = "require-tmpfs"
+	// optionChecksum writes a sha256 checksum file next to each file of the resource
+	optionChecksum = "checksum"
+	// optionSignalProcess is the name of a sibling process signalled when the resource rotates
+	optionSignalProcess = "signal-process"
+	// optionSignal is the signal sent to the sibling process, defaults to HUP
+	optionSignal = "signal"
+	// optionRestart is a workload, i.e. deployment/NAME, restarted when the resource rotates
+	optionRestart = "restart"
+	// optionExecStdin pipes the rendered secret to the stdin of the exec rather than writing it to a file
+	optionExecStdin = "exec-stdin"
+	// optionReloadSignal is the signal sent to the supervised process when the resource changes
+	optionReloadSignal = "reload-signal"
+	// optionRestartOnChange restarts the supervised process, or terminates the signal-process, when the resource changes
+	optionRestartOnChange = "restart-on-change"
 	// defaultSize sets the default size of a generic secret
 	defaultSize = 20
 )
 
 var (
-	resourceFormatRegex = regexp.MustCompile("^(yaml|yml|json|env|ini|txt|rootca|cert|certchain|bundle|csv|template|credential|aws)$")
-
 	// a map of valid resource to retrieve from vault
 	validResources = map[string]bool{
 		"raw":       true,
@@ -74,6 +162,9 @@ var (
 		"cassandra": true,
 		"ssh":       true,
 		"database":  true,
+		"policy":    true,
+		"config":    true,
+		"csi":       true,
 	}
 )
 
@@ -108,16 +199,68 @@ type VaultResource struct {
 	Create bool
 	// the size of a secret to create
 	Size int64
+	// the character set used to generate a secret to create
+	Charset string
+	// the vault password policy used to generate a secret to create
+	PasswordPolicy string
 	// the filename to save the secret
 	Filename string
 	// the template file
 	TemplateFile string
+	// the syntax of the template, go or consul-template compatible
+	TemplateSyntax string
+	// the section the keys are placed under in the ini and toml formats, or the option group of mycnf
+	Section string
+	// how long a read of the resource is shared with others reading the same path
+	CacheTTL time.Duration
+	// the hostname, port and database name of the server in the pgpass and mycnf formats
+	DBHost string
+	DBPort string
+	DBName string
+	// whether to prefix the lines of the dotenv format with export
+	Export bool
+	// the passphrase protecting a keystore; a value, env:NAME, file:PATH or vault:PATH#KEY
+	Passphrase string
+	// the passphrase the private key is encrypted with; a value, env:NAME, file:PATH or vault:PATH#KEY
+	KeyPassphrase string
+	// how the certificate chain is ordered and included, nil for the default layout of each format
+	Chain *chainOptions
+	// the order of the key, certificate and chain in the combined format
+	Order []string
+	// the alias of the key in a keystore, defaults to the common name
+	KeyAlias string
+	// the alias of the ca certificates in a truststore
+	CAAlias string
+	// whether the secret is passed to the supervised process as environment variables
+	Env bool
+	// whether the secret is only passed to the supervised process, never being written to disk
+	EnvOnly bool
 	// the path to an exec to run on a change
 	ExecPath []string
+	// whether the hooks are logged rather than run
+	ExecDryRun bool
+	// whether the rendered secret is piped to the stdin of the exec, rather than written to a file
+	ExecStdin bool
+	// the name of a sibling process signalled when the resource rotates
+	SignalProcess string
+	// the signal sent to the sibling process, HUP when empty
+	Signal string
+	// the workload, i.e. deployment/NAME, restarted when the resource rotates
+	Restart string
+	// the signal sent to the supervised process when the resource changes
+	ReloadSignal string
+	// whether the supervised process is restarted, or the signal-process terminated, when the resource changes
+	RestartOnChange bool
+	// the path to a command which verifies the files written, before the exec is run
+	VerifyExecPath []string
+	// the path to a command to run when the secret is found deleted in vault
+	OnDeletePath []string
 	// additional options to the resource
 	Options map[string]string
 	// the file permissions on the resource
 	FileMode os.FileMode
+	// fileModes are the modes of the parts of a pki resource, i.e. the key, overriding the fileMode
+	FileModes map[string]os.FileMode
 	// maxRetries is the maximum number of times this resource should be
 	// attempted to be retrieved from Vault before failing
 	MaxRetries int
@@ -127,6 +270,46 @@ type VaultResource struct {
 	// maxJitter is the maximum jitter duration to use for this resource when
 	// performing renewals
 	MaxJitter time.Duration
+	// onFailure is the policy once the resource has exhausted its retries, fatal, warn or keep-stale
+	OnFailure string
+	// firstFetchTimeout is the deadline for the first successful retrieval, the sidekick exiting once it passes
+	FirstFetchTimeout time.Duration
+	// backoff is the first delay before retrying a failed retrieval or renewal, doubled on each failure
+	Backoff time.Duration
+	// backoffMax caps the delay before retrying a failed retrieval or renewal
+	BackoffMax time.Duration
+	// jitterFraction is the maximum jitter as a fraction of the renewal time, used over maxJitter when set
+	JitterFraction float64
+	// renewalFraction is the fraction of the ttl of the lease or certificate the resource is renewed at,
+	// rather than between 80 and 95%
+	RenewalFraction float64
+	// name is an optional name other resources can use to refer to this resource
+	Name string
+	// rotateWith is a list of resources which when rotated force a re-fetch of this resource
+	RotateWith []string
+	// dependsOn is a list of resources which must have succeeded before this resource is rendered
+	DependsOn []string
+	// layout is how the files of the resource are laid out, file or dir
+	Layout string
+	// versions is the number of versions of the resource kept, each render being written into a directory
+	// of its own with a current symlink to the latest; zero writes the files in place
+	Versions int
+	// keys are the keys of the secret rendered, all of them when empty
+	Keys []string
+	// rename is a map of the keys of the secret to the names they are rendered as
+	Rename map[string]string
+	// gracePeriod is how long the previous certificate of a pki resource is kept after a rotation
+	GracePeriod time.Duration
+	// owner is the user, by uid or name, the files and directories of the resource are handed to
+	Owner string
+	// group is the group, by gid or name, the files and directories of the resource are handed to
+	Group string
+	// requireTmpfs overrides the -require-tmpfs flag for the resource when set
+	RequireTmpfs *bool
+	// checksum writes a sha256 checksum file next to each file of the resource
+	Checksum bool
+	// triggerFile is a file which when created, touched or replaced forces a re-fetch of the resource
+	TriggerFile string
 }
 
 // GetFilename generates a resource filename by default the resource name and resource type, which
@@ -139,6 +322,20 @@ func (r VaultResource) GetFilename() string {
 	return fmt.Sprintf("%s.%s", r.Path, r.Resource)
 }
 
+// GetKeyAlias returns the alias of the key in a keystore, by default the common name of the certificate
+func (r VaultResource) GetKeyAlias() string {
+	if r.KeyAlias != "" {
+		return r.KeyAlias
+	}
+
+	return r.Options["common_name"]
+}
+
+// databaseTarget returns the server and database of the pgpass and mycnf formats
+func (r VaultResource) databaseTarget() databaseTarget {
+	return databaseTarget{host: r.DBHost, port: r.DBPort, database: r.DBName}
+}
+
 // IsValid checks to see if the resource is valid
 func (r *VaultResource) IsValid() error {
 	// step: check the resource type
@@ -156,17 +353,31 @@ func (r *VaultResource) IsValid() error {
 
 // isValidResource validates the resource meets the requirements
 func (r *VaultResource) isValidResource() error {
+	if r.GracePeriod != 0 && r.Resource != "pki" {
+		return fmt.Errorf("the grace option is only supported by pki resources")
+	}
+	if r.ExecStdin {
+		if len(r.ExecPath) == 0 {
+			return fmt.Errorf("the exec-stdin option needs an exec to pipe the secret to")
+		}
+		if r.Versions > 0 || r.GracePeriod != 0 || len(r.VerifyExecPath) > 0 || r.Checksum || r.Layout == layoutDir {
+			return fmt.Errorf("the exec-stdin option writes no files, so can't be used with versions, grace, verify-exec, checksum or layout=dir")
+		}
+	}
 	switch r.Resource {
 	case "pki":
 		if _, found := r.Options["common_name"]; !found {
 			return fmt.Errorf("pki resource requires a common name specified")
 		}
+		if r.GracePeriod < 0 {
+			return fmt.Errorf("the grace period cannot be negative")
+		}
 	case "transit":
 		if _, found := r.Options["ciphertext"]; !found {
 			return fmt.Errorf("transit requires a ciphertext option")
 		}
 	case "tpl":
-		if _, found := r.Options[optionTemplatePath]; !found {
+		if r.TemplateFile == "" {
 			return fmt.Errorf("template resource requires a template path option")
 		}
 	case "ssh":
@@ -184,12 +395,17 @@ func (r *VaultResource) isValidResource() error {
 // String returns a string representation of the struct
 func (r VaultResource) String() string {
 	str := fmt.Sprintf("type: %s, path: %s", r.Resource, r.Path)
-	if r.MaxRetries > 0 {
-		str = fmt.Sprintf("%s, attempts: %d/%d", str, r.Retries, r.MaxRetries+1)
+	if max := maxRetries(&r); max > 0 {
+		str = fmt.Sprintf("%s, attempts: %d/%d", str, r.Retries, max+1)
 	}
 	return str
 }
 
+// ID returns the identifier of the resource, its name if it has one or otherwise the path
 func (r VaultResource) ID() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
 	return r.Path
 }
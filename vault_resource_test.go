@@ -24,23 +24,23 @@ import (
 
 func TestResourceFilename(t *testing.T) {
 	rn := VaultResource{
-		path:     "test_secret",
-		resource: "secret",
-		options:  map[string]string{},
+		Path:     "test_secret",
+		Resource: "secret",
+		Options:  map[string]string{},
 	}
 	assert.Equal(t, "test_secret.secret", rn.GetFilename())
 }
 
 func TestIsValid(t *testing.T) {
 	resource := defaultVaultResource()
-	resource.path = "/test/name"
-	resource.resource = "secret"
+	resource.Path = "/test/name"
+	resource.Resource = "secret"
 
 	assert.Nil(t, resource.IsValid())
-	resource.resource = "nothing"
+	resource.Resource = "nothing"
 	assert.NotNil(t, resource.IsValid())
-	resource.resource = "pki"
+	resource.Resource = "pki"
 	assert.NotNil(t, resource.IsValid())
-	resource.resource = "ssh"
+	resource.Resource = "ssh"
 	assert.NotNil(t, resource.IsValid())
 }
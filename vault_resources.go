@@ -17,7 +17,6 @@ limitations under the License.
 package main
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -36,15 +35,13 @@ type VaultResources struct {
 func (r *VaultResources) Set(value string) error {
 	rn := defaultVaultResource()
 
-	// step: split on the separator, default ':'
+	// step: split on the separator, default ':', the options being all that follows the path so their
+	// values can hold the separator, i.e. rename=password:DB_PASS
 	sep := getEnv("VAULT_SIDEKICK_SEPARATOR", ":")
-	items := strings.Split(os.ExpandEnv(value), sep)
+	items := strings.SplitN(os.ExpandEnv(value), sep, 3)
 	if len(items) < 2 {
 		return fmt.Errorf("invalid resource, must have at least two sections TYPE:PATH")
 	}
-	if len(items) > 3 {
-		return fmt.Errorf("invalid resource, can only has three sections, TYPE:PATH[:OPTIONS]")
-	}
 	if items[0] == "" || items[1] == "" {
 		return fmt.Errorf("invalid resource, neither type or path can be empty")
 	}
@@ -55,6 +52,7 @@ func (r *VaultResources) Set(value string) error {
 	rn.Options = make(map[string]string, 0)
 
 	// step: extract any options
+	templated := false
 	if len(items) > 2 {
 		optionSep := getEnv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", "§")
 		for _, x := range strings.Split(items[2], optionSep) {
@@ -72,19 +70,18 @@ func (r *VaultResources) Set(value string) error {
 			// step: extract the control options from the path resource parameters
 			switch name {
 			case optionMode:
-				if !strings.HasPrefix(value, "0") {
-					value = "0" + value
+				mode, parts, err := parseFileModes(value)
+				if err != nil {
+					return err
 				}
-				if len(value) != 4 {
-					return errors.New("the file permission invalid, should be octal 0444 or alike")
+				if mode != 0 {
+					rn.FileMode = mode
 				}
-				v, err := strconv.ParseUint(value, 0, 32)
-				if err != nil {
-					return errors.New("invalid file permissions on resource")
+				if len(parts) > 0 {
+					rn.FileModes = parts
 				}
-				rn.FileMode = os.FileMode(v)
 			case optionFormat:
-				if matched := resourceFormatRegex.MatchString(value); !matched {
+				if _, found := lookupFormatter(value); !found {
 					return fmt.Errorf("unsupported output format: %s", value)
 				}
 				rn.Format = value
@@ -94,6 +91,12 @@ func (r *VaultResources) Set(value string) error {
 					return fmt.Errorf("update option: %s is not value, should be a duration format", value)
 				}
 				rn.Update = duration
+			case optionCacheTTL:
+				duration, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("the cache-ttl option: %s is invalid, should be in duration format", value)
+				}
+				rn.CacheTTL = duration
 			case optionRevoke:
 				choice, err := strconv.ParseBool(value)
 				if err != nil {
@@ -117,8 +120,8 @@ func (r *VaultResources) Set(value string) error {
 				if err != nil {
 					return fmt.Errorf("the create option: %s is invalid, should be a boolean", value)
 				}
-				if rn.Resource != "secret" {
-					return fmt.Errorf("the create option is only supported for 'cn=secret' at this time")
+				if rn.Resource != "secret" && rn.Resource != "cubbyhole" {
+					return fmt.Errorf("the create option is only supported for 'cn=secret' and 'cn=cubbyhole' at this time")
 				}
 				rn.Create = choice
 			case optionSize:
@@ -127,29 +130,237 @@ func (r *VaultResources) Set(value string) error {
 					return fmt.Errorf("the size option: %s is invalid, should be an integer", value)
 				}
 				rn.Size = size
+			case optionCharset:
+				if _, found := charsets[value]; !found {
+					return fmt.Errorf("the charset option: %s is invalid, should be one of %s", value, strings.Join(charsetNames(), ", "))
+				}
+				rn.Charset = value
+			case optionPasswordPolicy:
+				rn.PasswordPolicy = value
 			case optionExec:
 				rn.ExecPath = strings.Split(value, " ")
+			case optionVerifyExec:
+				rn.VerifyExecPath = strings.Split(value, " ")
+			case optionOnDelete:
+				rn.OnDeletePath = strings.Split(value, " ")
 			case optionFilename:
 				rn.Filename = value
 			case optionTemplatePath:
 				rn.TemplateFile = value
+			case optionTemplate:
+				rn.TemplateFile = value
+				templated = true
+			case optionTemplateSyntax:
+				if value != templateSyntaxGo && value != templateSyntaxConsul {
+					return fmt.Errorf("the template syntax: %s is invalid, should be go or consul", value)
+				}
+				rn.TemplateSyntax = value
 			case optionMaxRetries:
 				maxRetries, err := strconv.ParseInt(value, 10, 32)
 				if err != nil {
 					return fmt.Errorf("the retries option: %s is invalid, should be an integer", value)
 				}
 				rn.MaxRetries = int(maxRetries)
+			case optionOnFailure:
+				policy, err := parseFailurePolicy(value)
+				if err != nil {
+					return fmt.Errorf("the on-failure option: %s is invalid, %s", value, err)
+				}
+				rn.OnFailure = policy
+			case optionFirstFetchTimeout:
+				timeout, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("the first-fetch-timeout option: %s is invalid, should be in duration format", value)
+				}
+				rn.FirstFetchTimeout = timeout
 			case optionMaxJitter:
+				if strings.HasSuffix(value, "%") {
+					fraction, err := parsePercentage(value)
+					if err != nil {
+						return fmt.Errorf("the jitter option: %s is invalid, %s", value, err)
+					}
+					rn.JitterFraction = fraction
+					break
+				}
 				maxJitter, err := time.ParseDuration(value)
 				if err != nil {
-					return fmt.Errorf("the jitter option: %s is invalid, should be in duration format", value)
+					return fmt.Errorf("the jitter option: %s is invalid, should be in duration format or a percentage", value)
 				}
 				rn.MaxJitter = maxJitter
+			case optionBackoff:
+				duration, err := time.ParseDuration(value)
+				if err != nil || duration <= 0 {
+					return fmt.Errorf("the backoff option: %s is invalid, should be a positive duration", value)
+				}
+				rn.Backoff = duration
+			case optionBackoffMax:
+				duration, err := time.ParseDuration(value)
+				if err != nil || duration <= 0 {
+					return fmt.Errorf("the backoff-max option: %s is invalid, should be a positive duration", value)
+				}
+				rn.BackoffMax = duration
+			case optionRenewalPercentage:
+				fraction, err := parsePercentage(value)
+				if err != nil {
+					return fmt.Errorf("the renewal option: %s is invalid, %s", value, err)
+				}
+				rn.RenewalFraction = fraction
+			case optionSection:
+				rn.Section = value
+			case optionDBHost:
+				rn.DBHost = value
+			case optionDBPort:
+				if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+					return fmt.Errorf("the db-port option: %s is invalid, should be a port number", value)
+				}
+				rn.DBPort = value
+			case optionDBName:
+				rn.DBName = value
+			case optionExport:
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the export option: %s is invalid, should be a boolean", value)
+				}
+				rn.Export = choice
+			case optionBundle:
+				switch value {
+				case "pkcs12":
+					rn.Format = "p12"
+				case "combined":
+					rn.Format = "combined"
+				default:
+					return fmt.Errorf("unsupported bundle: %s, should be pkcs12 or combined", value)
+				}
+			case optionChain:
+				chain, err := parseChainOptions(value)
+				if err != nil {
+					return err
+				}
+				rn.Chain = chain
+			case optionOrder:
+				rn.Order = strings.Split(value, ",")
+				for _, x := range rn.Order {
+					if _, found := combinedParts[x]; !found {
+						return fmt.Errorf("the order option: %s is invalid, should be a list of key, cert and chain", value)
+					}
+				}
+			case optionPassphrase:
+				rn.Passphrase = value
+			case optionKeyPassphrase:
+				rn.KeyPassphrase = value
+			case optionAlias:
+				rn.KeyAlias = value
+			case optionCAAlias:
+				rn.CAAlias = value
+			case optionEnv:
+				if value == envOnly {
+					rn.Env, rn.EnvOnly = true, true
+					break
+				}
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the env option: %s is invalid, should be a boolean or %s", value, envOnly)
+				}
+				rn.Env = choice
+			case optionRequireTmpfs:
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the require-tmpfs option: %s is invalid, should be a boolean", value)
+				}
+				rn.RequireTmpfs = &choice
+			case optionChecksum:
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the checksum option: %s is invalid, should be a boolean", value)
+				}
+				rn.Checksum = choice
+			case optionExecDryRun:
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the exec-dry-run option: %s is invalid, should be a boolean", value)
+				}
+				rn.ExecDryRun = choice
+			case optionExecStdin:
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the exec-stdin option: %s is invalid, should be a boolean", value)
+				}
+				rn.ExecStdin = choice
+			case optionSignalProcess:
+				rn.SignalProcess = value
+			case optionSignal:
+				if _, err := parseSignal(value); err != nil {
+					return fmt.Errorf("the signal option: %s is invalid, %s", value, err)
+				}
+				rn.Signal = value
+			case optionRestart:
+				if _, _, err := parseWorkload(value); err != nil {
+					return fmt.Errorf("the restart option: %s is invalid, %s", value, err)
+				}
+				rn.Restart = value
+			case optionReloadSignal:
+				if _, err := parseSignal(value); err != nil {
+					return fmt.Errorf("the reload-signal option: %s is invalid, %s", value, err)
+				}
+				rn.ReloadSignal = value
+			case optionRestartOnChange:
+				choice, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("the restart-on-change option: %s is invalid, should be a boolean", value)
+				}
+				rn.RestartOnChange = choice
+			case optionTrigger:
+				rn.TriggerFile = value
+			case optionName:
+				rn.Name = value
+			case optionOwner:
+				if _, _, err := resolveOwnership(value, ""); err != nil {
+					return fmt.Errorf("the owner option is invalid, %s", err)
+				}
+				rn.Owner = value
+			case optionGroup:
+				if _, _, err := resolveOwnership("", value); err != nil {
+					return fmt.Errorf("the group option is invalid, %s", err)
+				}
+				rn.Group = value
+			case optionLayout:
+				if value != layoutFile && value != layoutDir {
+					return fmt.Errorf("the layout: %s is invalid, should be file or dir", value)
+				}
+				rn.Layout = value
+			case optionVersions:
+				versions, err := strconv.Atoi(value)
+				if err != nil || versions <= 0 {
+					return fmt.Errorf("the versions option: %s is invalid, should be a positive integer", value)
+				}
+				rn.Versions = versions
+			case optionKeys:
+				rn.Keys = strings.Split(value, ",")
+			case optionRename:
+				rename, err := parseRename(value)
+				if err != nil {
+					return err
+				}
+				rn.Rename = rename
+			case optionGrace:
+				grace, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("the grace option: %s is invalid, should be in duration format", value)
+				}
+				rn.GracePeriod = grace
+			case optionRotateWith:
+				rn.RotateWith = strings.Split(value, ",")
+			case optionDependsOn:
+				rn.DependsOn = strings.Split(value, ",")
 			default:
 				rn.Options[name] = value
 			}
 		}
 	}
+	// step: a template takes precedence over any other format given
+	if templated || rn.Resource == "tpl" {
+		rn.Format = "template"
+	}
 	// step: append to the list of resources
 	r.items = append(r.items, rn)
 
@@ -160,3 +371,18 @@ func (r *VaultResources) Set(value string) error {
 func (r VaultResources) String() string {
 	return ""
 }
+
+// parseRename parses the rename option, a comma separated list of key:name pairs
+//	value		: the value of the option
+func parseRename(value string) (map[string]string, error) {
+	rename := make(map[string]string)
+	for _, x := range strings.Split(value, ",") {
+		items := strings.SplitN(x, ":", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return nil, fmt.Errorf("the rename option: %s is invalid, should be a list of key:name", value)
+		}
+		rename[items[0]] = items[1]
+	}
+
+	return rename, nil
+}
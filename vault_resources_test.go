@@ -19,11 +19,14 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestSetResources(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
 	var items VaultResources
 
 	assert.Nil(t, items.Set("secret:test:file=filename.test,fmt=yaml"))
@@ -36,6 +39,24 @@ func TestSetResources(t *testing.T) {
 	assert.Nil(t, items.Set("pki:example-dot-com:common_name=blah.example.com,renew=true"))
 	assert.Nil(t, items.Set("secret:secrets/${ENV}/me:file=filename.test,fmt=yaml"))
 
+	assert.Nil(t, items.Set("secret:/db/prod:template=/etc/templates/db.tmpl,fmt=yaml"))
+	assert.Equal(t, "template", items.items[len(items.items)-1].Format)
+	assert.Equal(t, "/etc/templates/db.tmpl", items.items[len(items.items)-1].TemplateFile)
+
+	assert.Nil(t, items.Set("pki:example-dot-com:common_name=blah.example.com,renewal=75%,jitter=10%"))
+	assert.Equal(t, 0.75, items.items[len(items.items)-1].RenewalFraction)
+	assert.Equal(t, 0.1, items.items[len(items.items)-1].JitterFraction)
+	assert.NotNil(t, items.Set("pki:example-dot-com:common_name=blah.example.com,renewal=120%"))
+	assert.Nil(t, items.Set("secret:test:backoff=30s,backoff-max=15m"))
+	assert.Equal(t, 30*time.Second, items.items[len(items.items)-1].Backoff)
+	assert.Equal(t, 15*time.Minute, items.items[len(items.items)-1].BackoffMax)
+	assert.NotNil(t, items.Set("secret:test:backoff=-1s"))
+	assert.Nil(t, items.Set("secret:test:retries=3,on-failure=keep-stale"))
+	assert.Equal(t, failurePolicyKeepStale, items.items[len(items.items)-1].OnFailure)
+	assert.NotNil(t, items.Set("secret:test:on-failure=ignore"))
+	assert.NotNil(t, items.Set("pki:example-dot-com:common_name=blah.example.com,renewal=soon"))
+	assert.NotNil(t, items.Set("secret:test:rename=password"))
+
 	assert.NotNil(t, items.Set("secret:"))
 	assert.NotNil(t, items.Set("secret:test:file=filename.test,fmt="))
 	assert.NotNil(t, items.Set("secret::file=filename.test,fmt=yaml"))
@@ -43,7 +64,37 @@ func TestSetResources(t *testing.T) {
 	assert.NotNil(t, items.Set("file=filename.test,fmt=yaml"))
 }
 
+func TestSetRenameResource(t *testing.T) {
+	var items VaultResources
+	// step: the options follow the path, so the separator can be given in their values
+	mustNoError(t, items.Set("secret:db:keys=password§rename=password:DB_PASS"))
+	assert.Equal(t, "db", items.items[0].Path)
+	assert.Equal(t, []string{"password"}, items.items[0].Keys)
+	assert.Equal(t, map[string]string{"password": "DB_PASS"}, items.items[0].Rename)
+}
+
+func TestSetCreateResource(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
+	var items VaultResources
+
+	assert.Nil(t, items.Set("secret:db/password:create=true,size=32,charset=alphanumeric"))
+	assert.Nil(t, items.Set("cubbyhole:db/password:create=true,policy=strong"))
+	assert.NotNil(t, items.Set("secret:db/password:create=true,charset=klingon"))
+	assert.NotNil(t, items.Set("pki:db/password:create=true"))
+	if assert.Len(t, items.items, 2) {
+		assert.Equal(t, "alphanumeric", items.items[0].Charset)
+		assert.Equal(t, int64(32), items.items[0].Size)
+		assert.Equal(t, "strong", items.items[1].PasswordPolicy)
+	}
+	for _, c := range newPasswordFromCharset(64, "hex") {
+		assert.Contains(t, "0123456789abcdef", string(c))
+	}
+}
+
 func TestSetEnvironmentResource(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR", ",")
+	defer os.Unsetenv("VAULT_SIDEKICK_OPTIONS_SEPARATOR")
 	tests := []struct {
 		ResourceText string
 		ExpectedPath string
@@ -94,7 +145,7 @@ func TestSetEnvironmentResource(t *testing.T) {
 		if !assert.NoError(t, resource.Set(c.ResourceText), "case %d, should not have failed", i) {
 			continue
 		}
-		assert.Equal(t, c.ExpectedPath, resource.items[0].path, "case %d, the paths do not match", i)
+		assert.Equal(t, c.ExpectedPath, resource.items[0].Path, "case %d, the paths do not match", i)
 	}
 }
 
@@ -0,0 +1,342 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestVaultService creates a vault service talking to a fake vault serving the responses by path
+func newTestVaultService(t *testing.T, responses map[string]interface{}) (*VaultService, func()) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if r.Method == "LIST" || r.URL.Query().Get("list") == "true" {
+			path += "?list"
+		}
+		response, found := responses[path]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": response})
+	}))
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	mustNoError(t, err)
+
+	return &VaultService{client: client}, server.Close
+}
+
+func TestGetPolicyResource(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/sys/policies/acl?list":    map[string]interface{}{"keys": []string{"default", "app"}},
+		"/v1/sys/policies/acl/default": map[string]interface{}{"name": "default", "policy": "path \"a\" {}"},
+		"/v1/sys/policies/acl/app":     map[string]interface{}{"name": "app", "policy": "path \"b\" {}"},
+	})
+	defer closer()
+
+	rn := &watchedResource{resource: &VaultResource{Resource: "policy", Path: "app", Update: time.Hour}}
+	mustNoError(t, service.get(rn))
+	assert.Equal(t, map[string]interface{}{"app": "path \"b\" {}"}, rn.secret.Data)
+	assert.Equal(t, 3600, rn.secret.LeaseDuration)
+	assert.False(t, rn.secret.Renewable)
+
+	rn = &watchedResource{resource: &VaultResource{Resource: "policy", Path: "*"}}
+	mustNoError(t, service.get(rn))
+	assert.Equal(t, map[string]interface{}{
+		"default": "path \"a\" {}",
+		"app":     "path \"b\" {}",
+	}, rn.secret.Data)
+	assert.Equal(t, 86400, rn.secret.LeaseDuration)
+
+	rn = &watchedResource{resource: &VaultResource{Resource: "policy", Path: "missing"}}
+	assert.Error(t, service.get(rn))
+}
+
+func TestGetConfigResource(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/auth/kubernetes/role/app": map[string]interface{}{
+			"bound_service_account_names": []string{"app"},
+			"token_ttl":                   3600,
+		},
+	})
+	defer closer()
+
+	rn := &watchedResource{resource: &VaultResource{Resource: "config", Path: "auth/kubernetes/role/app", Update: time.Minute}}
+	mustNoError(t, service.get(rn))
+	assert.Equal(t, []interface{}{"app"}, rn.secret.Data["bound_service_account_names"])
+	assert.Equal(t, 60, rn.secret.LeaseDuration)
+	assert.Equal(t, "config", rn.secret.LeaseID)
+}
+
+func TestGetDeletedSecret(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/secret/metadata/deleted": map[string]interface{}{
+			"current_version": 3,
+			"versions": map[string]interface{}{
+				"2": map[string]interface{}{"deletion_time": "", "destroyed": false},
+				"3": map[string]interface{}{"deletion_time": "2018-03-01T10:00:00Z", "destroyed": false},
+			},
+		},
+		"/v1/secret/data/destroyed": map[string]interface{}{
+			"data":     nil,
+			"metadata": map[string]interface{}{"deletion_time": "", "destroyed": true, "version": 2},
+		},
+		"/v1/secret/data/live": map[string]interface{}{
+			"data":     map[string]interface{}{"password": "test"},
+			"metadata": map[string]interface{}{"deletion_time": "", "destroyed": false, "version": 1},
+		},
+	})
+	defer closer()
+
+	rn := &watchedResource{resource: &VaultResource{Resource: "secret", Path: "secret/data/deleted"}}
+	err := service.get(rn)
+	if assert.IsType(t, &deletedSecretError{}, err) {
+		assert.Equal(t, "deleted", err.(*deletedSecretError).state())
+		assert.Equal(t, 3, err.(*deletedSecretError).version)
+	}
+	assert.Nil(t, rn.secret)
+
+	rn = &watchedResource{resource: &VaultResource{Resource: "secret", Path: "secret/data/destroyed"}}
+	err = service.get(rn)
+	if assert.IsType(t, &deletedSecretError{}, err) {
+		assert.Equal(t, "destroyed", err.(*deletedSecretError).state())
+	}
+
+	rn = &watchedResource{resource: &VaultResource{Resource: "secret", Path: "secret/data/live"}}
+	mustNoError(t, service.get(rn))
+	assert.Equal(t, map[string]interface{}{"password": "test"}, rn.secret.Data)
+
+	rn = &watchedResource{resource: &VaultResource{Resource: "secret", Path: "secret/data/missing"}}
+	assert.EqualError(t, service.get(rn), "VS-VAULT-001: the resource does not exist")
+}
+
+func TestMountTuning(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/sys/mounts/team/pki/tune": map[string]interface{}{"default_lease_ttl": 3600, "max_lease_ttl": 86400},
+	})
+	defer closer()
+
+	tuning := service.tuning("team/pki/issue/app")
+	if assert.NotNil(t, tuning) {
+		assert.Equal(t, "team/pki", tuning.path)
+		assert.Equal(t, time.Hour, tuning.defaultTTL)
+		assert.Equal(t, 24*time.Hour, tuning.maxTTL)
+	}
+	assert.Nil(t, service.tuning("team/secret/data/app"))
+
+	rn := &watchedResource{
+		resource: &VaultResource{Resource: "pki", Path: "team/pki/issue/app", Update: 48 * time.Hour},
+		secret:   &api.Secret{},
+	}
+	service.applyTuning(rn)
+	assert.Equal(t, tuning, rn.tuning)
+
+	renewal, ok := rn.renewal()
+	assert.True(t, ok)
+	assert.True(t, renewal <= time.Duration(float64(24*time.Hour)*renewalMaximum))
+
+	rn.resource.Update = 0
+	renewal, ok = rn.renewal()
+	assert.True(t, ok)
+	assert.True(t, renewal <= time.Duration(float64(time.Hour)*renewalMaximum))
+}
+
+func TestRenewalPercentage(t *testing.T) {
+	rn := &watchedResource{
+		resource: &VaultResource{Resource: "aws", Path: "aws/creds/app", RenewalFraction: 0.75},
+		secret:   &api.Secret{LeaseDuration: 3600},
+	}
+	renewal, ok := rn.renewal()
+	assert.True(t, ok)
+	assert.Equal(t, 45*time.Minute, renewal)
+
+	// step: a certificate without a lease is renewed through its expiration
+	expiration := json.Number(strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	rn.secret = &api.Secret{Data: map[string]interface{}{"expiration": expiration}}
+	renewal, ok = rn.renewal()
+	assert.True(t, ok)
+	assert.True(t, renewal > 44*time.Minute && renewal <= 45*time.Minute)
+
+	// step: the flag is the default for resources without a percentage
+	rn.resource.RenewalFraction = 0
+	options.renewal = 0.5
+	defer func() { options.renewal = 0 }()
+	rn.secret = &api.Secret{LeaseDuration: 3600}
+	renewal, _ = rn.renewal()
+	assert.Equal(t, 30*time.Minute, renewal)
+
+	rn.renewalTime = renewal
+	assert.Equal(t, time.Duration(0), rn.maxJitter())
+	rn.resource.JitterFraction = 0.1
+	assert.Equal(t, 3*time.Minute, rn.maxJitter())
+	rn.resource.MaxJitter = time.Minute
+	assert.Equal(t, time.Minute, rn.maxJitter())
+
+	rn.secret = &api.Secret{}
+	_, ok = rn.renewal()
+	assert.False(t, ok)
+}
+
+func TestRenewalWithinLease(t *testing.T) {
+	rn := &watchedResource{
+		resource: &VaultResource{Resource: "database", Path: "database/creds/app", Update: 24 * time.Hour},
+		secret:   &api.Secret{LeaseDuration: 3600},
+	}
+	renewal, ok := rn.renewal()
+	assert.True(t, ok)
+	assert.True(t, renewal <= time.Duration(float64(time.Hour)*renewalMaximum), "renewal: %s", renewal)
+
+	rn.resource.Update = 30 * time.Minute
+	renewal, _ = rn.renewal()
+	assert.Equal(t, 30*time.Minute, renewal)
+}
+
+func TestRenewAtMaxTTL(t *testing.T) {
+	lease := 3600
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       "database/creds/app/1",
+			"lease_duration": lease,
+			"renewable":      true,
+		})
+	}))
+	defer server.Close()
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	mustNoError(t, err)
+	service := &VaultService{client: client}
+
+	rn := &watchedResource{
+		resource: &VaultResource{Resource: "database", Path: "database/creds/app"},
+		secret:   &api.Secret{LeaseID: "database/creds/app/1", LeaseDuration: 3600, Renewable: true},
+	}
+	mustNoError(t, service.renew(rn))
+	assert.False(t, rn.atMaxTTL)
+
+	// step: a lease cut short is at its max ttl and is re-issued rather than renewed again
+	lease = 600
+	mustNoError(t, service.renew(rn))
+	assert.True(t, rn.atMaxTTL)
+	assert.Equal(t, 600, rn.secret.LeaseDuration)
+}
+
+func TestCalculateRetry(t *testing.T) {
+	for attempt, ceiling := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second} {
+		for i := 0; i < 50; i++ {
+			delay := fullJitter(10*time.Second, time.Minute, attempt)
+			assert.True(t, delay >= time.Second && delay <= ceiling && delay <= time.Minute, "attempt %d gave %s", attempt, delay)
+		}
+	}
+	assert.True(t, fullJitter(10*time.Second, time.Minute, 100) <= time.Minute)
+	assert.Equal(t, 500*time.Millisecond, fullJitter(500*time.Millisecond, time.Minute, 0))
+
+	rn := &watchedResource{resource: &VaultResource{Backoff: time.Second, BackoffMax: 2 * time.Second, Retries: 10}}
+	assert.True(t, rn.calculateRetry() <= 2*time.Second)
+}
+
+func TestTokenRenewPeriod(t *testing.T) {
+	assert.Equal(t, 30*time.Minute, tokenRenewPeriod(time.Hour, 0))
+	assert.Equal(t, 15*time.Minute, tokenRenewPeriod(time.Hour, 0.75))
+	assert.Equal(t, 54*time.Minute, tokenRenewPeriod(time.Hour, 0.1))
+}
+
+func TestRevokeResource(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/sys/leases/revoke/database/creds/app/1": map[string]interface{}{},
+		"/v1/team/pki/revoke":                        map[string]interface{}{"revocation_time": 1},
+	})
+	defer closer()
+
+	rn := &watchedResource{
+		resource: &VaultResource{Resource: "database", Path: "database/creds/app", Revoked: true},
+		secret:   &api.Secret{LeaseID: "database/creds/app/1"},
+	}
+	mustNoError(t, service.revokeResource(rn))
+	rn.secret.LeaseID = "database/creds/app/2"
+	assert.Error(t, service.revokeResource(rn))
+
+	// step: a certificate without a lease is revoked by its serial
+	rn = &watchedResource{
+		resource: &VaultResource{Resource: "pki", Path: "team/pki/issue/app", Revoked: true},
+		secret:   &api.Secret{Data: map[string]interface{}{"serial_number": "39:dd:2e"}},
+	}
+	mustNoError(t, service.revokeResource(rn))
+	rn.resource.Path = "other/pki/issue/app"
+	assert.Error(t, service.revokeResource(rn))
+
+	assert.Equal(t, "team/pki", pkiMount("team/pki/issue/app"))
+	assert.Equal(t, "pki", pkiMount("pki/sign/web"))
+	assert.Equal(t, "", pkiMount("secret/app"))
+}
+
+func TestLeaseNotRenewable(t *testing.T) {
+	assert.True(t, leaseNotRenewable(errors.New("Error making API request.\n\nCode: 400. Errors:\n\n* lease is not renewable")))
+	assert.True(t, leaseNotRenewable(errors.New("Code: 400. Errors:\n\n* lease not found")))
+	assert.False(t, leaseNotRenewable(errors.New("Code: 503. Errors:\n\n* Vault is sealed")))
+}
+
+func TestTokenEvents(t *testing.T) {
+	service, closer := newTestVaultService(t, map[string]interface{}{
+		"/v1/auth/token/lookup-self": map[string]interface{}{"ttl": 1},
+	})
+	defer closer()
+	os.Setenv("VAULT_TOKEN", "first")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	events := make(chan VaultEvent, 10)
+	cfg := &config{
+		vaultURL:         service.client.Address(),
+		vaultRenewToken:  true,
+		vaultAuthOptions: &vaultAuthOptions{Method: "token"},
+	}
+	client, err := newVaultClient(cfg, func(event VaultEvent) { events <- event })
+	mustNoError(t, err)
+	assert.Equal(t, "first", client.Token())
+	assert.Equal(t, EventTypeTokenAcquired, (<-events).Type)
+
+	// step: a refresh keeping the token is a renewal, one giving a new token an acquisition
+	select {
+	case event := <-events:
+		assert.Equal(t, "token-renewed", event.Type.String())
+		assert.Equal(t, time.Second, event.TokenTTL)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the token to be renewed")
+	}
+	os.Setenv("VAULT_TOKEN", "second")
+	select {
+	case event := <-events:
+		assert.Equal(t, "token-acquired", event.Type.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a new token to be acquired")
+	}
+}
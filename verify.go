@@ -0,0 +1,189 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
+	"github.com/golang/glog"
+)
+
+// previousFile is the state of a file before it was overwritten
+type previousFile struct {
+	// whether the file existed
+	exists bool
+	// the content of the file
+	content []byte
+	// the permissions of the file
+	mode os.FileMode
+}
+
+// writeJournal records the previous state of the files written while rendering a resource, so the
+// render can be rolled back
+type writeJournal struct {
+	sync.Mutex
+	// whether the journal is recording
+	active bool
+	// the previous state of the files written
+	files map[string]*previousFile
+	// the order the files were written
+	order []string
+}
+
+// journal records the files written while a resource is being verified
+var journal = &writeJournal{}
+
+// begin starts recording the files written
+func (j *writeJournal) begin() {
+	j.Lock()
+	defer j.Unlock()
+	j.active = true
+	j.files = make(map[string]*previousFile)
+	j.order = nil
+}
+
+// record keeps the current state of the file, if the journal is recording and it's not already been recorded
+//	filename	: the file about to be written
+func (j *writeJournal) record(filename string) error {
+	j.Lock()
+	defer j.Unlock()
+	if !j.active {
+		return nil
+	}
+	if _, found := j.files[filename]; found {
+		return nil
+	}
+
+	previous := &previousFile{}
+	stat, err := os.Stat(filename)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		previous.exists = true
+		previous.content = content
+		previous.mode = stat.Mode().Perm()
+	}
+	j.files[filename] = previous
+	j.order = append(j.order, filename)
+
+	return nil
+}
+
+// commit stops recording, keeping the files written
+func (j *writeJournal) commit() {
+	j.Lock()
+	defer j.Unlock()
+	j.active = false
+	j.files = nil
+	j.order = nil
+}
+
+// rollback stops recording, restoring the files written to their previous state
+func (j *writeJournal) rollback() error {
+	j.Lock()
+	defer j.Unlock()
+	j.active = false
+
+	var failed []string
+	for _, filename := range j.order {
+		previous := j.files[filename]
+		var err error
+		if previous.exists {
+			err = ioutil.WriteFile(filename, previous.content, previous.mode)
+		} else {
+			err = removeSecret(filename)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			glog.Errorf("failed to roll back the file: %s, error: %s", filename, err)
+			failed = append(failed, filename)
+		}
+	}
+	j.files = nil
+	j.order = nil
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to roll back the files: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// verifyResource runs the verify command of the resource against the files just written
+//	rn			: the vault resource
+//	filename	: the file the resource was written to
+func verifyResource(rn *VaultResource, filename string) error {
+	var args []string
+	if len(rn.VerifyExecPath) > 1 {
+		args = rn.VerifyExecPath[1:]
+	} else {
+		args = []string{filename}
+	}
+	cmd := exec.Command(rn.VerifyExecPath[0], args...)
+	if execDryRun(rn) {
+		logHook(rn, "verify-exec", cmd, nil)
+		return nil
+	}
+	metrics.ResourceProcessTotal(rn.ID(), "verify")
+	glog.V(10).Infof("verifying the resource: %s with the command: %s", rn.ID(), rn.VerifyExecPath)
+
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	var startErr error
+	err := execs.limit(func() error {
+		started := time.Now()
+		if startErr = cmd.Start(); startErr != nil {
+			audit.exec(rn, "verify-exec", cmd.Args, 0, startErr)
+			return startErr
+		}
+		timer := time.AfterFunc(options.execTimeout, func() {
+			if err := cmd.Process.Kill(); err != nil {
+				glog.Errorf("failed to kill the verify command, pid: %d, error: %s", cmd.Process.Pid, err)
+			}
+		})
+		err := cmd.Wait()
+		timer.Stop()
+		audit.exec(rn, "verify-exec", cmd.Args, time.Since(started), err)
+
+		return err
+	})
+	if startErr != nil {
+		metrics.ResourceProcessError(rn.ID(), "verify")
+		return startErr
+	}
+	if err != nil {
+		metrics.ResourceProcessError(rn.ID(), "verify")
+		return fmt.Errorf("verification failed: %s, output: %s", err, strings.TrimSpace(output.String()))
+	}
+	metrics.ResourceProcessSuccess(rn.ID(), "verify")
+
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteResourceVerifyExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.env")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("PASSWORD=old\n"), 0600))
+	rn := &VaultResource{
+		Resource:       "secret",
+		Path:           "app",
+		Format:         "env",
+		Filename:       filename,
+		FileMode:       0640,
+		VerifyExecPath: []string{"false"},
+	}
+
+	// step: a failed verification restores the previous content
+	_, err = writeResource(rn, map[string]interface{}{"password": "new"})
+	assert.Error(t, err)
+	content, _ := ioutil.ReadFile(filename)
+	assert.Equal(t, "PASSWORD=old\n", string(content))
+	stat, _ := os.Stat(filename)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	// step: a failed verification of a new file removes it
+	rn.Filename = filepath.Join(dir, "new.env")
+	_, err = writeResource(rn, map[string]interface{}{"password": "new"})
+	assert.Error(t, err)
+	_, err = os.Stat(rn.Filename)
+	assert.True(t, os.IsNotExist(err))
+
+	// step: a successful verification keeps the content
+	rn.Filename = filename
+	rn.VerifyExecPath = []string{"grep", "-q", "PASSWORD='new'", filename}
+	_, err = writeResource(rn, map[string]interface{}{"password": "new"})
+	assert.NoError(t, err)
+	content, _ = ioutil.ReadFile(filename)
+	assert.Equal(t, "PASSWORD='new'\n", string(content))
+}
+
+func TestExecDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-sidekick")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "ran")
+	rn := &VaultResource{
+		Resource:       "secret",
+		Path:           "app",
+		Format:         "env",
+		Filename:       filepath.Join(dir, "app.env"),
+		FileMode:       0600,
+		ExecPath:       []string{"touch", marker},
+		VerifyExecPath: []string{"false"},
+		OnDeletePath:   []string{"touch", marker},
+		ExecDryRun:     true,
+	}
+
+	// step: the hooks are logged, the failing verification and the commands never run
+	filename, err := writeResource(rn, map[string]interface{}{"password": "new"})
+	assert.NoError(t, err)
+	assert.NoError(t, execResource(rn, filename))
+	assert.NoError(t, deletedResource(rn, &deletedSecretError{path: "app", version: 2}))
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+
+	// step: the global option applies to every resource
+	rn.ExecDryRun = false
+	options.execDryRun = true
+	defer func() { options.execDryRun = false }()
+	assert.NoError(t, execResource(rn, filename))
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
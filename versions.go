@@ -0,0 +1,122 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// currentVersion is the symlink to the latest version of a versioned resource
+	currentVersion = "current"
+	// versionLayout is the layout of the directory names of the versions, sorting by time
+	versionLayout = "20060102T150405.000000000Z"
+)
+
+// newVersion creates the directory of a new version of a resource, returning the filename the resource is
+// written to within it; the versions are kept in a directory named as the file of the resource would be
+//	filename	: the filename of the resource
+func newVersion(filename string) (string, error) {
+	dir := filepath.Join(filename, time.Now().UTC().Format(versionLayout))
+	if options.dryRun {
+		return filepath.Join(dir, filepath.Base(filename)), nil
+	}
+	if err := makeDirectory(dir, directoryMode(&options)); err != nil {
+		return "", fmt.Errorf("unable to create the version directory: %s, error: %s", dir, err)
+	}
+	for _, x := range []string{filename, dir} {
+		if err := owners.apply(x); err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(dir, filepath.Base(filename)), nil
+}
+
+// publishVersion points the current symlink at a version once it has been written, replacing the link with a
+// rename so readers see either the old or the new version as a whole, then prunes the oldest versions; the
+// filename of the resource through the current symlink is returned
+//	filename	: the filename of the resource
+//	version		: the filename of the resource within the version
+//	keep		: the number of versions to keep
+func publishVersion(filename, version string, keep int) (string, error) {
+	current := filepath.Join(filename, currentVersion, filepath.Base(version))
+	if options.dryRun {
+		return current, nil
+	}
+	name := filepath.Base(filepath.Dir(version))
+	link := filepath.Join(filename, "."+currentVersion)
+	os.Remove(link)
+	if err := os.Symlink(name, link); err != nil {
+		return version, fmt.Errorf("unable to create the current symlink, error: %s", err)
+	}
+	if err := os.Rename(link, filepath.Join(filename, currentVersion)); err != nil {
+		os.Remove(link)
+		return version, fmt.Errorf("unable to replace the current symlink, error: %s", err)
+	}
+
+	pruned, err := pruneVersions(filename, name, keep)
+	if err != nil {
+		glog.Errorf("unable to prune the versions of: %s, error: %s", filename, err)
+	}
+	for _, x := range pruned {
+		glog.V(3).Infof("removed the version: %s of: %s", x, filename)
+	}
+
+	return current, nil
+}
+
+// pruneVersions removes the oldest versions of a resource beyond the number to keep, never the current
+//	filename	: the filename of the resource
+//	current		: the name of the current version
+//	keep		: the number of versions to keep
+func pruneVersions(filename, current string, keep int) ([]string, error) {
+	files, err := ioutil.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, x := range files {
+		if !x.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(versionLayout, x.Name()); err == nil {
+			versions = append(versions, x.Name())
+		}
+	}
+	sort.Strings(versions)
+
+	var pruned []string
+	for i := 0; i < len(versions)-keep; i++ {
+		if versions[i] == current {
+			continue
+		}
+		if err := removeSecret(filepath.Join(filename, versions[i])); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, versions[i])
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionedResource(t *testing.T) {
+	defer withOutputDir(t)()
+
+	rn := &VaultResource{Resource: "secret", Path: "secret/app", Format: "txt", Filename: "app", FileMode: 0600, Versions: 2}
+	for _, value := range []string{"first", "second", "third"} {
+		filename, err := writeResource(rn, map[string]interface{}{"password": value})
+		mustNoError(t, err)
+		assert.Equal(t, filepath.Join(options.outputDir, "app", currentVersion, "app"), filename)
+		content, err := ioutil.ReadFile(filename)
+		mustNoError(t, err)
+		assert.Equal(t, value, string(content))
+	}
+
+	// step: only the versions to keep are left, along with the current symlink
+	files, err := ioutil.ReadDir(filepath.Join(options.outputDir, "app"))
+	mustNoError(t, err)
+	assert.Len(t, files, 3)
+	link, err := os.Readlink(filepath.Join(options.outputDir, "app", currentVersion))
+	mustNoError(t, err)
+	assert.Equal(t, files[1].Name(), link)
+
+	// step: a failed render leaves the current version alone
+	rn.Format = "certchain"
+	_, err = writeResource(rn, map[string]interface{}{"password": "fourth"})
+	assert.Error(t, err)
+	files, err = ioutil.ReadDir(filepath.Join(options.outputDir, "app"))
+	mustNoError(t, err)
+	assert.Len(t, files, 3)
+	after, err := os.Readlink(filepath.Join(options.outputDir, "app", currentVersion))
+	mustNoError(t, err)
+	assert.Equal(t, link, after)
+}
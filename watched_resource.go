@@ -17,15 +17,23 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/hashicorp/vault/api"
+
+	"github.com/UKHomeOffice/vault-sidekick/metrics"
 )
 
 const (
 	renewalMinimum = 0.8
 	renewalMaximum = 0.95
+	// the backoff and cap on retries when neither the resource or flags give one
+	retryBackoff    = 10 * time.Second
+	retryBackoffMax = time.Hour
 )
 
 // watchedResource is a resource which is being watched - i.e. when the item is coming up for renewal
@@ -41,61 +49,220 @@ type watchedResource struct {
 	renewalTime time.Duration
 	// the secret
 	secret *api.Secret
+	// the generation of the renewal notification, bumped each time a new one is scheduled so stale ones are dropped
+	generation int64
+	// whether the secret was found deleted on the last retrieval
+	deleted bool
+	// the lease tuning of the mount, empty if it couldn't be read
+	tuning *mountTuning
+	// whether the resource is no longer watched
+	removed bool
+	// whether the lease was cut short by its max ttl, so it's re-issued rather than renewed again
+	atMaxTTL bool
 }
 
 // notifyOnRenewal creates a trigger and notifies when a resource is up for renewal
 func (r *watchedResource) notifyOnRenewal(ch chan *watchedResource) {
+	generation := atomic.AddInt64(&r.generation, 1)
 	go func() {
-		// step: check if the resource has a pre-configured renewal time
-		r.renewalTime = r.resource.Update
-		// step: if the answer is no, we set the notification between 80-95% of the lease time of the secret
-		if r.renewalTime <= 0 {
-			// if there is no lease time, we canout set a renewal, just fade into the background
-			if r.secret.LeaseDuration <= 0 {
-				glog.Warningf("resource: %s has no lease duration, no custom update set, so item will not be updated", r.resource.Path)
-				return
-			}
-			r.renewalTime = r.calculateRenewal()
+		var ok bool
+		if r.renewalTime, ok = r.renewal(); !ok {
+			glog.Warningf("resource: %s has no lease duration, no custom update set, so item will not be updated", r.resource.Path)
+			return
 		}
-		if r.resource.MaxJitter != 0 {
-			glog.V(4).Infof("using maxJitter (%s) to calculate renewal time", r.resource.MaxJitter)
+		if maxJitter := r.maxJitter(); maxJitter != 0 {
+			glog.V(4).Infof("using maxJitter (%s) to calculate renewal time", maxJitter)
 			r.renewalTime = time.Duration(getDurationWithin(
-				int((r.renewalTime-r.resource.MaxJitter)/time.Second),
+				int((r.renewalTime-maxJitter)/time.Second),
 				int(r.renewalTime/time.Second),
 			))
 		}
 		glog.V(3).Infof("setting a renewal notification on resource: %s, time: %s", r.resource, r.renewalTime)
+		statuses.scheduled(r.resource, time.Now().Add(r.renewalTime))
 		// step: wait for the duration
 		<-time.After(r.renewalTime)
+		// step: the resource has been re-fetched in the meantime, a newer notification is pending
+		if atomic.LoadInt64(&r.generation) != generation {
+			glog.V(4).Infof("dropping a stale renewal notification on resource: %s", r.resource)
+			return
+		}
 		// step: send the notification on the renewal channel
 		ch <- r
 	}()
 }
 
-// calculateRenewal calculate the renewal between
-func (r watchedResource) calculateRenewal() time.Duration {
+// renewal returns the time until the resource is next renewed, false if it has no lease or update set
+func (r *watchedResource) renewal() (time.Duration, bool) {
+	// step: check if the resource has a pre-configured renewal time
+	renewal := r.resource.Update
+	// step: if the answer is no, we set the notification at a point through the ttl of the secret
+	if renewal <= 0 {
+		ttl, found := r.ttl()
+		if !found {
+			// if there is no lease time, we canout set a renewal, just fade into the background
+			return 0, false
+		}
+		renewal = r.renewalAt(ttl)
+	}
+	// step: never schedule beyond the lease vault returned, it may be shorter than the update asked for
+	if r.secret != nil && r.secret.LeaseDuration > 0 {
+		if lease := time.Duration(r.secret.LeaseDuration) * time.Second; renewal > lease {
+			renewal = r.renewalAt(lease)
+		}
+	}
+	// step: never schedule beyond the max ttl of the mount, the secret would have expired
+	if r.tuning != nil && r.tuning.maxTTL > 0 && renewal > r.tuning.maxTTL {
+		renewal = r.renewalAt(r.tuning.maxTTL)
+	}
+
+	return renewal, true
+}
+
+// leaseReduced records vault returning a shorter lease than expected, i.e. capped by the max ttl of the mount or the
+// ttl of the token, the renewal being scheduled off the lease returned
+//	expected	: the lease which was expected
+func (r *watchedResource) leaseReduced(expected time.Duration) {
+	lease := time.Duration(r.secret.LeaseDuration) * time.Second
+	glog.Warningf("resource: %s was given a lease of %s by vault rather than %s, renewing within it", r.resource, lease, expected)
+	metrics.ResourceLeaseReduced(r.resource.ID())
+}
+
+// expiry returns when the secret expires, the expiration of a certificate or the end of a lease, the zero time if
+// it doesn't
+func (r *watchedResource) expiry() time.Time {
+	if r.secret == nil {
+		return time.Time{}
+	}
+	if expiration, ok := r.secret.Data["expiration"].(json.Number); ok {
+		if seconds, err := expiration.Int64(); err == nil {
+			return time.Unix(seconds, 0)
+		}
+	}
+	// step: the resources read on a schedule are given a lease of their own which never expires
+	switch r.resource.Resource {
+	case "raw", "config", "tpl", "policy", "csi":
+		return time.Time{}
+	}
+	if r.secret.LeaseID == "" || r.secret.LeaseDuration <= 0 {
+		return time.Time{}
+	}
+
+	return r.leaseExpireTime
+}
+
+// refreshAt returns when the secret expires or, for one without an expiry such as a kv secret, when it's next due to
+// be re-read, the zero time if neither is known
+func (r *watchedResource) refreshAt() time.Time {
+	if expiry := r.expiry(); !expiry.IsZero() {
+		return expiry
+	}
+	if renewal, ok := r.renewal(); ok {
+		return r.lastUpdated.Add(renewal)
+	}
+
+	return time.Time{}
+}
+
+// ttl returns the time the secret lives for, taken from the lease, the expiration of a certificate or
+// the default ttl of the mount, false if none are known
+func (r *watchedResource) ttl() (time.Duration, bool) {
+	if r.secret != nil && r.secret.LeaseDuration > 0 {
+		return time.Duration(r.secret.LeaseDuration) * time.Second, true
+	}
+	// step: certificates are issued without a lease, though carry their expiration
+	if r.secret != nil {
+		if expiration, ok := r.secret.Data["expiration"].(json.Number); ok {
+			if seconds, err := expiration.Int64(); err == nil {
+				if ttl := time.Until(time.Unix(seconds, 0)); ttl > 0 {
+					return ttl, true
+				}
+			}
+		}
+	}
+	// step: without a lease we fall back to the default ttl of the mount
+	if r.tuning != nil && r.tuning.defaultTTL > 0 {
+		return r.tuning.defaultTTL, true
+	}
+
+	return 0, false
+}
+
+// renewalAt returns the point through the ttl the resource is renewed at, the renewal percentage of the
+// resource or the -renewal flag when set, otherwise between 80-95%
+//	ttl			: the ttl of the secret
+func (r *watchedResource) renewalAt(ttl time.Duration) time.Duration {
+	fraction := r.resource.RenewalFraction
+	if fraction <= 0 {
+		fraction = float64(options.renewal)
+	}
+	if fraction > 0 {
+		return time.Duration(float64(ttl) * fraction)
+	}
+
+	return renewalWithin(int(ttl.Seconds()))
+}
+
+// maxJitter returns the most taken off the renewal time at random, the jitter of the resource or a
+// percentage of the renewal time
+func (r *watchedResource) maxJitter() time.Duration {
+	if r.resource.MaxJitter != 0 {
+		return r.resource.MaxJitter
+	}
+	fraction := r.resource.JitterFraction
+	if fraction <= 0 {
+		fraction = float64(options.renewalJitter)
+	}
+
+	return time.Duration(float64(r.renewalTime) * fraction)
+}
+
+// renewalWithin returns a renewal between 80-95% of a ttl
+//	ttl			: the ttl in seconds
+func renewalWithin(ttl int) time.Duration {
 	return time.Duration(getDurationWithin(
-		int(float64(r.secret.LeaseDuration)*renewalMinimum),
-		int(float64(r.secret.LeaseDuration)*renewalMaximum)))
+		int(float64(ttl)*renewalMinimum),
+		int(float64(ttl)*renewalMaximum)))
 }
 
 // calculateRetry calculates the time to wait before retrying a failed
 // attempt to retrieve or renew a resource.
 // The duration is calculated using an exponential backoff algorithm
-// based on the number of attempts.
-// The maximum retry time is set to 1h.
+// based on the number of attempts, doubling the backoff of the resource
+// or the -retry-backoff flag up to the cap, with a random delay up to the
+// ceiling taken so failing sidekicks don't retry in step.
 func (r watchedResource) calculateRetry() time.Duration {
-	var retryMaximum time.Duration
-	retryMinimum := 10 * time.Second
+	backoff, backoffMax := r.resource.Backoff, r.resource.BackoffMax
+	if backoff <= 0 {
+		backoff = options.retryBackoff
+	}
+	if backoff <= 0 {
+		backoff = retryBackoff
+	}
+	if backoffMax <= 0 {
+		backoffMax = options.retryBackoffMax
+	}
+	if backoffMax <= 0 {
+		backoffMax = retryBackoffMax
+	}
+
+	return fullJitter(backoff, backoffMax, r.resource.Retries)
+}
 
-	switch r.resource.Retries {
-	case 0:
-		retryMaximum = 1 * time.Minute
-	case 1:
-		retryMaximum = 10 * time.Minute
-	default:
-		retryMaximum = 1 * time.Hour
+// fullJitter returns a random delay between a second and the backoff doubled for each attempt, never beyond the cap
+//	backoff		: the backoff of the first attempt
+//	max			: the cap on the backoff
+//	attempt		: the number of attempts which have failed
+func fullJitter(backoff, max time.Duration, attempt int) time.Duration {
+	ceiling := backoff
+	for i := 0; i < attempt && ceiling < max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > max {
+		ceiling = max
+	}
+	if ceiling <= time.Second {
+		return ceiling
 	}
 
-	return getDurationWithin(int(retryMinimum/time.Second), int(retryMaximum/time.Second))
+	return time.Second + time.Duration(rand.Int63n(int64(ceiling-time.Second)))
 }